@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/schema"
+)
+
+// detectFormat 在未指定 -format 时按扩展名判断外部 schema 的格式
+func detectFormat(path, format string) string {
+	if format != "" {
+		return format
+	}
+	switch filepath.Ext(path) {
+	case ".avsc":
+		return "avro"
+	case ".proto":
+		return "proto"
+	case ".pb", ".desc", ".binpb":
+		return "descriptor"
+	}
+	return "jsonschema"
+}
+
+// runImport 将 JSON Schema、Avro 或 Protobuf 描述的 schema 转换为 pipeline schema
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "input format: jsonschema, avro, proto or descriptor, default by extension")
+	message := fs.String("message", "", "protobuf message to convert, required when the file has several")
+	importPath := fs.String("I", "", "protobuf import path, default the directory of the input file")
+	out := fs.String("o", "", "write the schema to this file (.json or .yaml), default stdout")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import: exactly one input file is required")
+	}
+	input := fs.Arg(0)
+
+	var s *pipeline.Schema
+	var err error
+	switch detectFormat(input, *format) {
+	case "proto":
+		var paths []string
+		if *importPath != "" {
+			paths = filepath.SplitList(*importPath)
+		}
+		s, err = schema.FromProtoFile(input, *message, paths...)
+	case "jsonschema", "avro", "descriptor":
+		var data []byte
+		if data, err = os.ReadFile(input); err != nil {
+			return err
+		}
+		switch detectFormat(input, *format) {
+		case "avro":
+			s, err = schema.FromAvro(data)
+		case "descriptor":
+			s, err = schema.FromDescriptorSet(data, *message)
+		default:
+			s, err = schema.FromJSONSchema(data)
+		}
+	default:
+		return fmt.Errorf("import: unknown format %q", *format)
+	}
+	if err != nil {
+		return fmt.Errorf("import %s: %w", input, err)
+	}
+	return writeSchema(s, *out)
+}
+
+// runExport 将 pipeline schema 导出为 JSON Schema 或 Avro
+func runExport(args []string) error {
+	fs, dir, _ := registryFlags("export")
+	format := fs.String("format", "jsonschema", "output format: jsonschema or avro")
+	out := fs.String("o", "", "write to this file, default stdout")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("export: want one schema, subject@version or file")
+	}
+	registry, err := openRegistry(*dir)
+	if err != nil {
+		return err
+	}
+	s, err := loadSchema(registry, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch *format {
+	case "jsonschema":
+		data, err = schema.ToJSONSchema(s)
+	case "avro":
+		data, err = schema.ToAvro(s)
+	default:
+		return fmt.Errorf("export: unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*out, data, 0o644)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ipush/littlepipe/pkg/codec/jsonl"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/schema"
+	filesource "github.com/ipush/littlepipe/pkg/source/file"
+)
+
+func infer(input string, n int, out, reportPath string) error {
+	// fractional numbers are inferred as float64, decimal needs a scale the data cannot tell
+	source, err := filesource.NewFileSourceWithCodec(input, jsonl.New(jsonl.Config{Floats: true}))
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	s, report, err := schema.Infer(source, n)
+	if err != nil {
+		return fmt.Errorf("infer %s: %w", input, err)
+	}
+
+	if err := writeSchema(s, out); err != nil {
+		return err
+	}
+
+	if reportPath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(reportPath, data, 0o644)
+	}
+	fmt.Fprint(os.Stderr, report.String())
+	return nil
+}
+
+// writeSchema 写入 out 指定的文件，out 为空时以 JSON 输出到标准输出
+func writeSchema(s *pipeline.Schema, out string) error {
+	if out != "" {
+		return schema.WriteFile(out, s)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
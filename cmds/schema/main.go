@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: schema <command> [flags]
+
+commands:
+  infer      infer a schema from newline-delimited JSON records
+  list       list registered subjects, or the versions of one subject
+  diff       compare two schemas (subject@version or file)
+  register   register a schema file under a subject
+  import     convert a JSON Schema, Avro or Protobuf schema
+  export     convert a schema to JSON Schema or Avro`)
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "infer":
+		err = runInfer(os.Args[2:])
+	case "list":
+		err = runList(os.Args[2:])
+	case "diff":
+		err = runDiff(os.Args[2:])
+	case "register":
+		err = runRegister(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runInfer(args []string) error {
+	fs := flag.NewFlagSet("infer", flag.ExitOnError)
+	n := fs.Int("n", 1000, "number of records to scan, 0 scans everything")
+	out := fs.String("o", "", "write the schema to this file (.json or .yaml), default stdout")
+	reportPath := fs.String("report", "", "write the inconsistency report as JSON to this file")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("infer: exactly one input file is required")
+	}
+
+	return infer(fs.Arg(0), *n, *out, *reportPath)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/schema"
+)
+
+func registryFlags(name string) (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	dir := fs.String("registry", "schemas", "schema registry directory")
+	compat := fs.String("compat", "", "compatibility rule: none, backward, forward or full")
+	return fs, dir, compat
+}
+
+func openRegistry(dir string) (*schema.Registry, error) {
+	return schema.NewRegistry(dir, schema.CompatBackward)
+}
+
+// runList 不带参数列出所有 subject，带 subject 时列出它的版本
+func runList(args []string) error {
+	fs, dir, _ := registryFlags("list")
+	fs.Parse(args)
+	registry, err := openRegistry(*dir)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		subjects, err := registry.Subjects()
+		if err != nil {
+			return err
+		}
+		for _, subject := range subjects {
+			versions, err := registry.Versions(subject)
+			if err != nil {
+				return err
+			}
+			compat, err := registry.Compatibility(subject)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%d versions\t%s\n", subject, len(versions), compat)
+		}
+		return nil
+	}
+
+	subject := fs.Arg(0)
+	versions, err := registry.Versions(subject)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		s, err := registry.Get(subject, v)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s@%d\t%d fields\n", subject, v, len(s.Fields))
+	}
+	return nil
+}
+
+// runDiff 比较两个引用，参数可以是 subject@version 或 schema 文件路径
+func runDiff(args []string) error {
+	fs, dir, compat := registryFlags("diff")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("diff: want two schemas, e.g. users@1 users@2")
+	}
+	registry, err := openRegistry(*dir)
+	if err != nil {
+		return err
+	}
+
+	prev, err := loadSchema(registry, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	next, err := loadSchema(registry, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	changes := schema.Diff(prev, next)
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+
+	mode := schema.CompatFull
+	if *compat != "" {
+		if mode, err = schema.ParseCompatibility(*compat); err != nil {
+			return err
+		}
+	}
+	for _, issue := range schema.Check(prev, next, mode) {
+		fmt.Println("! " + issue.String())
+	}
+	return nil
+}
+
+func runRegister(args []string) error {
+	fs, dir, compat := registryFlags("register")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("register: want a subject and a schema file")
+	}
+	registry, err := openRegistry(*dir)
+	if err != nil {
+		return err
+	}
+
+	subject := fs.Arg(0)
+	s, err := schema.ReadFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	var version int
+	if *compat != "" {
+		// the mode is only saved once the schema is registered under it
+		mode, err := schema.ParseCompatibility(*compat)
+		if err != nil {
+			return err
+		}
+		version, err = registry.RegisterWithCompatibility(subject, s, mode)
+		if err != nil {
+			return err
+		}
+	} else {
+		version, err = registry.Register(subject, s)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("registered %s@%d\n", subject, version)
+	return nil
+}
+
+func loadSchema(registry *schema.Registry, arg string) (*pipeline.Schema, error) {
+	// an existing file wins over a subject of the same name
+	if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+		return schema.ReadFile(arg)
+	}
+	if ref, err := schema.ParseRef(arg); err == nil {
+		return registry.Resolve(ref)
+	}
+	return schema.ReadFile(arg)
+}
@@ -0,0 +1,97 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// FileStore 每个 pipeline 一个 JSON 文件，通过临时文件加 rename 原子地替换
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.dir, name+".checkpoint.json")
+}
+
+func (s *FileStore) Load(name string) (*pipeline.Checkpoint, error) {
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp pipeline.Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("decode checkpoint %s: %w", name, err)
+	}
+	return &cp, nil
+}
+
+func (s *FileStore) Save(name string, cp pipeline.Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(name))
+}
+
+// MemoryStore 进程内的 checkpoint 存储，用于测试
+type MemoryStore struct {
+	mu          sync.Mutex
+	checkpoints map[string]pipeline.Checkpoint
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{checkpoints: make(map[string]pipeline.Checkpoint)}
+}
+
+func (s *MemoryStore) Load(name string) (*pipeline.Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.checkpoints[name]
+	if !ok {
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+func (s *MemoryStore) Save(name string, cp pipeline.Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[name] = cp
+	return nil
+}
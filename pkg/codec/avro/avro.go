@@ -0,0 +1,178 @@
+// Package avro Avro 二进制格式和对象容器文件。流式读写使用容器文件，文件头中保存 writer schema，
+// 读取时可以指定 reader schema，按 Avro 的 schema 解析规则处理字段的增删、改名和类型提升
+package avro
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/schema"
+)
+
+const Name = "avro"
+
+// 容器文件的块压缩方式，与 avro.codec 元数据的取值相同
+const (
+	CompressionNull    = "null"
+	CompressionDeflate = "deflate"
+	CompressionSnappy  = "snappy"
+)
+
+// DefaultBlockSize 块中未压缩的数据达到该大小时写出
+const DefaultBlockSize = 64 << 10
+
+func init() {
+	codec.Register(Name, func(opts codec.Options) (codec.Codec, error) {
+		params := opts.Params
+		if err := codec.CheckParams(Name, params, "schema_file", "reader_schema_file", "compression", "block_size"); err != nil {
+			return nil, err
+		}
+		config := Config{Compression: strings.ToLower(params["compression"])}
+		var err error
+		if path := params["schema_file"]; path != "" {
+			if config.Schema, err = os.ReadFile(path); err != nil {
+				return nil, fmt.Errorf("codec %s: %w", Name, err)
+			}
+		} else if opts.Schema != nil {
+			if config.Schema, err = schema.ToAvro(opts.Schema); err != nil {
+				return nil, fmt.Errorf("codec %s: %w", Name, err)
+			}
+		}
+		if path := params["reader_schema_file"]; path != "" {
+			if config.ReaderSchema, err = os.ReadFile(path); err != nil {
+				return nil, fmt.Errorf("codec %s: %w", Name, err)
+			}
+		}
+		if size := params["block_size"]; size != "" {
+			if config.BlockSize, err = strconv.Atoi(size); err != nil || config.BlockSize <= 0 {
+				return nil, fmt.Errorf("codec %s: block_size must be a positive integer, got %q", Name, size)
+			}
+		}
+		return New(config)
+	})
+}
+
+type Config struct {
+	// Schema writer schema (.avsc)，为空时写入第一条记录的 pipeline.Schema 经 schema.ToAvro 转换的结果。
+	// 容器文件读取时使用文件头中的 schema，只有 Codec.Decode 解码单条数据时需要它
+	Schema []byte
+	// ReaderSchema 读取时使用的 schema，为空时与 writer schema 相同
+	ReaderSchema []byte
+	// Compression 容器文件的块压缩方式，默认为 CompressionNull
+	Compression string
+	// BlockSize 默认为 DefaultBlockSize
+	BlockSize int
+}
+
+// Codec 在 Avro 类型和 pipeline 类型之间的映射与 schema.FromAvro 相同：
+// int/long 为 int64，float/double 为 float64，bytes/fixed 为 bytes，enum 为 string，
+// map 和 record 为 dict，["null", T] 为可空的 T，其余 union 为 json；
+// decimal、timestamp-*、date 和 time-* 逻辑类型解码为 decimal、timestamp 和 duration
+type Codec struct {
+	config Config
+	writer *avroType
+	reader *avroType
+	schema *pipeline.Schema
+}
+
+func New(config Config) (*Codec, error) {
+	if config.Compression == "" {
+		config.Compression = CompressionNull
+	}
+	if !validCompression(config.Compression) {
+		return nil, fmt.Errorf("codec %s: compression must be null, deflate or snappy, got %q", Name, config.Compression)
+	}
+	if config.BlockSize <= 0 {
+		config.BlockSize = DefaultBlockSize
+	}
+
+	c := &Codec{config: config}
+	var err error
+	if config.Schema != nil {
+		if c.writer, err = parseSchema(config.Schema); err != nil {
+			return nil, fmt.Errorf("codec %s: writer schema: %w", Name, err)
+		}
+		if c.schema, err = schema.FromAvro(config.Schema); err != nil {
+			return nil, fmt.Errorf("codec %s: writer schema: %w", Name, err)
+		}
+	}
+	if config.ReaderSchema != nil {
+		if c.reader, err = parseSchema(config.ReaderSchema); err != nil {
+			return nil, fmt.Errorf("codec %s: reader schema: %w", Name, err)
+		}
+		if c.schema, err = schema.FromAvro(config.ReaderSchema); err != nil {
+			return nil, fmt.Errorf("codec %s: reader schema: %w", Name, err)
+		}
+	}
+	return c, nil
+}
+
+func validCompression(name string) bool {
+	return name == CompressionNull || name == CompressionDeflate || name == CompressionSnappy
+}
+
+func (c *Codec) Name() string {
+	return Name
+}
+
+// Decode 解码单条不带容器的 Avro 二进制数据，需要 Config.Schema
+func (c *Codec) Decode(data []byte) (*pipeline.Record, error) {
+	if c.writer == nil {
+		return nil, fmt.Errorf("decoding a single Avro datum requires a writer schema")
+	}
+	rd := c.writer
+	if c.reader != nil {
+		rd = c.reader
+	}
+	r := &reader{buf: data}
+	value, err := decodeValue(r, c.writer, rd)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("%d bytes after the Avro datum", len(data)-r.pos)
+	}
+	return &pipeline.Record{
+		Schema:    c.schema,
+		Data:      value.Value.(map[string]pipeline.Value),
+		Timestamp: time.Now(),
+	}, nil
+}
+
+// Encode 编码为单条不带容器的 Avro 二进制数据
+func (c *Codec) Encode(record *pipeline.Record) ([]byte, error) {
+	writer := c.writer
+	if writer == nil {
+		var err error
+		if _, writer, err = writerFor(record); err != nil {
+			return nil, err
+		}
+	}
+	return encodeFields(nil, writer, record.Data)
+}
+
+func (c *Codec) NewDecoder(r io.Reader) codec.Decoder {
+	return &containerDecoder{codec: c, in: &stream{r: bufio.NewReader(r)}}
+}
+
+func (c *Codec) NewEncoder(w io.Writer) codec.Encoder {
+	return &containerEncoder{codec: c, w: w}
+}
+
+// compact 去掉 schema 中的空白，写入文件头
+func compact(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,373 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const orderSchema = `{
+  "type": "record", "name": "Order", "namespace": "shop",
+  "fields": [
+    {"name": "id", "type": "long"},
+    {"name": "qty", "type": "int"},
+    {"name": "customer", "type": ["null", "string"], "default": null},
+    {"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+    {"name": "at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "PAID"]}},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "attrs", "type": {"type": "map", "values": "double"}},
+    {"name": "extra", "type": ["null", "long", "string"], "default": null}
+  ]
+}`
+
+func mustNew(t *testing.T, config Config) *Codec {
+	t.Helper()
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func order(id int64, price string) *pipeline.Record {
+	return &pipeline.Record{Data: map[string]pipeline.Value{
+		"id":       pipeline.ValueOf(id),
+		"qty":      pipeline.ValueOf(3),
+		"customer": pipeline.ValueOf("ann"),
+		"price":    pipeline.ValueOf(pipeline.MustParseDecimal(price)),
+		"at":       pipeline.ValueOf(time.Date(2024, 5, 6, 7, 8, 9, 123e6, time.UTC)),
+		"status":   pipeline.ValueOf("PAID"),
+		"tags":     pipeline.ValueOf([]any{"a", "b"}),
+		"attrs":    pipeline.ValueOf(map[string]any{"w": 1.5}),
+		"extra":    pipeline.Null,
+	}}
+}
+
+func writeAll(t *testing.T, c *Codec, records ...*pipeline.Record) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder := c.NewEncoder(&buf)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, c *Codec, data []byte) []*pipeline.Record {
+	t.Helper()
+	decoder := c.NewDecoder(bytes.NewReader(data))
+	var records []*pipeline.Record
+	for {
+		record, err := decoder.Decode()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestContainer_RoundTrip(t *testing.T) {
+	for _, compression := range []string{CompressionNull, CompressionDeflate, CompressionSnappy} {
+		t.Run(compression, func(t *testing.T) {
+			// a small block size spreads the records over several blocks
+			c := mustNew(t, Config{Schema: []byte(orderSchema), Compression: compression, BlockSize: 64})
+			var records []*pipeline.Record
+			for i := 0; i < 20; i++ {
+				records = append(records, order(int64(i), fmt.Sprintf("%d.99", i)))
+			}
+			data := writeAll(t, c, records...)
+			if !bytes.HasPrefix(data, magic) {
+				t.Fatalf("missing magic: %q", data[:4])
+			}
+
+			got := readAll(t, mustNew(t, Config{}), data)
+			if len(got) != len(records) {
+				t.Fatalf("records = %d", len(got))
+			}
+			r := got[7].Data
+			if r["id"].Value != int64(7) || r["qty"].Value != int64(3) || r["customer"].Value != "ann" {
+				t.Errorf("scalars = %v", r)
+			}
+			if d := r["price"].Value.(pipeline.Decimal); d.String() != "7.99" {
+				t.Errorf("price = %s", d)
+			}
+			if at := r["at"].Value.(time.Time); !at.Equal(time.Date(2024, 5, 6, 7, 8, 9, 123e6, time.UTC)) {
+				t.Errorf("at = %v", at)
+			}
+			if r["status"].Value != "PAID" || !r["extra"].IsNull() {
+				t.Errorf("status = %v, extra = %v", r["status"], r["extra"])
+			}
+			if !r["tags"].Equal(pipeline.ValueOf([]any{"a", "b"})) || !r["attrs"].Equal(pipeline.ValueOf(map[string]any{"w": 1.5})) {
+				t.Errorf("tags = %v, attrs = %v", r["tags"], r["attrs"])
+			}
+
+			schema := got[0].Schema
+			if schema == nil {
+				t.Fatal("decoded record has no schema")
+			}
+			if f, _ := schema.Lookup("price"); f.Type != pipeline.TypeDecimal || *f.Scale != 2 {
+				t.Errorf("price field = %+v", f)
+			}
+			if f, _ := schema.Lookup("customer"); f.Type != pipeline.TypeString || !f.Nullable {
+				t.Errorf("customer field = %+v", f)
+			}
+		})
+	}
+}
+
+func TestEncode_UnionAndDecimalSign(t *testing.T) {
+	c := mustNew(t, Config{Schema: []byte(orderSchema)})
+	r := order(1, "-128.00")
+	r.Data["extra"] = pipeline.ValueOf("note")
+	r.Data["customer"] = pipeline.Null
+
+	data, err := c.Encode(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := got.Data["price"].Value.(pipeline.Decimal); d.String() != "-128.00" {
+		t.Errorf("price = %s", d)
+	}
+	if !got.Data["customer"].IsNull() {
+		t.Errorf("customer = %v", got.Data["customer"])
+	}
+	// unions with several typed branches are json, like schema.FromAvro
+	if got.Data["extra"].Type != pipeline.TypeJSON || string(got.Data["extra"].Value.(json.RawMessage)) != `"note"` {
+		t.Errorf("extra = %v", got.Data["extra"])
+	}
+
+	// and json values are written back to the matching branch
+	again, err := c.Encode(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("re-encoded = %x, want %x", again, data)
+	}
+}
+
+func TestEncode_Invalid(t *testing.T) {
+	c := mustNew(t, Config{Schema: []byte(orderSchema)})
+	r := order(1, "1")
+	r.Data["status"] = pipeline.ValueOf("LOST")
+	if _, err := c.Encode(r); err == nil {
+		t.Error("unknown enum symbol accepted")
+	}
+
+	r = order(1, "1")
+	delete(r.Data, "id")
+	encoder := c.NewEncoder(io.Discard)
+	if err := encoder.Encode(r); pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("missing required field: %v", err)
+	}
+}
+
+func TestDecode_SchemaResolution(t *testing.T) {
+	writer := `{"type": "record", "name": "User", "fields": [
+	  {"name": "id", "type": "int"},
+	  {"name": "name", "type": "string"},
+	  {"name": "score", "type": "float"},
+	  {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["LOW", "HIGH", "SECRET"]}},
+	  {"name": "dropped", "type": {"type": "array", "items": "long"}}
+	]}`
+	reader := `{"type": "record", "name": "Member", "aliases": ["User"], "fields": [
+	  {"name": "id", "type": "long"},
+	  {"name": "full_name", "aliases": ["name"], "type": "string"},
+	  {"name": "score", "type": ["null", "double"]},
+	  {"name": "level", "type": {"type": "enum", "name": "Level", "symbols": ["LOW", "HIGH", "UNKNOWN"], "default": "UNKNOWN"}},
+	  {"name": "country", "type": "string", "default": "NZ"},
+	  {"name": "limit", "type": {"type": "bytes", "logicalType": "decimal", "scale": 1}, "default": "\u0000d"}
+	]}`
+
+	w := mustNew(t, Config{Schema: []byte(writer), Compression: CompressionDeflate})
+	data := writeAll(t, w,
+		&pipeline.Record{Data: map[string]pipeline.Value{
+			"id": pipeline.ValueOf(1), "name": pipeline.ValueOf("ann"), "score": pipeline.ValueOf(0.5),
+			"level": pipeline.ValueOf("HIGH"), "dropped": pipeline.ValueOf([]any{int64(1)}),
+		}},
+		&pipeline.Record{Data: map[string]pipeline.Value{
+			"id": pipeline.ValueOf(2), "name": pipeline.ValueOf("bob"), "score": pipeline.ValueOf(2),
+			"level": pipeline.ValueOf("SECRET"), "dropped": pipeline.ValueOf([]any{}),
+		}},
+	)
+
+	got := readAll(t, mustNew(t, Config{ReaderSchema: []byte(reader)}), data)
+	if len(got) != 2 {
+		t.Fatalf("records = %d", len(got))
+	}
+	first, second := got[0].Data, got[1].Data
+	if first["id"].Value != int64(1) || first["full_name"].Value != "ann" || first["score"].Value != 0.5 {
+		t.Errorf("first = %v", first)
+	}
+	if first["level"].Value != "HIGH" || second["level"].Value != "UNKNOWN" {
+		t.Errorf("levels = %v, %v", first["level"], second["level"])
+	}
+	if first["country"].Value != "NZ" || first["limit"].Value.(pipeline.Decimal).String() != "10.0" {
+		t.Errorf("defaults = %v, %v", first["country"], first["limit"])
+	}
+	if _, ok := first["dropped"]; ok {
+		t.Error("field missing from the reader schema was kept")
+	}
+	if _, ok := got[0].Schema.Lookup("full_name"); !ok {
+		t.Error("record schema does not follow the reader schema")
+	}
+
+	incompatible := `{"type": "record", "name": "User", "fields": [{"name": "id", "type": "string"}]}`
+	decoder := mustNew(t, Config{ReaderSchema: []byte(incompatible)}).NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Decode(); pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("int read as string: %v", err)
+	}
+}
+
+func TestDecode_Corrupt(t *testing.T) {
+	data := writeAll(t, mustNew(t, Config{Schema: []byte(orderSchema)}), order(1, "1"))
+	data[len(data)-1] ^= 0xff
+	decoder := mustNew(t, Config{}).NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Decode(); pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("bad sync marker: %v", err)
+	}
+
+	decoder = mustNew(t, Config{}).NewDecoder(bytes.NewReader([]byte("id,name\n")))
+	if _, err := decoder.Decode(); err == nil || err == io.EOF {
+		t.Errorf("not an Avro file: %v", err)
+	}
+}
+
+func TestDecode_OversizedLengths(t *testing.T) {
+	header := func(meta ...[]byte) []byte {
+		data := append(append([]byte(nil), magic...), appendLong(nil, int64(len(meta)/2))...)
+		for _, b := range meta {
+			data = appendBytes(data, b)
+		}
+		return append(appendLong(data, 0), make([]byte, syncSize)...)
+	}
+
+	// a key length of 2^62 is rejected instead of allocated
+	data := append(append([]byte(nil), magic...), appendLong(appendLong(nil, 1), 1<<62)...)
+	decoder := mustNew(t, Config{}).NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Decode(); err == nil || err == io.EOF {
+		t.Errorf("huge header length: %v", err)
+	}
+
+	data = appendLong(appendLong(header([]byte(metaSchema), []byte(orderSchema)), 1), MaxBlockSize+1)
+	decoder = mustNew(t, Config{}).NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Decode(); pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("huge block size: %v", err)
+	}
+
+	// zero-byte items cannot claim more entries than there are bytes left
+	c := mustNew(t, Config{Schema: []byte(`{"type":"record","name":"R","fields":[{"name":"a","type":{"type":"array","items":"null"}}]}`)})
+	if _, err := c.Decode(appendLong(nil, 1<<40)); err == nil {
+		t.Error("huge array count accepted")
+	}
+	if _, err := c.Decode(appendLong(appendLong(nil, 1), 0)); err != nil {
+		t.Errorf("array of nulls: %v", err)
+	}
+}
+
+func TestFactory(t *testing.T) {
+	schema := &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "id", Type: pipeline.TypeInt64, Required: true},
+		{Name: "note", Type: pipeline.TypeString, Nullable: true},
+	}}
+	c, err := codec.New("avro", codec.Options{Schema: schema, Params: map[string]string{"compression": "snappy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := writeAll(t, c.(*Codec), &pipeline.Record{Data: map[string]pipeline.Value{"id": pipeline.ValueOf(5)}})
+	got := readAll(t, mustNew(t, Config{}), data)
+	if len(got) != 1 || got[0].Data["id"].Value != int64(5) || !got[0].Data["note"].IsNull() {
+		t.Errorf("got %v", got)
+	}
+
+	if _, err := codec.New("avro", codec.Options{Params: map[string]string{"compression": "lzma"}}); err == nil {
+		t.Error("unknown compression accepted")
+	}
+}
+
+func TestFile_SinkAndSeek(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir, "orders", Config{Schema: []byte(orderSchema), Compression: CompressionSnappy, BlockSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(&pipeline.Message{ID: fmt.Sprint(i), Payload: order(int64(i), "1.00")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Prepare(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "orders-*"+Ext))
+	if len(paths) != 1 {
+		t.Fatalf("published files = %v", paths)
+	}
+
+	source, err := NewFileSource(paths[0], Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	var positions [][]byte
+	for i := 0; i < 10; i++ {
+		position, err := source.Position()
+		if err != nil {
+			t.Fatal(err)
+		}
+		positions = append(positions, position)
+		msg, err := source.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Payload.Data["id"].Value != int64(i) {
+			t.Fatalf("record %d = %v", i, msg.Payload.Data["id"])
+		}
+	}
+	if _, err := source.Read(); err != io.EOF {
+		t.Fatalf("after the last record: %v", err)
+	}
+
+	// positions in the middle of a block and at block boundaries both resume at the right record
+	for _, i := range []int{0, 3, 7, 9} {
+		if err := source.Seek(positions[i]); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := source.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Payload.Data["id"].Value != int64(i) {
+			t.Errorf("seek to %d read %v", i, msg.Payload.Data["id"])
+		}
+	}
+
+	info, err := os.Stat(paths[0])
+	if err != nil || info.Size() == 0 {
+		t.Fatalf("published file: %v", err)
+	}
+}
@@ -0,0 +1,856 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+var errShortBuffer = errors.New("unexpected end of Avro data")
+
+// reader 从内存中的 Avro 二进制数据读取，容器文件的块在解压后整体读入
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) long() (int64, error) {
+	u, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		if n == 0 {
+			return 0, errShortBuffer
+		}
+		return 0, fmt.Errorf("Avro long overflows 64 bits")
+	}
+	r.pos += n
+	// zigzag
+	return int64(u>>1) ^ -int64(u&1), nil
+}
+
+func (r *reader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.buf)-r.pos {
+		return nil, errShortBuffer
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) bytes() ([]byte, error) {
+	n, err := r.long()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > int64(len(r.buf)-r.pos) {
+		return nil, errShortBuffer
+	}
+	return r.next(int(n))
+}
+
+// blockCount 读取 array 和 map 的块长度，负数表示后面跟着块的字节数。
+// 长度超过剩余的字节数时视为损坏的数据，避免按伪造的长度空转
+func (r *reader) blockCount() (int64, error) {
+	n, err := r.long()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		if n == math.MinInt64 {
+			return 0, fmt.Errorf("invalid Avro block count %d", n)
+		}
+		n = -n
+		if _, err := r.long(); err != nil {
+			return 0, err
+		}
+	}
+	if n > int64(len(r.buf)-r.pos) {
+		return 0, fmt.Errorf("Avro block count %d exceeds the %d remaining bytes", n, len(r.buf)-r.pos)
+	}
+	return n, nil
+}
+
+func appendLong(buf []byte, n int64) []byte {
+	return binary.AppendUvarint(buf, uint64((n<<1)^(n>>63)))
+}
+
+func appendBytes(buf, b []byte) []byte {
+	return append(appendLong(buf, int64(len(b))), b...)
+}
+
+// decodeValue 按 writer schema 读取一个值，并按 reader schema 解释。没有 reader schema 时两者相同。
+// 类型提升、字段按名称或别名匹配、enum 和 union 的分支匹配遵循 Avro 规范的 schema 解析规则
+func decodeValue(r *reader, writer, rd *avroType) (pipeline.Value, error) {
+	if writer.kind == "union" {
+		i, err := r.long()
+		if err != nil {
+			return pipeline.Value{}, err
+		}
+		if i < 0 || i >= int64(len(writer.branches)) {
+			return pipeline.Value{}, fmt.Errorf("union branch %d out of range", i)
+		}
+		return decodeValue(r, writer.branches[i], rd)
+	}
+	if rd.kind == "union" {
+		branch := resolveBranch(writer, rd)
+		if branch == nil {
+			return pipeline.Value{}, fmt.Errorf("no branch of the reader union matches %s", describe(writer))
+		}
+		v, err := decodeValue(r, writer, branch)
+		if err != nil || v.IsNull() || !generalUnion(rd) {
+			return v, err
+		}
+		return toJSON(v)
+	}
+
+	switch writer.kind {
+	case "record":
+		return decodeRecord(r, writer, rd)
+	case "enum":
+		return decodeEnum(r, writer, rd)
+	case "array":
+		if rd.kind != "array" {
+			return pipeline.Value{}, mismatch(writer, rd)
+		}
+		var list []pipeline.Value
+		for {
+			n, err := r.blockCount()
+			if err != nil || n == 0 {
+				if list == nil {
+					list = []pipeline.Value{}
+				}
+				return pipeline.Value{Type: pipeline.TypeList, Value: list}, err
+			}
+			for ; n > 0; n-- {
+				item, err := decodeValue(r, writer.items, rd.items)
+				if err != nil {
+					return pipeline.Value{}, err
+				}
+				list = append(list, item)
+			}
+		}
+	case "map":
+		if rd.kind != "map" {
+			return pipeline.Value{}, mismatch(writer, rd)
+		}
+		dict := make(map[string]pipeline.Value)
+		for {
+			n, err := r.blockCount()
+			if err != nil || n == 0 {
+				return pipeline.Value{Type: pipeline.TypeDict, Value: dict}, err
+			}
+			for ; n > 0; n-- {
+				key, err := r.bytes()
+				if err != nil {
+					return pipeline.Value{}, err
+				}
+				item, err := decodeValue(r, writer.values, rd.values)
+				if err != nil {
+					return pipeline.Value{}, err
+				}
+				dict[string(key)] = item
+			}
+		}
+	}
+
+	raw, err := readPrimitive(r, writer)
+	if err != nil {
+		return pipeline.Value{}, err
+	}
+	if !promotable(writer, rd) {
+		return pipeline.Value{}, mismatch(writer, rd)
+	}
+	return fromRaw(rd, raw)
+}
+
+func readPrimitive(r *reader, t *avroType) (any, error) {
+	switch t.kind {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int", "long":
+		return r.long()
+	case "float":
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case "double":
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "bytes":
+		b, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case "string":
+		b, err := r.bytes()
+		return string(b), err
+	case "fixed":
+		b, err := r.next(t.size)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	}
+	return nil, fmt.Errorf("unsupported Avro type %s", t.kind)
+}
+
+// promotable 判断 writer 的基本类型能否按 reader 的类型读取
+func promotable(writer, rd *avroType) bool {
+	if writer.kind == rd.kind {
+		if writer.kind == "fixed" {
+			return writer.size == rd.size && matchesName(writer, rd)
+		}
+		return true
+	}
+	switch writer.kind {
+	case "int":
+		return rd.kind == "long" || rd.kind == "float" || rd.kind == "double"
+	case "long":
+		return rd.kind == "float" || rd.kind == "double"
+	case "float":
+		return rd.kind == "double"
+	case "string":
+		return rd.kind == "bytes"
+	case "bytes":
+		return rd.kind == "string"
+	}
+	return false
+}
+
+// fromRaw 把基本类型的原始值转换为 reader 类型对应的 pipeline 值，包括逻辑类型
+func fromRaw(t *avroType, raw any) (pipeline.Value, error) {
+	switch t.kind {
+	case "null":
+		return pipeline.Null, nil
+	case "boolean":
+		return pipeline.Value{Type: pipeline.TypeBoolean, Value: raw}, nil
+	case "int", "long":
+		return fromLong(t, raw.(int64)), nil
+	case "float", "double":
+		switch n := raw.(type) {
+		case int64:
+			return pipeline.Value{Type: pipeline.TypeFloat64, Value: float64(n)}, nil
+		default:
+			return pipeline.Value{Type: pipeline.TypeFloat64, Value: n}, nil
+		}
+	case "string":
+		if b, ok := raw.([]byte); ok {
+			raw = string(b)
+		}
+		return pipeline.Value{Type: pipeline.TypeString, Value: raw}, nil
+	case "bytes", "fixed":
+		b, ok := raw.([]byte)
+		if !ok {
+			b = []byte(raw.(string))
+		}
+		if t.logical == "decimal" {
+			return pipeline.Value{Type: pipeline.TypeDecimal, Value: decimalFromBytes(b, t.scale)}, nil
+		}
+		return pipeline.Value{Type: pipeline.TypeBytes, Value: b}, nil
+	}
+	return pipeline.Value{}, fmt.Errorf("unsupported Avro type %s", t.kind)
+}
+
+// fromLong 时间戳逻辑类型按 UTC 解释，local-* 同样按 UTC 保存墙上时间
+func fromLong(t *avroType, n int64) pipeline.Value {
+	switch t.logical {
+	case "timestamp-millis", "local-timestamp-millis":
+		return pipeline.Value{Type: pipeline.TypeTimestamp, Value: time.UnixMilli(n).UTC()}
+	case "timestamp-micros", "local-timestamp-micros":
+		return pipeline.Value{Type: pipeline.TypeTimestamp, Value: time.UnixMicro(n).UTC()}
+	case "timestamp-nanos", "local-timestamp-nanos":
+		return pipeline.Value{Type: pipeline.TypeTimestamp, Value: time.Unix(0, n).UTC()}
+	case "date":
+		return pipeline.Value{Type: pipeline.TypeTimestamp, Value: time.Unix(n*secondsPerDay, 0).UTC()}
+	case "time-millis":
+		return pipeline.Value{Type: pipeline.TypeDuration, Value: time.Duration(n) * time.Millisecond}
+	case "time-micros":
+		return pipeline.Value{Type: pipeline.TypeDuration, Value: time.Duration(n) * time.Microsecond}
+	}
+	return pipeline.Value{Type: pipeline.TypeInt64, Value: n}
+}
+
+const secondsPerDay = 24 * 60 * 60
+
+func decodeRecord(r *reader, writer, rd *avroType) (pipeline.Value, error) {
+	if rd.kind != "record" || !matchesName(writer, rd) {
+		return pipeline.Value{}, mismatch(writer, rd)
+	}
+	dict := make(map[string]pipeline.Value, len(rd.fields))
+	for _, wf := range writer.fields {
+		rf := readerField(wf, rd)
+		if rf == nil {
+			// fields the reader does not know are read and dropped
+			if _, err := decodeValue(r, wf.typ, wf.typ); err != nil {
+				return pipeline.Value{}, fmt.Errorf("%s: %w", wf.name, err)
+			}
+			continue
+		}
+		v, err := decodeValue(r, wf.typ, rf.typ)
+		if err != nil {
+			return pipeline.Value{}, fmt.Errorf("%s: %w", wf.name, err)
+		}
+		dict[rf.name] = v
+	}
+	if writer == rd {
+		return pipeline.Value{Type: pipeline.TypeDict, Value: dict}, nil
+	}
+	for _, rf := range rd.fields {
+		if _, ok := dict[rf.name]; ok {
+			continue
+		}
+		if !rf.hasDefault {
+			return pipeline.Value{}, fmt.Errorf("%s: field is missing from the writer schema and has no default", rf.name)
+		}
+		v, err := defaultValue(rf.typ, rf.def)
+		if err != nil {
+			return pipeline.Value{}, fmt.Errorf("%s: default: %w", rf.name, err)
+		}
+		dict[rf.name] = v
+	}
+	return pipeline.Value{Type: pipeline.TypeDict, Value: dict}, nil
+}
+
+func readerField(wf *avroField, rd *avroType) *avroField {
+	for _, rf := range rd.fields {
+		if rf.name == wf.name {
+			return rf
+		}
+	}
+	for _, rf := range rd.fields {
+		for _, alias := range rf.aliases {
+			if alias == wf.name {
+				return rf
+			}
+		}
+	}
+	return nil
+}
+
+func decodeEnum(r *reader, writer, rd *avroType) (pipeline.Value, error) {
+	i, err := r.long()
+	if err != nil {
+		return pipeline.Value{}, err
+	}
+	if i < 0 || i >= int64(len(writer.symbols)) {
+		return pipeline.Value{}, fmt.Errorf("enum %s: symbol %d out of range", writer.name, i)
+	}
+	symbol := writer.symbols[i]
+	if rd.kind != "enum" || !matchesName(writer, rd) {
+		return pipeline.Value{}, mismatch(writer, rd)
+	}
+	if writer != rd && !contains(rd.symbols, symbol) {
+		if rd.enumDef == "" {
+			return pipeline.Value{}, fmt.Errorf("enum %s: reader has no symbol %s", rd.name, symbol)
+		}
+		symbol = rd.enumDef
+	}
+	return pipeline.Value{Type: pipeline.TypeString, Value: symbol}, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveBranch 选择 reader union 中读取 writer 类型的分支，先找类型相同的，再找可以提升的
+func resolveBranch(writer *avroType, union *avroType) *avroType {
+	for _, b := range union.branches {
+		if b.kind == writer.kind && (b.name == "" || matchesName(writer, b)) {
+			return b
+		}
+	}
+	for _, b := range union.branches {
+		if b.name == "" && promotable(writer, b) {
+			return b
+		}
+	}
+	return nil
+}
+
+// generalUnion 除 null 外有多个分支的 union，对应 pipeline 的 json 类型
+func generalUnion(t *avroType) bool {
+	if t.kind != "union" {
+		return false
+	}
+	typed := 0
+	for _, b := range t.branches {
+		if b.kind != "null" {
+			typed++
+		}
+	}
+	return typed != 1
+}
+
+// toJSON 把 union 的值保存为 JSON，值本身不带分支的类型名
+func toJSON(v pipeline.Value) (pipeline.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return pipeline.Value{}, err
+	}
+	return pipeline.Value{Type: pipeline.TypeJSON, Value: json.RawMessage(data)}, nil
+}
+
+func describe(t *avroType) string {
+	if t.name != "" {
+		return t.kind + " " + t.name
+	}
+	if t.logical != "" {
+		return t.kind + " (" + t.logical + ")"
+	}
+	return t.kind
+}
+
+func mismatch(writer, rd *avroType) error {
+	return fmt.Errorf("writer type %s cannot be read as %s", describe(writer), describe(rd))
+}
+
+// decimalFromBytes 解码大端序的二进制补码
+func decimalFromBytes(b []byte, scale int) pipeline.Decimal {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return pipeline.NewDecimalFromBigInt(n, int32(scale))
+}
+
+// decimalBytes 编码为最短的二进制补码，size 大于 0 时按符号扩展到 size 字节
+func decimalBytes(d pipeline.Decimal, size int) ([]byte, error) {
+	n := d.Unscaled()
+	var b []byte
+	if n.Sign() >= 0 {
+		b = n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+	} else {
+		// the smallest k with -2^(8k-1) <= n
+		k := new(big.Int).Sub(new(big.Int).Neg(n), big.NewInt(1)).BitLen()/8 + 1
+		b = n.Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8*k))).FillBytes(make([]byte, k))
+	}
+	if size <= 0 {
+		return b, nil
+	}
+	if len(b) > size {
+		return nil, fmt.Errorf("decimal %s does not fit in %d bytes", d, size)
+	}
+	pad := byte(0)
+	if b[0]&0x80 != 0 {
+		pad = 0xff
+	}
+	fixed := make([]byte, size)
+	for i := range fixed[:size-len(b)] {
+		fixed[i] = pad
+	}
+	copy(fixed[size-len(b):], b)
+	return fixed, nil
+}
+
+// defaultValue 把 schema 中字段的 JSON 默认值转换为 pipeline 值。
+// union 的默认值对应第一个分支，bytes 和 fixed 的默认值是码点为 0-255 的字符串
+func defaultValue(t *avroType, def any) (pipeline.Value, error) {
+	switch t.kind {
+	case "union":
+		v, err := defaultValue(t.branches[0], def)
+		if err != nil || v.IsNull() || !generalUnion(t) {
+			return v, err
+		}
+		return toJSON(v)
+	case "null":
+		if def != nil {
+			return pipeline.Value{}, fmt.Errorf("null default must be null")
+		}
+		return pipeline.Null, nil
+	case "record":
+		members, ok := def.(map[string]any)
+		if !ok {
+			return pipeline.Value{}, fmt.Errorf("record default must be an object")
+		}
+		dict := make(map[string]pipeline.Value, len(t.fields))
+		for _, f := range t.fields {
+			member, ok := members[f.name]
+			if !ok {
+				if !f.hasDefault {
+					return pipeline.Value{}, fmt.Errorf("%s: missing from default", f.name)
+				}
+				member = f.def
+			}
+			v, err := defaultValue(f.typ, member)
+			if err != nil {
+				return pipeline.Value{}, fmt.Errorf("%s: %w", f.name, err)
+			}
+			dict[f.name] = v
+		}
+		return pipeline.Value{Type: pipeline.TypeDict, Value: dict}, nil
+	case "array":
+		items, ok := def.([]any)
+		if !ok {
+			return pipeline.Value{}, fmt.Errorf("array default must be an array")
+		}
+		list := make([]pipeline.Value, len(items))
+		for i, item := range items {
+			v, err := defaultValue(t.items, item)
+			if err != nil {
+				return pipeline.Value{}, err
+			}
+			list[i] = v
+		}
+		return pipeline.Value{Type: pipeline.TypeList, Value: list}, nil
+	case "map":
+		members, ok := def.(map[string]any)
+		if !ok {
+			return pipeline.Value{}, fmt.Errorf("map default must be an object")
+		}
+		dict := make(map[string]pipeline.Value, len(members))
+		for name, member := range members {
+			v, err := defaultValue(t.values, member)
+			if err != nil {
+				return pipeline.Value{}, err
+			}
+			dict[name] = v
+		}
+		return pipeline.Value{Type: pipeline.TypeDict, Value: dict}, nil
+	case "enum", "string":
+		s, ok := def.(string)
+		if !ok {
+			return pipeline.Value{}, fmt.Errorf("%s default must be a string", t.kind)
+		}
+		return pipeline.Value{Type: pipeline.TypeString, Value: s}, nil
+	case "boolean":
+		b, ok := def.(bool)
+		if !ok {
+			return pipeline.Value{}, fmt.Errorf("boolean default must be true or false")
+		}
+		return pipeline.Value{Type: pipeline.TypeBoolean, Value: b}, nil
+	case "int", "long":
+		n, ok := def.(json.Number)
+		if !ok {
+			return pipeline.Value{}, fmt.Errorf("%s default must be a number", t.kind)
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return pipeline.Value{}, err
+		}
+		return fromLong(t, i), nil
+	case "float", "double":
+		n, ok := def.(json.Number)
+		if !ok {
+			return pipeline.Value{}, fmt.Errorf("%s default must be a number", t.kind)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return pipeline.Value{}, err
+		}
+		return pipeline.Value{Type: pipeline.TypeFloat64, Value: f}, nil
+	case "bytes", "fixed":
+		s, ok := def.(string)
+		if !ok {
+			return pipeline.Value{}, fmt.Errorf("%s default must be a string", t.kind)
+		}
+		b := make([]byte, 0, len(s))
+		for _, c := range s {
+			if c > 0xff {
+				return pipeline.Value{}, fmt.Errorf("%s default has a code point above 255", t.kind)
+			}
+			b = append(b, byte(c))
+		}
+		return fromRaw(t, b)
+	}
+	return pipeline.Value{}, fmt.Errorf("unsupported Avro type %s", t.kind)
+}
+
+// encodeValue 按 schema 写入一个值，值先经 pipeline.FromNative 转换为对应的类型，
+// 因此 int64 可以写入 double、RFC 3339 字符串可以写入时间戳
+func encodeValue(buf []byte, t *avroType, v pipeline.Value) ([]byte, error) {
+	switch t.kind {
+	case "null":
+		if !v.IsNull() {
+			return nil, fmt.Errorf("expected null, got %s", v.Type)
+		}
+		return buf, nil
+	case "union":
+		return encodeUnion(buf, t, v)
+	}
+	if v.IsNull() {
+		return nil, fmt.Errorf("null value for non-null %s", describe(t))
+	}
+
+	switch t.kind {
+	case "record":
+		dict, ok := v.Value.(map[string]pipeline.Value)
+		if !ok {
+			return nil, fmt.Errorf("expected dict for record %s, got %s", t.name, v.Type)
+		}
+		return encodeFields(buf, t, dict)
+	case "array":
+		list, ok := v.Value.([]pipeline.Value)
+		if !ok {
+			return nil, fmt.Errorf("expected list, got %s", v.Type)
+		}
+		if len(list) > 0 {
+			buf = appendLong(buf, int64(len(list)))
+			for i, item := range list {
+				var err error
+				if buf, err = encodeValue(buf, t.items, item); err != nil {
+					return nil, fmt.Errorf("[%d]: %w", i, err)
+				}
+			}
+		}
+		return appendLong(buf, 0), nil
+	case "map":
+		dict, ok := v.Value.(map[string]pipeline.Value)
+		if !ok {
+			return nil, fmt.Errorf("expected dict for map, got %s", v.Type)
+		}
+		if len(dict) > 0 {
+			buf = appendLong(buf, int64(len(dict)))
+			for _, name := range sortedKeys(dict) {
+				buf = appendBytes(buf, []byte(name))
+				var err error
+				if buf, err = encodeValue(buf, t.values, dict[name]); err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+		return appendLong(buf, 0), nil
+	case "enum":
+		s, ok := v.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected string for enum %s, got %s", t.name, v.Type)
+		}
+		for i, symbol := range t.symbols {
+			if symbol == s {
+				return appendLong(buf, int64(i)), nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a symbol of enum %s", s, t.name)
+	case "boolean":
+		b, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeBoolean})
+		if err != nil {
+			return nil, err
+		}
+		if b.Value.(bool) {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case "int", "long":
+		n, err := toLong(t, v)
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == "int" && (n < math.MinInt32 || n > math.MaxInt32) {
+			return nil, fmt.Errorf("%d overflows Avro int", n)
+		}
+		return appendLong(buf, n), nil
+	case "float", "double":
+		f, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeFloat64})
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == "float" {
+			return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(f.Value.(float64)))), nil
+		}
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f.Value.(float64))), nil
+	case "string":
+		switch s := v.Value.(type) {
+		case string:
+			return appendBytes(buf, []byte(s)), nil
+		case json.RawMessage:
+			return appendBytes(buf, s), nil
+		}
+		return nil, fmt.Errorf("expected string, got %s", v.Type)
+	case "bytes", "fixed":
+		var b []byte
+		if t.logical == "decimal" {
+			d, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeDecimal})
+			if err != nil {
+				return nil, err
+			}
+			if b, err = decimalBytes(d.Value.(pipeline.Decimal).Rescale(int32(t.scale)), t.size); err != nil {
+				return nil, err
+			}
+		} else {
+			raw, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeBytes})
+			if err != nil {
+				return nil, err
+			}
+			b = raw.Value.([]byte)
+		}
+		if t.kind == "bytes" {
+			return appendBytes(buf, b), nil
+		}
+		if len(b) != t.size {
+			return nil, fmt.Errorf("fixed %s needs %d bytes, got %d", t.name, t.size, len(b))
+		}
+		return append(buf, b...), nil
+	}
+	return nil, fmt.Errorf("unsupported Avro type %s", t.kind)
+}
+
+// encodeFields 写入 record 的字段，记录中缺少的字段使用 schema 的默认值
+func encodeFields(buf []byte, t *avroType, data map[string]pipeline.Value) ([]byte, error) {
+	for _, f := range t.fields {
+		v, ok := data[f.name]
+		if !ok {
+			if !f.hasDefault {
+				v = pipeline.Null
+			} else {
+				var err error
+				if v, err = defaultValue(f.typ, f.def); err != nil {
+					return nil, fmt.Errorf("%s: default: %w", f.name, err)
+				}
+			}
+		}
+		var err error
+		if buf, err = encodeValue(buf, f.typ, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	return buf, nil
+}
+
+func toLong(t *avroType, v pipeline.Value) (int64, error) {
+	switch t.logical {
+	case "timestamp-millis", "local-timestamp-millis", "timestamp-micros", "local-timestamp-micros",
+		"timestamp-nanos", "local-timestamp-nanos", "date":
+		ts, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeTimestamp})
+		if err != nil {
+			return 0, err
+		}
+		tm := ts.Value.(time.Time)
+		switch t.logical {
+		case "timestamp-millis", "local-timestamp-millis":
+			return tm.UnixMilli(), nil
+		case "timestamp-micros", "local-timestamp-micros":
+			return tm.UnixMicro(), nil
+		case "date":
+			days := tm.Unix() / secondsPerDay
+			if tm.Unix() < 0 && tm.Unix()%secondsPerDay != 0 {
+				days--
+			}
+			return days, nil
+		}
+		return tm.UnixNano(), nil
+	case "time-millis", "time-micros":
+		d, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeDuration})
+		if err != nil {
+			return 0, err
+		}
+		if t.logical == "time-millis" {
+			return d.Value.(time.Duration).Milliseconds(), nil
+		}
+		return d.Value.(time.Duration).Microseconds(), nil
+	}
+	n, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeInt64})
+	if err != nil {
+		return 0, err
+	}
+	return n.Value.(int64), nil
+}
+
+// encodeUnion 空值写入 null 分支；json 值先解析，其余值依次尝试各分支，
+// 优先选择 pipeline 类型与分支直接对应的
+func encodeUnion(buf []byte, t *avroType, v pipeline.Value) ([]byte, error) {
+	if v.IsNull() {
+		for i, b := range t.branches {
+			if b.kind == "null" {
+				return appendLong(buf, int64(i)), nil
+			}
+		}
+		return nil, fmt.Errorf("null value for a union without null")
+	}
+	if raw, ok := v.Value.(json.RawMessage); ok && generalUnion(t) {
+		var native any
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&native); err != nil {
+			return nil, err
+		}
+		v = pipeline.ValueOf(native)
+		if v.IsNull() {
+			return encodeUnion(buf, t, v)
+		}
+	}
+
+	order := make([]int, 0, len(t.branches))
+	for i, b := range t.branches {
+		if b.kind != "null" && natural(b) == v.Type {
+			order = append(order, i)
+		}
+	}
+	for i, b := range t.branches {
+		if b.kind != "null" && natural(b) != v.Type {
+			order = append(order, i)
+		}
+	}
+	var firstErr error
+	for _, i := range order {
+		out, err := encodeValue(appendLong(buf, int64(i)), t.branches[i], v)
+		if err == nil {
+			return out, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = fmt.Errorf("no branch for %s", v.Type)
+	}
+	return nil, firstErr
+}
+
+// natural Avro 类型解码后的 pipeline 类型
+func natural(t *avroType) pipeline.FieldType {
+	switch t.kind {
+	case "boolean":
+		return pipeline.TypeBoolean
+	case "int", "long":
+		return fromLong(t, 0).Type
+	case "float", "double":
+		return pipeline.TypeFloat64
+	case "bytes", "fixed":
+		if t.logical == "decimal" {
+			return pipeline.TypeDecimal
+		}
+		return pipeline.TypeBytes
+	case "string", "enum":
+		return pipeline.TypeString
+	case "array":
+		return pipeline.TypeList
+	case "map", "record":
+		return pipeline.TypeDict
+	}
+	return pipeline.TypeNull
+}
+
+func sortedKeys(dict map[string]pipeline.Value) []string {
+	keys := make([]string, 0, len(dict))
+	for name := range dict {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,452 @@
+package avro
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/schema"
+)
+
+// magic 对象容器文件的开头
+var magic = []byte{'O', 'b', 'j', 1}
+
+const (
+	metaSchema = "avro.schema"
+	metaCodec  = "avro.codec"
+	syncSize   = 16
+
+	// indexBits 位置的低位保存块内的记录序号，高位保存块的起始偏移
+	indexBits = 24
+	maxBlock  = 1<<indexBits - 1
+)
+
+// MaxBlockSize 块在压缩前后以及文件头中元数据值的长度上限，更大的长度视为损坏的数据
+const MaxBlockSize = 64 << 20
+
+func compress(name string, data []byte) ([]byte, error) {
+	switch name {
+	case CompressionNull:
+		return data, nil
+	case CompressionDeflate:
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		// snappy blocks end with the big-endian CRC32 of the uncompressed data
+		out := snappy.Encode(nil, data)
+		return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(data)), nil
+	}
+	return nil, fmt.Errorf("unknown Avro codec %q", name)
+}
+
+func decompress(name string, data []byte) ([]byte, error) {
+	switch name {
+	case CompressionNull:
+		return data, nil
+	case CompressionDeflate:
+		out, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), MaxBlockSize+1))
+		if err == nil && len(out) > MaxBlockSize {
+			err = fmt.Errorf("deflate block inflates to more than %d bytes", MaxBlockSize)
+		}
+		return out, err
+	case CompressionSnappy:
+		if len(data) < 4 {
+			return nil, fmt.Errorf("snappy block without checksum")
+		}
+		n, err := snappy.DecodedLen(data[:len(data)-4])
+		if err != nil {
+			return nil, err
+		}
+		if n > MaxBlockSize {
+			return nil, fmt.Errorf("snappy block decodes to %d bytes, more than %d", n, MaxBlockSize)
+		}
+		out, err := snappy.Decode(nil, data[:len(data)-4])
+		if err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(out) != binary.BigEndian.Uint32(data[len(data)-4:]) {
+			return nil, fmt.Errorf("snappy block checksum mismatch")
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown Avro codec %q", name)
+}
+
+// stream 记录已读取字节数的输入
+type stream struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+func (s *stream) long() (int64, error) {
+	u, err := binary.ReadUvarint(s)
+	if err != nil {
+		return 0, err
+	}
+	return int64(u>>1) ^ -int64(u&1), nil
+}
+
+func (s *stream) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil {
+		s.pos++
+	}
+	return b, err
+}
+
+// full 较大的长度分段读取，被截断的文件不会按声明的长度一次分配
+func (s *stream) full(n int64) ([]byte, error) {
+	const chunk = 64 << 10
+	if n <= chunk {
+		buf := make([]byte, n)
+		read, err := io.ReadFull(s.r, buf)
+		s.pos += int64(read)
+		return buf, errUnexpected(err)
+	}
+	var buf bytes.Buffer
+	read, err := io.CopyN(&buf, s.r, n)
+	s.pos += read
+	return buf.Bytes(), errUnexpected(err)
+}
+
+func (s *stream) discard(n int64) error {
+	discarded, err := io.CopyN(io.Discard, s.r, n)
+	s.pos += discarded
+	return err
+}
+
+// containerDecoder 读取对象容器文件，文件中的 writer schema 按 Config.ReaderSchema 解析。
+// Offset 报告的位置不是字节偏移：高位为块的起始偏移，低 24 位为块内已读的记录数，
+// Resume 据此跳到块的开头再跳过已读的记录
+type containerDecoder struct {
+	codec *Codec
+	in    *stream
+
+	started     bool
+	writer      *avroType
+	reader      *avroType
+	schema      *pipeline.Schema
+	compression string
+	sync        []byte
+
+	block      reader
+	blockStart int64
+	count      int64
+	index      int64
+}
+
+func (d *containerDecoder) readHeader() error {
+	d.started = true
+	head, err := d.in.full(int64(len(magic)))
+	if err == io.ErrUnexpectedEOF && d.in.pos == 0 {
+		// an empty file has no records
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("read Avro header: %w", err)
+	}
+	if !bytes.Equal(head, magic) {
+		return pipeline.Errorf(pipeline.KindConversion, "not an Avro object container file")
+	}
+
+	meta := make(map[string][]byte)
+	for {
+		n, err := d.in.long()
+		if err != nil {
+			return fmt.Errorf("read Avro header: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		if n < 0 {
+			n = -n
+			if _, err := d.in.long(); err != nil {
+				return fmt.Errorf("read Avro header: %w", err)
+			}
+		}
+		for ; n > 0; n-- {
+			key, err := d.streamBytes()
+			if err != nil {
+				return fmt.Errorf("read Avro header: %w", err)
+			}
+			value, err := d.streamBytes()
+			if err != nil {
+				return fmt.Errorf("read Avro header: %w", err)
+			}
+			meta[string(key)] = value
+		}
+	}
+	if d.sync, err = d.in.full(syncSize); err != nil {
+		return fmt.Errorf("read Avro header: %w", err)
+	}
+
+	d.compression = CompressionNull
+	if c, ok := meta[metaCodec]; ok && len(c) > 0 {
+		d.compression = string(c)
+	}
+	if !validCompression(d.compression) {
+		return pipeline.Errorf(pipeline.KindConversion, "unsupported Avro codec %q", d.compression)
+	}
+	if d.writer, err = parseSchema(meta[metaSchema]); err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "writer schema: %w", err)
+	}
+	readerJSON := meta[metaSchema]
+	d.reader = d.writer
+	if d.codec.reader != nil {
+		readerJSON = d.codec.config.ReaderSchema
+		d.reader = d.codec.reader
+	}
+	if d.schema, err = schema.FromAvro(readerJSON); err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "reader schema: %w", err)
+	}
+	return nil
+}
+
+func (d *containerDecoder) streamBytes() ([]byte, error) {
+	n, err := d.in.long()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > MaxBlockSize {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	return d.in.full(n)
+}
+
+// nextBlock 读取并解压下一个块，文件在块的边界结束时返回 io.EOF
+func (d *containerDecoder) nextBlock() error {
+	d.blockStart = d.in.pos
+	count, err := d.in.long()
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("read Avro block: %w", err)
+	}
+	size, err := d.in.long()
+	if err != nil {
+		return fmt.Errorf("read Avro block: %w", errUnexpected(err))
+	}
+	if count < 0 || count > maxBlock || size < 0 || size > MaxBlockSize {
+		return pipeline.Errorf(pipeline.KindConversion, "invalid Avro block at offset %d: %d records, %d bytes", d.blockStart, count, size)
+	}
+	data, err := d.in.full(size)
+	if err != nil {
+		return fmt.Errorf("read Avro block: %w", err)
+	}
+	sync, err := d.in.full(syncSize)
+	if err != nil {
+		return fmt.Errorf("read Avro block: %w", err)
+	}
+	if !bytes.Equal(sync, d.sync) {
+		return pipeline.Errorf(pipeline.KindConversion, "Avro block at offset %d is not followed by the sync marker", d.blockStart)
+	}
+	if data, err = decompress(d.compression, data); err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "Avro block at offset %d: %w", d.blockStart, err)
+	}
+	d.block = reader{buf: data}
+	d.count, d.index = count, 0
+	return nil
+}
+
+func errUnexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Decode 记录无法解码时块中剩余的记录也无法定位，返回 KindConversion 错误后从下一个块继续
+func (d *containerDecoder) Decode() (*pipeline.Record, error) {
+	if !d.started {
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+	for d.index == d.count {
+		if err := d.nextBlock(); err != nil {
+			return nil, err
+		}
+	}
+
+	value, err := decodeValue(&d.block, d.writer, d.reader)
+	if err != nil {
+		d.index = d.count
+		return nil, pipeline.Errorf(pipeline.KindConversion, "%s: block at offset %d: %w", Name, d.blockStart, err)
+	}
+	d.index++
+	return &pipeline.Record{
+		Schema:    d.schema,
+		Data:      value.Value.(map[string]pipeline.Value),
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func (d *containerDecoder) Offset() int64 {
+	if !d.started {
+		return 0
+	}
+	if d.index == d.count {
+		return d.in.pos << indexBits
+	}
+	return d.blockStart<<indexBits | d.index
+}
+
+func (d *containerDecoder) Resume(offset int64) error {
+	if err := d.readHeader(); err != nil {
+		return err
+	}
+	start, index := offset>>indexBits, offset&maxBlock
+	if start == 0 {
+		return nil
+	}
+	if start < d.in.pos {
+		return fmt.Errorf("invalid Avro position %d: block offset %d is inside the header", offset, start)
+	}
+	if err := d.in.discard(start - d.in.pos); err != nil {
+		return fmt.Errorf("seek to Avro block %d: %w", start, err)
+	}
+	if index == 0 {
+		return nil
+	}
+	if err := d.nextBlock(); err != nil {
+		return fmt.Errorf("seek to Avro block %d: %w", start, errUnexpected(err))
+	}
+	if index > d.count {
+		return fmt.Errorf("invalid Avro position %d: block has only %d records", offset, d.count)
+	}
+	for ; d.index < index; d.index++ {
+		if _, err := decodeValue(&d.block, d.writer, d.writer); err != nil {
+			return fmt.Errorf("skip Avro record: %w", err)
+		}
+	}
+	return nil
+}
+
+// containerEncoder 写入对象容器文件。第一条记录写入时才输出文件头，
+// 这样没有配置 schema 时可以使用第一条记录的 schema
+type containerEncoder struct {
+	codec *Codec
+	w     io.Writer
+
+	started bool
+	writer  *avroType
+	sync    []byte
+	block   []byte
+	count   int64
+}
+
+func (e *containerEncoder) writeHeader(record *pipeline.Record) error {
+	writerJSON, writer := e.codec.config.Schema, e.codec.writer
+	if writer == nil {
+		var err error
+		if writerJSON, writer, err = writerFor(record); err != nil {
+			return err
+		}
+	}
+	e.writer = writer
+	e.sync = make([]byte, syncSize)
+	if _, err := rand.Read(e.sync); err != nil {
+		return err
+	}
+
+	header := append([]byte(nil), magic...)
+	header = appendLong(header, 2)
+	header = appendBytes(header, []byte(metaCodec))
+	header = appendBytes(header, []byte(e.codec.config.Compression))
+	header = appendBytes(header, []byte(metaSchema))
+	header = appendBytes(header, compact(writerJSON))
+	header = appendLong(header, 0)
+	header = append(header, e.sync...)
+	if _, err := e.w.Write(header); err != nil {
+		return err
+	}
+	e.started = true
+	return nil
+}
+
+func (e *containerEncoder) Encode(record *pipeline.Record) error {
+	if !e.started {
+		if err := e.writeHeader(record); err != nil {
+			return err
+		}
+	}
+	block, err := encodeFields(e.block, e.writer, record.Data)
+	if err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "%s: %w", Name, err)
+	}
+	e.block = block
+	e.count++
+	if len(e.block) >= e.codec.config.BlockSize || e.count == maxBlock {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *containerEncoder) flush() error {
+	if e.count == 0 {
+		return nil
+	}
+	data, err := compress(e.codec.config.Compression, e.block)
+	if err != nil {
+		return err
+	}
+	out := appendLong(nil, e.count)
+	out = appendLong(out, int64(len(data)))
+	out = append(out, data...)
+	out = append(out, e.sync...)
+	if _, err := e.w.Write(out); err != nil {
+		return err
+	}
+	e.block, e.count = e.block[:0], 0
+	return nil
+}
+
+// Close 写出最后一个块。配置了 schema 时即使没有记录也输出文件头，得到合法的空文件
+func (e *containerEncoder) Close() error {
+	if !e.started {
+		if e.codec.writer == nil {
+			return nil
+		}
+		if err := e.writeHeader(nil); err != nil {
+			return err
+		}
+	}
+	return e.flush()
+}
+
+// writerFor 没有配置 schema 时由记录的 pipeline.Schema 生成 writer schema
+func writerFor(record *pipeline.Record) ([]byte, *avroType, error) {
+	if record == nil || record.Schema == nil {
+		return nil, nil, errors.New("avro: no writer schema configured and the record has no schema")
+	}
+	data, err := schema.ToAvro(record.Schema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("avro: %w", err)
+	}
+	writer, err := parseSchema(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("avro: %w", err)
+	}
+	return data, writer, nil
+}
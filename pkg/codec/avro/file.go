@@ -0,0 +1,27 @@
+package avro
+
+import (
+	filesink "github.com/ipush/littlepipe/pkg/sink/file"
+	filesource "github.com/ipush/littlepipe/pkg/source/file"
+)
+
+// Ext Avro 对象容器文件的扩展名
+const Ext = ".avro"
+
+// NewFileSource 读取 Avro 对象容器文件，checkpoint 的位置可以用于 Seek
+func NewFileSource(path string, config Config) (*filesource.FileSource, error) {
+	c, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return filesource.NewFileSourceWithCodec(path, c)
+}
+
+// NewFileSink 每个事务发布一个完整的 Avro 对象容器文件，文件头中保存 writer schema
+func NewFileSink(dir, prefix string, config Config) (*filesink.FileSink, error) {
+	c, err := New(config)
+	if err != nil {
+		return nil, err
+	}
+	return filesink.NewFileSinkWithCodec(dir, prefix, Ext, c)
+}
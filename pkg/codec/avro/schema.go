@@ -0,0 +1,237 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// avroType 解析后的 Avro schema 节点。命名类型只解析一次，引用指向同一个节点，
+// 因此递归的 record 也能表示
+type avroType struct {
+	kind    string
+	logical string
+	// name 命名类型（record、enum、fixed）的全名，aliases 同样是全名
+	name    string
+	aliases []string
+
+	fields   []*avroField
+	symbols  []string
+	enumDef  string
+	items    *avroType
+	values   *avroType
+	branches []*avroType
+	size     int
+	scale    int
+}
+
+type avroField struct {
+	name       string
+	aliases    []string
+	typ        *avroType
+	def        any
+	hasDefault bool
+}
+
+// parseSchema 解析 .avsc JSON，数字默认值保留为 json.Number
+func parseSchema(data []byte) (*avroType, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var root any
+	if err := decoder.Decode(&root); err != nil {
+		return nil, fmt.Errorf("decode Avro schema: %w", err)
+	}
+	p := &schemaParser{named: make(map[string]*avroType)}
+	t, err := p.parse(root, "")
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != "record" {
+		return nil, fmt.Errorf("Avro schema root must be a record, got %s", t.kind)
+	}
+	return t, nil
+}
+
+type schemaParser struct {
+	named map[string]*avroType
+}
+
+var primitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+func qualify(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func (p *schemaParser) parse(def any, namespace string) (*avroType, error) {
+	switch def := def.(type) {
+	case string:
+		if primitives[def] {
+			return &avroType{kind: def}, nil
+		}
+		if t, ok := p.named[qualify(def, namespace)]; ok {
+			return t, nil
+		}
+		if t, ok := p.named[def]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown Avro type %q", def)
+	case []any:
+		t := &avroType{kind: "union"}
+		for _, branch := range def {
+			b, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if b.kind == "union" {
+				return nil, fmt.Errorf("union cannot directly contain a union")
+			}
+			t.branches = append(t.branches, b)
+		}
+		return t, nil
+	case map[string]any:
+		return p.complex(def, namespace)
+	default:
+		return nil, fmt.Errorf("invalid Avro type %v", def)
+	}
+}
+
+func (p *schemaParser) complex(def map[string]any, namespace string) (*avroType, error) {
+	kind, _ := def["type"].(string)
+	logical, _ := def["logicalType"].(string)
+
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		name, _ := def["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("%s without a name", kind)
+		}
+		if ns, ok := def["namespace"].(string); ok && !strings.Contains(name, ".") {
+			namespace = ns
+		}
+		full := qualify(name, namespace)
+		if i := strings.LastIndex(full, "."); i >= 0 {
+			namespace = full[:i]
+		}
+		t := &avroType{kind: kind, logical: logical, name: full}
+		if kind == "error" {
+			t.kind = "record"
+		}
+		if aliases, ok := def["aliases"].([]any); ok {
+			for _, a := range aliases {
+				if s, ok := a.(string); ok {
+					t.aliases = append(t.aliases, qualify(s, namespace))
+				}
+			}
+		}
+		// registered before the fields so that they can refer to the record itself
+		p.named[full] = t
+		return t, p.named_(t, def, namespace)
+
+	case "array":
+		items, err := p.parse(def["items"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("array items: %w", err)
+		}
+		return &avroType{kind: kind, items: items}, nil
+
+	case "map":
+		values, err := p.parse(def["values"], namespace)
+		if err != nil {
+			return nil, fmt.Errorf("map values: %w", err)
+		}
+		return &avroType{kind: kind, values: values}, nil
+
+	default:
+		t, err := p.parse(def["type"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		if logical == "" || !primitives[t.kind] {
+			return t, nil
+		}
+		annotated := *t
+		annotated.logical = logical
+		if logical == "decimal" {
+			annotated.scale = intProp(def, "scale")
+		}
+		return &annotated, nil
+	}
+}
+
+// named_ 填充命名类型的内容
+func (p *schemaParser) named_(t *avroType, def map[string]any, namespace string) error {
+	switch t.kind {
+	case "record":
+		raw, ok := def["fields"].([]any)
+		if !ok {
+			return fmt.Errorf("record %s has no fields", t.name)
+		}
+		for _, item := range raw {
+			fd, ok := item.(map[string]any)
+			if !ok {
+				return fmt.Errorf("record %s: field must be an object", t.name)
+			}
+			name, _ := fd["name"].(string)
+			typ, err := p.parse(fd["type"], namespace)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", t.name, name, err)
+			}
+			f := &avroField{name: name, typ: typ}
+			f.def, f.hasDefault = fd["default"]
+			if aliases, ok := fd["aliases"].([]any); ok {
+				for _, a := range aliases {
+					if s, ok := a.(string); ok {
+						f.aliases = append(f.aliases, s)
+					}
+				}
+			}
+			t.fields = append(t.fields, f)
+		}
+	case "enum":
+		raw, _ := def["symbols"].([]any)
+		for _, s := range raw {
+			symbol, _ := s.(string)
+			t.symbols = append(t.symbols, symbol)
+		}
+		t.enumDef, _ = def["default"].(string)
+	case "fixed":
+		t.size = intProp(def, "size")
+		if t.logical == "decimal" {
+			t.scale = intProp(def, "scale")
+		}
+	}
+	return nil
+}
+
+func intProp(def map[string]any, key string) int {
+	if n, ok := def[key].(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return int(i)
+		}
+	}
+	return 0
+}
+
+// matchesName 判断 reader 的命名类型能否读取 writer 的命名类型，按全名、短名或 reader 的别名比较
+func matchesName(writer, reader *avroType) bool {
+	if writer.name == reader.name || shortName(writer.name) == shortName(reader.name) {
+		return true
+	}
+	for _, alias := range reader.aliases {
+		if alias == writer.name || shortName(alias) == shortName(writer.name) {
+			return true
+		}
+	}
+	return false
+}
+
+func shortName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
@@ -0,0 +1,186 @@
+// Package codec 在字节和 pipeline.Record 之间转换。每种格式实现 Codec，
+// 在 init 中按名称注册，source 和 sink 只依赖 Codec，因此任意组合都可以工作
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// Codec 编解码单条记录。默认的流式框架按行分隔，Decode 返回 nil 记录且无错误时
+// 跳过该行（例如空行）；需要表头、容器或二进制分帧的格式另外实现 StreamCodec
+type Codec interface {
+	Name() string
+	Decode(data []byte) (*pipeline.Record, error)
+	Encode(record *pipeline.Record) ([]byte, error)
+}
+
+// StreamCodec 自行处理流的格式，例如只写一次表头的 CSV 或带长度前缀的二进制格式
+type StreamCodec interface {
+	Codec
+	NewDecoder(r io.Reader) Decoder
+	NewEncoder(w io.Writer) Encoder
+}
+
+// Decoder 从流中逐条读取记录，结束时返回 io.EOF
+type Decoder interface {
+	Decode() (*pipeline.Record, error)
+}
+
+// Encoder 向流中逐条写入记录。Close 写出缓存和结尾，但不关闭底层的 io.Writer
+type Encoder interface {
+	Encode(record *pipeline.Record) error
+	Close() error
+}
+
+// Offsetter 由能报告读取位置的 Decoder 实现，Offset 为已解码记录之后的字节偏移，
+// 从该偏移开始新建 Decoder 可以接着读取，用于 checkpoint。
+// 同时实现 Resumer 的 Decoder 只需保证 Resume 能识别 Offset 的返回值，可以使用自己的编码
+type Offsetter interface {
+	Offset() int64
+}
+
+// Resumer 由需要先读取流开头（例如表头）才能解码的 Decoder 实现。
+// Resume 在从流开头创建的 Decoder 上调用，读取必要的内容后跳到 offset
+type Resumer interface {
+	Resume(offset int64) error
+}
+
+// NewDecoder 为 c 创建流式解码器，StreamCodec 使用自己的实现，其余按行解码
+func NewDecoder(c Codec, r io.Reader) Decoder {
+	if sc, ok := c.(StreamCodec); ok {
+		return sc.NewDecoder(r)
+	}
+	return &lineDecoder{codec: c, reader: bufio.NewReader(r)}
+}
+
+// NewEncoder 为 c 创建流式编码器，StreamCodec 使用自己的实现，其余每条记录占一行
+func NewEncoder(c Codec, w io.Writer) Encoder {
+	if sc, ok := c.(StreamCodec); ok {
+		return sc.NewEncoder(w)
+	}
+	return &lineEncoder{codec: c, writer: w}
+}
+
+type lineDecoder struct {
+	codec  Codec
+	reader *bufio.Reader
+	offset int64
+}
+
+func (d *lineDecoder) Decode() (*pipeline.Record, error) {
+	for {
+		line, err := d.reader.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		d.offset += int64(len(line))
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		record, err := d.codec.Decode(line)
+		if err != nil {
+			return nil, pipeline.Errorf(pipeline.KindConversion, "%s: %w", d.codec.Name(), err)
+		}
+		if record != nil {
+			return record, nil
+		}
+	}
+}
+
+func (d *lineDecoder) Offset() int64 {
+	return d.offset
+}
+
+type lineEncoder struct {
+	codec  Codec
+	writer io.Writer
+}
+
+func (e *lineEncoder) Encode(record *pipeline.Record) error {
+	data, err := e.codec.Encode(record)
+	if err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "%s: %w", e.codec.Name(), err)
+	}
+	if _, err := e.writer.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *lineEncoder) Close() error {
+	return nil
+}
+
+// Options 按名称创建 codec 时的参数。Schema 为空时由 codec 自行推断类型，
+// Params 是各 codec 自己的字符串参数，例如 CSV 的 delimiter
+type Options struct {
+	Schema *pipeline.Schema
+	Params map[string]string
+}
+
+// Factory 按参数创建 codec，不认识的参数应当返回错误
+type Factory func(opts Options) (Codec, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register 注册 codec，名称重复时 panic，通常在格式包的 init 中调用
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("codec: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("codec: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// New 按名称创建 codec，对应的格式包必须已被导入
+func New(name string, opts Options) (Codec, error) {
+	mu.RLock()
+	factory, ok := factories[strings.ToLower(name)]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q, registered codecs: %s", name, strings.Join(Names(), ", "))
+	}
+	return factory(opts)
+}
+
+// Names 返回已注册的 codec 名称，按字母排序
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckParams 在 params 含有 known 以外的键时返回错误，供各 codec 的 Factory 使用
+func CheckParams(name string, params map[string]string, known ...string) error {
+	for key := range params {
+		found := false
+		for _, k := range known {
+			if k == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("codec %s: unknown parameter %q", name, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package codec_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	_ "github.com/ipush/littlepipe/pkg/codec/jsonl"
+	"github.com/ipush/littlepipe/pkg/codec/text"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func TestNew(t *testing.T) {
+	c, err := codec.New("JSONL", codec.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Name() != "jsonl" {
+		t.Errorf("name = %s", c.Name())
+	}
+	if _, err := codec.New("nope", codec.Options{}); err == nil || !strings.Contains(err.Error(), "jsonl, text") {
+		t.Errorf("unknown codec error = %v", err)
+	}
+	if _, err := codec.New("text", codec.Options{Params: map[string]string{"delimiter": ","}}); err == nil {
+		t.Error("unknown parameter accepted")
+	}
+}
+
+func TestLineDecoder_Offset(t *testing.T) {
+	input := "a\r\n\nbc"
+	decoder := codec.NewDecoder(text.New(""), strings.NewReader(input))
+	var lines []string
+	var offsets []int64
+	for {
+		record, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, record.Data[text.LineField].Value.(string))
+		offsets = append(offsets, decoder.(codec.Offsetter).Offset())
+	}
+	if strings.Join(lines, "|") != "a||bc" {
+		t.Errorf("lines = %q", lines)
+	}
+	if offsets[0] != 3 || offsets[1] != 4 || offsets[2] != 6 {
+		t.Errorf("offsets = %v", offsets)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c, err := codec.New("jsonl", codec.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	encoder := codec.NewEncoder(c, &buf)
+	record := &pipeline.Record{Data: map[string]pipeline.Value{
+		"b": {Type: pipeline.TypeString, Value: "x"},
+		"a": {Type: pipeline.TypeInt64, Value: int64(1)},
+	}}
+	if err := encoder.Encode(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != `{"a":1,"b":"x"}`+"\n" {
+		t.Errorf("encoded = %q", buf.String())
+	}
+
+	buf.WriteString("\n")
+	decoded, err := codec.NewDecoder(c, &buf).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Data["a"].Equal(record.Data["a"]) || !decoded.Data["b"].Equal(record.Data["b"]) {
+		t.Errorf("decoded = %v", decoded.Data)
+	}
+}
+
+func TestLineEncoder_Error(t *testing.T) {
+	encoder := codec.NewEncoder(text.New(""), io.Discard)
+	err := encoder.Encode(&pipeline.Record{Data: map[string]pipeline.Value{}})
+	if pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestInferString_CanonicalNumbers(t *testing.T) {
+	for cell, want := range map[string]pipeline.FieldType{
+		"42": pipeline.TypeInt64, "-7": pipeline.TypeInt64, "0": pipeline.TypeInt64,
+		"007": pipeline.TypeString, "+1": pipeline.TypeString, "-0": pipeline.TypeString,
+		"1.50": pipeline.TypeDecimal, "0.5": pipeline.TypeDecimal,
+		"01.5": pipeline.TypeString, "+1.5": pipeline.TypeString, ".5": pipeline.TypeString,
+	} {
+		if got := codec.InferString(cell, false).Type; got != want {
+			t.Errorf("%q: type = %v, want %v", cell, got, want)
+		}
+	}
+}
@@ -0,0 +1,635 @@
+// Package csv 逗号或制表符分隔的表格格式，支持表头、引号和转义字符
+package csv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const (
+	Name    = "csv"
+	TSVName = "tsv"
+)
+
+func init() {
+	codec.Register(Name, factory(Config{Delimiter: ','}))
+	codec.Register(TSVName, factory(Config{Delimiter: '\t'}))
+}
+
+// HeaderMode 决定第一行是否为表头
+type HeaderMode string
+
+const (
+	// HeaderAuto 有 Schema 时第一行的单元格都是字段名才视为表头；没有 Schema 时
+	// 单元格都非空、互不相同且按 codec.InferString 都是字符串才视为表头
+	HeaderAuto HeaderMode = "auto"
+	HeaderYes  HeaderMode = "yes"
+	HeaderNo   HeaderMode = "no"
+)
+
+type Config struct {
+	// Delimiter 默认为逗号
+	Delimiter rune
+	// Quote 默认为双引号，NoQuote 表示不识别引号
+	Quote rune
+	// Escape 引号内的转义字符，默认与 Quote 相同，即两个引号表示一个引号
+	Escape rune
+	Header HeaderMode
+	// Schema 非空时按名称把列映射到字段并转换为字段类型，没有表头时按字段顺序对应各列
+	Schema *pipeline.Schema
+	// Floats 推断类型时把非整数解码为 float64，默认解码为 Decimal 以保留全部数位
+	Floats bool
+}
+
+// NoQuote 作为 Config.Quote 时关闭引号处理，适用于不含分隔符和换行的 TSV
+const NoQuote rune = -1
+
+func factory(defaults Config) codec.Factory {
+	return func(opts codec.Options) (codec.Codec, error) {
+		name := Name
+		if defaults.Delimiter == '\t' {
+			name = TSVName
+		}
+		params := opts.Params
+		if err := codec.CheckParams(name, params, "delimiter", "quote", "escape", "header", "numbers"); err != nil {
+			return nil, err
+		}
+
+		config := defaults
+		config.Schema = opts.Schema
+		var err error
+		if config.Delimiter, err = paramRune(params, "delimiter", config.Delimiter); err != nil {
+			return nil, fmt.Errorf("codec %s: %w", name, err)
+		}
+		if config.Quote, err = paramRune(params, "quote", 0); err != nil {
+			return nil, fmt.Errorf("codec %s: %w", name, err)
+		}
+		if config.Escape, err = paramRune(params, "escape", 0); err != nil {
+			return nil, fmt.Errorf("codec %s: %w", name, err)
+		}
+		config.Header = HeaderMode(strings.ToLower(params["header"]))
+		switch params["numbers"] {
+		case "", "exact":
+		case "float":
+			config.Floats = true
+		default:
+			return nil, fmt.Errorf("codec %s: numbers must be exact or float, got %q", name, params["numbers"])
+		}
+		return New(config)
+	}
+}
+
+// paramRune 接受单个字符，或者 \t、tab 和 none 这样的写法
+func paramRune(params map[string]string, key string, fallback rune) (rune, error) {
+	s, ok := params[key]
+	if !ok {
+		return fallback, nil
+	}
+	switch strings.ToLower(s) {
+	case `\t`, "tab":
+		return '\t', nil
+	case "none":
+		return NoQuote, nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("%s must be a single character, got %q", key, s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
+
+// Codec 作为 StreamCodec 使用时解码器处理表头和跨行的引号字段，每个编码器在第一条记录前写一次表头。
+// 单独调用 Decode 和 Encode 时只处理一行数据，不读写表头
+type Codec struct {
+	config Config
+	// columns 没有表头时各列的字段名
+	columns []string
+}
+
+func New(config Config) (*Codec, error) {
+	if config.Delimiter == 0 {
+		config.Delimiter = ','
+	}
+	if config.Quote == 0 {
+		config.Quote = '"'
+	}
+	if config.Escape == 0 {
+		config.Escape = config.Quote
+	}
+	if config.Header == "" {
+		config.Header = HeaderAuto
+	}
+	switch {
+	case config.Header != HeaderAuto && config.Header != HeaderYes && config.Header != HeaderNo:
+		return nil, fmt.Errorf("header must be auto, yes or no, got %q", config.Header)
+	case config.Delimiter == config.Quote || config.Delimiter == config.Escape:
+		return nil, fmt.Errorf("delimiter %q conflicts with quote or escape", config.Delimiter)
+	case config.Delimiter == '\n' || config.Delimiter == '\r' || config.Quote == '\n' || config.Quote == '\r':
+		return nil, fmt.Errorf("delimiter and quote cannot be line breaks")
+	}
+
+	c := &Codec{config: config}
+	if config.Schema != nil {
+		for _, f := range config.Schema.Fields {
+			c.columns = append(c.columns, f.Name)
+		}
+	}
+	return c, nil
+}
+
+func (c *Codec) Name() string {
+	if c.config.Delimiter == '\t' {
+		return TSVName
+	}
+	return Name
+}
+
+func (c *Codec) Decode(data []byte) (*pipeline.Record, error) {
+	p := &parser{config: &c.config, reader: bufio.NewReader(bytes.NewReader(data))}
+	row, err := p.row()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a single row has no header, columns are named like the stream decoder does
+	columns := c.columns
+	if columns == nil {
+		columns = defaultColumns(len(row))
+	}
+	return c.record(columns, row)
+}
+
+func (c *Codec) Encode(record *pipeline.Record) ([]byte, error) {
+	columns := c.columns
+	if columns == nil {
+		columns = recordColumns(record)
+	}
+	var buf bytes.Buffer
+	if err := c.writeRecord(&buf, columns, record); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Codec) NewDecoder(r io.Reader) codec.Decoder {
+	return &decoder{codec: c, source: r, parser: parser{config: &c.config, reader: bufio.NewReader(r)}}
+}
+
+func (c *Codec) NewEncoder(w io.Writer) codec.Encoder {
+	return &encoder{codec: c, writer: w}
+}
+
+// record 按列名组装记录。有 Schema 时 schema 中的列按字段类型转换，空单元格在非字符串字段中为空值；
+// 其余的列按内容推断类型
+func (c *Codec) record(columns []string, row []string) (*pipeline.Record, error) {
+	if len(row) > len(columns) {
+		return nil, fmt.Errorf("row has %d columns, expected %d", len(row), len(columns))
+	}
+	record := &pipeline.Record{
+		Schema:    c.config.Schema,
+		Data:      make(map[string]pipeline.Value, len(row)),
+		Timestamp: time.Now(),
+	}
+	for i, cell := range row {
+		name := columns[i]
+		field, ok := c.field(name)
+		switch {
+		case !ok:
+			record.Data[name] = codec.InferString(cell, c.config.Floats)
+		case cell == "" && field.Type != pipeline.TypeString:
+			record.Data[name] = pipeline.Null
+		default:
+			record.Data[name] = pipeline.Value{Type: pipeline.TypeString, Value: cell}
+		}
+	}
+	if c.config.Schema != nil {
+		if err := c.config.Schema.Coerce(record, pipeline.CoerceStrict); err != nil {
+			return nil, err
+		}
+	} else {
+		record.Schema = rowSchema(columns[:len(row)], record.Data)
+	}
+	return record, nil
+}
+
+// rowSchema 没有 Schema 时按列的顺序描述这一行推断出的类型，编码器据此按原来的列顺序写回。
+// 各列都可以为空，空值的列记为字符串
+func rowSchema(columns []string, data map[string]pipeline.Value) *pipeline.Schema {
+	schema := &pipeline.Schema{Fields: make([]pipeline.Field, 0, len(columns))}
+	seen := make(map[string]bool, len(columns))
+	for _, name := range columns {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		t := data[name].Type
+		if data[name].IsNull() {
+			t = pipeline.TypeString
+		}
+		schema.Fields = append(schema.Fields, pipeline.Field{Name: name, Type: t, Nullable: true})
+	}
+	return schema
+}
+
+func (c *Codec) field(name string) (pipeline.Field, bool) {
+	if c.config.Schema == nil {
+		return pipeline.Field{}, false
+	}
+	for _, f := range c.config.Schema.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return pipeline.Field{}, false
+}
+
+// isHeader 判断第一行是否为表头，规则见 HeaderAuto
+func (c *Codec) isHeader(row []string) bool {
+	switch c.config.Header {
+	case HeaderYes:
+		return true
+	case HeaderNo:
+		return false
+	}
+	if c.config.Schema != nil {
+		for _, cell := range row {
+			if _, ok := c.field(cell); !ok {
+				return false
+			}
+		}
+		return true
+	}
+	seen := make(map[string]bool, len(row))
+	for _, cell := range row {
+		if cell == "" || seen[cell] || codec.InferString(cell, c.config.Floats).Type != pipeline.TypeString {
+			return false
+		}
+		seen[cell] = true
+	}
+	return true
+}
+
+// defaultColumns 没有表头也没有 Schema 时各列命名为 col1、col2……
+func defaultColumns(n int) []string {
+	columns := make([]string, n)
+	for i := range columns {
+		columns[i] = "col" + strconv.Itoa(i+1)
+	}
+	return columns
+}
+
+// recordColumns 记录的 schema 中的字段在前，其余字段按名称排序
+func recordColumns(record *pipeline.Record) []string {
+	var columns []string
+	seen := make(map[string]bool)
+	if record.Schema != nil {
+		for _, f := range record.Schema.Fields {
+			columns = append(columns, f.Name)
+			seen[f.Name] = true
+		}
+	}
+	var extra []string
+	for name := range record.Data {
+		if !seen[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	return append(columns, extra...)
+}
+
+type decoder struct {
+	codec   *Codec
+	source  io.Reader
+	parser  parser
+	columns []string
+	started bool
+	// dataStart 是第一行数据的偏移，有表头时在表头之后
+	dataStart int64
+}
+
+func (d *decoder) Decode() (*pipeline.Record, error) {
+	if !d.started {
+		d.started = true
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+	row, err := d.parser.row()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err == nil && d.columns == nil {
+		d.columns = defaultColumns(len(row))
+	}
+	var record *pipeline.Record
+	if err == nil {
+		record, err = d.codec.record(d.columns, row)
+	}
+	if err != nil {
+		return nil, pipeline.Errorf(pipeline.KindConversion, "%s: line %d: %w", d.codec.Name(), d.parser.line, err)
+	}
+	return record, nil
+}
+
+// readHeader 读取第一行，不是表头时留给下一次 Decode 作为数据行
+func (d *decoder) readHeader() error {
+	d.columns = d.codec.columns
+	if d.codec.config.Header == HeaderNo {
+		return nil
+	}
+	offset, line := d.parser.offset, d.parser.line
+	row, err := d.parser.row()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "%s: header: %w", d.codec.Name(), err)
+	}
+	if !d.codec.isHeader(row) {
+		d.parser.unread(row, offset, line)
+		return nil
+	}
+	d.columns = row
+	d.dataStart = d.parser.offset
+	return nil
+}
+
+func (d *decoder) Offset() int64 {
+	return d.parser.offset
+}
+
+// Resume 先读取表头，再跳到 offset 继续读取数据行
+func (d *decoder) Resume(offset int64) error {
+	if !d.started {
+		d.started = true
+		if err := d.readHeader(); err != nil {
+			return err
+		}
+	}
+	if d.parser.pending != nil {
+		// drop the data row read while looking for a header, the reader is already past it
+		d.parser.offset, d.parser.line = d.parser.pendingOffset, d.parser.pendingLine
+		d.parser.pending = nil
+	}
+	if offset < d.dataStart {
+		return fmt.Errorf("%s: offset %d is inside the header", d.codec.Name(), offset)
+	}
+	if seeker, ok := d.source.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		d.parser.reader.Reset(d.source)
+	} else {
+		if offset < d.parser.offset {
+			return fmt.Errorf("%s: cannot go back to offset %d", d.codec.Name(), offset)
+		}
+		if _, err := d.parser.reader.Discard(int(offset - d.parser.offset)); err != nil {
+			return err
+		}
+	}
+	d.parser.offset = offset
+	return nil
+}
+
+type encoder struct {
+	codec   *Codec
+	writer  io.Writer
+	columns []string
+}
+
+// Encode 在第一条记录前写表头，列由 Schema 或第一条记录决定
+func (e *encoder) Encode(record *pipeline.Record) error {
+	var buf bytes.Buffer
+	if e.columns == nil {
+		e.columns = e.codec.columns
+		if e.columns == nil {
+			e.columns = recordColumns(record)
+		}
+		if e.codec.config.Header != HeaderNo {
+			if err := e.codec.writeRow(&buf, e.columns); err != nil {
+				return pipeline.Errorf(pipeline.KindConversion, "%s: header: %w", e.codec.Name(), err)
+			}
+			buf.WriteByte('\n')
+		}
+	}
+	if err := e.codec.writeRecord(&buf, e.columns, record); err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "%s: %w", e.codec.Name(), err)
+	}
+	buf.WriteByte('\n')
+	_, err := e.writer.Write(buf.Bytes())
+	return err
+}
+
+func (e *encoder) Close() error {
+	return nil
+}
+
+func (c *Codec) writeRecord(buf *bytes.Buffer, columns []string, record *pipeline.Record) error {
+	known := make(map[string]bool, len(columns))
+	for _, name := range columns {
+		known[name] = true
+	}
+	for name := range record.Data {
+		if !known[name] {
+			return fmt.Errorf("field %s is not a column", name)
+		}
+	}
+
+	row := make([]string, len(columns))
+	for i, name := range columns {
+		cell, err := codec.FormatString(record.Data[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		row[i] = cell
+	}
+	return c.writeRow(buf, row)
+}
+
+func (c *Codec) writeRow(buf *bytes.Buffer, row []string) error {
+	for i, cell := range row {
+		if i > 0 {
+			buf.WriteRune(c.config.Delimiter)
+		}
+		if !c.needsQuotes(cell) {
+			buf.WriteString(cell)
+			continue
+		}
+		if c.config.Quote == NoQuote {
+			return fmt.Errorf("%q needs quoting but quoting is disabled", cell)
+		}
+		buf.WriteRune(c.config.Quote)
+		for _, r := range cell {
+			if r == c.config.Quote || r == c.config.Escape {
+				buf.WriteRune(c.config.Escape)
+			}
+			buf.WriteRune(r)
+		}
+		buf.WriteRune(c.config.Quote)
+	}
+	return nil
+}
+
+func (c *Codec) needsQuotes(cell string) bool {
+	if cell == "" {
+		return false
+	}
+	if cell[0] == ' ' || cell[len(cell)-1] == ' ' {
+		return true
+	}
+	return strings.ContainsFunc(cell, func(r rune) bool {
+		return r == c.config.Delimiter || r == c.config.Quote || r == c.config.Escape || r == '\n' || r == '\r'
+	})
+}
+
+// parser 逐字符读取，引号内的字段可以跨行，offset 为已消费的字节数
+type parser struct {
+	config *Config
+	reader *bufio.Reader
+	offset int64
+	line   int
+	// pending 是被退回的一行，由下一次 row 返回
+	pending       []string
+	pendingOffset int64
+	pendingLine   int
+}
+
+func (p *parser) unread(row []string, offset int64, line int) {
+	p.pending = row
+	p.pendingOffset, p.pendingLine = p.offset, p.line
+	p.offset, p.line = offset, line
+}
+
+func (p *parser) next() (rune, error) {
+	r, size, err := p.reader.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	p.offset += int64(size)
+	return r, nil
+}
+
+// row 读取一行，空行被跳过
+func (p *parser) row() ([]string, error) {
+	if p.pending != nil {
+		row := p.pending
+		p.pending = nil
+		p.offset, p.line = p.pendingOffset, p.pendingLine
+		return row, nil
+	}
+	for {
+		row, blank, err := p.readRow()
+		if err != nil {
+			return nil, err
+		}
+		if !blank {
+			return row, nil
+		}
+	}
+}
+
+func (p *parser) readRow() (row []string, blank bool, err error) {
+	var field strings.Builder
+	quoted, inQuotes, started := false, false, false
+	endField := func() {
+		row = append(row, field.String())
+		field.Reset()
+		quoted = false
+	}
+	p.line++
+
+	for {
+		r, err := p.next()
+		if err == io.EOF {
+			switch {
+			case inQuotes:
+				return nil, false, fmt.Errorf("unterminated quoted field")
+			case !started:
+				return nil, false, io.EOF
+			}
+			endField()
+			return row, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		if inQuotes {
+			switch {
+			case r == p.config.Escape && p.config.Escape != p.config.Quote:
+				escaped, err := p.next()
+				if err != nil {
+					return nil, false, fmt.Errorf("unterminated quoted field")
+				}
+				field.WriteRune(escaped)
+			case r == p.config.Quote:
+				if p.peek() == p.config.Quote && p.config.Escape == p.config.Quote {
+					p.next()
+					field.WriteRune(r)
+					continue
+				}
+				inQuotes = false
+			default:
+				if r == '\n' {
+					p.line++
+				}
+				field.WriteRune(r)
+			}
+			continue
+		}
+
+		switch {
+		case r == '\n':
+			if !started {
+				return nil, true, nil
+			}
+			endField()
+			return row, false, nil
+		case r == '\r' && p.peek() == '\n':
+			// the '\n' ends the row on the next iteration
+		case r == p.config.Delimiter:
+			started = true
+			endField()
+		case r == p.config.Quote && field.Len() == 0 && !quoted:
+			started, quoted, inQuotes = true, true, true
+		case quoted:
+			// skip the rest of the line so the next row starts cleanly
+			p.skipLine()
+			return nil, false, fmt.Errorf("unexpected %q after closing quote", r)
+		default:
+			started = true
+			field.WriteRune(r)
+		}
+	}
+}
+
+func (p *parser) skipLine() {
+	for {
+		r, err := p.next()
+		if err != nil || r == '\n' {
+			return
+		}
+	}
+}
+
+func (p *parser) peek() rune {
+	r, _, err := p.reader.ReadRune()
+	if err != nil {
+		return -1
+	}
+	p.reader.UnreadRune()
+	return r
+}
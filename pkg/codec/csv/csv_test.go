@@ -0,0 +1,259 @@
+package csv
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	filesource "github.com/ipush/littlepipe/pkg/source/file"
+)
+
+func decodeAll(t *testing.T, c codec.Codec, input string) []*pipeline.Record {
+	t.Helper()
+	decoder := codec.NewDecoder(c, strings.NewReader(input))
+	var records []*pipeline.Record
+	for {
+		record, err := decoder.Decode()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func mustNew(t *testing.T, config Config) *Codec {
+	t.Helper()
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDecode_Header(t *testing.T) {
+	input := "id,name,price,paid,at\r\n1,\"Smith, J\",9.90,true,2024-01-02T03:04:05Z\n\n2,\"say \"\"hi\"\"\nthere\",,false,\n"
+	records := decodeAll(t, mustNew(t, Config{}), input)
+	if len(records) != 2 {
+		t.Fatalf("records = %d", len(records))
+	}
+	r := records[0].Data
+	if r["id"].Value != int64(1) || r["name"].Value != "Smith, J" || r["paid"].Value != true {
+		t.Errorf("first = %v", r)
+	}
+	if d, ok := r["price"].Value.(pipeline.Decimal); !ok || d.String() != "9.90" {
+		t.Errorf("price = %#v", r["price"])
+	}
+	if ts, ok := r["at"].Value.(time.Time); !ok || ts.Year() != 2024 {
+		t.Errorf("at = %#v", r["at"])
+	}
+	r = records[1].Data
+	if r["name"].Value != "say \"hi\"\nthere" || !r["price"].IsNull() || r["paid"].Value != false {
+		t.Errorf("second = %v", r)
+	}
+}
+
+func TestDecode_NoHeader(t *testing.T) {
+	records := decodeAll(t, mustNew(t, Config{}), "1,a\n2,b\n")
+	if len(records) != 2 || records[0].Data["col1"].Value != int64(1) || records[1].Data["col2"].Value != "b" {
+		t.Errorf("records = %v", records)
+	}
+
+	records = decodeAll(t, mustNew(t, Config{Header: HeaderNo}), "a,b\n")
+	if len(records) != 1 || records[0].Data["col1"].Value != "a" {
+		t.Errorf("forced no header = %v", records)
+	}
+}
+
+func TestCodec_DecodeRow(t *testing.T) {
+	// without a schema a single row gets default column names
+	record, err := mustNew(t, Config{}).Decode([]byte("a,2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Data["col1"].Value != "a" || record.Data["col2"].Value != int64(2) {
+		t.Errorf("record = %v", record.Data)
+	}
+}
+
+func TestDecode_Schema(t *testing.T) {
+	s := &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "qty", Type: pipeline.TypeInt64},
+		{Name: "note", Type: pipeline.TypeString},
+		{Name: "ok", Type: pipeline.TypeBoolean, Nullable: true},
+	}}
+	c := mustNew(t, Config{Schema: s})
+
+	// columns are matched by name, in any order
+	records := decodeAll(t, c, "ok,qty,note\n,7,\n")
+	r := records[0].Data
+	if r["qty"].Value != int64(7) || r["note"].Value != "" || !r["ok"].IsNull() {
+		t.Errorf("by name = %v", r)
+	}
+	if records[0].Schema != s {
+		t.Error("schema not set")
+	}
+
+	// without a header columns follow the schema order
+	records = decodeAll(t, c, "3,x,true\n")
+	if r := records[0].Data; r["qty"].Value != int64(3) || r["ok"].Value != true {
+		t.Errorf("by position = %v", r)
+	}
+
+	decoder := codec.NewDecoder(c, strings.NewReader("qty\nmany\n4\n"))
+	if _, err := decoder.Decode(); pipeline.KindOf(err) != pipeline.KindConversion || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("bad cell err = %v", err)
+	}
+	if record, err := decoder.Decode(); err != nil || record.Data["qty"].Value != int64(4) {
+		t.Errorf("after error = %v, %v", record, err)
+	}
+}
+
+func TestDecode_QuoteEscape(t *testing.T) {
+	c := mustNew(t, Config{Delimiter: ';', Quote: '\'', Escape: '\\', Header: HeaderNo})
+	records := decodeAll(t, c, `'it\'s; fine';'a\\b'`+"\n")
+	if r := records[0].Data; r["col1"].Value != "it's; fine" || r["col2"].Value != `a\b` {
+		t.Errorf("record = %v", r)
+	}
+
+	decoder := codec.NewDecoder(mustNew(t, Config{Header: HeaderNo}), strings.NewReader("\"a\"b,c\nd\n"))
+	if _, err := decoder.Decode(); err == nil {
+		t.Error("text after closing quote accepted")
+	}
+	if record, err := decoder.Decode(); err != nil || record.Data["col1"].Value != "d" {
+		t.Errorf("after error = %v, %v", record, err)
+	}
+	if _, err := codec.NewDecoder(mustNew(t, Config{}), strings.NewReader("\"open\n")).Decode(); err == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
+
+func TestEncode_HeaderOnce(t *testing.T) {
+	c := mustNew(t, Config{})
+	var buf bytes.Buffer
+	encoder := codec.NewEncoder(c, &buf)
+	records := []*pipeline.Record{
+		{Data: map[string]pipeline.Value{
+			"b": {Type: pipeline.TypeString, Value: "x, \"y\""},
+			"a": {Type: pipeline.TypeInt64, Value: int64(1)},
+		}},
+		{Data: map[string]pipeline.Value{
+			"a": {Type: pipeline.TypeDecimal, Value: pipeline.MustParseDecimal("0.10")},
+			"b": pipeline.Null,
+		}},
+	}
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "a,b\n1,\"x, \"\"y\"\"\"\n0.10,\n"
+	if buf.String() != want {
+		t.Errorf("encoded = %q, want %q", buf.String(), want)
+	}
+
+	err := encoder.Encode(&pipeline.Record{Data: map[string]pipeline.Value{"c": pipeline.ValueOf("z")}})
+	if pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("unknown column err = %v", err)
+	}
+
+	decoded := decodeAll(t, c, buf.String())
+	if len(decoded) != 2 || decoded[0].Data["b"].Value != "x, \"y\"" {
+		t.Errorf("round trip = %v", decoded)
+	}
+}
+
+func TestTSV(t *testing.T) {
+	c, err := codec.New(TSVName, codec.Options{Params: map[string]string{"quote": "none"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := decodeAll(t, c, "name\tnote\nx\t\"quoted\"\n")
+	if records[0].Data["note"].Value != `"quoted"` {
+		t.Errorf("record = %v", records[0].Data)
+	}
+	if _, err := c.Encode(&pipeline.Record{Data: map[string]pipeline.Value{"a": pipeline.ValueOf("x\ty")}}); err == nil {
+		t.Error("tab written without quoting")
+	}
+
+	if _, err := codec.New(Name, codec.Options{Params: map[string]string{"delimiter": "ab"}}); err == nil {
+		t.Error("multi-character delimiter accepted")
+	}
+}
+
+func TestFileSource_SeekKeepsHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "in.csv")
+	if err := os.WriteFile(path, []byte("id,name\n1,a\n2,b\n3,c\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := filesource.NewFileSourceWithCodec(path, mustNew(t, Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if _, err := source.Read(); err != nil {
+		t.Fatal(err)
+	}
+	position, err := source.Position()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := source.Seek(position); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := source.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Payload.Data["id"].Value != int64(2) || msg.Payload.Data["name"].Value != "b" {
+		t.Errorf("after seek = %v", msg.Payload.Data)
+	}
+	if msg.ID != path+":"+string(position) {
+		t.Errorf("id = %s", msg.ID)
+	}
+}
+
+func TestCodec_RoundTripWithoutSchema(t *testing.T) {
+	input := "zip,phone,id,price\n02134,+15551234,007,1.50\n10001,,7,-0.5\n"
+	c := mustNew(t, Config{})
+	records := decodeAll(t, c, input)
+	if len(records) != 2 {
+		t.Fatalf("records = %d", len(records))
+	}
+	if r := records[0].Data; r["zip"].Value != "02134" || r["phone"].Value != "+15551234" || r["id"].Value != "007" {
+		t.Errorf("non-canonical numbers = %v", r)
+	}
+	if r := records[1].Data; r["zip"].Value != int64(10001) || r["id"].Value != int64(7) {
+		t.Errorf("canonical numbers = %v", r)
+	}
+
+	var buf bytes.Buffer
+	encoder := codec.NewEncoder(c, &buf)
+	for _, r := range records {
+		if err := encoder.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != input {
+		t.Errorf("encoded = %q, want %q", buf.String(), input)
+	}
+}
@@ -0,0 +1,241 @@
+// Package jsonl 每行一个 JSON 对象的格式
+package jsonl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const Name = "jsonl"
+
+func init() {
+	codec.Register(Name, func(opts codec.Options) (codec.Codec, error) {
+		if err := codec.CheckParams(Name, opts.Params, "numbers"); err != nil {
+			return nil, err
+		}
+		config := Config{Schema: opts.Schema}
+		switch opts.Params["numbers"] {
+		case "", "exact":
+		case "float":
+			config.Floats = true
+		default:
+			return nil, fmt.Errorf("codec %s: numbers must be exact or float, got %q", Name, opts.Params["numbers"])
+		}
+		return New(config), nil
+	})
+}
+
+type Config struct {
+	// Schema 非空时按字段类型解码，不在 schema 中的字段按 JSON 值推断类型
+	Schema *pipeline.Schema
+	// Floats 推断类型时把非整数和超出 int64 的数字解码为 float64，
+	// 默认解码为 Decimal 以保留全部数位
+	Floats bool
+}
+
+// Codec 解码时对象和数组转换为 dict 和 list，整数在 int64 范围内时为 int64；空行被跳过。
+// 编码时 schema 中的字段按 schema 的顺序输出，其余字段按名称排序，相同记录总是得到相同的字节
+type Codec struct {
+	config Config
+	fields map[string]*pipeline.Field
+}
+
+func New(config Config) *Codec {
+	c := &Codec{config: config}
+	if config.Schema != nil {
+		c.fields = make(map[string]*pipeline.Field, len(config.Schema.Fields))
+		for i := range config.Schema.Fields {
+			c.fields[config.Schema.Fields[i].Name] = &config.Schema.Fields[i]
+		}
+	}
+	return c
+}
+
+func (c *Codec) Name() string {
+	return Name
+}
+
+func (c *Codec) Decode(data []byte) (*pipeline.Record, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var obj map[string]json.RawMessage
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("decode JSON object: %w", err)
+	}
+	if obj == nil {
+		return nil, fmt.Errorf("decode JSON object: got null")
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON object")
+	}
+
+	record := &pipeline.Record{
+		Schema:    c.config.Schema,
+		Data:      make(map[string]pipeline.Value, len(obj)),
+		Timestamp: time.Now(),
+	}
+	for name, raw := range obj {
+		value, err := c.decodeField(raw, c.fields[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		record.Data[name] = value
+	}
+	return record, nil
+}
+
+func (c *Codec) decodeField(raw json.RawMessage, field *pipeline.Field) (pipeline.Value, error) {
+	if field != nil && field.Type == pipeline.TypeJSON {
+		if bytes.Equal(raw, []byte("null")) {
+			return pipeline.Null, nil
+		}
+		// keep the document as written instead of re-encoding it
+		return pipeline.Value{Type: pipeline.TypeJSON, Value: raw}, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return pipeline.Value{}, err
+	}
+	if field == nil {
+		return c.infer(v), nil
+	}
+	return pipeline.FromNative(v, *field)
+}
+
+// infer 按 JSON 值推断类型，数字按 Floats 的设置转换
+func (c *Codec) infer(v any) pipeline.Value {
+	switch v := v.(type) {
+	case map[string]any:
+		dict := make(map[string]pipeline.Value, len(v))
+		for name, member := range v {
+			dict[name] = c.infer(member)
+		}
+		return pipeline.Value{Type: pipeline.TypeDict, Value: dict}
+	case []any:
+		list := make([]pipeline.Value, len(v))
+		for i, elem := range v {
+			list[i] = c.infer(elem)
+		}
+		return pipeline.Value{Type: pipeline.TypeList, Value: list}
+	case json.Number:
+		if c.config.Floats {
+			if _, err := v.Int64(); err != nil {
+				if f, err := v.Float64(); err == nil {
+					return pipeline.Value{Type: pipeline.TypeFloat64, Value: f}
+				}
+			}
+		}
+	}
+	return pipeline.ValueOf(v)
+}
+
+func (c *Codec) Encode(record *pipeline.Record) ([]byte, error) {
+	schema := c.config.Schema
+	if schema == nil {
+		schema = record.Schema
+	}
+	var fields []pipeline.Field
+	if schema != nil {
+		fields = schema.Fields
+	}
+	var buf bytes.Buffer
+	if err := encodeDict(&buf, record.Data, fields, ""); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeDict(buf *bytes.Buffer, dict map[string]pipeline.Value, fields []pipeline.Field, path string) error {
+	names := make([]string, 0, len(dict))
+	nested := make(map[string]*pipeline.Field, len(fields))
+	for i := range fields {
+		if _, ok := dict[fields[i].Name]; ok {
+			names = append(names, fields[i].Name)
+			nested[fields[i].Name] = &fields[i]
+		}
+	}
+	extra := make([]string, 0, len(dict)-len(names))
+	for name := range dict {
+		if _, ok := nested[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		if err := encodeValue(buf, dict[name], nested[name], joinPath(path, name)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func encodeValue(buf *bytes.Buffer, value pipeline.Value, field *pipeline.Field, path string) error {
+	switch inner := value.Value.(type) {
+	case map[string]pipeline.Value:
+		var fields []pipeline.Field
+		if field != nil {
+			fields = field.Fields
+		}
+		return encodeDict(buf, inner, fields, path)
+	case []pipeline.Value:
+		var elem *pipeline.Field
+		if field != nil {
+			elem = field.Elem
+		}
+		buf.WriteByte('[')
+		for i, item := range inner {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeValue(buf, item, elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	case json.RawMessage:
+		// a pretty-printed document would break the one-object-per-line framing
+		if err := json.Compact(buf, inner); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	buf.Write(data)
+	return nil
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
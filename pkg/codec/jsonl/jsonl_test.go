@@ -0,0 +1,142 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func TestDecode_Inferred(t *testing.T) {
+	c := New(Config{})
+	record, err := c.Decode([]byte(`{"id": 12345678901234567890, "n": 7, "price": 0.10, "tags": ["a", null], "user": {"name": "x", "ok": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := record.Data["id"]; v.Type != pipeline.TypeDecimal || v.Value.(pipeline.Decimal).String() != "12345678901234567890" {
+		t.Errorf("id = %#v", v)
+	}
+	if v := record.Data["n"]; v.Type != pipeline.TypeInt64 || v.Value != int64(7) {
+		t.Errorf("n = %#v", v)
+	}
+	if v := record.Data["price"]; v.Type != pipeline.TypeDecimal || v.Value.(pipeline.Decimal).String() != "0.10" {
+		t.Errorf("price = %#v", v)
+	}
+	tags := record.Data["tags"].Value.([]pipeline.Value)
+	if len(tags) != 2 || tags[0].Value != "a" || !tags[1].IsNull() {
+		t.Errorf("tags = %#v", tags)
+	}
+	if user := record.Data["user"]; user.Type != pipeline.TypeDict || user.Value.(map[string]pipeline.Value)["ok"].Value != true {
+		t.Errorf("user = %#v", user)
+	}
+
+	record, err = New(Config{Floats: true}).Decode([]byte(`{"price": 0.5, "n": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Data["price"].Value != 0.5 || record.Data["n"].Value != int64(2) {
+		t.Errorf("floats = %v", record.Data)
+	}
+}
+
+func TestDecode_Schema(t *testing.T) {
+	scale := 2
+	s := &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "amount", Type: pipeline.TypeDecimal, Scale: &scale},
+		{Name: "ratio", Type: pipeline.TypeFloat64},
+		{Name: "at", Type: pipeline.TypeTimestamp},
+		{Name: "raw", Type: pipeline.TypeJSON},
+		{Name: "items", Type: pipeline.TypeList, Elem: &pipeline.Field{Type: pipeline.TypeDict, Fields: []pipeline.Field{
+			{Name: "qty", Type: pipeline.TypeInt64},
+		}}},
+	}}
+	record, err := New(Config{Schema: s}).Decode([]byte(`{"amount": 1.5, "ratio": 1, "at": "2024-01-02T03:04:05Z", "raw": {"b": 1,  "a": 2}, "items": [{"qty": 3}], "extra": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Schema != s {
+		t.Error("record schema not set")
+	}
+	if v := record.Data["amount"].Value.(pipeline.Decimal); v.String() != "1.50" {
+		t.Errorf("amount = %s", v)
+	}
+	if v := record.Data["ratio"]; v.Type != pipeline.TypeFloat64 || v.Value != 1.0 {
+		t.Errorf("ratio = %#v", v)
+	}
+	if v := record.Data["at"]; v.Type != pipeline.TypeTimestamp {
+		t.Errorf("at = %#v", v)
+	}
+	if v := record.Data["raw"]; string(v.Value.(json.RawMessage)) != `{"b": 1,  "a": 2}` {
+		t.Errorf("raw = %s", v.Value)
+	}
+	items := record.Data["items"].Value.([]pipeline.Value)
+	if qty := items[0].Value.(map[string]pipeline.Value)["qty"]; qty.Type != pipeline.TypeInt64 {
+		t.Errorf("qty = %#v", qty)
+	}
+	if v := record.Data["extra"]; v.Type != pipeline.TypeInt64 {
+		t.Errorf("extra = %#v", v)
+	}
+
+	if _, err := New(Config{Schema: s}).Decode([]byte(`{"ratio": "x"}`)); err == nil {
+		t.Error("string accepted for float64 field")
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	c := New(Config{})
+	for _, line := range []string{`[1]`, `null`, `{"a": 1} {"b": 2}`, `{"a":`} {
+		if _, err := c.Decode([]byte(line)); err == nil {
+			t.Errorf("%s: no error", line)
+		}
+	}
+	if record, err := c.Decode([]byte("  ")); record != nil || err != nil {
+		t.Errorf("blank line = %v, %v", record, err)
+	}
+}
+
+func TestEncode_Deterministic(t *testing.T) {
+	s := &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "z", Type: pipeline.TypeString},
+		{Name: "user", Type: pipeline.TypeDict, Fields: []pipeline.Field{
+			{Name: "name", Type: pipeline.TypeString},
+			{Name: "age", Type: pipeline.TypeInt64},
+		}},
+	}}
+	input := `{"z":"last","user":{"name":"x","age":3,"b":true,"a":null},"big":12345678901234567890,"price":0.10,"raw":{"k":[1, 2]}}`
+	c := New(Config{Schema: s})
+	record, err := c.Decode([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.Data["raw"] = pipeline.Value{Type: pipeline.TypeJSON, Value: json.RawMessage("{\n  \"k\": [1, 2]\n}")}
+
+	want := `{"z":"last","user":{"name":"x","age":3,"a":null,"b":true},"big":12345678901234567890,"price":0.10,"raw":{"k":[1,2]}}`
+	for i := 0; i < 5; i++ {
+		data, err := c.Encode(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("encoded = %s\nwant      %s", data, want)
+		}
+	}
+
+	// without a schema keys are sorted
+	data, err := New(Config{}).Encode(&pipeline.Record{Data: record.Data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"big":12345678901234567890,"price":0.10,"raw":{"k":[1,2]},"user":{"a":null,"age":3,"b":true,"name":"x"},"z":"last"}` {
+		t.Errorf("sorted = %s", data)
+	}
+}
+
+func TestFactory(t *testing.T) {
+	if _, err := codec.New(Name, codec.Options{Params: map[string]string{"numbers": "float"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.New(Name, codec.Options{Params: map[string]string{"numbers": "big"}}); err == nil {
+		t.Error("invalid numbers accepted")
+	}
+}
@@ -0,0 +1,248 @@
+// Package logfmt 每行由空格分隔的 key=value 组成的日志格式
+package logfmt
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const Name = "logfmt"
+
+func init() {
+	codec.Register(Name, func(opts codec.Options) (codec.Codec, error) {
+		if err := codec.CheckParams(Name, opts.Params, "numbers"); err != nil {
+			return nil, err
+		}
+		config := Config{Schema: opts.Schema}
+		switch opts.Params["numbers"] {
+		case "", "exact":
+		case "float":
+			config.Floats = true
+		default:
+			return nil, fmt.Errorf("codec %s: numbers must be exact or float, got %q", Name, opts.Params["numbers"])
+		}
+		return New(config), nil
+	})
+}
+
+type Config struct {
+	// Schema 非空时其中的字段按字段类型转换，其余字段按内容推断类型
+	Schema *pipeline.Schema
+	// Floats 推断类型时把非整数解码为 float64，默认解码为 Decimal
+	Floats bool
+}
+
+// Codec 解码 `level=info msg="hello world" took=12ms cached` 这样的行。
+// 加引号的值总是字符串，未加引号的值按 codec.InferString 推断类型，只有键没有 = 的为 true，
+// key= 为空值。编码时 schema 中的字段按 schema 的顺序输出，其余按名称排序
+type Codec struct {
+	config Config
+	fields map[string]pipeline.Field
+}
+
+func New(config Config) *Codec {
+	c := &Codec{config: config}
+	if config.Schema != nil {
+		c.fields = make(map[string]pipeline.Field, len(config.Schema.Fields))
+		for _, f := range config.Schema.Fields {
+			c.fields[f.Name] = f
+		}
+	}
+	return c
+}
+
+func (c *Codec) Name() string {
+	return Name
+}
+
+func (c *Codec) Decode(data []byte) (*pipeline.Record, error) {
+	pairs, err := parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	record := &pipeline.Record{
+		Schema:    c.config.Schema,
+		Data:      make(map[string]pipeline.Value, len(pairs)),
+		Timestamp: time.Now(),
+	}
+	for _, p := range pairs {
+		field, typed := c.fields[p.key]
+		switch {
+		case !p.hasValue:
+			record.Data[p.key] = pipeline.Value{Type: pipeline.TypeBoolean, Value: true}
+		case typed && p.value == "" && !p.quoted && field.Type != pipeline.TypeString:
+			record.Data[p.key] = pipeline.Null
+		case typed || p.quoted:
+			record.Data[p.key] = pipeline.Value{Type: pipeline.TypeString, Value: p.value}
+		default:
+			record.Data[p.key] = codec.InferString(p.value, c.config.Floats)
+		}
+	}
+	if c.config.Schema != nil {
+		if err := c.config.Schema.Coerce(record, pipeline.CoerceStrict); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+type pair struct {
+	key      string
+	value    string
+	hasValue bool
+	quoted   bool
+}
+
+// parse 后出现的重复键覆盖前面的值
+func parse(line string) ([]pair, error) {
+	var pairs []pair
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return pairs, nil
+		}
+
+		start := i
+		for i < len(line) && !isSpace(line[i]) && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("column %d: expected a key, got %q", i+1, line[i])
+		}
+		p := pair{key: line[start:i]}
+		if i == len(line) || isSpace(line[i]) {
+			pairs = append(pairs, p)
+			continue
+		}
+		if line[i] == '"' {
+			return nil, fmt.Errorf("column %d: unexpected quote in key %s", i+1, p.key)
+		}
+
+		i++ // '='
+		p.hasValue = true
+		if i < len(line) && line[i] == '"' {
+			end, err := closingQuote(line, i)
+			if err != nil {
+				return nil, fmt.Errorf("column %d: value of %s: %w", i+1, p.key, err)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("column %d: value of %s: %w", i+1, p.key, err)
+			}
+			p.value, p.quoted = value, true
+			i = end + 1
+			if i < len(line) && !isSpace(line[i]) {
+				return nil, fmt.Errorf("column %d: expected a space after the value of %s", i+1, p.key)
+			}
+		} else {
+			start = i
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			p.value = line[start:i]
+		}
+		pairs = append(pairs, p)
+	}
+}
+
+func closingQuote(line string, open int) (int, error) {
+	for i := open + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated quoted value")
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t'
+}
+
+func (c *Codec) Encode(record *pipeline.Record) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, name := range c.keys(record) {
+		if !validKey(name) {
+			return nil, fmt.Errorf("field name %q cannot be written as a logfmt key", name)
+		}
+		value := record.Data[name]
+		s, err := codec.FormatString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		if c.needsQuotes(value, s) {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	return buf.Bytes(), nil
+}
+
+// keys schema 中的字段在前，其余按名称排序
+func (c *Codec) keys(record *pipeline.Record) []string {
+	schema := c.config.Schema
+	if schema == nil {
+		schema = record.Schema
+	}
+	keys := make([]string, 0, len(record.Data))
+	seen := make(map[string]bool, len(record.Data))
+	if schema != nil {
+		for _, f := range schema.Fields {
+			if _, ok := record.Data[f.Name]; ok {
+				keys = append(keys, f.Name)
+				seen[f.Name] = true
+			}
+		}
+	}
+	extra := make([]string, 0, len(record.Data)-len(keys))
+	for name := range record.Data {
+		if !seen[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	return append(keys, extra...)
+}
+
+// needsQuotes 除了包含空格、引号、= 和控制字符的文本，字符串在读回时会被推断为其他类型的也加引号，
+// 例如字符串 "42"，这样解码后的类型不变
+func (c *Codec) needsQuotes(value pipeline.Value, s string) bool {
+	if value.IsNull() {
+		return false
+	}
+	if s == "" || strings.ContainsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r)
+	}) || !utf8.ValidString(s) {
+		return true
+	}
+	return value.Type == pipeline.TypeString && codec.InferString(s, c.config.Floats).Type != pipeline.TypeString
+}
+
+func validKey(key string) bool {
+	return key != "" && !strings.ContainsFunc(key, func(r rune) bool {
+		return r == ' ' || r == '=' || r == '"' || !unicode.IsPrint(r)
+	})
+}
@@ -0,0 +1,124 @@
+package logfmt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/stage/transform"
+)
+
+func TestDecode(t *testing.T) {
+	record, err := New(Config{}).Decode([]byte(`ts=2024-01-02T03:04:05Z level=info msg="hello \"world\"" status=200 took=12ms ratio=0.25 cached user= id="42"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := record.Data
+	checks := map[string]pipeline.FieldType{
+		"ts": pipeline.TypeTimestamp, "level": pipeline.TypeString, "msg": pipeline.TypeString,
+		"status": pipeline.TypeInt64, "took": pipeline.TypeDuration, "ratio": pipeline.TypeDecimal,
+		"cached": pipeline.TypeBoolean, "user": pipeline.TypeNull, "id": pipeline.TypeString,
+	}
+	for name, want := range checks {
+		if d[name].Type != want {
+			t.Errorf("%s: type = %s, want %s", name, d[name].Type, want)
+		}
+	}
+	if d["msg"].Value != `hello "world"` || d["took"].Value != 12*time.Millisecond || d["id"].Value != "42" {
+		t.Errorf("values = %v", d)
+	}
+
+	if record, err := New(Config{}).Decode([]byte("   ")); record != nil || err != nil {
+		t.Errorf("blank line = %v, %v", record, err)
+	}
+	for _, line := range []string{`=x`, `a="open`, `a="x"b`, `a"b=1`} {
+		if _, err := New(Config{}).Decode([]byte(line)); err == nil {
+			t.Errorf("%s: no error", line)
+		}
+	}
+}
+
+func TestDecode_Schema(t *testing.T) {
+	s := &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "status", Type: pipeline.TypeString},
+		{Name: "bytes", Type: pipeline.TypeInt64, Nullable: true},
+		{Name: "at", Type: pipeline.TypeTimestamp, Precision: pipeline.PrecisionMilli},
+	}}
+	record, err := New(Config{Schema: s}).Decode([]byte(`status=200 bytes= at=1700000000123 ratio=0.5`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Data["status"].Value != "200" || !record.Data["bytes"].IsNull() {
+		t.Errorf("data = %v", record.Data)
+	}
+	if ts := record.Data["at"].Value.(time.Time); ts.UnixMilli() != 1700000000123 {
+		t.Errorf("at = %v", ts)
+	}
+
+	_, err = New(Config{Schema: s}).Decode([]byte(`bytes=lots`))
+	if pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestEncode_RoundTrip(t *testing.T) {
+	c := New(Config{Schema: &pipeline.Schema{Fields: []pipeline.Field{{Name: "msg", Type: pipeline.TypeString}}}})
+	record := &pipeline.Record{Data: map[string]pipeline.Value{
+		"msg":    pipeline.ValueOf("disk full\n"),
+		"code":   pipeline.ValueOf("42"),
+		"status": pipeline.ValueOf(500),
+		"ok":     pipeline.ValueOf(false),
+		"user":   pipeline.Null,
+		"empty":  pipeline.ValueOf(""),
+	}}
+	data, err := c.Encode(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `msg="disk full\n" code="42" empty="" ok=false status=500 user=`
+	if string(data) != want {
+		t.Fatalf("encoded = %s\nwant      %s", data, want)
+	}
+
+	decoded, err := c.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range record.Data {
+		if !decoded.Data[name].Equal(value) {
+			t.Errorf("%s: decoded %#v, want %#v", name, decoded.Data[name], value)
+		}
+	}
+
+	if _, err := c.Encode(&pipeline.Record{Data: map[string]pipeline.Value{"a b": pipeline.ValueOf(1)}}); err == nil {
+		t.Error("key with a space accepted")
+	}
+}
+
+func TestFeedsExprTransform(t *testing.T) {
+	c, err := codec.New(Name, codec.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := codec.NewDecoder(c, strings.NewReader("method=GET status=503 took=1500ms\n"))
+	record, err := decoder.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transformer := transform.NewExprTransformer(transform.TransformConfig{
+		KeepInput: true,
+		Rules: []transform.TransformRule{
+			{Target: "failed", Expr: "status >= 500", Type: pipeline.TypeBoolean},
+		},
+	})
+	out, err := transformer.Process(pipeline.NewMessage(record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Payload.Data["failed"].Value != true || out.Payload.Data["method"].Value != "GET" {
+		t.Errorf("output = %v", out.Payload.Data)
+	}
+}
@@ -0,0 +1,461 @@
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// extTimestamp MessagePack 规范中时间戳扩展类型的编号
+const extTimestamp = -1
+
+// maxDepth 限制嵌套层数，避免恶意输入耗尽栈
+const maxDepth = 512
+
+// errSyntax 数据不是合法的 MessagePack，流无法继续读取
+type errSyntax struct {
+	offset int64
+	msg    string
+}
+
+func (e *errSyntax) Error() string {
+	return fmt.Sprintf("invalid MessagePack at offset %d: %s", e.offset, e.msg)
+}
+
+type reader struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+func (r *reader) syntax(format string, args ...any) error {
+	return &errSyntax{offset: r.pos, msg: fmt.Sprintf(format, args...)}
+}
+
+func (r *reader) byte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, errUnexpected(err)
+	}
+	r.pos++
+	return b, nil
+}
+
+// full 分块读取，声明的长度大于实际数据时不会预先分配全部内存
+func (r *reader) full(n uint64) ([]byte, error) {
+	const chunk = 64 << 10
+	if n <= chunk {
+		buf := make([]byte, n)
+		read, err := io.ReadFull(r.r, buf)
+		r.pos += int64(read)
+		return buf, errUnexpected(err)
+	}
+	var buf bytes.Buffer
+	read, err := io.CopyN(&buf, r.r, int64(n))
+	r.pos += read
+	return buf.Bytes(), errUnexpected(err)
+}
+
+func (r *reader) uint(size int) (uint64, error) {
+	b, err := r.full(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func errUnexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// value 读取一个值，返回 FromNative 和 ValueOf 能处理的 Go 值：nil、bool、int64、float64、
+// string、[]byte、time.Time、[]any 和 map[string]any；超出 int64 的 uint64 为 pipeline.Decimal
+func (r *reader) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, r.syntax("nested deeper than %d levels", maxDepth)
+	}
+	b, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return r.dict(uint64(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return r.list(uint64(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		data, err := r.full(uint64(b & 0x1f))
+		return string(data), err
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.uint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return r.full(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := r.uint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return r.ext(n)
+	case 0xca:
+		n, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := r.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := r.uint(1 << (b - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return pipeline.NewDecimalFromBigInt(new(big.Int).SetUint64(n), 0), nil
+		}
+		return int64(n), nil
+	case 0xd0:
+		n, err := r.uint(1)
+		return int64(int8(n)), err
+	case 0xd1:
+		n, err := r.uint(2)
+		return int64(int16(n)), err
+	case 0xd2:
+		n, err := r.uint(4)
+		return int64(int32(n)), err
+	case 0xd3:
+		n, err := r.uint(8)
+		return int64(n), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return r.ext(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.full(n)
+		return string(data), err
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.list(n, depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.dict(n, depth)
+	}
+	return nil, r.syntax("unused format byte 0x%02x", b)
+}
+
+func (r *reader) list(n uint64, depth int) ([]any, error) {
+	list := make([]any, 0, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		item, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// dict 整数键转换为十进制文本，其余非字符串键无法表示为 dict
+func (r *reader) dict(n uint64, depth int) (map[string]any, error) {
+	dict := make(map[string]any, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		start := r.pos
+		key, err := r.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		var name string
+		switch k := key.(type) {
+		case string:
+			name = k
+		case int64:
+			name = strconv.FormatInt(k, 10)
+		case pipeline.Decimal:
+			name = k.String()
+		default:
+			return nil, &errSyntax{offset: start, msg: fmt.Sprintf("map key of type %T is not supported", key)}
+		}
+		if dict[name], err = r.value(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return dict, nil
+}
+
+// ext 时间戳扩展解码为 time.Time，其余扩展类型保留数据部分，解码为 bytes
+func (r *reader) ext(n uint64) (any, error) {
+	t, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	start := r.pos
+	data, err := r.full(n)
+	if err != nil || int8(t) != extTimestamp {
+		return data, err
+	}
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		n := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(n&(1<<34-1)), int64(n>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(data)
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(nsec)).UTC(), nil
+	}
+	return nil, &errSyntax{offset: start, msg: fmt.Sprintf("timestamp extension of %d bytes", len(data))}
+}
+
+// appendValue 按值的类型编码，field 只用于 duration 的单位和 dict 成员的顺序
+func appendValue(buf []byte, v pipeline.Value, field *pipeline.Field, path string) ([]byte, error) {
+	switch inner := v.Value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if inner {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case int64:
+		return appendInt(buf, inner), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(inner)), nil
+	case string:
+		return appendString(buf, inner), nil
+	case []byte:
+		return appendBytes(buf, inner), nil
+	case pipeline.Decimal:
+		// MessagePack has no decimal type, the text keeps every digit
+		return appendString(buf, inner.String()), nil
+	case time.Time:
+		return appendTimestamp(buf, inner), nil
+	case time.Duration:
+		var precision pipeline.TimePrecision
+		if field != nil {
+			precision = field.Precision
+		}
+		unit, err := precision.Unit()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return appendInt(buf, int64(inner/unit)), nil
+	case json.RawMessage:
+		decoder := json.NewDecoder(bytes.NewReader(inner))
+		decoder.UseNumber()
+		var doc any
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return appendJSON(buf, doc)
+	case []pipeline.Value:
+		var elem *pipeline.Field
+		if field != nil {
+			elem = field.Elem
+		}
+		buf = appendHeader(buf, len(inner), 0x90, 0xdc)
+		for i, item := range inner {
+			var err error
+			if buf, err = appendValue(buf, item, elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]pipeline.Value:
+		var fields []pipeline.Field
+		if field != nil {
+			fields = field.Fields
+		}
+		return appendDict(buf, inner, fields, path)
+	}
+	return nil, fmt.Errorf("%s: cannot encode %s", path, v.Type)
+}
+
+// appendDict schema 中的字段按 schema 的顺序在前，其余按名称排序，相同记录总是得到相同的字节
+func appendDict(buf []byte, dict map[string]pipeline.Value, fields []pipeline.Field, path string) ([]byte, error) {
+	names := make([]string, 0, len(dict))
+	nested := make(map[string]*pipeline.Field, len(fields))
+	for i := range fields {
+		if _, ok := dict[fields[i].Name]; ok {
+			names = append(names, fields[i].Name)
+			nested[fields[i].Name] = &fields[i]
+		}
+	}
+	extra := make([]string, 0, len(dict)-len(names))
+	for name := range dict {
+		if _, ok := nested[name]; !ok {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	buf = appendHeader(buf, len(names), 0x80, 0xde)
+	for _, name := range names {
+		buf = appendString(buf, name)
+		var err error
+		if buf, err = appendValue(buf, dict[name], nested[name], joinPath(path, name)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendJSON 编码 json 文档，整数为 int，其余数字为 float64
+func appendJSON(buf []byte, doc any) ([]byte, error) {
+	switch v := doc.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return appendInt(buf, n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f)), nil
+	case []any:
+		buf = appendHeader(buf, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			var err error
+			if buf, err = appendJSON(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf = appendHeader(buf, len(keys), 0x80, 0xde)
+		for _, k := range keys {
+			buf = appendString(buf, k)
+			var err error
+			if buf, err = appendJSON(buf, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return appendValue(buf, pipeline.ValueOf(doc), nil, "")
+}
+
+// appendInt 使用最短的编码，非负数按无符号整数编码
+func appendInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n <= 0x7f, n < 0 && n >= -32:
+		return append(buf, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		return append(buf, 0xcc, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(n))
+	case n >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(n))
+	case n >= math.MinInt8:
+		return append(buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+}
+
+func appendString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n <= 31:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xc5), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xc6), uint32(n))
+	}
+	return append(buf, b...)
+}
+
+// appendHeader 写出数组或 map 的长度，fix 为 4 位长度的格式，wide 为 16 位长度的格式，
+// 其后一个字节为 32 位长度的格式
+func appendHeader(buf []byte, n int, fix, wide byte) []byte {
+	switch {
+	case n <= 15:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, wide), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, wide+1), uint32(n))
+}
+
+// appendTimestamp 按规范选择 32、64 或 96 位的时间戳扩展
+func appendTimestamp(buf []byte, t time.Time) []byte {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	switch {
+	case sec >= 0 && sec <= math.MaxUint32 && nsec == 0:
+		buf = append(buf, 0xd6, 0xff)
+		return binary.BigEndian.AppendUint32(buf, uint32(sec))
+	case sec >= 0 && sec < 1<<34:
+		buf = append(buf, 0xd7, 0xff)
+		return binary.BigEndian.AppendUint64(buf, uint64(nsec)<<34|uint64(sec))
+	}
+	buf = append(buf, 0xc7, 12, 0xff)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(nsec))
+	return binary.BigEndian.AppendUint64(buf, uint64(sec))
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,163 @@
+// Package msgpack MessagePack 二进制格式。每条记录是一个 map，流中的记录直接首尾相接
+package msgpack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const Name = "msgpack"
+
+func init() {
+	codec.Register(Name, func(opts codec.Options) (codec.Codec, error) {
+		if err := codec.CheckParams(Name, opts.Params); err != nil {
+			return nil, err
+		}
+		return New(Config{Schema: opts.Schema}), nil
+	})
+}
+
+type Config struct {
+	// Schema 非空时按字段类型解码，不在 schema 中的字段按 MessagePack 的类型推断；
+	// 编码时 schema 中的字段按 schema 的顺序在前，duration 按字段的 Precision 计数
+	Schema *pipeline.Schema
+}
+
+// Codec 推断类型时 nil 为空值，整数为 int64（超出 int64 的无符号整数为 decimal），
+// float 32/64 为 float64，str 为 string，bin 为 bytes，array 和 map 为 list 和 dict，
+// 时间戳扩展（-1）为 timestamp，其余扩展类型只保留数据部分，为 bytes。
+// MessagePack 没有的类型编码时 decimal 为 str，duration 为整数，json 为对应的 array、map 和标量，
+// 需要 schema 才能按原来的类型读回
+type Codec struct {
+	config Config
+	fields map[string]*pipeline.Field
+}
+
+func New(config Config) *Codec {
+	c := &Codec{config: config}
+	if config.Schema != nil {
+		c.fields = make(map[string]*pipeline.Field, len(config.Schema.Fields))
+		for i := range config.Schema.Fields {
+			c.fields[config.Schema.Fields[i].Name] = &config.Schema.Fields[i]
+		}
+	}
+	return c
+}
+
+func (c *Codec) Name() string {
+	return Name
+}
+
+// Decode 解码一个 MessagePack map，之后不能有多余的数据
+func (c *Codec) Decode(data []byte) (*pipeline.Record, error) {
+	r := &reader{r: bufio.NewReader(bytes.NewReader(data))}
+	v, err := r.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != int64(len(data)) {
+		return nil, fmt.Errorf("%d bytes after the MessagePack value", int64(len(data))-r.pos)
+	}
+	return c.record(v)
+}
+
+func (c *Codec) record(v any) (*pipeline.Record, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a map, got %T", v)
+	}
+	record := &pipeline.Record{
+		Schema:    c.config.Schema,
+		Data:      make(map[string]pipeline.Value, len(obj)),
+		Timestamp: time.Now(),
+	}
+	for name, member := range obj {
+		field, ok := c.fields[name]
+		if !ok {
+			record.Data[name] = pipeline.ValueOf(member)
+			continue
+		}
+		value, err := pipeline.FromNative(member, *field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		record.Data[name] = value
+	}
+	return record, nil
+}
+
+func (c *Codec) Encode(record *pipeline.Record) ([]byte, error) {
+	schema := c.config.Schema
+	if schema == nil {
+		schema = record.Schema
+	}
+	var fields []pipeline.Field
+	if schema != nil {
+		fields = schema.Fields
+	}
+	return appendDict(nil, record.Data, fields, "")
+}
+
+func (c *Codec) NewDecoder(r io.Reader) codec.Decoder {
+	return &decoder{codec: c, in: &reader{r: bufio.NewReader(r)}}
+}
+
+func (c *Codec) NewEncoder(w io.Writer) codec.Encoder {
+	return &encoder{codec: c, w: w}
+}
+
+// decoder 数据不是合法的 MessagePack 时无法找到下一条记录的开头，之后一直返回同一个错误；
+// 合法但不能转换为记录的值返回 KindConversion，可以跳过
+type decoder struct {
+	codec *Codec
+	in    *reader
+	err   error
+}
+
+func (d *decoder) Decode() (*pipeline.Record, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if _, err := d.in.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+	start := d.in.pos
+	v, err := d.in.value(0)
+	if err != nil {
+		d.err = fmt.Errorf("%s: %w", Name, err)
+		return nil, d.err
+	}
+	record, err := d.codec.record(v)
+	if err != nil {
+		return nil, pipeline.Errorf(pipeline.KindConversion, "%s: value at offset %d: %w", Name, start, err)
+	}
+	return record, nil
+}
+
+func (d *decoder) Offset() int64 {
+	return d.in.pos
+}
+
+type encoder struct {
+	codec *Codec
+	w     io.Writer
+}
+
+func (e *encoder) Encode(record *pipeline.Record) error {
+	data, err := e.codec.Encode(record)
+	if err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "%s: %w", Name, err)
+	}
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *encoder) Close() error {
+	return nil
+}
@@ -0,0 +1,217 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip_Schema(t *testing.T) {
+	scale := 2
+	schema := &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "id", Type: pipeline.TypeInt64},
+		{Name: "price", Type: pipeline.TypeDecimal, Scale: &scale},
+		{Name: "ratio", Type: pipeline.TypeFloat64},
+		{Name: "ok", Type: pipeline.TypeBoolean},
+		{Name: "at", Type: pipeline.TypeTimestamp},
+		{Name: "old", Type: pipeline.TypeTimestamp},
+		{Name: "took", Type: pipeline.TypeDuration, Precision: pipeline.PrecisionMilli},
+		{Name: "raw", Type: pipeline.TypeBytes},
+		{Name: "doc", Type: pipeline.TypeJSON},
+		{Name: "tags", Type: pipeline.TypeList, Elem: &pipeline.Field{Type: pipeline.TypeString}},
+		{Name: "address", Type: pipeline.TypeDict, Fields: []pipeline.Field{
+			{Name: "city", Type: pipeline.TypeString},
+		}},
+		{Name: "note", Type: pipeline.TypeString, Nullable: true},
+	}}
+	record := &pipeline.Record{Data: map[string]pipeline.Value{
+		"id":      pipeline.ValueOf(int64(-1) << 40),
+		"price":   pipeline.ValueOf(pipeline.MustParseDecimal("-12345678901234567890.25")),
+		"ratio":   pipeline.ValueOf(2.0),
+		"ok":      pipeline.ValueOf(true),
+		"at":      pipeline.ValueOf(time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)),
+		"old":     pipeline.ValueOf(time.Date(1969, 7, 20, 20, 17, 40, 0, time.UTC)),
+		"took":    pipeline.ValueOf(1500 * time.Millisecond),
+		"raw":     pipeline.ValueOf([]byte{0, 1, 0xff}),
+		"doc":     pipeline.ValueOf(json.RawMessage(`{"a":[1,2.5,"x",null],"b":true}`)),
+		"tags":    pipeline.ValueOf([]any{"a", strings.Repeat("b", 300)}),
+		"address": pipeline.ValueOf(map[string]any{"city": "Oslo", "zip": int64(150)}),
+		"note":    pipeline.Null,
+		"extra":   pipeline.ValueOf(int64(70000)),
+	}}
+
+	c := New(Config{Schema: schema})
+	data, err := c.Encode(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := record.Diff(got); len(changes) != 0 {
+		t.Errorf("changes after round trip: %v", changes)
+	}
+
+	// without a schema the types MessagePack has survive and the rest come back as their encoding
+	got, err = New(Config{}).Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]pipeline.Value{
+		"ratio": pipeline.ValueOf(2.0),
+		"at":    record.Data["at"],
+		"old":   record.Data["old"],
+		"raw":   record.Data["raw"],
+		"price": pipeline.ValueOf("-12345678901234567890.25"),
+		"took":  pipeline.ValueOf(int64(1500)),
+	} {
+		if !got.Data[name].Equal(want) {
+			t.Errorf("%s = %#v, want %#v", name, got.Data[name], want)
+		}
+	}
+}
+
+func TestEncode_Deterministic(t *testing.T) {
+	schema := &pipeline.Schema{Fields: []pipeline.Field{{Name: "z", Type: pipeline.TypeInt64}}}
+	record := &pipeline.Record{Schema: schema, Data: map[string]pipeline.Value{
+		"b": pipeline.ValueOf(int64(-33)),
+		"a": pipeline.ValueOf("x"),
+		"z": pipeline.ValueOf(int64(200)),
+	}}
+	data, err := New(Config{}).Encode(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// schema fields first, the rest by name, integers in their shortest form
+	if want := mustHex(t, "83 a17a ccc8 a161 a178 a162 d0df"); !bytes.Equal(data, want) {
+		t.Errorf("encoded = % x, want % x", data, want)
+	}
+}
+
+func TestDecode_Inferred(t *testing.T) {
+	data := mustHex(t, "87"+
+		"a1 66 ca3fc00000"+ // f: float32 1.5
+		"a1 75 cfffffffffffffffff"+ // u: uint64 max
+		"a1 6e c0"+ // n: nil
+		"a1 74 d6ff 00000e10"+ // t: timestamp 32
+		"a1 65 d505 abcd"+ // e: extension type 5
+		"a1 6d 82 01 a161 02 c2"+ // m: {1: "a", 2: false}
+		"a1 6c 92 ff 90") // l: [-1, []]
+	got, err := New(Config{}).Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]pipeline.Value{
+		"f": pipeline.ValueOf(1.5),
+		"u": pipeline.ValueOf(pipeline.MustParseDecimal("18446744073709551615")),
+		"n": pipeline.Null,
+		"t": pipeline.ValueOf(time.Unix(3600, 0)),
+		"e": pipeline.ValueOf([]byte{0xab, 0xcd}),
+		"m": pipeline.ValueOf(map[string]any{"1": "a", "2": false}),
+		"l": pipeline.ValueOf([]any{int64(-1), []any{}}),
+	}
+	if changes := (&pipeline.Record{Data: want}).Diff(got); len(changes) != 0 {
+		t.Errorf("decoded changes: %v", changes)
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	c := New(Config{})
+	for name, input := range map[string]string{
+		"not a map":     "92 01 02",
+		"trailing":      "80 01",
+		"truncated":     "81 a161 cd00",
+		"unused byte":   "81 a161 c1",
+		"float key":     "81 cb3ff0000000000000 01",
+		"bad timestamp": "81 a161 d4ff 00",
+	} {
+		if _, err := c.Decode(mustHex(t, input)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	c := New(Config{})
+	var buf bytes.Buffer
+	encoder := c.NewEncoder(&buf)
+	for i := 0; i < 3; i++ {
+		if err := encoder.Encode(&pipeline.Record{Data: map[string]pipeline.Value{"i": pipeline.ValueOf(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	first := buf.Len() / 3
+	// a value that is not a map can be skipped
+	buf.Write(mustHex(t, "2a"))
+	buf.Write(mustHex(t, "81 a169 03"))
+
+	decoder := c.NewDecoder(bytes.NewReader(buf.Bytes()))
+	for i := 0; i < 5; i++ {
+		record, err := decoder.Decode()
+		if i == 3 {
+			if pipeline.KindOf(err) != pipeline.KindConversion {
+				t.Fatalf("non-map value: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := min(i, 3); !record.Data["i"].Equal(pipeline.ValueOf(n)) {
+			t.Errorf("record %d = %v", i, record.Data)
+		}
+		if i == 0 {
+			if offset := decoder.(codec.Offsetter).Offset(); offset != int64(first) {
+				t.Errorf("offset = %d, want %d", offset, first)
+			}
+		}
+	}
+	if _, err := decoder.Decode(); err != io.EOF {
+		t.Errorf("end of stream: %v", err)
+	}
+
+	// after a syntax error the stream cannot be resynchronised
+	decoder = c.NewDecoder(bytes.NewReader(mustHex(t, "c1 81 a169 03")))
+	_, syntaxErr := decoder.Decode()
+	_, again := decoder.Decode()
+	if syntaxErr == nil || again != syntaxErr || pipeline.KindOf(syntaxErr) == pipeline.KindConversion {
+		t.Errorf("syntax errors: %v, %v", syntaxErr, again)
+	}
+	decoder = c.NewDecoder(bytes.NewReader(mustHex(t, "81 a169")))
+	if _, err := decoder.Decode(); err == nil || err == io.EOF {
+		t.Errorf("truncated stream: %v", err)
+	}
+}
+
+func TestFactory(t *testing.T) {
+	if _, err := codec.New(Name, codec.Options{Params: map[string]string{"schema_file": "x"}}); err == nil {
+		t.Error("unknown parameter accepted")
+	}
+	c, err := codec.New(Name, codec.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(codec.StreamCodec); !ok {
+		t.Error("msgpack codec does not frame its own stream")
+	}
+}
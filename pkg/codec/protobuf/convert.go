@@ -0,0 +1,413 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// jsonMessages 按 protojson 格式与 json 互相转换的常用类型，Struct 和 ListValue 再转为 dict 和 list
+var jsonMessages = map[protoreflect.FullName]bool{
+	"google.protobuf.Any":       true,
+	"google.protobuf.Value":     true,
+	"google.protobuf.Struct":    true,
+	"google.protobuf.ListValue": true,
+}
+
+func isWrapper(name protoreflect.FullName) bool {
+	switch name {
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		return true
+	}
+	return false
+}
+
+// fromMessage fields 与消息描述符的字段一一对应，由 schema.FromProtoMessage 生成
+func (c *Codec) fromMessage(m protoreflect.Message, fields []pipeline.Field) (map[string]pipeline.Value, error) {
+	fds := m.Descriptor().Fields()
+	dict := make(map[string]pipeline.Value, fds.Len())
+	for i := 0; i < fds.Len(); i++ {
+		fd, field := fds.Get(i), fields[i]
+		value, err := c.fromField(m, fd, field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fd.Name(), err)
+		}
+		dict[field.Name] = value
+	}
+	return dict, nil
+}
+
+func (c *Codec) fromField(m protoreflect.Message, fd protoreflect.FieldDescriptor, field pipeline.Field) (pipeline.Value, error) {
+	switch {
+	case fd.IsMap():
+		dict := make(map[string]pipeline.Value, m.Get(fd).Map().Len())
+		var err error
+		m.Get(fd).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			var value pipeline.Value
+			if value, err = c.fromValue(fd.MapValue(), *field.Elem, v); err != nil {
+				err = fmt.Errorf("%s: %w", k.String(), err)
+				return false
+			}
+			dict[k.String()] = value
+			return true
+		})
+		if err != nil {
+			return pipeline.Value{}, err
+		}
+		return pipeline.Value{Type: pipeline.TypeDict, Value: dict}, nil
+
+	case fd.IsList():
+		l := m.Get(fd).List()
+		list := make([]pipeline.Value, l.Len())
+		for i := range list {
+			item, err := c.fromValue(fd, *field.Elem, l.Get(i))
+			if err != nil {
+				return pipeline.Value{}, fmt.Errorf("[%d]: %w", i, err)
+			}
+			list[i] = item
+		}
+		return pipeline.Value{Type: pipeline.TypeList, Value: list}, nil
+	}
+
+	if fd.HasPresence() && !m.Has(fd) {
+		return pipeline.Null, nil
+	}
+	return c.fromValue(fd, field, m.Get(fd))
+}
+
+// fromValue 转换单个值，repeated 和 map 的元素也经过这里
+func (c *Codec) fromValue(fd protoreflect.FieldDescriptor, field pipeline.Field, v protoreflect.Value) (pipeline.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return pipeline.ValueOf(v.Bool()), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return pipeline.ValueOf(v.Int()), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if v.Uint() > math.MaxInt64 {
+			return pipeline.Value{}, fmt.Errorf("%d is out of the int64 range", v.Uint())
+		}
+		return pipeline.ValueOf(int64(v.Uint())), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return pipeline.ValueOf(v.Float()), nil
+	case protoreflect.StringKind:
+		return pipeline.ValueOf(v.String()), nil
+	case protoreflect.BytesKind:
+		return pipeline.ValueOf(bytes.Clone(v.Bytes())), nil
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return pipeline.ValueOf(string(ev.Name())), nil
+		}
+		// numbers unknown to the descriptor, e.g. from a newer producer, are kept as text
+		return pipeline.ValueOf(strconv.Itoa(int(v.Enum()))), nil
+	}
+	return c.fromMessageValue(v.Message(), field)
+}
+
+func (c *Codec) fromMessageValue(m protoreflect.Message, field pipeline.Field) (pipeline.Value, error) {
+	md := m.Descriptor()
+	switch name := md.FullName(); {
+	case name == "google.protobuf.Timestamp":
+		seconds, nanos := m.Get(md.Fields().ByName("seconds")).Int(), m.Get(md.Fields().ByName("nanos")).Int()
+		return pipeline.ValueOf(time.Unix(seconds, nanos).UTC()), nil
+	case name == "google.protobuf.Duration":
+		seconds, nanos := m.Get(md.Fields().ByName("seconds")).Int(), m.Get(md.Fields().ByName("nanos")).Int()
+		return pipeline.ValueOf(time.Duration(seconds)*time.Second + time.Duration(nanos)), nil
+	case isWrapper(name):
+		fd := md.Fields().ByName("value")
+		return c.fromValue(fd, field, m.Get(fd))
+	case jsonMessages[name] || field.Type == pipeline.TypeJSON:
+		data, err := c.marshalJSON(m)
+		if err != nil {
+			return pipeline.Value{}, err
+		}
+		if field.Type == pipeline.TypeJSON {
+			return pipeline.Value{Type: pipeline.TypeJSON, Value: json.RawMessage(data)}, nil
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var doc any
+		if err := decoder.Decode(&doc); err != nil {
+			return pipeline.Value{}, err
+		}
+		return pipeline.ValueOf(doc), nil
+	}
+	dict, err := c.fromMessage(m, field.Fields)
+	if err != nil {
+		return pipeline.Value{}, err
+	}
+	return pipeline.Value{Type: pipeline.TypeDict, Value: dict}, nil
+}
+
+// marshalJSON protojson 的输出会随机加入空白，压缩后才是稳定的
+func (c *Codec) marshalJSON(m protoreflect.Message) ([]byte, error) {
+	marshal, _ := c.jsonOptions()
+	data, err := marshal.Marshal(m.Interface())
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Codec) toMessage(m protoreflect.Message, fields []pipeline.Field, data map[string]pipeline.Value, path string) error {
+	fds := m.Descriptor().Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd, field := fds.Get(i), fields[i]
+		v, ok := data[field.Name]
+		if !ok || v.IsNull() {
+			continue
+		}
+		if err := c.toField(m, fd, field, v, joinPath(path, field.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Codec) toField(m protoreflect.Message, fd protoreflect.FieldDescriptor, field pipeline.Field, v pipeline.Value, path string) error {
+	switch {
+	case fd.IsMap():
+		dict, ok := v.Value.(map[string]pipeline.Value)
+		if !ok {
+			return fmt.Errorf("%s: expected dict, got %s", path, v.Type)
+		}
+		mv := m.Mutable(fd).Map()
+		for k, item := range dict {
+			if item.IsNull() {
+				continue
+			}
+			key, err := mapKey(fd.MapKey(), k)
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", path, k, err)
+			}
+			var value protoreflect.Value
+			if fd.MapValue().Message() != nil {
+				value = mv.NewValue()
+			}
+			if value, err = c.toValue(fd.MapValue(), *field.Elem, item, value, joinPath(path, k)); err != nil {
+				return err
+			}
+			mv.Set(key, value)
+		}
+		return nil
+
+	case fd.IsList():
+		list, ok := v.Value.([]pipeline.Value)
+		if !ok {
+			return fmt.Errorf("%s: expected list, got %s", path, v.Type)
+		}
+		lv := m.Mutable(fd).List()
+		for i, item := range list {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if item.IsNull() {
+				return fmt.Errorf("%s: repeated fields cannot hold null", itemPath)
+			}
+			var value protoreflect.Value
+			if fd.Message() != nil {
+				value = lv.NewElement()
+			}
+			value, err := c.toValue(fd, *field.Elem, item, value, itemPath)
+			if err != nil {
+				return err
+			}
+			lv.Append(value)
+		}
+		return nil
+	}
+
+	var value protoreflect.Value
+	if fd.Message() != nil {
+		value = m.NewField(fd)
+	}
+	value, err := c.toValue(fd, field, v, value, path)
+	if err != nil {
+		return err
+	}
+	m.Set(fd, value)
+	return nil
+}
+
+// toValue 转换单个值，message 类型的值写入 into
+func (c *Codec) toValue(fd protoreflect.FieldDescriptor, field pipeline.Field, v pipeline.Value, into protoreflect.Value, path string) (protoreflect.Value, error) {
+	if fd.Message() == nil {
+		value, err := scalar(fd, v)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("%s: %w", path, err)
+		}
+		return value, nil
+	}
+	if err := c.toMessageValue(into.Message(), field, v, path); err != nil {
+		return protoreflect.Value{}, err
+	}
+	return into, nil
+}
+
+func (c *Codec) toMessageValue(m protoreflect.Message, field pipeline.Field, v pipeline.Value, path string) error {
+	md := m.Descriptor()
+	switch name := md.FullName(); {
+	case name == "google.protobuf.Timestamp":
+		converted, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeTimestamp})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		t := converted.Value.(time.Time)
+		m.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+		m.Set(md.Fields().ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+		return nil
+	case name == "google.protobuf.Duration":
+		converted, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeDuration})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		d := converted.Value.(time.Duration)
+		m.Set(md.Fields().ByName("seconds"), protoreflect.ValueOfInt64(int64(d/time.Second)))
+		m.Set(md.Fields().ByName("nanos"), protoreflect.ValueOfInt32(int32(d%time.Second)))
+		return nil
+	case isWrapper(name):
+		fd := md.Fields().ByName("value")
+		value, err := scalar(fd, v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		m.Set(fd, value)
+		return nil
+	case jsonMessages[name] || field.Type == pipeline.TypeJSON:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		_, unmarshal := c.jsonOptions()
+		if err := unmarshal.Unmarshal(data, m.Interface()); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	}
+	dict, ok := v.Value.(map[string]pipeline.Value)
+	if !ok {
+		return fmt.Errorf("%s: expected dict, got %s", path, v.Type)
+	}
+	return c.toMessage(m, field.Fields, dict, path)
+}
+
+// scalar 按字段的种类转换，整数检查范围，enum 接受名称或编号
+func scalar(fd protoreflect.FieldDescriptor, v pipeline.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeBoolean})
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfBool(b.Value.(bool)), nil
+	case protoreflect.StringKind:
+		s, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeString})
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfString(s.Value.(string)), nil
+	case protoreflect.BytesKind:
+		b, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeBytes})
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfBytes(b.Value.([]byte)), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeFloat64})
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		if fd.Kind() == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(f.Value.(float64))), nil
+		}
+		return protoreflect.ValueOfFloat64(f.Value.(float64)), nil
+	case protoreflect.EnumKind:
+		if name, ok := v.Value.(string); ok {
+			if ev := fd.Enum().Values().ByName(protoreflect.Name(name)); ev != nil {
+				return protoreflect.ValueOfEnum(ev.Number()), nil
+			}
+			n, err := strconv.ParseInt(name, 10, 32)
+			if err != nil {
+				return protoreflect.Value{}, fmt.Errorf("%q is not a value of %s", name, fd.Enum().FullName())
+			}
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+		}
+		n, err := integer(v, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	}
+
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := integer(v, math.MinInt32, math.MaxInt32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := integer(v, 0, math.MaxUint32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := integer(v, 0, math.MaxInt64)
+		return protoreflect.ValueOfUint64(uint64(n)), err
+	}
+	n, err := integer(v, math.MinInt64, math.MaxInt64)
+	return protoreflect.ValueOfInt64(n), err
+}
+
+func integer(v pipeline.Value, min, max int64) (int64, error) {
+	converted, err := pipeline.FromNative(v, pipeline.Field{Type: pipeline.TypeInt64})
+	if err != nil {
+		return 0, err
+	}
+	n := converted.Value.(int64)
+	if n < min || n > max {
+		return 0, fmt.Errorf("%d is out of range [%d, %d]", n, min, max)
+	}
+	return n, nil
+}
+
+// mapKey dict 的键是字符串，按 map 键的种类解析
+func mapKey(fd protoreflect.FieldDescriptor, key string) (protoreflect.MapKey, error) {
+	if fd.Kind() == protoreflect.StringKind {
+		return protoreflect.ValueOfString(key).MapKey(), nil
+	}
+	var native any
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(key)
+		if err != nil {
+			return protoreflect.MapKey{}, err
+		}
+		native = b
+	default:
+		n, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return protoreflect.MapKey{}, fmt.Errorf("map key %q is not an integer", key)
+		}
+		native = n
+	}
+	value, err := scalar(fd, pipeline.ValueOf(native))
+	if err != nil {
+		return protoreflect.MapKey{}, err
+	}
+	return value.MapKey(), nil
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
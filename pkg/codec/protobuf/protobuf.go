@@ -0,0 +1,208 @@
+// Package protobuf 按描述符集解码和编码 Protobuf 消息，不需要生成的代码。
+// 流中的每条消息前有 varint 长度前缀，与 protodelim 和 Java 的 writeDelimitedTo 相同
+package protobuf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/schema"
+)
+
+const Name = "protobuf"
+
+// MaxMessageSize 流中单条消息的长度上限，更大的长度前缀视为损坏的数据
+const MaxMessageSize = 64 << 20
+
+func init() {
+	codec.Register(Name, func(opts codec.Options) (codec.Codec, error) {
+		params := opts.Params
+		if err := codec.CheckParams(Name, params, "descriptor_set", "message"); err != nil {
+			return nil, err
+		}
+		path := params["descriptor_set"]
+		if path == "" {
+			return nil, fmt.Errorf("codec %s: descriptor_set is required", Name)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("codec %s: %w", Name, err)
+		}
+		return New(Config{DescriptorSet: data, Message: params["message"]})
+	})
+}
+
+type Config struct {
+	// DescriptorSet protoc --descriptor_set_out 生成的 FileDescriptorSet，需要包含导入的文件
+	// （--include_imports）
+	DescriptorSet []byte
+	// Message 消息的全名或短名，为空时描述符集中必须只有一个顶层 message
+	Message string
+}
+
+// Codec 的字段类型与 schema.FromProtoMessage 相同，记录的 Schema 就是它的结果：
+// 整数为 int64，enum 为值的名称，repeated 为 list，map 为 dict，嵌套 message 为 dict，
+// Timestamp、Duration 和包装类型为对应的标量，Struct 和 ListValue 为 dict 和 list，
+// Any、Value 和递归引用为 json（protojson 格式）。
+// 没有 presence 的 proto3 字段未设置时解码为零值，有 presence 的字段未设置时为空值；
+// 编码时空值和缺少的字段不设置，不在描述符中的字段被忽略
+type Codec struct {
+	message  protoreflect.MessageDescriptor
+	schema   *pipeline.Schema
+	resolver *dynamicpb.Types
+}
+
+func New(config Config) (*Codec, error) {
+	md, err := schema.DescriptorSetMessage(config.DescriptorSet, config.Message)
+	if err != nil {
+		return nil, fmt.Errorf("codec %s: %w", Name, err)
+	}
+	files := new(protoregistry.Files)
+	if err := register(files, md.ParentFile()); err != nil {
+		return nil, fmt.Errorf("codec %s: %w", Name, err)
+	}
+	return &Codec{
+		message:  md,
+		schema:   schema.FromProtoMessage(md),
+		resolver: dynamicpb.NewTypes(files),
+	}, nil
+}
+
+// register 注册文件和它导入的文件，Any 中的类型按它们解析
+func register(files *protoregistry.Files, fd protoreflect.FileDescriptor) error {
+	if _, err := files.FindFileByPath(fd.Path()); err == nil {
+		return nil
+	}
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if err := register(files, imports.Get(i).FileDescriptor); err != nil {
+			return err
+		}
+	}
+	return files.RegisterFile(fd)
+}
+
+func (c *Codec) Name() string {
+	return Name
+}
+
+// Schema 由消息描述符转换得到的 schema
+func (c *Codec) Schema() *pipeline.Schema {
+	return c.schema
+}
+
+// Decode 解码一条不带长度前缀的消息
+func (c *Codec) Decode(data []byte) (*pipeline.Record, error) {
+	m := dynamicpb.NewMessage(c.message)
+	if err := (proto.UnmarshalOptions{Resolver: c.resolver}).Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	dict, err := c.fromMessage(m, c.schema.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeline.Record{Schema: c.schema, Data: dict, Timestamp: time.Now()}, nil
+}
+
+// Encode 编码为一条不带长度前缀的消息，map 按键排序，相同记录总是得到相同的字节
+func (c *Codec) Encode(record *pipeline.Record) ([]byte, error) {
+	m := dynamicpb.NewMessage(c.message)
+	if err := c.toMessage(m, c.schema.Fields, record.Data, ""); err != nil {
+		return nil, err
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}
+
+func (c *Codec) NewDecoder(r io.Reader) codec.Decoder {
+	return &decoder{codec: c, r: bufio.NewReader(r)}
+}
+
+func (c *Codec) NewEncoder(w io.Writer) codec.Encoder {
+	return &encoder{codec: c, w: w}
+}
+
+func (c *Codec) jsonOptions() (protojson.MarshalOptions, protojson.UnmarshalOptions) {
+	return protojson.MarshalOptions{Resolver: c.resolver}, protojson.UnmarshalOptions{Resolver: c.resolver}
+}
+
+// decoder 长度前缀损坏时无法找到下一条消息，返回普通错误；
+// 消息本身无法解码时返回 KindConversion，可以跳过
+type decoder struct {
+	codec  *Codec
+	r      *bufio.Reader
+	offset int64
+}
+
+func (d *decoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err == nil {
+		d.offset++
+	}
+	return b, err
+}
+
+func (d *decoder) Decode() (*pipeline.Record, error) {
+	start := d.offset
+	size, err := binary.ReadUvarint(d)
+	if err == io.EOF && d.offset == start {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: read length at offset %d: %w", Name, start, errUnexpected(err))
+	}
+	if size > MaxMessageSize {
+		return nil, fmt.Errorf("%s: message at offset %d is %d bytes, more than %d", Name, start, size, MaxMessageSize)
+	}
+	data := make([]byte, size)
+	n, err := io.ReadFull(d.r, data)
+	d.offset += int64(n)
+	if err != nil {
+		return nil, fmt.Errorf("%s: read message at offset %d: %w", Name, start, errUnexpected(err))
+	}
+	record, err := d.codec.Decode(data)
+	if err != nil {
+		return nil, pipeline.Errorf(pipeline.KindConversion, "%s: message at offset %d: %w", Name, start, err)
+	}
+	return record, nil
+}
+
+func (d *decoder) Offset() int64 {
+	return d.offset
+}
+
+func errUnexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type encoder struct {
+	codec *Codec
+	w     io.Writer
+}
+
+func (e *encoder) Encode(record *pipeline.Record) error {
+	data, err := e.codec.Encode(record)
+	if err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "%s: %w", Name, err)
+	}
+	_, err = e.w.Write(append(binary.AppendUvarint(nil, uint64(len(data))), data...))
+	return err
+}
+
+func (e *encoder) Close() error {
+	return nil
+}
@@ -0,0 +1,310 @@
+package protobuf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const orderProto = `syntax = "proto3";
+
+package shop;
+
+import "google/protobuf/any.proto";
+import "google/protobuf/duration.proto";
+import "google/protobuf/struct.proto";
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+
+message Order {
+  enum Status {
+    NEW = 0;
+    PAID = 1;
+  }
+
+  int64 id = 1;
+  Status status = 2;
+  google.protobuf.Timestamp created_at = 3;
+  google.protobuf.Duration ttl = 4;
+  optional string note = 5;
+  repeated string tags = 6;
+  map<string, int32> attrs = 7;
+  map<int64, string> names = 8;
+  Customer customer = 9;
+  repeated Item items = 10;
+  google.protobuf.DoubleValue discount = 11;
+  bytes signature = 12;
+  google.protobuf.Struct payload = 13;
+  google.protobuf.Any extension = 14;
+  float ratio = 15;
+  sint32 delta = 16;
+}
+
+message Customer {
+  string email = 1;
+  Customer referrer = 2;
+}
+
+message Item {
+  string sku = 1;
+  uint32 qty = 2;
+}
+`
+
+func descriptorSet(t *testing.T) []byte {
+	t.Helper()
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{"order.proto": orderProto}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), "order.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	deps := files[0].Imports()
+	for i := 0; i < deps.Len(); i++ {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(deps.Get(i).FileDescriptor))
+	}
+	set.File = append(set.File, protodesc.ToFileDescriptorProto(files[0]))
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func mustNew(t *testing.T, message string) *Codec {
+	t.Helper()
+	c, err := New(Config{DescriptorSet: descriptorSet(t), Message: message})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func order(id int64) *pipeline.Record {
+	return &pipeline.Record{Data: map[string]pipeline.Value{
+		"id":         pipeline.ValueOf(id),
+		"status":     pipeline.ValueOf("PAID"),
+		"created_at": pipeline.ValueOf(time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)),
+		"ttl":        pipeline.ValueOf(90 * time.Minute),
+		"note":       pipeline.ValueOf("fragile"),
+		"tags":       pipeline.ValueOf([]any{"a", "b"}),
+		"attrs":      pipeline.ValueOf(map[string]any{"w": int64(-3)}),
+		"names":      pipeline.ValueOf(map[string]any{"7": "seven", "-1": "minus one"}),
+		"customer": pipeline.ValueOf(map[string]any{
+			"email":    "ann@example.com",
+			"referrer": json.RawMessage(`{"email":"bob@example.com"}`),
+		}),
+		"items": pipeline.ValueOf([]any{
+			map[string]any{"sku": "x1", "qty": int64(2)},
+			map[string]any{"sku": "x2", "qty": int64(1)},
+		}),
+		"discount":  pipeline.ValueOf(0.0),
+		"signature": pipeline.ValueOf([]byte{0, 1, 0xff}),
+		"payload":   pipeline.ValueOf(map[string]any{"k": []any{true, nil, "v"}, "n": pipeline.MustParseDecimal("1.5")}),
+		"extension": pipeline.ValueOf(json.RawMessage(`{"@type":"type.googleapis.com/shop.Item","sku":"gift","qty":1}`)),
+		"ratio":     pipeline.ValueOf(0.25),
+		"delta":     pipeline.ValueOf(int64(-42)),
+	}}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := mustNew(t, "shop.Order")
+	record := order(1)
+	data, err := c.Encode(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changes := record.Diff(got); len(changes) != 0 {
+		t.Errorf("changes after round trip: %v", changes)
+	}
+	if got.Schema != c.Schema() {
+		t.Error("decoded record does not carry the descriptor schema")
+	}
+	if err := got.Schema.Validate(got); err != nil {
+		t.Errorf("decoded record does not match its schema: %v", err)
+	}
+
+	again, err := c.Encode(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Error("encoding is not deterministic")
+	}
+}
+
+func TestDecode_PresenceAndDefaults(t *testing.T) {
+	c := mustNew(t, "Order")
+	md := c.message
+	m := dynamicpb.NewMessage(md)
+	m.Set(md.Fields().ByName("status"), protoreflect.ValueOfEnum(9))
+	m.Set(md.Fields().ByName("tags"), protoreflect.ValueOfList(m.NewField(md.Fields().ByName("tags")).List()))
+	data, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]pipeline.Value{
+		// proto3 scalars without presence read as their zero value
+		"id":    pipeline.ValueOf(int64(0)),
+		"ratio": pipeline.ValueOf(0.0),
+		// fields with presence read as null when unset
+		"note":       pipeline.Null,
+		"created_at": pipeline.Null,
+		"discount":   pipeline.Null,
+		"customer":   pipeline.Null,
+		// enum numbers the descriptor does not know are kept
+		"status": pipeline.ValueOf("9"),
+		"tags":   pipeline.ValueOf([]any{}),
+	} {
+		if !got.Data[name].Equal(want) {
+			t.Errorf("%s = %#v, want %#v", name, got.Data[name], want)
+		}
+	}
+
+	// the unknown number encodes back to itself
+	again, err := c.Encode(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(again, m); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Get(md.Fields().ByName("status")).Enum(); n != 9 {
+		t.Errorf("status = %d", n)
+	}
+}
+
+func TestEncode_Invalid(t *testing.T) {
+	c := mustNew(t, "shop.Order")
+	for name, value := range map[string]pipeline.Value{
+		"delta":  pipeline.ValueOf(int64(1) << 40),
+		"status": pipeline.ValueOf("LOST"),
+		"tags":   pipeline.ValueOf("a"),
+		"items":  pipeline.ValueOf([]any{map[string]any{"qty": int64(-1)}}),
+		"names":  pipeline.ValueOf(map[string]any{"x": "y"}),
+	} {
+		record := order(1)
+		record.Data[name] = value
+		if _, err := c.Encode(record); err == nil {
+			t.Errorf("%s = %v: no error", name, value.Value)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	c := mustNew(t, "shop.Order")
+	var buf bytes.Buffer
+	encoder := c.NewEncoder(&buf)
+	for i := int64(1); i <= 2; i++ {
+		if err := encoder.Encode(order(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.Encode(order(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := int64(len(protowire.AppendVarint(nil, uint64(len(data)))) + len(data))
+	// a message that cannot be decoded is skipped, the length prefix keeps the stream aligned
+	buf.Write([]byte{2, 0xff, 0xff})
+	tail := dynamicpb.NewMessage(c.message)
+	tail.Set(c.message.Fields().ByName("id"), protoreflect.ValueOfInt64(3))
+	if _, err := protodelim.MarshalTo(&buf, tail); err != nil {
+		t.Fatal(err)
+	}
+
+	// the framing is the one protodelim reads
+	m := dynamicpb.NewMessage(c.message)
+	if err := protodelim.UnmarshalFrom(bufio.NewReader(bytes.NewReader(buf.Bytes())), m); err != nil {
+		t.Fatal(err)
+	}
+	if id := m.Get(c.message.Fields().ByName("id")).Int(); id != 1 {
+		t.Errorf("protodelim read id %d", id)
+	}
+
+	decoder := c.NewDecoder(bytes.NewReader(buf.Bytes()))
+	var ids []int64
+	for {
+		record, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if pipeline.KindOf(err) != pipeline.KindConversion {
+				t.Fatal(err)
+			}
+			continue
+		}
+		ids = append(ids, record.Data["id"].Value.(int64))
+		if len(ids) == 1 && decoder.(codec.Offsetter).Offset() != first {
+			t.Errorf("offset = %d, want %d", decoder.(codec.Offsetter).Offset(), first)
+		}
+	}
+	if len(ids) != 3 || ids[2] != 3 {
+		t.Errorf("ids = %v", ids)
+	}
+
+	decoder = c.NewDecoder(bytes.NewReader([]byte{5, 1}))
+	if _, err := decoder.Decode(); err == nil || err == io.EOF || pipeline.KindOf(err) == pipeline.KindConversion {
+		t.Errorf("truncated stream: %v", err)
+	}
+}
+
+func TestFactory(t *testing.T) {
+	if _, err := codec.New(Name, codec.Options{}); err == nil {
+		t.Error("missing descriptor_set accepted")
+	}
+	path := filepath.Join(t.TempDir(), "order.pb")
+	if err := os.WriteFile(path, descriptorSet(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.New(Name, codec.Options{Params: map[string]string{"descriptor_set": path}}); err == nil {
+		t.Error("ambiguous message accepted")
+	}
+	c, err := codec.New(Name, codec.Options{Params: map[string]string{"descriptor_set": path, "message": "Item"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := c.Decode([]byte{0x0a, 0x02, 'x', '1', 0x10, 0x05})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !record.Data["sku"].Equal(pipeline.ValueOf("x1")) || !record.Data["qty"].Equal(pipeline.ValueOf(5)) {
+		t.Errorf("item = %v", record.Data)
+	}
+}
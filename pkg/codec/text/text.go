@@ -0,0 +1,70 @@
+// Package text 把每行文本作为只有一个字符串字段的记录
+package text
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const (
+	Name = "text"
+	// LineField 默认保存一行文本的字段
+	LineField = "line"
+)
+
+func init() {
+	codec.Register(Name, func(opts codec.Options) (codec.Codec, error) {
+		if err := codec.CheckParams(Name, opts.Params, "field"); err != nil {
+			return nil, err
+		}
+		return New(opts.Params["field"]), nil
+	})
+}
+
+// Codec 解码时整行（不含换行符）保存在 Field 中，空行同样产生记录；编码时只输出 Field
+type Codec struct {
+	field  string
+	schema *pipeline.Schema
+}
+
+// New 创建使用 field 字段的 codec，field 为空时使用 LineField
+func New(field string) *Codec {
+	if field == "" {
+		field = LineField
+	}
+	return &Codec{
+		field: field,
+		schema: &pipeline.Schema{
+			Fields: []pipeline.Field{{Name: field, Type: pipeline.TypeString, Required: true}},
+		},
+	}
+}
+
+func (c *Codec) Name() string {
+	return Name
+}
+
+func (c *Codec) Decode(data []byte) (*pipeline.Record, error) {
+	return &pipeline.Record{
+		Schema: c.schema,
+		Data: map[string]pipeline.Value{
+			c.field: {Type: pipeline.TypeString, Value: string(data)},
+		},
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func (c *Codec) Encode(record *pipeline.Record) ([]byte, error) {
+	value, ok := record.Data[c.field]
+	if !ok {
+		return nil, fmt.Errorf("record has no %s field", c.field)
+	}
+	s, ok := value.Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("field %s holds %s, want string", c.field, value.Type)
+	}
+	return []byte(s), nil
+}
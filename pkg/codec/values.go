@@ -0,0 +1,146 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// InferString 推断文本格式中未加引号的值的类型：空文本为空值，其余依次尝试整数、
+// 布尔值、数字、时间戳和时长，都不是时为字符串。floats 为 true 时非整数的数字为 float64，
+// 否则为 Decimal 以保留全部数位。
+// 只有规范写法的文本才推断为数字，例如 02134 和 +1555 保持为字符串，写回时不变
+func InferString(cell string, floats bool) pipeline.Value {
+	if cell == "" {
+		return pipeline.Null
+	}
+	if n, err := strconv.ParseInt(cell, 10, 64); err == nil && strconv.FormatInt(n, 10) == cell {
+		return pipeline.Value{Type: pipeline.TypeInt64, Value: n}
+	}
+	if strings.EqualFold(cell, "true") || strings.EqualFold(cell, "false") {
+		return pipeline.Value{Type: pipeline.TypeBoolean, Value: strings.EqualFold(cell, "true")}
+	}
+	if isNumber(cell) && canonicalNumber(cell) {
+		if floats {
+			if f, err := strconv.ParseFloat(cell, 64); err == nil {
+				return pipeline.Value{Type: pipeline.TypeFloat64, Value: f}
+			}
+		}
+		if d, err := pipeline.ParseDecimal(cell); err == nil && d.String() == cell {
+			return pipeline.Value{Type: pipeline.TypeDecimal, Value: d}
+		}
+	}
+	if looksLikeTimestamp(cell) {
+		if ts, err := pipeline.FromNative(cell, pipeline.Field{Type: pipeline.TypeTimestamp}); err == nil {
+			return ts
+		}
+	}
+	if looksLikeDuration(cell) {
+		if d, err := time.ParseDuration(cell); err == nil {
+			return pipeline.Value{Type: pipeline.TypeDuration, Value: d}
+		}
+	}
+	return pipeline.Value{Type: pipeline.TypeString, Value: cell}
+}
+
+// isNumber 只接受十进制写法，排除 ParseFloat 认识的 Inf、NaN 和十六进制
+func isNumber(s string) bool {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	digits := false
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = true
+		case r == '.':
+		case (r == 'e' || r == 'E') && digits && i < len(s)-1:
+			return isExponent(s[i+1:])
+		default:
+			return false
+		}
+	}
+	return digits
+}
+
+// canonicalNumber 排除 + 号、整数部分的前导零以及缺少整数部分的写法，例如 .5
+func canonicalNumber(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	end := strings.IndexAny(s, ".eE")
+	if end < 0 {
+		end = len(s)
+	}
+	integer := s[:end]
+	return integer != "" && integer[0] != '+' && (integer == "0" || integer[0] != '0')
+}
+
+func isExponent(s string) bool {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// looksLikeTimestamp 只对以 yyyy-mm-dd 开头的文本尝试解析时间戳
+func looksLikeTimestamp(s string) bool {
+	if len(s) < 10 || s[4] != '-' || s[7] != '-' {
+		return false
+	}
+	for _, i := range []int{0, 1, 2, 3, 5, 6, 8, 9} {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// looksLikeDuration 只对以数字开头、以单位字母结尾的文本尝试解析时长，例如 12ms
+func looksLikeDuration(s string) bool {
+	last := s[len(s)-1]
+	return s[0] >= '0' && s[0] <= '9' && (last == 's' || last == 'm' || last == 'h')
+}
+
+// FormatString 输出值在文本格式中的写法：空值为空文本，dict、list 和 json 为紧凑的 JSON 文本，
+// 其余与 Value.MarshalJSON 的形式一致，能被 InferString 或按字段类型转换读回
+func FormatString(v pipeline.Value) (string, error) {
+	switch inner := v.Value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return inner, nil
+	case int64:
+		return strconv.FormatInt(inner, 10), nil
+	case float64:
+		return strconv.FormatFloat(inner, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(inner), nil
+	case pipeline.Decimal:
+		return inner.String(), nil
+	case time.Time:
+		return inner.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return inner.String(), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(inner), nil
+	case json.RawMessage:
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, inner); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
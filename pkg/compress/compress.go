@@ -0,0 +1,226 @@
+// Package compress 为字节流 source 和 sink 提供透明的压缩和解压。
+// 读取时按 magic bytes 识别格式，识别不了时按扩展名，解压是流式的，不需要读完整个文件
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+const (
+	// Auto 读取时按 magic bytes 或扩展名识别，都不是压缩格式时原样读取
+	Auto   = "auto"
+	None   = "none"
+	Gzip   = "gzip"
+	Zstd   = "zstd"
+	Snappy = "snappy"
+	LZ4    = "lz4"
+)
+
+// magics 各格式流开头的字节，snappy 为 framing format 的 stream identifier
+var magics = []struct {
+	name  string
+	magic []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{LZ4, []byte{0x04, 0x22, 0x4d, 0x18}},
+	{Snappy, []byte("\xff\x06\x00\x00sNaPpY")},
+}
+
+var extensions = map[string]string{
+	".gz":     Gzip,
+	".gzip":   Gzip,
+	".zst":    Zstd,
+	".zstd":   Zstd,
+	".sz":     Snappy,
+	".snappy": Snappy,
+	".lz4":    LZ4,
+}
+
+// Names 返回支持的压缩格式，不含 Auto
+func Names() []string {
+	return []string{None, Gzip, Zstd, Snappy, LZ4}
+}
+
+// Check 在 name 不是支持的格式时返回错误，allowAuto 为 true 时也接受 Auto。空名称等同于 None
+func Check(name string, allowAuto bool) error {
+	switch strings.ToLower(name) {
+	case "", None, Gzip, Zstd, Snappy, LZ4:
+		return nil
+	case Auto:
+		if allowAuto {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown compression %q, valid: %s", name, strings.Join(Names(), ", "))
+}
+
+// Detect 按开头的字节识别压缩格式，不是已知格式时返回 None
+func Detect(header []byte) string {
+	for _, m := range magics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.name
+		}
+	}
+	return None
+}
+
+// FromExtension 按文件扩展名识别压缩格式，不是已知扩展名时返回 None
+func FromExtension(path string) string {
+	if name, ok := extensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return name
+	}
+	return None
+}
+
+// Ext 返回写文件时附加的扩展名，None 为空
+func Ext(name string) string {
+	switch strings.ToLower(name) {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	case Snappy:
+		return ".sz"
+	case LZ4:
+		return ".lz4"
+	}
+	return ""
+}
+
+// NewReader 返回解压 r 的流。name 为 Auto 时按 magic bytes 识别，识别不了时使用 fallback
+// （通常是 FromExtension 的结果），空输入原样返回。Close 释放解压器，不关闭 r
+func NewReader(r io.Reader, name, fallback string) (io.ReadCloser, error) {
+	name = strings.ToLower(name)
+	if err := Check(name, true); err != nil {
+		return nil, err
+	}
+	if name == Auto {
+		br := bufio.NewReader(r)
+		detected, err := detect(br)
+		if err != nil {
+			return nil, err
+		}
+		if detected == None {
+			detected = strings.ToLower(fallback)
+			if _, err := br.Peek(1); err == io.EOF {
+				detected = None
+			}
+		}
+		name, r = detected, br
+	}
+
+	switch name {
+	case Gzip:
+		// multi-member streams, e.g. files appended with cat, are read as one
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return zr, nil
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	case Snappy:
+		return io.NopCloser(snappy.NewReader(r)), nil
+	case LZ4:
+		return newLZ4Reader(r), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// detect 逐步加长 Peek 的长度，第一个字节不可能是 magic 时不再等待更多输入，
+// 避免交互式的标准输入在一行很短时被阻塞
+func detect(br *bufio.Reader) (string, error) {
+	for n := 1; ; n++ {
+		head, err := br.Peek(n)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		possible := false
+		for _, m := range magics {
+			if len(head) >= len(m.magic) && bytes.HasPrefix(head, m.magic) {
+				return m.name, nil
+			}
+			if len(head) < len(m.magic) && bytes.HasPrefix(m.magic, head) {
+				possible = true
+			}
+		}
+		if !possible || len(head) < n {
+			return None, nil
+		}
+	}
+}
+
+// Flusher 由能把已写入的数据立即输出为完整压缩块的 Writer 实现，用于需要及时可见的流
+type Flusher interface {
+	Flush() error
+}
+
+// NewWriter 返回压缩写入 w 的流，Close 写出结尾但不关闭 w。
+// 除 None 外返回的 Writer 都实现 Flusher
+func NewWriter(w io.Writer, name string) (io.WriteCloser, error) {
+	name = strings.ToLower(name)
+	if err := Check(name, false); err != nil {
+		return nil, err
+	}
+	switch name {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return zw, nil
+	case Snappy:
+		return snappy.NewBufferedWriter(w), nil
+	case LZ4:
+		return lz4.NewWriter(w), nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newLZ4Reader 在后台把解压的数据写入管道。pierrec/lz4 的 Reader.Read 会一直读到填满缓冲区，
+// 在还没有结束的流上会阻塞，WriteTo 则每解压一个块就写出；它也只读一个 frame，
+// 这里继续读取紧接着的 frame。关闭返回的管道后，后台的解压在下一次写出时结束
+func newLZ4Reader(r io.Reader) io.ReadCloser {
+	src := bufio.NewReader(r)
+	pr, pw := io.Pipe()
+	go func() {
+		zr := lz4.NewReader(src)
+		for {
+			if _, err := zr.WriteTo(pw); err != nil {
+				pw.CloseWithError(fmt.Errorf("lz4: %w", err))
+				return
+			}
+			if _, err := src.Peek(1); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			zr.Reset(src)
+		}
+	}()
+	return pr
+}
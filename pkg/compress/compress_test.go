@@ -0,0 +1,170 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compressed(t *testing.T, name string, chunks ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, chunk := range chunks {
+		// every chunk is a complete stream, so several chunks are concatenated members or frames
+		w, err := NewWriter(&buf, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, chunk); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func readAll(t *testing.T, data []byte, name, fallback string) string {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data), name, fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestRoundTrip_Detected(t *testing.T) {
+	large := strings.Repeat("0123456789abcdef\n", 1<<14)
+	for _, name := range []string{Gzip, Zstd, Snappy, LZ4} {
+		data := compressed(t, name, large, "tail\n")
+		if got := Detect(data); got != name {
+			t.Errorf("%s detected as %s", name, got)
+		}
+		if len(data) >= len(large) {
+			t.Errorf("%s: %d bytes compressed to %d", name, len(large), len(data))
+		}
+		if got := readAll(t, data, Auto, ""); got != large+"tail\n" {
+			t.Errorf("%s: read %d bytes, want %d", name, len(got), len(large)+5)
+		}
+		if got := readAll(t, data, name, ""); got != large+"tail\n" {
+			t.Errorf("%s by name: read %d bytes", name, len(got))
+		}
+	}
+
+	if got := readAll(t, []byte("plain\n"), Auto, ""); got != "plain\n" {
+		t.Errorf("plain input = %q", got)
+	}
+	if got := readAll(t, nil, Auto, Gzip); got != "" {
+		t.Errorf("empty input = %q", got)
+	}
+}
+
+func TestExtension(t *testing.T) {
+	for path, want := range map[string]string{
+		"a.jsonl.gz": Gzip, "a.ZST": Zstd, "a.snappy": Snappy, "a.sz": Snappy, "a.lz4": LZ4, "a.jsonl": None,
+	} {
+		if got := FromExtension(path); got != want {
+			t.Errorf("%s: %s, want %s", path, got, want)
+		}
+	}
+	for _, name := range Names() {
+		if name != None && FromExtension("x"+Ext(name)) != name {
+			t.Errorf("%s: extension %q does not map back", name, Ext(name))
+		}
+	}
+
+	// the extension is used when the content does not tell
+	raw := compressed(t, Snappy, "x")
+	if _, err := NewReader(bytes.NewReader([]byte("not gzip")), Auto, Gzip); err == nil {
+		t.Error("plain data read as gzip without an error")
+	}
+	if got := readAll(t, raw, Auto, Gzip); got != "x" {
+		t.Errorf("magic bytes did not win over the extension: %q", got)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	if _, err := NewWriter(io.Discard, "lzo"); err == nil {
+		t.Error("unknown writer compression accepted")
+	}
+	if _, err := NewWriter(io.Discard, Auto); err == nil {
+		t.Error("auto accepted for writing")
+	}
+	if _, err := NewReader(strings.NewReader(""), "lzo", ""); err == nil {
+		t.Error("unknown reader compression accepted")
+	}
+	data := compressed(t, Gzip, "hello")
+	data[len(data)-5] ^= 0xff
+	r, err := NewReader(bytes.NewReader(data), Auto, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("corrupt gzip read without an error")
+	}
+}
+
+// TestStreaming 解压不等待流结束，压缩端 flush 之后读取方就能拿到数据
+func TestStreaming(t *testing.T) {
+	for _, name := range []string{Gzip, Zstd, Snappy, LZ4} {
+		pr, pw := io.Pipe()
+		w, err := NewWriter(pw, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			io.WriteString(w, "first\n")
+			w.(Flusher).Flush()
+		}()
+
+		lines := make(chan string, 1)
+		go func() {
+			r, err := NewReader(pr, Auto, "")
+			if err != nil {
+				lines <- err.Error()
+				return
+			}
+			line, err := bufio.NewReader(r).ReadString('\n')
+			if err != nil {
+				line = err.Error()
+			}
+			lines <- line
+		}()
+		select {
+		case line := <-lines:
+			if line != "first\n" {
+				t.Errorf("%s: %q", name, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: flushed data is not readable before the stream ends", name)
+		}
+		pw.Close()
+	}
+
+	// short uncompressed input is not held back waiting for a full magic
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go io.WriteString(pw, "a\n")
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewReader(pr, Auto, "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("detection blocked on a short uncompressed line")
+	}
+}
@@ -7,9 +7,17 @@ type Metrics struct {
 	ProcessingDuration *prometheus.HistogramVec
 	ErrorsTotal        *prometheus.CounterVec
 	MessagesInProgress *prometheus.GaugeVec
+
+	registerer prometheus.Registerer
 }
 
 func NewMetrics(namespace string) *Metrics {
+	return NewMetricsWithRegisterer(namespace, prometheus.DefaultRegisterer)
+}
+
+// NewMetricsWithRegisterer 将指标注册到指定的 Registerer，
+// 配合 prometheus.WrapRegistererWith 可以为同名指标附加区分标签
+func NewMetricsWithRegisterer(namespace string, reg prometheus.Registerer) *Metrics {
 	m := &Metrics{
 		MessagesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Namespace: namespace,
@@ -36,9 +44,11 @@ func NewMetrics(namespace string) *Metrics {
 			Name:      "messages_in_progress",
 			Help:      "Number of messages in progress",
 		}, []string{"stage"}),
+
+		registerer: reg,
 	}
 
-	prometheus.MustRegister(
+	reg.MustRegister(
 		m.MessagesTotal,
 		m.ProcessingDuration,
 		m.ErrorsTotal,
@@ -46,3 +56,11 @@ func NewMetrics(namespace string) *Metrics {
 
 	return m
 }
+
+// Unregister 从注册时使用的 Registerer 中移除全部指标
+func (m *Metrics) Unregister() {
+	m.registerer.Unregister(m.MessagesTotal)
+	m.registerer.Unregister(m.ProcessingDuration)
+	m.registerer.Unregister(m.ErrorsTotal)
+	m.registerer.Unregister(m.MessagesInProgress)
+}
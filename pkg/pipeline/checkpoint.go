@@ -0,0 +1,210 @@
+package pipeline
+
+import (
+	"fmt"
+	"time"
+)
+
+// MetadataPosition 是 exactly-once 模式下携带源位置的元数据键，
+// 自行构造新 Message 的阶段必须保留 Metadata
+const MetadataPosition = "checkpoint.position"
+
+// MetadataSequence 是与源位置一起附加的读取序号，提交的位置只会前进到序号更大的消息
+const MetadataSequence = "checkpoint.sequence"
+
+// CheckpointSource 可以报告读取位置并从该位置恢复的 Source。
+// 为了让幂等写入生效，重放时同一条数据应当产生相同的 Message.ID
+type CheckpointSource interface {
+	Source
+	// Position returns the position right after the last message returned by Read
+	Position() ([]byte, error)
+	Seek(position []byte) error
+}
+
+// Checkpoint 已提交的源位置以及与之一起提交的 sink 事务
+type Checkpoint struct {
+	Position []byte `json:"position"`
+	Txn      uint64 `json:"txn"`
+}
+
+type CheckpointStore interface {
+	// Load returns nil without error when nothing has been saved yet
+	Load(name string) (*Checkpoint, error)
+	Save(name string, cp Checkpoint) error
+}
+
+// TransactionalSink 以两阶段提交方式写入的 Sink：Prepare 让写入持久化但不可见，
+// checkpoint 保存成功之后才 Commit 发布
+type TransactionalSink interface {
+	Sink
+	Prepare(txn uint64) error
+	Commit(txn uint64) error
+	Abort(txn uint64) error
+	// Recover commits prepared transactions up to and including committed
+	// and aborts everything after it, it is called once before the first write
+	Recover(committed uint64) error
+}
+
+// IdempotentSink 按 IdempotencyKey 去重写入的 Sink，重放的消息会覆盖而不是重复
+type IdempotentSink interface {
+	Sink
+	Idempotent()
+}
+
+// IdempotencyKey 优先使用 Schema.PrimaryKey 对应的值，否则使用 Message.ID
+func IdempotencyKey(msg *Message) string {
+	if r := msg.Payload; r != nil && r.Schema != nil && r.Schema.PrimaryKey != "" {
+		if v, ok := r.GetValue(r.Schema.PrimaryKey); ok {
+			return fmt.Sprint(v.Value)
+		}
+	}
+	return msg.ID
+}
+
+const defaultCommitEvery = 1000
+
+type ExactlyOnceConfig struct {
+	// Name identifies the pipeline in the checkpoint store
+	Name  string
+	Store CheckpointStore
+	// CommitEvery is the number of messages per transaction, defaults to 1000
+	CommitEvery int
+	// CommitInterval additionally commits when the open transaction is older, 0 disables it
+	CommitInterval time.Duration
+}
+
+// committer 在 sink 协程中驱动 checkpoint 和 sink 事务
+type committer struct {
+	config ExactlyOnceConfig
+	sink   Sink
+	txSink TransactionalSink
+
+	txn      uint64
+	position []byte
+	// seq is the read sequence number of position
+	seq      uint64
+	pending  int
+	openedAt time.Time
+}
+
+func newCommitter(config ExactlyOnceConfig, source Source, stages []Stage, sink Sink, priority *PriorityConfig) (*committer, error) {
+	if config.Store == nil || config.Name == "" {
+		return nil, fmt.Errorf("exactly-once: checkpoint store and name are required")
+	}
+	if priority != nil {
+		return nil, fmt.Errorf("exactly-once: priority lanes reorder messages and cannot be checkpointed")
+	}
+	for i, stage := range stages {
+		if buffers(stage) {
+			return nil, fmt.Errorf("exactly-once: stage %d (%T) buffers or fans out messages and cannot be checkpointed", i, stage)
+		}
+	}
+	cpSource, ok := source.(CheckpointSource)
+	if !ok {
+		return nil, fmt.Errorf("exactly-once: source %T does not support checkpoints", source)
+	}
+	txSink, transactional := sink.(TransactionalSink)
+	if _, idempotent := sink.(IdempotentSink); !transactional && !idempotent {
+		return nil, fmt.Errorf("exactly-once: sink %T is neither transactional nor idempotent", sink)
+	}
+	if config.CommitEvery <= 0 {
+		config.CommitEvery = defaultCommitEvery
+	}
+
+	c := &committer{config: config, sink: sink, txSink: txSink}
+	cp, err := config.Store.Load(config.Name)
+	if err != nil {
+		return nil, fmt.Errorf("exactly-once: load checkpoint: %w", err)
+	}
+	if cp != nil {
+		c.txn = cp.Txn
+		c.position = cp.Position
+		if err := cpSource.Seek(cp.Position); err != nil {
+			return nil, fmt.Errorf("exactly-once: seek source: %w", err)
+		}
+	}
+	if txSink != nil {
+		if err := txSink.Recover(c.txn); err != nil {
+			return nil, fmt.Errorf("exactly-once: recover sink: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *committer) write(msg *Message) error {
+	if err := c.sink.Write(msg); err != nil {
+		return err
+	}
+	if c.pending == 0 {
+		c.openedAt = time.Now()
+	}
+	c.pending++
+	// a stage may emit messages out of source order, the position never moves back
+	pos, ok := msg.Metadata[MetadataPosition].([]byte)
+	seq, _ := msg.Metadata[MetadataSequence].(uint64)
+	if ok && seq > c.seq {
+		c.position, c.seq = pos, seq
+	}
+	return nil
+}
+
+// buffers 判断阶段是否可能缓存、重排或一分多地输出消息，这些消息在提交时
+// 可能尚未写入 sink，checkpoint 无法覆盖。包装的阶段按被包装的阶段判断
+func buffers(stage Stage) bool {
+	for {
+		wrapper, ok := stage.(interface{ Unwrap() Stage })
+		if !ok {
+			break
+		}
+		stage = wrapper.Unwrap()
+	}
+	switch stage.(type) {
+	case FanOutStage, Flusher, Ticker:
+		return true
+	}
+	return false
+}
+
+func (c *committer) due() bool {
+	if c.pending >= c.config.CommitEvery {
+		return true
+	}
+	return c.pending > 0 && c.config.CommitInterval > 0 &&
+		time.Since(c.openedAt) >= c.config.CommitInterval
+}
+
+// commit 先 Prepare sink 事务，再保存 checkpoint，最后发布。
+// 保存 checkpoint 是提交点，之后崩溃由 Recover 补齐发布
+func (c *committer) commit() error {
+	if c.pending == 0 {
+		return nil
+	}
+	txn := c.txn + 1
+	if c.txSink != nil {
+		if err := c.txSink.Prepare(txn); err != nil {
+			return fmt.Errorf("prepare txn %d: %w", txn, err)
+		}
+	}
+	if err := c.config.Store.Save(c.config.Name, Checkpoint{Position: c.position, Txn: txn}); err != nil {
+		if c.txSink != nil {
+			c.txSink.Abort(txn)
+		}
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	c.txn = txn
+	c.pending = 0
+	if c.txSink != nil {
+		if err := c.txSink.Commit(txn); err != nil {
+			return fmt.Errorf("commit txn %d: %w", txn, err)
+		}
+	}
+	return nil
+}
+
+// abort 丢弃尚未 Prepare 的写入，下次启动会从上一个 checkpoint 重放
+func (c *committer) abort() {
+	if c.txSink != nil && c.pending > 0 {
+		c.txSink.Abort(c.txn + 1)
+	}
+	c.pending = 0
+}
@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/observability"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// positionSource 位置为已读取的消息数
+type positionSource struct {
+	n, read int
+}
+
+func (s *positionSource) Read() (*Message, error) {
+	if s.read == s.n {
+		return nil, io.EOF
+	}
+	s.read++
+	msg := NewMessage(nil)
+	msg.ID = string(rune('0' + s.read))
+	return msg, nil
+}
+
+func (s *positionSource) Position() ([]byte, error) {
+	return []byte{byte(s.read)}, nil
+}
+
+func (s *positionSource) Seek(position []byte) error {
+	s.read = int(position[0])
+	return nil
+}
+
+type idempotentSink struct{ collectSink }
+
+func (*idempotentSink) Idempotent() {}
+
+// savedStore 记录每次保存的 checkpoint
+type savedStore struct{ saved []Checkpoint }
+
+func (s *savedStore) Load(string) (*Checkpoint, error) {
+	if len(s.saved) == 0 {
+		return nil, nil
+	}
+	return &s.saved[len(s.saved)-1], nil
+}
+
+func (s *savedStore) Save(_ string, cp Checkpoint) error {
+	s.saved = append(s.saved, cp)
+	return nil
+}
+
+// swapStage 奇数次调用时先输出上一条被保留的消息：1 2 3 4 5 输出为 2 1 4 3，5 被保留
+type swapStage struct {
+	calls int
+	held  *Message
+}
+
+func (s *swapStage) Process(msg *Message) (*Message, error) {
+	s.calls++
+	if s.calls%2 == 0 {
+		return msg, nil
+	}
+	held := s.held
+	s.held = msg
+	return held, nil
+}
+
+func TestCommitter_PositionNeverMovesBack(t *testing.T) {
+	store := &savedStore{}
+	sink := &idempotentSink{}
+	err := NewLittlePipe(Config{
+		ExactlyOnce: &ExactlyOnceConfig{Name: "test", Store: store, CommitEvery: 2},
+	}).SetSource(&positionSource{n: 5}).AddStage(&swapStage{}).SetSink(sink).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sink.ids, ""); got != "2143" {
+		t.Fatalf("stage order = %s", got)
+	}
+	// the first transaction holds messages 2 and 1, so it covers the source up to 2
+	if len(store.saved) != 2 || store.saved[0].Position[0] != 2 || store.saved[1].Position[0] != 4 {
+		t.Errorf("saved checkpoints = %v", store.saved)
+	}
+}
+
+type bufferingStage struct{}
+
+func (bufferingStage) Process(msg *Message) (*Message, error) { return msg, nil }
+func (bufferingStage) Flush() ([]*Message, error)             { return nil, nil }
+
+func TestCommitter_RejectsBufferingStages(t *testing.T) {
+	metrics := observability.NewMetricsWithRegisterer("test", prometheus.NewRegistry())
+	wrapped := NewObservableStage("buffer", bufferingStage{}, observability.NewLogger(), observability.NewTracer("test"), metrics)
+	plain := NewObservableStage("plain", &swapStage{}, observability.NewLogger(), observability.NewTracer("test"), metrics)
+
+	for _, stages := range [][]Stage{{bufferingStage{}}, {plain, wrapped}} {
+		p := NewLittlePipe(Config{
+			ExactlyOnce: &ExactlyOnceConfig{Name: "test", Store: &savedStore{}},
+		}).SetSource(&positionSource{n: 1}).SetSink(&idempotentSink{})
+		for _, stage := range stages {
+			p.AddStage(stage)
+		}
+		if err := p.Run(); err == nil || !strings.Contains(err.Error(), "buffers") {
+			t.Errorf("%T: want buffering stage rejected, got %v", stages[len(stages)-1], err)
+		}
+	}
+
+	// a wrapped plain stage is accepted
+	err := NewLittlePipe(Config{
+		ExactlyOnce: &ExactlyOnceConfig{Name: "test", Store: &savedStore{}},
+	}).SetSource(&positionSource{n: 2}).AddStage(plain).SetSink(&idempotentSink{}).Run()
+	if err != nil {
+		t.Errorf("wrapped plain stage: %v", err)
+	}
+}
@@ -0,0 +1,369 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CoercionMode 决定 Coerce 接受哪些转换
+type CoercionMode string
+
+const (
+	// CoerceStrict 只做不丢信息、没有歧义的转换，例如 "42" 到 int64、
+	// "true"/"1" 到 boolean、纪元秒到 timestamp，其余一律报错
+	CoerceStrict CoercionMode = "strict"
+	// CoerceLenient 另外接受有损或宽松的写法：截断小数、yes/no/on/off、
+	// 去掉首尾空白、空字符串视为空值；仍然无法转换的字段置为空值并报告
+	CoerceLenient CoercionMode = "lenient"
+)
+
+func ParseCoercionMode(name string) (CoercionMode, error) {
+	switch mode := CoercionMode(strings.ToLower(strings.TrimSpace(name))); mode {
+	case CoerceStrict, CoerceLenient:
+		return mode, nil
+	case "":
+		return CoerceStrict, nil
+	default:
+		return "", fmt.Errorf("unknown coercion mode %q, valid modes: strict, lenient", name)
+	}
+}
+
+// CoercionError 汇总每个无法转换的字段，经 NewError 包装为 KindConversion
+type CoercionError struct {
+	Violations []Violation
+}
+
+func (e *CoercionError) Error() string {
+	if len(e.Violations) == 1 {
+		return e.Violations[0].Message
+	}
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return fmt.Sprintf("%d fields failed to convert: %s", len(e.Violations), strings.Join(messages, "; "))
+}
+
+// Coerce 将记录中的值原地转换为 schema 声明的类型，嵌套的 dict 和 list 按
+// Fields 和 Elem 递归处理，schema 以外的字段保持不变。
+// 严格模式下出错的字段保留原值；宽松模式下置为空值，可以交给 Validate 按 Nullable 判断
+func (s *Schema) Coerce(record *Record, mode CoercionMode) error {
+	c := &coercer{lenient: mode == CoerceLenient}
+	c.fields(s.Fields, record.Data, "")
+	if len(c.violations) == 0 {
+		return nil
+	}
+	return NewError(KindConversion, &CoercionError{Violations: c.violations})
+}
+
+// Coerce 按 field 转换单个值
+func Coerce(v Value, field Field, mode CoercionMode) (Value, error) {
+	c := &coercer{lenient: mode == CoerceLenient}
+	coerced := c.value(field, v, field.Name)
+	if len(c.violations) > 0 {
+		return coerced, NewError(KindConversion, &CoercionError{Violations: c.violations})
+	}
+	return coerced, nil
+}
+
+type coercer struct {
+	lenient    bool
+	violations []Violation
+}
+
+func (c *coercer) fields(fields []Field, data map[string]Value, prefix string) {
+	for _, field := range fields {
+		value, ok := data[field.Name]
+		if !ok {
+			continue
+		}
+		data[field.Name] = c.value(field, value, joinFieldPath(prefix, field.Name))
+	}
+}
+
+// value 出错时记录问题，严格模式返回原值，宽松模式返回空值
+func (c *coercer) value(field Field, value Value, path string) Value {
+	coerced, err := c.convert(field, value, path)
+	if err == nil {
+		return coerced
+	}
+	c.violations = append(c.violations, Violation{
+		Path:    path,
+		Rule:    "coerce",
+		Message: fmt.Sprintf("field %s cannot be coerced from %s to %s: %v", path, value.Type, field.Type, err),
+	})
+	if c.lenient {
+		return Null
+	}
+	return value
+}
+
+func (c *coercer) convert(field Field, value Value, path string) (Value, error) {
+	if value.IsNull() {
+		return value, nil
+	}
+	raw := value.Value
+	if value.Type == TypeUnknown || value.Type == field.Type {
+		// untyped values from sources are classified by their Go type
+		value = ValueOf(raw)
+	}
+
+	switch field.Type {
+	case TypeDict:
+		return c.dict(field, value, path)
+	case TypeList:
+		return c.list(field, value, path)
+	}
+	if value.Type == field.Type {
+		return value, nil
+	}
+
+	if s, ok := raw.(string); ok {
+		if c.lenient {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				return Null, nil
+			}
+		}
+		return c.fromString(field, s)
+	}
+	return c.fromValue(field, value)
+}
+
+func (c *coercer) dict(field Field, value Value, path string) (Value, error) {
+	if s, ok := value.Value.(string); ok {
+		parsed, err := parseJSONString(s)
+		if err != nil {
+			return Value{}, err
+		}
+		value = parsed
+	}
+	members, ok := value.Value.(map[string]Value)
+	if !ok {
+		return Value{}, fmt.Errorf("%s is not a dict", value.Type)
+	}
+
+	coerced := make(map[string]Value, len(members))
+	for name, member := range members {
+		coerced[name] = member
+	}
+	if len(field.Fields) > 0 {
+		c.fields(field.Fields, coerced, path)
+	} else if field.Elem != nil {
+		for name, member := range members {
+			coerced[name] = c.value(*field.Elem, member, joinFieldPath(path, name))
+		}
+	}
+	return Value{Type: TypeDict, Value: coerced}, nil
+}
+
+func (c *coercer) list(field Field, value Value, path string) (Value, error) {
+	if s, ok := value.Value.(string); ok {
+		parsed, err := parseJSONString(s)
+		if err != nil {
+			return Value{}, err
+		}
+		value = parsed
+	}
+	elems, ok := value.Value.([]Value)
+	if !ok {
+		return Value{}, fmt.Errorf("%s is not a list", value.Type)
+	}
+
+	coerced := make([]Value, len(elems))
+	for i, elem := range elems {
+		if field.Elem == nil {
+			coerced[i] = elem
+			continue
+		}
+		coerced[i] = c.value(*field.Elem, elem, fmt.Sprintf("%s[%d]", path, i))
+	}
+	return Value{Type: TypeList, Value: coerced}, nil
+}
+
+func parseJSONString(s string) (Value, error) {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return Value{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return ValueOf(doc), nil
+}
+
+func (c *coercer) fromString(field Field, s string) (Value, error) {
+	switch field.Type {
+	case TypeString:
+		return Value{Type: TypeString, Value: s}, nil
+
+	case TypeInt64:
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return Value{Type: TypeInt64, Value: n}, nil
+		}
+		if c.lenient {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return c.fromValue(field, Value{Type: TypeFloat64, Value: f})
+			}
+		}
+		return Value{}, fmt.Errorf("%q is not an integer", s)
+
+	case TypeFloat64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return Value{}, fmt.Errorf("%q is not a number", s)
+		}
+		return Value{Type: TypeFloat64, Value: f}, nil
+
+	case TypeBoolean:
+		if b, ok := parseBool(s, c.lenient); ok {
+			return Value{Type: TypeBoolean, Value: b}, nil
+		}
+		return Value{}, fmt.Errorf("%q is not a boolean", s)
+
+	case TypeTimestamp, TypeDuration:
+		// numeric text such as epoch seconds is counted in the field precision
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return FromNative(n, field)
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return FromNative(f, field)
+		}
+		return FromNative(s, field)
+
+	case TypeBytes:
+		v, err := FromNative(s, field)
+		if err != nil && c.lenient {
+			return Value{Type: TypeBytes, Value: []byte(s)}, nil
+		}
+		return v, err
+
+	case TypeJSON:
+		if json.Valid([]byte(s)) {
+			return Value{Type: TypeJSON, Value: json.RawMessage(s)}, nil
+		}
+		if c.lenient {
+			return FromNative(s, field)
+		}
+		return Value{}, fmt.Errorf("%q is not a JSON document", s)
+
+	default:
+		return FromNative(s, field)
+	}
+}
+
+func (c *coercer) fromValue(field Field, value Value) (Value, error) {
+	raw := value.Value
+	switch field.Type {
+	case TypeString:
+		return c.toString(value)
+
+	case TypeInt64:
+		switch n := raw.(type) {
+		case float64:
+			if n != math.Trunc(n) && !c.lenient {
+				return Value{}, fmt.Errorf("%v has a fractional part", n)
+			}
+			if math.IsNaN(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+				return Value{}, fmt.Errorf("%v is out of int64 range", n)
+			}
+			return Value{Type: TypeInt64, Value: int64(n)}, nil
+		case Decimal:
+			if i, ok := n.Int64(); ok {
+				return Value{Type: TypeInt64, Value: i}, nil
+			}
+			if c.lenient {
+				return c.fromValue(field, Value{Type: TypeFloat64, Value: n.Float64()})
+			}
+			return Value{}, fmt.Errorf("%s is not an int64", n)
+		case bool:
+			if c.lenient {
+				return Value{Type: TypeInt64, Value: boolToInt(n)}, nil
+			}
+		case time.Duration:
+			if c.lenient {
+				return Value{Type: TypeInt64, Value: int64(n)}, nil
+			}
+		}
+
+	case TypeBoolean:
+		switch n := raw.(type) {
+		case int64:
+			if n == 0 || n == 1 || c.lenient {
+				return Value{Type: TypeBoolean, Value: n != 0}, nil
+			}
+			return Value{}, fmt.Errorf("%d is not 0 or 1", n)
+		case float64:
+			if c.lenient {
+				return Value{Type: TypeBoolean, Value: n != 0}, nil
+			}
+		}
+
+	case TypeFloat64:
+		if b, ok := raw.(bool); ok && c.lenient {
+			return Value{Type: TypeFloat64, Value: float64(boolToInt(b))}, nil
+		}
+	}
+	return FromNative(raw, field)
+}
+
+// toString 标量总能无歧义地格式化；dict、list 等结构仅在宽松模式下编码为 JSON
+func (c *coercer) toString(value Value) (Value, error) {
+	var s string
+	switch v := value.Value.(type) {
+	case bool:
+		s = strconv.FormatBool(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	case Decimal:
+		s = v.String()
+	case time.Time:
+		s = v.Format(time.RFC3339Nano)
+	case time.Duration:
+		s = v.String()
+	case json.RawMessage:
+		s = string(v)
+	default:
+		if !c.lenient {
+			return Value{}, fmt.Errorf("%s has no unambiguous string form", value.Type)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return Value{}, err
+		}
+		s = string(data)
+	}
+	return Value{Type: TypeString, Value: s}, nil
+}
+
+var (
+	strictBools  = map[string]bool{"true": true, "1": true, "false": false, "0": false}
+	lenientBools = map[string]bool{
+		"t": true, "y": true, "yes": true, "on": true,
+		"f": false, "n": false, "no": false, "off": false,
+	}
+)
+
+func parseBool(s string, lenient bool) (b, ok bool) {
+	s = strings.ToLower(s)
+	if b, ok := strictBools[s]; ok {
+		return b, true
+	}
+	if lenient {
+		b, ok := lenientBools[s]
+		return b, ok
+	}
+	return false, false
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
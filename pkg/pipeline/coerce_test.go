@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func str(s string) Value {
+	return Value{Type: TypeString, Value: s}
+}
+
+func TestCoerce_Scalars(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   Value
+		field   Field
+		mode    CoercionMode
+		want    any
+		wantErr bool
+	}{
+		{"int", str("42"), Field{Type: TypeInt64}, CoerceStrict, int64(42), false},
+		{"fractional int", str("4.5"), Field{Type: TypeInt64}, CoerceStrict, nil, true},
+		{"fractional int lenient", str("4.5"), Field{Type: TypeInt64}, CoerceLenient, int64(4), false},
+		{"float", str("1e3"), Field{Type: TypeFloat64}, CoerceStrict, 1000.0, false},
+		{"bool one", str("1"), Field{Type: TypeBoolean}, CoerceStrict, true, false},
+		{"bool TRUE", str("TRUE"), Field{Type: TypeBoolean}, CoerceStrict, true, false},
+		{"bool yes", str("yes"), Field{Type: TypeBoolean}, CoerceStrict, nil, true},
+		{"bool yes lenient", str(" yes "), Field{Type: TypeBoolean}, CoerceLenient, true, false},
+		{"int to bool", Value{Type: TypeInt64, Value: int64(0)}, Field{Type: TypeBoolean}, CoerceStrict, false, false},
+		{"int to string", Value{Type: TypeInt64, Value: int64(7)}, Field{Type: TypeString}, CoerceStrict, "7", false},
+		{"float to int", Value{Type: TypeFloat64, Value: 3.0}, Field{Type: TypeInt64}, CoerceStrict, int64(3), false},
+		{"untyped", Value{Value: "12"}, Field{Type: TypeInt64}, CoerceStrict, int64(12), false},
+		{"decimal", str("19.99"), Field{Type: TypeDecimal}, CoerceStrict, MustParseDecimal("19.99"), false},
+		{"epoch", str("1709281800"), Field{Type: TypeTimestamp}, CoerceStrict, time.Unix(1709281800, 0).UTC(), false},
+		{"duration", str("90s"), Field{Type: TypeDuration}, CoerceStrict, 90 * time.Second, false},
+		{"empty lenient", str(""), Field{Type: TypeInt64}, CoerceLenient, nil, false},
+		{"unconvertible lenient", str("abc"), Field{Type: TypeInt64}, CoerceLenient, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Coerce(tt.value, tt.field, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil && KindOf(err) != KindConversion {
+				t.Errorf("want conversion error, got %s", KindOf(err))
+			}
+			if tt.wantErr && tt.mode == CoerceStrict {
+				if got.Value != tt.value.Value {
+					t.Errorf("want original value kept in strict mode, got %v", got.Value)
+				}
+				return
+			}
+			switch want := tt.want.(type) {
+			case Decimal:
+				if d, ok := got.Value.(Decimal); !ok || d.Cmp(want) != 0 {
+					t.Errorf("want %s, got %v", want, got.Value)
+				}
+			case time.Time:
+				if ts, ok := got.Value.(time.Time); !ok || !ts.Equal(want) {
+					t.Errorf("want %v, got %v", want, got.Value)
+				}
+			default:
+				if got.Value != tt.want {
+					t.Errorf("want %v (%T), got %v (%T)", tt.want, tt.want, got.Value, got.Value)
+				}
+			}
+			if got.Value != nil && got.Type != tt.field.Type {
+				t.Errorf("want type %s, got %s", tt.field.Type, got.Type)
+			}
+		})
+	}
+}
+
+func TestSchema_Coerce(t *testing.T) {
+	schema := &Schema{Fields: []Field{
+		{Name: "id", Type: TypeInt64},
+		{Name: "active", Type: TypeBoolean},
+		{Name: "address", Type: TypeDict, Fields: []Field{{Name: "zip", Type: TypeInt64}}},
+		{Name: "scores", Type: TypeList, Elem: &Field{Type: TypeFloat64}},
+	}}
+	record := &Record{Data: map[string]Value{
+		"id":      str("7"),
+		"active":  str("maybe"),
+		"address": {Type: TypeDict, Value: map[string]Value{"zip": str("10001")}},
+		"scores":  str(`[1, "2.5", "x"]`),
+		"extra":   str("kept"),
+	}}
+
+	err := schema.Coerce(record, CoerceStrict)
+	var cerr *CoercionError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("want CoercionError, got %v", err)
+	}
+	paths := make([]string, len(cerr.Violations))
+	for i, v := range cerr.Violations {
+		paths[i] = v.Path
+	}
+	if len(paths) != 2 || paths[0] != "active" || paths[1] != "scores[2]" {
+		t.Errorf("want active and scores[2] reported, got %v", paths)
+	}
+
+	if v, _ := record.GetValue("id"); v.Value != int64(7) {
+		t.Errorf("want id coerced, got %v", v.Value)
+	}
+	if v, _ := record.GetValue("address.zip"); v.Value != int64(10001) {
+		t.Errorf("want nested zip coerced, got %v", v.Value)
+	}
+	if v, _ := record.GetValue("scores[1]"); v.Value != 2.5 {
+		t.Errorf("want list element coerced, got %v", v.Value)
+	}
+	if v, _ := record.GetValue("active"); v.Value != "maybe" {
+		t.Errorf("want failed field kept in strict mode, got %v", v.Value)
+	}
+	if v, _ := record.GetValue("extra"); v.Value != "kept" {
+		t.Errorf("want unknown field untouched, got %v", v.Value)
+	}
+}
@@ -0,0 +1,339 @@
+package pipeline
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// ValueOf 根据 Go 值推断类型，map 和 slice 递归转换为 TypeDict 和 TypeList，nil 为 Null
+func ValueOf(v any) Value {
+	switch v := v.(type) {
+	case nil:
+		return Null
+	case Value:
+		return v
+	case string:
+		return Value{Type: TypeString, Value: v}
+	case bool:
+		return Value{Type: TypeBoolean, Value: v}
+	case int:
+		return Value{Type: TypeInt64, Value: int64(v)}
+	case int32:
+		return Value{Type: TypeInt64, Value: int64(v)}
+	case int64:
+		return Value{Type: TypeInt64, Value: v}
+	case float32:
+		return Value{Type: TypeFloat64, Value: float64(v)}
+	case float64:
+		return Value{Type: TypeFloat64, Value: v}
+	case Decimal:
+		return Value{Type: TypeDecimal, Value: v}
+	case json.RawMessage:
+		return Value{Type: TypeJSON, Value: v}
+	case time.Time:
+		return Value{Type: TypeTimestamp, Value: v}
+	case time.Duration:
+		return Value{Type: TypeDuration, Value: v}
+	case []byte:
+		return Value{Type: TypeBytes, Value: v}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return Value{Type: TypeInt64, Value: n}
+		}
+		if d, err := ParseDecimal(v.String()); err == nil {
+			return Value{Type: TypeDecimal, Value: d}
+		}
+		return Value{Type: TypeString, Value: v.String()}
+	case map[string]Value:
+		return Value{Type: TypeDict, Value: v}
+	case []Value:
+		return Value{Type: TypeList, Value: v}
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			m := make(map[string]Value, rv.Len())
+			iter := rv.MapRange()
+			for iter.Next() {
+				m[iter.Key().String()] = ValueOf(iter.Value().Interface())
+			}
+			return Value{Type: TypeDict, Value: m}
+		}
+	case reflect.Slice, reflect.Array:
+		l := make([]Value, rv.Len())
+		for i := range l {
+			l[i] = ValueOf(rv.Index(i).Interface())
+		}
+		return Value{Type: TypeList, Value: l}
+	case reflect.Int, reflect.Int8, reflect.Int16:
+		return Value{Type: TypeInt64, Value: rv.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return Value{Type: TypeInt64, Value: int64(rv.Uint())}
+	}
+	return Value{Type: TypeUnknown, Value: v}
+}
+
+// FromNative 将表达式结果等 Go 值转换为 field 描述的类型。
+// list 的元素按 Elem 转换；dict 的成员按 Fields 转换，没有 Fields 时全部按 Elem 转换；
+// decimal 在 Scale 非空时调整到该精度；json 保存为 json.RawMessage；
+// timestamp 和 duration 按 Timezone 和 Precision 转换；bytes 接受 base64 字符串。
+// nil 对任何类型都转换为 Null，是否允许由 Validate 按 Nullable 判断
+func FromNative(v any, field Field) (Value, error) {
+	if value, ok := v.(Value); ok {
+		v = value.Value
+	}
+	if v == nil {
+		return Null, nil
+	}
+	t := field.Type
+
+	switch t {
+	case TypeString:
+		str, ok := v.(string)
+		if !ok {
+			return Value{}, fmt.Errorf("cannot convert %T to string", v)
+		}
+		return Value{Type: t, Value: str}, nil
+
+	case TypeInt64:
+		switch num := v.(type) {
+		case int:
+			return Value{Type: t, Value: int64(num)}, nil
+		case int64:
+			return Value{Type: t, Value: num}, nil
+		case float64:
+			return Value{Type: t, Value: int64(num)}, nil
+		case Decimal:
+			if n, ok := num.Int64(); ok {
+				return Value{Type: t, Value: n}, nil
+			}
+			return Value{}, fmt.Errorf("cannot convert decimal %s to int64 exactly", num)
+		case json.Number:
+			n, err := num.Int64()
+			if err != nil {
+				return Value{}, fmt.Errorf("cannot convert %s to int64", num)
+			}
+			return Value{Type: t, Value: n}, nil
+		default:
+			return Value{}, fmt.Errorf("cannot convert %T to int64", v)
+		}
+
+	case TypeFloat64:
+		switch num := v.(type) {
+		case float64:
+			return Value{Type: t, Value: num}, nil
+		case int:
+			return Value{Type: t, Value: float64(num)}, nil
+		case int64:
+			return Value{Type: t, Value: float64(num)}, nil
+		case Decimal:
+			return Value{Type: t, Value: num.Float64()}, nil
+		case json.Number:
+			f, err := num.Float64()
+			if err != nil {
+				return Value{}, fmt.Errorf("cannot convert %s to float64", num)
+			}
+			return Value{Type: t, Value: f}, nil
+		default:
+			return Value{}, fmt.Errorf("cannot convert %T to float64", v)
+		}
+
+	case TypeBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return Value{}, fmt.Errorf("cannot convert %T to boolean", v)
+		}
+		return Value{Type: t, Value: b}, nil
+
+	case TypeDecimal:
+		d, err := toDecimal(v)
+		if err != nil {
+			return Value{}, err
+		}
+		if field.Scale != nil {
+			d = d.Rescale(int32(*field.Scale))
+		}
+		return Value{Type: t, Value: d}, nil
+
+	case TypeList:
+		return listFromNative(v, field)
+
+	case TypeDict:
+		return dictFromNative(v, field)
+
+	case TypeJSON:
+		switch raw := v.(type) {
+		case json.RawMessage:
+			if !json.Valid(raw) {
+				return Value{}, fmt.Errorf("invalid JSON document")
+			}
+			return Value{Type: t, Value: raw}, nil
+		case []byte:
+			if !json.Valid(raw) {
+				return Value{}, fmt.Errorf("invalid JSON document")
+			}
+			return Value{Type: t, Value: json.RawMessage(raw)}, nil
+		}
+		data, err := json.Marshal(ValueOf(v))
+		if err != nil {
+			return Value{}, fmt.Errorf("cannot convert %T to json: %w", v, err)
+		}
+		return Value{Type: t, Value: json.RawMessage(data)}, nil
+
+	case TypeTimestamp:
+		ts, err := toTimestamp(v, field)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Type: t, Value: ts}, nil
+
+	case TypeDuration:
+		d, err := toDuration(v, field)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{Type: t, Value: d}, nil
+
+	case TypeBytes:
+		switch b := v.(type) {
+		case []byte:
+			return Value{Type: t, Value: b}, nil
+		case string:
+			data, err := base64.StdEncoding.DecodeString(b)
+			if err != nil {
+				return Value{}, fmt.Errorf("cannot decode bytes: %w", err)
+			}
+			return Value{Type: t, Value: data}, nil
+		default:
+			return Value{}, fmt.Errorf("cannot convert %T to bytes", v)
+		}
+
+	case TypeNull:
+		return Value{}, fmt.Errorf("cannot convert %T to null", v)
+
+	default:
+		return Value{}, fmt.Errorf("unsupported type: %v", t)
+	}
+}
+
+func toDecimal(v any) (Decimal, error) {
+	switch num := v.(type) {
+	case Decimal:
+		return num, nil
+	case int:
+		return NewDecimal(int64(num), 0), nil
+	case int64:
+		return NewDecimal(num, 0), nil
+	case float64:
+		if math.IsNaN(num) || math.IsInf(num, 0) {
+			return Decimal{}, fmt.Errorf("cannot convert %v to decimal", num)
+		}
+		return DecimalFromFloat(num)
+	case string:
+		return ParseDecimal(num)
+	case json.Number:
+		return ParseDecimal(num.String())
+	default:
+		return Decimal{}, fmt.Errorf("cannot convert %T to decimal", v)
+	}
+}
+
+func listFromNative(v any, field Field) (Value, error) {
+	var items []any
+	switch l := v.(type) {
+	case []Value:
+		items = make([]any, len(l))
+		for i, item := range l {
+			items[i] = item
+		}
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return Value{}, fmt.Errorf("cannot convert %T to list", v)
+		}
+		items = make([]any, rv.Len())
+		for i := range items {
+			items[i] = rv.Index(i).Interface()
+		}
+	}
+
+	list := make([]Value, len(items))
+	for i, item := range items {
+		elem, err := elemFromNative(item, field.Elem)
+		if err != nil {
+			return Value{}, fmt.Errorf("[%d]: %w", i, err)
+		}
+		list[i] = elem
+	}
+	return Value{Type: TypeList, Value: list}, nil
+}
+
+func dictFromNative(v any, field Field) (Value, error) {
+	members := make(map[string]any)
+	switch m := v.(type) {
+	case map[string]Value:
+		for k, member := range m {
+			members[k] = member
+		}
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+			return Value{}, fmt.Errorf("cannot convert %T to dict", v)
+		}
+		iter := rv.MapRange()
+		for iter.Next() {
+			members[iter.Key().String()] = iter.Value().Interface()
+		}
+	}
+
+	typed := make(map[string]*Field, len(field.Fields))
+	for i := range field.Fields {
+		typed[field.Fields[i].Name] = &field.Fields[i]
+	}
+
+	dict := make(map[string]Value, len(members))
+	for name, member := range members {
+		schema := field.Elem
+		if f, ok := typed[name]; ok {
+			schema = f
+		} else if len(field.Fields) > 0 {
+			// members outside Fields keep their natural type
+			schema = nil
+		}
+		value, err := elemFromNative(member, schema)
+		if err != nil {
+			return Value{}, fmt.Errorf("%s: %w", name, err)
+		}
+		dict[name] = value
+	}
+	return Value{Type: TypeDict, Value: dict}, nil
+}
+
+// elemFromNative 没有元素 schema 时按 ValueOf 推断
+func elemFromNative(v any, schema *Field) (Value, error) {
+	if schema == nil {
+		return ValueOf(v), nil
+	}
+	return FromNative(v, *schema)
+}
+
+// MarshalJSON 输出 Value 的 JSON 形式：timestamp 为 RFC 3339 字符串，
+// duration 为 time.Duration.String 格式，bytes 为 base64 字符串，空值为 null。
+// 这些形式都能被 FromNative 按字段类型读回
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch inner := v.Value.(type) {
+	case nil:
+		return []byte("null"), nil
+	case time.Time:
+		return json.Marshal(inner.Format(time.RFC3339Nano))
+	case time.Duration:
+		return json.Marshal(inner.String())
+	}
+	return json.Marshal(v.Value)
+}
@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal 精确的十进制数，值为 coef * 10^-scale，用于金额等不能有浮点误差的字段。
+// Decimal 不可变，零值表示 0
+type Decimal struct {
+	coef  *big.Int
+	scale int32
+}
+
+var bigTen = big.NewInt(10)
+
+// maxDecimalScale 限制解析时的指数和小数位数，避免 "1e50000000" 这样很短的输入
+// 展开成巨大的整数
+const maxDecimalScale = 10000
+
+func NewDecimal(unscaled int64, scale int32) Decimal {
+	return Decimal{coef: big.NewInt(unscaled), scale: scale}
+}
+
+// ParseDecimal 解析 "-12.340"、"1e-3" 这样的十进制字符串，保留原有的小数位数
+func ParseDecimal(s string) (Decimal, error) {
+	str := strings.TrimSpace(s)
+	mantissa, exp := str, int64(0)
+	if i := strings.IndexAny(str, "eE"); i >= 0 {
+		var err error
+		exp, err = strconv.ParseInt(str[i+1:], 10, 32)
+		if err != nil {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+		mantissa = str[:i]
+	}
+
+	intPart, fracPart, _ := strings.Cut(mantissa, ".")
+	digits := intPart + fracPart
+	if digits == "" || digits == "-" || digits == "+" || strings.ContainsAny(digits[1:], "+-") {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	coef, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+
+	scale := int64(len(fracPart)) - exp
+	if scale > maxDecimalScale || scale < -maxDecimalScale {
+		return Decimal{}, fmt.Errorf("decimal %q: exponent out of range, scale must be within ±%d", s, maxDecimalScale)
+	}
+	if scale < 0 {
+		coef.Mul(coef, new(big.Int).Exp(bigTen, big.NewInt(-scale), nil))
+		scale = 0
+	}
+	return Decimal{coef: coef, scale: int32(scale)}, nil
+}
+
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// NewDecimalFromBigInt 值为 unscaled * 10^-scale，unscaled 会被复制
+func NewDecimalFromBigInt(unscaled *big.Int, scale int32) Decimal {
+	return Decimal{coef: new(big.Int).Set(unscaled), scale: scale}
+}
+
+// DecimalFromFloat 使用 float64 的最短十进制表示，因此 0.1 转换为 0.1 而不是二进制近似值
+func DecimalFromFloat(f float64) (Decimal, error) {
+	return ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+func (d Decimal) coefficient() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// Unscaled 返回 coef 的副本，二进制格式按它和 Scale 保存 decimal
+func (d Decimal) Unscaled() *big.Int {
+	return new(big.Int).Set(d.coefficient())
+}
+
+func (d Decimal) Scale() int32 {
+	return d.scale
+}
+
+func (d Decimal) Sign() int {
+	return d.coefficient().Sign()
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.coefficient()).String()
+	sign := ""
+	if d.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale <= 0 {
+		return sign + digits
+	}
+	if pad := int(d.scale) - len(digits) + 1; pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	point := len(digits) - int(d.scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// Int64 返回整数部分，ok 为 false 表示有小数部分或超出范围
+func (d Decimal) Int64() (n int64, ok bool) {
+	r := d.Rescale(0)
+	if r.Cmp(d) != 0 || !r.coefficient().IsInt64() {
+		return 0, false
+	}
+	return r.coefficient().Int64(), true
+}
+
+// align 返回两个数在相同 scale 下的系数
+func align(a, b Decimal) (*big.Int, *big.Int, int32) {
+	x, y := a.coefficient(), b.coefficient()
+	switch {
+	case a.scale < b.scale:
+		x = new(big.Int).Mul(x, pow10(b.scale-a.scale))
+		return x, y, b.scale
+	case a.scale > b.scale:
+		y = new(big.Int).Mul(y, pow10(a.scale-b.scale))
+		return x, y, a.scale
+	}
+	return x, y, a.scale
+}
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) Cmp(other Decimal) int {
+	x, y, _ := align(d, other)
+	return x.Cmp(y)
+}
+
+func (d Decimal) Add(other Decimal) Decimal {
+	x, y, scale := align(d, other)
+	return Decimal{coef: new(big.Int).Add(x, y), scale: scale}
+}
+
+func (d Decimal) Sub(other Decimal) Decimal {
+	x, y, scale := align(d, other)
+	return Decimal{coef: new(big.Int).Sub(x, y), scale: scale}
+}
+
+func (d Decimal) Mul(other Decimal) Decimal {
+	return Decimal{
+		coef:  new(big.Int).Mul(d.coefficient(), other.coefficient()),
+		scale: d.scale + other.scale,
+	}
+}
+
+// Rescale 调整到指定小数位数，舍去的部分四舍五入（远离零）
+func (d Decimal) Rescale(scale int32) Decimal {
+	if scale >= d.scale {
+		return Decimal{coef: new(big.Int).Mul(d.coefficient(), pow10(scale-d.scale)), scale: scale}
+	}
+
+	divisor := pow10(d.scale - scale)
+	q, r := new(big.Int).QuoRem(d.coefficient(), divisor, new(big.Int))
+	// |r| * 2 >= divisor rounds away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(divisor) >= 0 {
+		if d.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return Decimal{coef: q, scale: scale}
+}
+
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalText(text []byte) error {
+	parsed, err := ParseDecimal(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON 输出 JSON 数字字面量，不经过 float64
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	return d.UnmarshalText([]byte(strings.Trim(string(data), `"`)))
+}
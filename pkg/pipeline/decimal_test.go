@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecimal(t *testing.T) {
+	for input, want := range map[string]string{
+		"12.340": "12.340",
+		"-0.05":  "-0.05",
+		"-.5":    "-0.5",
+		"1e3":    "1000",
+		"1.5e-3": "0.0015",
+		"007":    "7",
+	} {
+		d, err := ParseDecimal(input)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if d.String() != want {
+			t.Errorf("%s: want %s, got %s", input, want, d)
+		}
+	}
+	for _, bad := range []string{"", "-", "1.2.3", "abc", "1-2", "1e50000000", "1e-2147483648", "1e-10001", "1e2147483648"} {
+		if _, err := ParseDecimal(bad); err == nil {
+			t.Errorf("%q: want parse error", bad)
+		}
+	}
+
+	if d, err := ParseDecimal("1e-10000"); err != nil || d.Scale() != 10000 {
+		t.Errorf("scale at the bound: %v, %v", d.Scale(), err)
+	}
+	// untrusted numbers beyond the bound stay strings instead of expanding
+	if v := ValueOf(json.Number("1e50000000")); v.Type != TypeString {
+		t.Errorf("want huge exponent kept as string, got %s", v.Type)
+	}
+
+	// 0.1 + 0.2 is exact
+	sum := MustParseDecimal("0.1").Add(MustParseDecimal("0.2"))
+	if sum.Cmp(MustParseDecimal("0.3")) != 0 || sum.String() != "0.3" {
+		t.Errorf("want 0.3, got %s", sum)
+	}
+	if got := MustParseDecimal("19.99").Mul(NewDecimal(3, 0)).String(); got != "59.97" {
+		t.Errorf("want 59.97, got %s", got)
+	}
+	for input, want := range map[string]string{"2.345": "2.35", "-2.345": "-2.35", "2.344": "2.34"} {
+		if got := MustParseDecimal(input).Rescale(2).String(); got != want {
+			t.Errorf("rescale %s: want %s, got %s", input, want, got)
+		}
+	}
+
+	data, err := json.Marshal(map[string]Decimal{"price": MustParseDecimal("12345678901234567890.12")})
+	if err != nil || string(data) != `{"price":12345678901234567890.12}` {
+		t.Errorf("want exact JSON number, got %s, %v", data, err)
+	}
+}
+
+func TestFromNative(t *testing.T) {
+	scale := 2
+	v, err := FromNative(19.999, Field{Type: TypeDecimal, Scale: &scale})
+	if err != nil || v.Value.(Decimal).String() != "20.00" {
+		t.Errorf("want 20.00, got %v, %v", v.Value, err)
+	}
+
+	v, err = FromNative([]any{1, 2.0}, Field{Type: TypeList, Elem: &Field{Type: TypeFloat64}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := v.Value.([]Value); len(list) != 2 || list[0] != (Value{Type: TypeFloat64, Value: 1.0}) {
+		t.Errorf("want typed float elements, got %v", list)
+	}
+	if _, err := FromNative([]any{"x"}, Field{Type: TypeList, Elem: &Field{Type: TypeInt64}}); err == nil {
+		t.Error("want element conversion error")
+	}
+
+	v, err = FromNative(map[string]any{"a": 1, "b": 2}, Field{Type: TypeDict, Elem: &Field{Type: TypeDecimal}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := v.Value.(map[string]Value)["b"]; d.Type != TypeDecimal {
+		t.Errorf("want decimal members, got %v", d)
+	}
+
+	v, err = FromNative(map[string]any{"a": []any{1, "x"}}, Field{Type: TypeJSON})
+	if err != nil || string(v.Value.(json.RawMessage)) != `{"a":[1,"x"]}` {
+		t.Errorf("want JSON document, got %v, %v", v.Value, err)
+	}
+}
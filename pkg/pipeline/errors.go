@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// ErrorKind 错误分类，用作指标标签、日志字段以及错误策略的依据。
+// 取值集合固定，避免把错误文本放进指标标签
+type ErrorKind string
+
+const (
+	KindUnknown    ErrorKind = "unknown"
+	KindValidation ErrorKind = "validation"
+	KindConversion ErrorKind = "conversion"
+	KindTimeout    ErrorKind = "timeout"
+	KindTransient  ErrorKind = "transient_io"
+	KindPermanent  ErrorKind = "permanent"
+)
+
+// Error 携带分类的错误
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func NewError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func Errorf(kind ErrorKind, format string, args ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorClassifier 由阶段或 sink 实现，对自己返回的未分类错误进行分类
+type ErrorClassifier interface {
+	ClassifyError(err error) ErrorKind
+}
+
+// KindOf 返回错误链中最外层的分类，未分类时根据常见的超时和 IO 错误推断
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return KindTimeout
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.EPIPE):
+		return KindTransient
+	}
+	return KindUnknown
+}
+
+// classify 给未分类的错误附加分类，优先使用 classifier 的判断
+func classify(classifier any, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	if c, ok := classifier.(ErrorClassifier); ok {
+		if kind := c.ClassifyError(err); kind != "" {
+			return &Error{Kind: kind, Err: err}
+		}
+	}
+	return &Error{Kind: KindOf(err), Err: err}
+}
+
+type ErrorAction int
+
+const (
+	ActionFail ErrorAction = iota
+	// ActionRetry retries up to Config.RetryCount times, waiting Config.RetryDelay in between
+	ActionRetry
+	// ActionSkip drops the message and keeps the pipeline running
+	ActionSkip
+)
+
+// ErrorPolicy 按错误分类决定处理方式，未列出的分类按 ActionFail 处理
+type ErrorPolicy map[ErrorKind]ErrorAction
+
+var DefaultErrorPolicy = ErrorPolicy{
+	KindTimeout:   ActionRetry,
+	KindTransient: ActionRetry,
+}
+
+func (p ErrorPolicy) action(err error) ErrorAction {
+	if p == nil {
+		p = DefaultErrorPolicy
+	}
+	return p[KindOf(err)]
+}
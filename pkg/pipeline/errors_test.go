@@ -0,0 +1,194 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/observability"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestKindOf(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want ErrorKind
+	}{
+		{Errorf(KindValidation, "field %s missing", "a"), KindValidation},
+		{fmt.Errorf("stage 0: %w", NewError(KindConversion, errors.New("bad"))), KindConversion},
+		{fmt.Errorf("read: %w", context.DeadlineExceeded), KindTimeout},
+		{io.ErrUnexpectedEOF, KindTransient},
+		{errors.New("user 42 has value 3.14"), KindUnknown},
+	}
+	for _, tc := range testCases {
+		if got := KindOf(tc.err); got != tc.want {
+			t.Errorf("KindOf(%v): want %s, got %s", tc.err, tc.want, got)
+		}
+	}
+}
+
+// flakyStage 前 failures 次调用返回给定错误
+type flakyStage struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (s *flakyStage) Process(msg *Message) (*Message, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, s.err
+	}
+	return msg, nil
+}
+
+type classifyingStage struct{ flakyStage }
+
+func (s *classifyingStage) ClassifyError(err error) ErrorKind {
+	return KindTransient
+}
+
+func runOne(config Config, stage Stage) (*collectSink, error) {
+	sink := &collectSink{}
+	err := NewLittlePipe(config).
+		SetSource(&sliceSource{msgs: []*Message{prioritized("a", 0)}}).
+		AddStage(stage).
+		SetSink(sink).
+		Run()
+	return sink, err
+}
+
+func TestLittlePipe_ErrorPolicy(t *testing.T) {
+	// classified by the stage itself, retried by the default policy
+	stage := &classifyingStage{flakyStage{failures: 2, err: errors.New("connection dropped")}}
+	sink, err := runOne(Config{RetryCount: 2}, stage)
+	if err != nil || len(sink.ids) != 1 || stage.calls != 3 {
+		t.Errorf("want success after 2 retries, got %v, %d calls", err, stage.calls)
+	}
+
+	// retries exhausted
+	stage = &classifyingStage{flakyStage{failures: 5, err: errors.New("connection dropped")}}
+	if _, err := runOne(Config{RetryCount: 2}, stage); KindOf(err) != KindTransient {
+		t.Errorf("want transient error after retries, got %v", err)
+	}
+
+	// validation errors are not retried by default
+	flaky := &flakyStage{failures: 1, err: Errorf(KindValidation, "bad record")}
+	if _, err := runOne(Config{RetryCount: 2}, flaky); err == nil || flaky.calls != 1 {
+		t.Errorf("want immediate failure, got %v, %d calls", err, flaky.calls)
+	}
+
+	// skipped by policy
+	flaky = &flakyStage{failures: 1, err: Errorf(KindValidation, "bad record")}
+	sink, err = runOne(Config{ErrorPolicy: ErrorPolicy{KindValidation: ActionSkip}}, flaky)
+	if err != nil || len(sink.ids) != 0 {
+		t.Errorf("want message skipped, got %v, %v", err, sink.ids)
+	}
+}
+
+// erringSource 在给定位置返回错误而不是消息，之后的读取继续
+type erringSource struct {
+	items []any
+}
+
+func (s *erringSource) Read() (*Message, error) {
+	if len(s.items) == 0 {
+		return nil, io.EOF
+	}
+	item := s.items[0]
+	s.items = s.items[1:]
+	if err, ok := item.(error); ok {
+		return nil, err
+	}
+	return prioritized(item.(string), 0), nil
+}
+
+func runSource(config Config, source Source) (*collectSink, error) {
+	sink := &collectSink{}
+	err := NewLittlePipe(config).SetSource(source).SetSink(sink).Run()
+	return sink, err
+}
+
+func TestLittlePipe_SourceErrorPolicy(t *testing.T) {
+	bad := Errorf(KindConversion, "line 2: bad record")
+
+	// conversion errors fail the run by default
+	if _, err := runSource(Config{}, &erringSource{items: []any{"a", bad, "c"}}); KindOf(err) != KindConversion {
+		t.Errorf("want conversion error, got %v", err)
+	}
+
+	// skipped records do not stop the source
+	sink, err := runSource(Config{ErrorPolicy: ErrorPolicy{KindConversion: ActionSkip}},
+		&erringSource{items: []any{"a", bad, "c"}})
+	if err != nil || len(sink.ids) != 2 || sink.ids[1] != "c" {
+		t.Errorf("want bad record skipped, got %v, %v", err, sink.ids)
+	}
+
+	// a retried read that hits the end still reports the failure
+	if _, err := runSource(Config{RetryCount: 3}, &erringSource{items: []any{"a", io.ErrUnexpectedEOF}}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("want the read error kept, got %v", err)
+	}
+}
+
+func TestObservableStage_ErrorLabel(t *testing.T) {
+	metrics := observability.NewMetricsWithRegisterer("test", prometheus.NewRegistry())
+	stage := NewObservableStage("flaky",
+		&flakyStage{failures: 1, err: fmt.Errorf("bad value %d", 12345)},
+		observability.NewLogger(), observability.NewTracer("test"), metrics)
+
+	if _, err := stage.Process(prioritized("a", 0)); KindOf(err) != KindUnknown {
+		t.Fatalf("want unknown error, got %v", err)
+	}
+	if got := testutil.ToFloat64(metrics.ErrorsTotal.WithLabelValues("flaky", string(KindUnknown))); got != 1 {
+		t.Errorf("want error counted under its kind, got %v", got)
+	}
+}
+
+// blockingSource 阻塞到通道被关闭
+type blockingSource chan struct{}
+
+func (s blockingSource) Read() (*Message, error) {
+	<-s
+	return nil, io.EOF
+}
+
+type closingSink struct {
+	collectSink
+	closed int
+}
+
+func (s *closingSink) Close() error {
+	s.closed++
+	return nil
+}
+
+func TestLittlePipe_ClosesSinkOnCompletion(t *testing.T) {
+	sink := &closingSink{}
+	if err := NewLittlePipe(Config{}).SetSource(&erringSource{items: []any{"a"}}).SetSink(sink).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if sink.closed != 1 {
+		t.Errorf("want sink closed once after a normal run, got %d", sink.closed)
+	}
+
+	// Stop closes the sink once it has finished the current write
+	sink = &closingSink{}
+	blocked := make(chan struct{})
+	defer close(blocked)
+	p := NewLittlePipe(Config{}).SetSource(blockingSource(blocked)).SetSink(sink)
+	time.AfterFunc(10*time.Millisecond, p.Stop)
+	if err := p.Run(); !errors.Is(err, context.Canceled) || sink.closed != 1 {
+		t.Errorf("want sink closed after Stop, got %v, %d closes", err, sink.closed)
+	}
+
+	// a failed run leaves the output unpublished
+	sink = &closingSink{}
+	err := NewLittlePipe(Config{}).SetSource(&erringSource{items: []any{"a", errors.New("boom")}}).SetSink(sink).Run()
+	if err == nil || sink.closed != 0 {
+		t.Errorf("want failure without closing the sink, got %v, %d closes", err, sink.closed)
+	}
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+var fieldTypeNames = []string{
+	TypeUnknown:   "unknown",
+	TypeString:    "string",
+	TypeInt64:     "int64",
+	TypeFloat64:   "float64",
+	TypeBoolean:   "boolean",
+	TypeList:      "list",
+	TypeDict:      "dict",
+	TypeDecimal:   "decimal",
+	TypeJSON:      "json",
+	TypeTimestamp: "timestamp",
+	TypeDuration:  "duration",
+	TypeBytes:     "bytes",
+	TypeNull:      "null",
+}
+
+// fieldTypeAliases 额外接受的手写名称
+var fieldTypeAliases = map[string]FieldType{
+	"bool": TypeBoolean,
+}
+
+func (t FieldType) String() string {
+	if t >= 0 && int(t) < len(fieldTypeNames) {
+		return fieldTypeNames[t]
+	}
+	return fmt.Sprintf("FieldType(%d)", int(t))
+}
+
+// ParseFieldType 按名称解析类型，名称不区分大小写
+func ParseFieldType(name string) (FieldType, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, n := range fieldTypeNames {
+		if n == name {
+			return FieldType(i), nil
+		}
+	}
+	if t, ok := fieldTypeAliases[name]; ok {
+		return t, nil
+	}
+	return TypeUnknown, fmt.Errorf("unknown field type %q, valid types: %s",
+		name, strings.Join(fieldTypeNames, ", "))
+}
+
+// MarshalText 同时用于 JSON、YAML 和其他基于文本的编码
+func (t FieldType) MarshalText() ([]byte, error) {
+	if t < 0 || int(t) >= len(fieldTypeNames) {
+		return nil, fmt.Errorf("invalid field type %d", int(t))
+	}
+	return []byte(t.String()), nil
+}
+
+func (t *FieldType) UnmarshalText(text []byte) error {
+	parsed, err := ParseFieldType(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// UnmarshalJSON 兼容旧配置中以整数表示的类型，null 不修改原值
+func (t *FieldType) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] != '"' {
+		var n int
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("field type must be a name or an integer: %w", err)
+		}
+		if n < 0 || n >= len(fieldTypeNames) {
+			return fmt.Errorf("invalid field type %d, valid types: %s",
+				n, strings.Join(fieldTypeNames, ", "))
+		}
+		*t = FieldType(n)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	return t.UnmarshalText([]byte(name))
+}
@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+type typed struct {
+	Type FieldType `json:"type" yaml:"type"`
+}
+
+func TestFieldType_JSON(t *testing.T) {
+	data, err := json.Marshal(typed{Type: TypeDecimal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"type":"decimal"}` {
+		t.Errorf("want type encoded by name, got %s", data)
+	}
+
+	for input, want := range map[string]FieldType{
+		`{"type":"int64"}`: TypeInt64,
+		`{"type":"Bool"}`:  TypeBoolean,
+		`{"type":3}`:       TypeFloat64,
+	} {
+		var v typed
+		if err := json.Unmarshal([]byte(input), &v); err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if v.Type != want {
+			t.Errorf("%s: want %s, got %s", input, want, v.Type)
+		}
+	}
+
+	// null leaves the value untouched, like encoding/json does for other types
+	v := typed{Type: TypeBytes}
+	if err := json.Unmarshal([]byte(`{"type":null}`), &v); err != nil || v.Type != TypeBytes {
+		t.Errorf("null: want %s kept, got %s, %v", TypeBytes, v.Type, err)
+	}
+
+	err = json.Unmarshal([]byte(`{"type":"integer"}`), &v)
+	if err == nil || !strings.Contains(err.Error(), "int64") {
+		t.Errorf("want error listing valid names, got %v", err)
+	}
+}
+
+func TestFieldType_YAML(t *testing.T) {
+	data, err := yaml.Marshal(typed{Type: TypeString})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "type: string" {
+		t.Errorf("want type encoded by name, got %s", data)
+	}
+
+	var v typed
+	if err := yaml.Unmarshal([]byte("type: json"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Type != TypeJSON {
+		t.Errorf("want json, got %s", v.Type)
+	}
+}
+
+func TestSchema_ValidateTypeNames(t *testing.T) {
+	schema := &Schema{Fields: []Field{{Name: "age", Type: TypeInt64}}}
+	err := schema.Validate(&Record{Data: map[string]Value{
+		"age": {Type: TypeString, Value: "42"},
+	}})
+	if err == nil || err.Error() != "field age type mismatch, want int64, get string" {
+		t.Errorf("want readable type names, got %v", err)
+	}
+}
@@ -15,10 +15,29 @@ type Sink interface {
 	Write(data *Message) error
 }
 
+// Stage 返回 nil 消息且无错误时，该消息被过滤掉
 type Stage interface {
 	Process(data *Message) (*Message, error)
 }
 
+// FanOutStage 一条输入可以产生零到多条输出的阶段
+type FanOutStage interface {
+	Stage
+	ProcessAll(data *Message) ([]*Message, error)
+}
+
+// Flusher 在输入正常结束时被调用，输出阶段内缓存的消息
+type Flusher interface {
+	Flush() ([]*Message, error)
+}
+
+// Ticker 需要按时间输出缓存消息的阶段，例如按时间窗口采样。输入在 TickInterval 内
+// 没有新消息时 pipeline 调用 Tick，TickInterval 不大于 0 时不调用
+type Ticker interface {
+	TickInterval() time.Duration
+	Tick() ([]*Message, error)
+}
+
 // ObservableStage 可观测的阶段
 type ObservableStage struct {
 	name    string
@@ -53,15 +72,12 @@ func (s *ObservableStage) Process(data *Message) (*Message, error) {
 	)
 
 	result, err := s.stage.Process(data)
+	err = classify(s.stage, err)
 	duration := time.Since(startTime)
 	s.metrics.ProcessingDuration.WithLabelValues(s.name).Observe(float64(duration.Milliseconds()))
 
 	if err != nil {
-		s.metrics.ErrorsTotal.WithLabelValues(s.name, err.Error()).Inc()
-		s.logger.Error("failed to process message",
-			zap.String("message_id", data.ID),
-			zap.Error(err),
-			zap.Int("duration", int(duration.Milliseconds())))
+		s.recordError("failed to process message", data, err, duration)
 	} else {
 		s.metrics.MessagesTotal.WithLabelValues(s.name).Inc()
 		s.logger.Info("processed message",
@@ -72,3 +88,89 @@ func (s *ObservableStage) Process(data *Message) (*Message, error) {
 
 	return result, err
 }
+
+func (s *ObservableStage) ProcessAll(data *Message) ([]*Message, error) {
+	fanOut, ok := s.stage.(FanOutStage)
+	if !ok {
+		result, err := s.Process(data)
+		if err != nil || result == nil {
+			return nil, err
+		}
+		return []*Message{result}, nil
+	}
+
+	startTime := time.Now()
+	s.metrics.MessagesInProgress.WithLabelValues(s.name).Inc()
+	defer s.metrics.MessagesInProgress.WithLabelValues(s.name).Dec()
+
+	results, err := fanOut.ProcessAll(data)
+	err = classify(s.stage, err)
+	duration := time.Since(startTime)
+	s.metrics.ProcessingDuration.WithLabelValues(s.name).Observe(duration.Seconds())
+	if err != nil {
+		s.recordError("failed to process message", data, err, duration)
+		return nil, err
+	}
+	s.metrics.MessagesTotal.WithLabelValues(s.name).Inc()
+	return results, nil
+}
+
+func (s *ObservableStage) Flush() ([]*Message, error) {
+	flusher, ok := s.stage.(Flusher)
+	if !ok {
+		return nil, nil
+	}
+	results, err := flusher.Flush()
+	err = classify(s.stage, err)
+	if err != nil {
+		kind := KindOf(err)
+		s.metrics.ErrorsTotal.WithLabelValues(s.name, string(kind)).Inc()
+		s.logger.Error("failed to flush stage",
+			zap.String("error_kind", string(kind)),
+			zap.Error(err))
+	}
+	return results, err
+}
+
+func (s *ObservableStage) TickInterval() time.Duration {
+	if ticker, ok := s.stage.(Ticker); ok {
+		return ticker.TickInterval()
+	}
+	return 0
+}
+
+func (s *ObservableStage) Tick() ([]*Message, error) {
+	ticker, ok := s.stage.(Ticker)
+	if !ok {
+		return nil, nil
+	}
+	results, err := ticker.Tick()
+	err = classify(s.stage, err)
+	if err != nil {
+		kind := KindOf(err)
+		s.metrics.ErrorsTotal.WithLabelValues(s.name, string(kind)).Inc()
+		s.logger.Error("failed to tick stage",
+			zap.String("error_kind", string(kind)),
+			zap.Error(err))
+	}
+	return results, err
+}
+
+// Unwrap 返回被包装的阶段
+func (s *ObservableStage) Unwrap() Stage {
+	return s.stage
+}
+
+func (s *ObservableStage) ClassifyError(err error) ErrorKind {
+	return KindOf(classify(s.stage, err))
+}
+
+func (s *ObservableStage) recordError(msg string, data *Message, err error, duration time.Duration) {
+	kind := KindOf(err)
+	s.metrics.ErrorsTotal.WithLabelValues(s.name, string(kind)).Inc()
+	s.logger.Error(msg,
+		zap.String("message_id", data.ID),
+		zap.String("error_kind", string(kind)),
+		zap.Error(err),
+		zap.Int("duration", int(duration.Milliseconds())))
+}
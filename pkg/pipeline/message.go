@@ -13,6 +13,9 @@ type Message struct {
 	Metadata  map[string]any
 	CreatedAt time.Time
 	Error     error
+	// Priority selects the lane when Config.Priority is enabled,
+	// higher values are served first
+	Priority int
 
 	TraceID string
 	SpanID  string
@@ -0,0 +1,342 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathSegment 字段路径中的一段，要么是字段名，要么是列表下标。
+// Schema 路径中的 [] 表示任意元素，此时 Index 为 -1
+type PathSegment struct {
+	Name    string
+	Index   int
+	IsIndex bool
+}
+
+// ParsePath 解析形如 user.address.city、items[0].sku 或 items[].sku 的路径
+func ParsePath(path string) ([]PathSegment, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty field path")
+	}
+
+	var segments []PathSegment
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unclosed [", path)
+			}
+			index, err := -1, error(nil)
+			if end > 1 {
+				index, err = strconv.Atoi(path[i+1 : i+end])
+			}
+			if err != nil || (end > 1 && index < 0) {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", path, path[i+1:i+end])
+			}
+			segments = append(segments, PathSegment{Index: index, IsIndex: true})
+			i += end + 1
+		case '.':
+			if len(segments) == 0 || i+1 == len(path) || path[i+1] == '.' || path[i+1] == '[' {
+				return nil, fmt.Errorf("invalid path %q: empty field name", path)
+			}
+			i++
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: empty field name", path)
+			}
+			if len(segments) > 0 && path[i-1] != '.' {
+				return nil, fmt.Errorf("invalid path %q: missing . before %q", path, path[i:i+end])
+			}
+			segments = append(segments, PathSegment{Name: path[i : i+end]})
+			i += end
+		}
+	}
+	if segments[0].IsIndex {
+		return nil, fmt.Errorf("invalid path %q: must start with a field name", path)
+	}
+	return segments, nil
+}
+
+func (s PathSegment) String() string {
+	if s.IsIndex && s.Index < 0 {
+		return "[]"
+	}
+	if s.IsIndex {
+		return fmt.Sprintf("[%d]", s.Index)
+	}
+	return s.Name
+}
+
+// Native 递归地去掉 Value 包装，TypeDict 和 TypeList 转换为 map[string]any 和 []any
+func (v Value) Native() any {
+	switch inner := v.Value.(type) {
+	case map[string]Value:
+		m := make(map[string]any, len(inner))
+		for k, child := range inner {
+			m[k] = child.Native()
+		}
+		return m
+	case []Value:
+		l := make([]any, len(inner))
+		for i, child := range inner {
+			l[i] = child.Native()
+		}
+		return l
+	case json.RawMessage:
+		var doc any
+		if err := json.Unmarshal(inner, &doc); err == nil {
+			return doc
+		}
+	}
+	return v.Value
+}
+
+// GetValue 按路径读取值，与字段名完全相同的顶层键优先
+func (r *Record) GetValue(path string) (Value, bool) {
+	if value, ok := r.Data[path]; ok {
+		return value, true
+	}
+	segments, err := ParsePath(path)
+	if err != nil {
+		return Value{}, false
+	}
+
+	current := Value{Type: TypeDict, Value: r.Data}
+	for _, seg := range segments {
+		next, ok := child(current, seg)
+		if !ok {
+			return Value{}, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+// SetValue 按路径写入值，自动创建中间的 dict；列表下标等于长度时追加
+func (r *Record) SetValue(path string, value Value) error {
+	segments, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	if r.Data == nil {
+		r.Data = make(map[string]Value)
+	}
+	_, err = setIn(Value{Type: TypeDict, Value: r.Data}, segments, value, path)
+	return err
+}
+
+// DeleteValue 按路径删除值，返回是否存在；删除列表元素会使后面的元素前移
+func (r *Record) DeleteValue(path string) bool {
+	if _, ok := r.Data[path]; ok {
+		delete(r.Data, path)
+		return true
+	}
+	segments, err := ParsePath(path)
+	if err != nil {
+		return false
+	}
+	_, ok := deleteIn(Value{Type: TypeDict, Value: r.Data}, segments)
+	return ok
+}
+
+func child(v Value, seg PathSegment) (Value, bool) {
+	if seg.IsIndex {
+		list, ok := v.Value.([]Value)
+		if !ok || seg.Index < 0 || seg.Index >= len(list) {
+			return Value{}, false
+		}
+		return list[seg.Index], true
+	}
+	dict, ok := v.Value.(map[string]Value)
+	if !ok {
+		return Value{}, false
+	}
+	c, ok := dict[seg.Name]
+	return c, ok
+}
+
+// setIn 返回更新后的容器，列表追加可能重新分配底层数组，需要写回上一层
+func setIn(container Value, segments []PathSegment, value Value, path string) (Value, error) {
+	seg := segments[0]
+	if seg.IsIndex {
+		list, ok := container.Value.([]Value)
+		if !ok {
+			return container, fmt.Errorf("set %s: %s is not a list", path, seg)
+		}
+		if seg.Index < 0 || seg.Index > len(list) {
+			return container, fmt.Errorf("set %s: index %d out of range", path, seg.Index)
+		}
+		if seg.Index == len(list) {
+			list = append(list, Value{Type: TypeDict, Value: make(map[string]Value)})
+		}
+		if len(segments) == 1 {
+			list[seg.Index] = value
+		} else {
+			updated, err := setIn(list[seg.Index], segments[1:], value, path)
+			if err != nil {
+				return container, err
+			}
+			list[seg.Index] = updated
+		}
+		return Value{Type: TypeList, Value: list}, nil
+	}
+
+	dict, ok := container.Value.(map[string]Value)
+	if !ok {
+		return container, fmt.Errorf("set %s: %s is not inside a dict", path, seg)
+	}
+	if len(segments) == 1 {
+		dict[seg.Name] = value
+		return container, nil
+	}
+
+	next, ok := dict[seg.Name]
+	if !ok {
+		if segments[1].IsIndex {
+			next = Value{Type: TypeList, Value: []Value{}}
+		} else {
+			next = Value{Type: TypeDict, Value: make(map[string]Value)}
+		}
+	}
+	updated, err := setIn(next, segments[1:], value, path)
+	if err != nil {
+		return container, err
+	}
+	dict[seg.Name] = updated
+	return container, nil
+}
+
+func deleteIn(container Value, segments []PathSegment) (Value, bool) {
+	seg := segments[0]
+	if seg.IsIndex {
+		list, ok := container.Value.([]Value)
+		if !ok || seg.Index < 0 || seg.Index >= len(list) {
+			return container, false
+		}
+		if len(segments) == 1 {
+			list = append(list[:seg.Index:seg.Index], list[seg.Index+1:]...)
+			return Value{Type: TypeList, Value: list}, true
+		}
+		updated, ok := deleteIn(list[seg.Index], segments[1:])
+		list[seg.Index] = updated
+		return container, ok
+	}
+
+	dict, ok := container.Value.(map[string]Value)
+	if !ok {
+		return container, false
+	}
+	next, ok := dict[seg.Name]
+	if !ok {
+		return container, false
+	}
+	if len(segments) == 1 {
+		delete(dict, seg.Name)
+		return container, true
+	}
+	updated, ok := deleteIn(next, segments[1:])
+	dict[seg.Name] = updated
+	return container, ok
+}
+
+// Lookup 按路径查找字段定义，下标段对应列表的 Elem
+func (s *Schema) Lookup(path string) (Field, bool) {
+	segments, err := ParsePath(path)
+	if err != nil {
+		return Field{}, false
+	}
+
+	fields := s.Fields
+	var current Field
+	for i, seg := range segments {
+		if seg.IsIndex {
+			if current.Elem == nil {
+				return Field{}, false
+			}
+			current = *current.Elem
+		} else {
+			if i > 0 && current.Type != TypeDict {
+				return Field{}, false
+			}
+			f, ok := findField(fields, seg.Name)
+			if !ok {
+				return Field{}, false
+			}
+			current = f
+		}
+		fields = current.Fields
+	}
+	return current, true
+}
+
+// SetField 按路径添加或替换字段定义，自动创建中间的 dict 字段
+func (s *Schema) SetField(path string, field Field) error {
+	segments, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	field.Name = segments[len(segments)-1].Name
+	return setField(&s.Fields, segments, field, path)
+}
+
+func setField(fields *[]Field, segments []PathSegment, field Field, path string) error {
+	seg := segments[0]
+	i := -1
+	for j := range *fields {
+		if (*fields)[j].Name == seg.Name {
+			i = j
+			break
+		}
+	}
+	if len(segments) == 1 {
+		if i < 0 {
+			*fields = append(*fields, field)
+		} else {
+			(*fields)[i] = field
+		}
+		return nil
+	}
+
+	if i < 0 {
+		*fields = append(*fields, Field{Name: seg.Name, Type: TypeDict})
+		i = len(*fields) - 1
+	}
+	parent := &(*fields)[i]
+	rest := segments[1:]
+	for len(rest) > 0 && rest[0].IsIndex {
+		if parent.Type != TypeList {
+			parent.Type = TypeList
+		}
+		if parent.Elem == nil {
+			parent.Elem = &Field{Type: TypeDict}
+		}
+		parent = parent.Elem
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		name := parent.Name
+		*parent = field
+		parent.Name = name
+		return nil
+	}
+	if parent.Type != TypeDict {
+		return fmt.Errorf("set field %s: %s is %s, not dict", path, parent.Name, parent.Type)
+	}
+	return setField(&parent.Fields, rest, field, path)
+}
+
+func findField(fields []Field, name string) (Field, bool) {
+	for _, f := range fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
@@ -0,0 +1,104 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func nestedRecord() *Record {
+	return &Record{Data: map[string]Value{
+		"user": {Type: TypeDict, Value: map[string]Value{
+			"address": {Type: TypeDict, Value: map[string]Value{
+				"city": {Type: TypeString, Value: "Berlin"},
+			}},
+		}},
+		"items": {Type: TypeList, Value: []Value{
+			{Type: TypeDict, Value: map[string]Value{"sku": {Type: TypeString, Value: "A1"}}},
+			{Type: TypeDict, Value: map[string]Value{"sku": {Type: TypeString, Value: "B2"}}},
+		}},
+	}}
+}
+
+func TestParsePath(t *testing.T) {
+	segments, err := ParsePath("items[0].sku")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 || segments[0].Name != "items" || !segments[1].IsIndex || segments[2].Name != "sku" {
+		t.Errorf("unexpected segments %v", segments)
+	}
+
+	for _, bad := range []string{"", "a..b", "a.", "[0]", "a[x]", "a[1", "a[0]b"} {
+		if _, err := ParsePath(bad); err == nil {
+			t.Errorf("%q: want parse error", bad)
+		}
+	}
+}
+
+func TestRecord_PathAccess(t *testing.T) {
+	r := nestedRecord()
+
+	if v, ok := r.GetValue("user.address.city"); !ok || v.Value != "Berlin" {
+		t.Errorf("want Berlin, got %v, %v", v, ok)
+	}
+	if v, ok := r.GetValue("items[1].sku"); !ok || v.Value != "B2" {
+		t.Errorf("want B2, got %v, %v", v, ok)
+	}
+	if _, ok := r.GetValue("items[2].sku"); ok {
+		t.Error("want out of range index missing")
+	}
+
+	if err := r.SetValue("user.address.zip", Value{Type: TypeString, Value: "10115"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetValue("items[2].sku", Value{Type: TypeString, Value: "C3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetValue("meta.tags[0]", Value{Type: TypeString, Value: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]any{"user.address.zip": "10115", "items[2].sku": "C3", "meta.tags[0]": "new"} {
+		if v, ok := r.GetValue(path); !ok || v.Value != want {
+			t.Errorf("%s: want %v, got %v", path, want, v.Value)
+		}
+	}
+	if err := r.SetValue("items[9].sku", Value{Type: TypeString, Value: "X"}); err == nil {
+		t.Error("want error setting past the end of a list")
+	}
+
+	if !r.DeleteValue("items[0]") {
+		t.Fatal("want items[0] deleted")
+	}
+	if v, _ := r.GetValue("items[0].sku"); v.Value != "B2" {
+		t.Errorf("want following items shifted, got %v", v.Value)
+	}
+	if !r.DeleteValue("user.address.city") || r.DeleteValue("user.address.city") {
+		t.Error("want city deleted exactly once")
+	}
+}
+
+func TestSchema_ValidateNested(t *testing.T) {
+	schema := &Schema{}
+	schema.SetField("user.address.city", Field{Type: TypeString, Required: true})
+	schema.SetField("items[].sku", Field{Type: TypeString, Required: true})
+	schema.Fields[0].Required = true
+
+	if f, ok := schema.Lookup("items[0].sku"); !ok || f.Type != TypeString {
+		t.Errorf("want sku field, got %+v", f)
+	}
+	if err := schema.Validate(nestedRecord()); err != nil {
+		t.Errorf("want valid, got %v", err)
+	}
+
+	r := nestedRecord()
+	r.DeleteValue("user.address.city")
+	if err := schema.Validate(r); err == nil || !strings.Contains(err.Error(), "user.address.city") {
+		t.Errorf("want error with full path, got %v", err)
+	}
+
+	r = nestedRecord()
+	r.SetValue("items[1].sku", Value{Type: TypeInt64, Value: int64(1)})
+	if err := schema.Validate(r); err == nil || !strings.Contains(err.Error(), "items[1].sku") {
+		t.Errorf("want error with full path, got %v", err)
+	}
+}
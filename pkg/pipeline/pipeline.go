@@ -2,12 +2,14 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
 	"time"
 
 	"github.com/ipush/littlepipe/pkg/observability"
+	"go.uber.org/zap"
 )
 
 type Config struct {
@@ -15,6 +17,14 @@ type Config struct {
 	Concurrency int
 	RetryCount  int
 	RetryDelay  time.Duration
+	// ErrorPolicy decides per ErrorKind whether source, stage and sink errors
+	// fail the pipeline, are retried or skip the message, nil uses DefaultErrorPolicy
+	ErrorPolicy ErrorPolicy
+	// Priority enables priority lanes between stages, nil keeps plain FIFO
+	Priority *PriorityConfig
+	// ExactlyOnce checkpoints the source together with sink transactions,
+	// nil keeps at-least-once delivery
+	ExactlyOnce *ExactlyOnceConfig
 
 	Logger  observability.Logger
 	Tracer  *observability.Tracer
@@ -56,27 +66,47 @@ func (p *LittlePipe) SetSink(sink Sink) *LittlePipe {
 	return p
 }
 
+// Stop 取消正在运行的 pipeline，sink 写完当前的消息后被关闭，Run 随后返回 context.Canceled
+func (p *LittlePipe) Stop() {
+	p.cancel()
+}
+
 func (p *LittlePipe) Run() error {
 	if p.source == nil || p.sink == nil {
 		return fmt.Errorf("source and sink are required")
 	}
 
-	channels := make([]chan *Message, len(p.stages)+1)
-	for i := range channels {
-		channels[i] = make(chan *Message, p.config.BufferSize)
+	if p.config.Priority != nil && p.config.Priority.Levels < 1 {
+		return fmt.Errorf("priority levels must be positive")
+	}
+
+	queues := make([]queue, len(p.stages)+1)
+	for i := range queues {
+		queues[i] = p.newQueue()
+	}
+
+	var committer *committer
+	if p.config.ExactlyOnce != nil {
+		var err error
+		committer, err = newCommitter(*p.config.ExactlyOnce, p.source, p.stages, p.sink, p.config.Priority)
+		if err != nil {
+			return err
+		}
 	}
 
 	var wg sync.WaitGroup
 	errChan := make(chan error, len(p.stages)+2)
+	sinkDone := make(chan struct{})
 
 	// start Source
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		defer close(channels[0])
+		defer queues[0].close()
 
+		var read uint64
 		for {
-			data, err := p.source.Read()
+			data, skip, err := p.read()
 			if err != nil {
 				if err == io.EOF {
 					return
@@ -84,9 +114,17 @@ func (p *LittlePipe) Run() error {
 				errChan <- err
 				return
 			}
-			select {
-			case channels[0] <- data:
-			case <-p.ctx.Done():
+			if skip {
+				continue
+			}
+			if committer != nil {
+				read++
+				if err := p.attachPosition(data, read); err != nil {
+					errChan <- err
+					return
+				}
+			}
+			if !queues[0].push(p.ctx, data) {
 				return
 			}
 		}
@@ -97,19 +135,58 @@ func (p *LittlePipe) Run() error {
 		wg.Add(1)
 		go func(index int, stage Stage) {
 			defer wg.Done()
-			defer close(channels[index+1])
+			defer queues[index+1].close()
 
-			for data := range channels[index] {
-				result, err := stage.Process(data)
+			ticker, _ := stage.(Ticker)
+			var interval time.Duration
+			if ticker != nil {
+				interval = ticker.TickInterval()
+			}
+			for {
+				data, ok, tick := p.popOrTick(queues[index], interval)
+				if tick {
+					results, err := ticker.Tick()
+					if err = classify(stage, err); err != nil {
+						errChan <- fmt.Errorf("stage %d: tick: %w", index, err)
+						return
+					}
+					for _, result := range results {
+						queues[index+1].push(p.ctx, result)
+					}
+					continue
+				}
+				if !ok {
+					break
+				}
+				var results []*Message
+				skip, err := p.withPolicy(stage, data, func() (err error) {
+					results, err = processStage(stage, data)
+					return err
+				})
 				if err != nil {
 					errChan <- fmt.Errorf("stage %d: %w", index, err)
 					return
 				}
-				select {
-				case channels[index+1] <- result:
-				case <-p.ctx.Done():
+				if skip {
+					continue
+				}
+				for _, result := range results {
+					queues[index+1].push(p.ctx, result)
 				}
 			}
+
+			flusher, ok := stage.(Flusher)
+			if !ok || p.ctx.Err() != nil {
+				return
+			}
+			results, err := flusher.Flush()
+			if err = classify(stage, err); err != nil {
+				errChan <- fmt.Errorf("stage %d: flush: %w", index, err)
+				return
+			}
+			for _, result := range results {
+				queues[index+1].push(p.ctx, result)
+			}
 		}(i, stage)
 	}
 
@@ -117,8 +194,22 @@ func (p *LittlePipe) Run() error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for data := range channels[len(channels)-1] {
-			if err := p.sink.Write(data); err != nil {
+		defer close(sinkDone)
+		if committer != nil {
+			if err := p.runCommitter(committer, queues[len(queues)-1]); err != nil {
+				errChan <- fmt.Errorf("sink: %w", err)
+			}
+			return
+		}
+		for {
+			data, ok := queues[len(queues)-1].pop(p.ctx)
+			if !ok {
+				return
+			}
+			_, err := p.withPolicy(p.sink, data, func() error {
+				return p.sink.Write(data)
+			})
+			if err != nil {
 				errChan <- fmt.Errorf("sink: %w", err)
 				return
 			}
@@ -132,23 +223,202 @@ func (p *LittlePipe) Run() error {
 	}()
 
 	select {
-	case err := <-errChan:
-		p.cancel()
-		return err
+	case err, ok := <-errChan:
+		if ok {
+			p.cancel()
+			p.waitSink(committer, sinkDone)
+			return err
+		}
+		// every goroutine has returned without an error, which after Stop
+		// only means the queues were abandoned
+		if p.ctx.Err() != nil {
+			return p.stopped(sinkDone)
+		}
+		return p.closeSink()
 	case <-p.ctx.Done():
-		return p.ctx.Err()
-	case <-waitChanClosed(errChan):
+		return p.stopped(sinkDone)
+	}
+}
+
+// stopped 在 Stop 之后等待 sink 写完正在写的消息再关闭它，已经写入的输出被发布而不是丢弃。
+// exactly-once 模式下未提交的事务此时已被放弃，关闭不会发布它们
+func (p *LittlePipe) stopped(sinkDone chan struct{}) error {
+	<-sinkDone
+	if err := p.closeSink(); err != nil {
+		return errors.Join(p.ctx.Err(), err)
+	}
+	return p.ctx.Err()
+}
+
+// closeSink 关闭实现了 io.Closer 的 sink，让它发布或写完剩余的输出，例如文件的尾部。
+// 出错时不关闭，避免发布不完整的输出
+func (p *LittlePipe) closeSink() error {
+	closer, ok := p.sink.(io.Closer)
+	if !ok {
 		return nil
 	}
+	if err := closer.Close(); err != nil {
+		return fmt.Errorf("sink: close: %w", err)
+	}
+	return nil
 }
 
-func waitChanClosed(ch <-chan error) chan struct{} {
-	done := make(chan struct{})
-	go func() {
-		for range ch {
-			// 消耗所有错误
+// popOrTick 在 interval 内没有取到消息时返回 tick 为 true，interval 不大于 0 时一直等待
+func (p *LittlePipe) popOrTick(q queue, interval time.Duration) (msg *Message, ok, tick bool) {
+	if interval <= 0 {
+		msg, ok = q.pop(p.ctx)
+		return msg, ok, false
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, interval)
+	defer cancel()
+	msg, ok = q.pop(ctx)
+	if !ok && p.ctx.Err() == nil && ctx.Err() != nil {
+		return nil, false, true
+	}
+	return msg, ok, false
+}
+
+// waitSink 在 exactly-once 模式下等待 sink 放弃未提交的事务后再返回，
+// 以免与下一次 Run 的恢复过程交错
+func (p *LittlePipe) waitSink(c *committer, sinkDone chan struct{}) {
+	if c != nil {
+		<-sinkDone
+	}
+}
+
+// read 从 source 读取一条消息，读取错误同样按 ErrorPolicy 处理，例如跳过解码失败的记录。
+// 出错之后重试读到的 io.EOF 不算正常结束，仍返回原来的错误
+func (p *LittlePipe) read() (data *Message, skip bool, err error) {
+	var last error
+	eof := false
+	skip, err = p.withPolicy(p.source, nil, func() error {
+		msg, err := p.source.Read()
+		switch {
+		case err == io.EOF && last != nil:
+			return last
+		case err == io.EOF:
+			eof = true
+			return nil
+		case err != nil:
+			last = err
+			return err
 		}
-		close(done)
-	}()
-	return done
+		data = msg
+		return nil
+	})
+	if err == nil && eof {
+		err = io.EOF
+	}
+	return data, skip, err
+}
+
+// withPolicy 执行 fn 并按 ErrorPolicy 处理分类后的错误，skip 为 true 表示丢弃该消息
+func (p *LittlePipe) withPolicy(classifier any, data *Message, fn func() error) (skip bool, err error) {
+	for attempt := 0; ; attempt++ {
+		err = classify(classifier, fn())
+		if err == nil {
+			return false, nil
+		}
+
+		switch p.config.ErrorPolicy.action(err) {
+		case ActionSkip:
+			p.logError("skipping message", data, err)
+			return true, nil
+		case ActionRetry:
+			if attempt >= p.config.RetryCount {
+				return false, err
+			}
+			p.logError("retrying message", data, err, zap.Int("attempt", attempt+1))
+			timer := time.NewTimer(p.config.RetryDelay)
+			select {
+			case <-timer.C:
+			case <-p.ctx.Done():
+				timer.Stop()
+				return false, err
+			}
+		default:
+			return false, err
+		}
+	}
+}
+
+func (p *LittlePipe) logError(msg string, data *Message, err error, fields ...observability.Field) {
+	if p.config.Logger == nil {
+		return
+	}
+	base := []observability.Field{
+		zap.String("error_kind", string(KindOf(err))),
+		zap.Error(err),
+	}
+	// source errors have no message yet
+	if data != nil {
+		base = append(base, zap.String("message_id", data.ID))
+	}
+	p.config.Logger.Error(msg, append(base, fields...)...)
+}
+
+func processStage(stage Stage, data *Message) ([]*Message, error) {
+	if fanOut, ok := stage.(FanOutStage); ok {
+		return fanOut.ProcessAll(data)
+	}
+	result, err := stage.Process(data)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	return []*Message{result}, nil
+}
+
+// attachPosition 附加读取这条消息之后的源位置，seq 为从 1 开始的读取序号
+func (p *LittlePipe) attachPosition(data *Message, seq uint64) error {
+	pos, err := p.source.(CheckpointSource).Position()
+	if err != nil {
+		return fmt.Errorf("source position: %w", err)
+	}
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]any)
+	}
+	data.Metadata[MetadataPosition] = pos
+	data.Metadata[MetadataSequence] = seq
+	return nil
+}
+
+// runCommitter 只在 pipeline 未被取消时提交。取消之后阶段可能丢弃消息，
+// 此时放弃未提交的事务，下次启动从上一个 checkpoint 重放
+func (p *LittlePipe) runCommitter(c *committer, q queue) error {
+	for {
+		data, ok := q.pop(p.ctx)
+		if !ok {
+			break
+		}
+		_, err := p.withPolicy(p.sink, data, func() error {
+			return c.write(data)
+		})
+		if err != nil {
+			c.abort()
+			return err
+		}
+		if c.due() && p.ctx.Err() == nil {
+			if err := c.commit(); err != nil {
+				c.abort()
+				return err
+			}
+		}
+	}
+
+	if p.ctx.Err() != nil {
+		c.abort()
+		return nil
+	}
+	if err := c.commit(); err != nil {
+		c.abort()
+		return err
+	}
+	return nil
+}
+
+func (p *LittlePipe) newQueue() queue {
+	if p.config.Priority == nil {
+		return newFIFOQueue(p.config.BufferSize)
+	}
+	return newPriorityQueue(*p.config.Priority, p.config.BufferSize)
 }
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// queue 连接相邻阶段的消息队列，close 只能在所有 push 返回之后调用。
+// push 和 pop 在 ctx 取消后返回 false
+type queue interface {
+	push(ctx context.Context, msg *Message) bool
+	pop(ctx context.Context) (*Message, bool)
+	close()
+}
+
+// fifoQueue 默认的先进先出队列
+type fifoQueue chan *Message
+
+func newFIFOQueue(size int) fifoQueue {
+	return make(fifoQueue, size)
+}
+
+func (q fifoQueue) push(ctx context.Context, msg *Message) bool {
+	select {
+	case q <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (q fifoQueue) pop(ctx context.Context) (*Message, bool) {
+	select {
+	case msg, ok := <-q:
+		return msg, ok
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+func (q fifoQueue) close() {
+	close(q)
+}
+
+const defaultMaxSkips = 64
+
+// PriorityConfig 启用阶段之间的优先级通道
+type PriorityConfig struct {
+	// Levels is the number of lanes, priorities are clamped into [0, Levels-1]
+	// and higher lanes are served first
+	Levels int
+	// MetadataKey, when set, takes the priority from Message.Metadata
+	// instead of Message.Priority
+	MetadataKey string
+	// MaxSkips is how many times a non-empty lane may be passed over before
+	// it is served regardless of priority, defaults to 64
+	MaxSkips int
+}
+
+// priorityQueue 每个优先级一个有界通道，pop 优先取高优先级通道，
+// 被跳过过多次的低优先级通道会被强制服务一次以避免饥饿
+type priorityQueue struct {
+	config PriorityConfig
+	lanes  []chan *Message
+	signal chan struct{}
+	done   chan struct{}
+
+	// only touched by the single consumer
+	skipped []int
+}
+
+func newPriorityQueue(config PriorityConfig, size int) *priorityQueue {
+	if config.MaxSkips <= 0 {
+		config.MaxSkips = defaultMaxSkips
+	}
+	q := &priorityQueue{
+		config:  config,
+		lanes:   make([]chan *Message, config.Levels),
+		signal:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		skipped: make([]int, config.Levels),
+	}
+	// tryPop only looks at buffered messages, an unbuffered lane would never be served
+	size = max(size, 1)
+	for i := range q.lanes {
+		q.lanes[i] = make(chan *Message, size)
+	}
+	return q
+}
+
+func (q *priorityQueue) push(ctx context.Context, msg *Message) bool {
+	lane := q.lanes[q.level(msg)]
+	select {
+	case lane <- msg:
+	case <-ctx.Done():
+		return false
+	}
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (q *priorityQueue) pop(ctx context.Context) (*Message, bool) {
+	for {
+		if msg, ok := q.tryPop(); ok {
+			return msg, true
+		}
+		select {
+		case <-q.signal:
+		case <-q.done:
+			// every push has returned before close, so one last scan is enough
+			return q.tryPop()
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+func (q *priorityQueue) tryPop() (*Message, bool) {
+	// a starved lane goes first
+	for i, n := range q.skipped {
+		if n >= q.config.MaxSkips && len(q.lanes[i]) > 0 {
+			q.skipped[i] = 0
+			return <-q.lanes[i], true
+		}
+	}
+
+	for i := len(q.lanes) - 1; i >= 0; i-- {
+		if len(q.lanes[i]) == 0 {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if len(q.lanes[j]) > 0 {
+				q.skipped[j]++
+			}
+		}
+		q.skipped[i] = 0
+		return <-q.lanes[i], true
+	}
+	return nil, false
+}
+
+func (q *priorityQueue) close() {
+	close(q.done)
+}
+
+func (q *priorityQueue) level(msg *Message) int {
+	p := msg.Priority
+	if q.config.MetadataKey != "" {
+		p = 0
+		if v, ok := msg.Metadata[q.config.MetadataKey]; ok {
+			p, _ = parsePriority(v)
+		}
+	}
+	return min(max(p, 0), len(q.lanes)-1)
+}
+
+func parsePriority(v any) (int, error) {
+	switch p := v.(type) {
+	case int:
+		return p, nil
+	case int32:
+		return int(p), nil
+	case int64:
+		return int(p), nil
+	case float64:
+		return int(p), nil
+	case string:
+		return strconv.Atoi(p)
+	default:
+		return 0, fmt.Errorf("unsupported priority type %T", v)
+	}
+}
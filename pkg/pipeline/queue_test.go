@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func pushAll(t *testing.T, q queue, msgs ...*Message) {
+	t.Helper()
+	for _, msg := range msgs {
+		if !q.push(context.Background(), msg) {
+			t.Fatal("push failed")
+		}
+	}
+}
+
+func prioritized(id string, priority int) *Message {
+	msg := NewMessage(nil)
+	msg.ID = id
+	msg.Priority = priority
+	return msg
+}
+
+func popIDs(q queue) []string {
+	var ids []string
+	for {
+		msg, ok := q.pop(context.Background())
+		if !ok {
+			return ids
+		}
+		ids = append(ids, msg.ID)
+	}
+}
+
+func TestPriorityQueue_HigherFirst(t *testing.T) {
+	q := newPriorityQueue(PriorityConfig{Levels: 3}, 10)
+	pushAll(t, q,
+		prioritized("bulk1", 0),
+		prioritized("alert", 2),
+		prioritized("bulk2", 0),
+		prioritized("warn", 1),
+		prioritized("overflow", 9),
+	)
+	q.close()
+
+	want := []string{"alert", "overflow", "warn", "bulk1", "bulk2"}
+	got := popIDs(q)
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func TestPriorityQueue_Starvation(t *testing.T) {
+	q := newPriorityQueue(PriorityConfig{Levels: 2, MaxSkips: 2}, 10)
+	pushAll(t, q,
+		prioritized("low", 0),
+		prioritized("h1", 1),
+		prioritized("h2", 1),
+		prioritized("h3", 1),
+	)
+	q.close()
+
+	got := popIDs(q)
+	want := []string{"h1", "h2", "low", "h3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func TestPriorityQueue_MetadataKey(t *testing.T) {
+	q := newPriorityQueue(PriorityConfig{Levels: 2, MetadataKey: "priority"}, 10)
+	pushAll(t, q,
+		prioritized("ignored", 1),
+		NewMessage(nil).WithMetadata("priority", "1"),
+	)
+	q.close()
+
+	msg, _ := q.pop(context.Background())
+	if msg.Metadata["priority"] != "1" {
+		t.Errorf("want metadata priority message first, got %s", msg.ID)
+	}
+}
+
+type sliceSource struct{ msgs []*Message }
+
+func (s *sliceSource) Read() (*Message, error) {
+	if len(s.msgs) == 0 {
+		return nil, io.EOF
+	}
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return msg, nil
+}
+
+type collectSink struct{ ids []string }
+
+func (s *collectSink) Write(msg *Message) error {
+	s.ids = append(s.ids, msg.ID)
+	return nil
+}
+
+func TestLittlePipe_FIFOByDefault(t *testing.T) {
+	sink := &collectSink{}
+	source := &sliceSource{msgs: []*Message{prioritized("a", 0), prioritized("b", 5), prioritized("c", 1)}}
+	err := NewLittlePipe(Config{BufferSize: 10}).
+		SetSource(source).
+		SetSink(sink).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.ids) != 3 || sink.ids[0] != "a" || sink.ids[1] != "b" || sink.ids[2] != "c" {
+		t.Errorf("want FIFO order, got %v", sink.ids)
+	}
+}
+
+func TestLittlePipe_PriorityZeroBufferSize(t *testing.T) {
+	sink := &collectSink{}
+	source := &sliceSource{msgs: []*Message{prioritized("a", 0), prioritized("b", 1), prioritized("c", 0)}}
+	done := make(chan error, 1)
+	go func() {
+		done <- NewLittlePipe(Config{Priority: &PriorityConfig{Levels: 2}}).
+			SetSource(source).
+			SetSink(sink).
+			Run()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline with priority lanes and BufferSize 0 deadlocked")
+	}
+	if len(sink.ids) != 3 {
+		t.Errorf("got %v", sink.ids)
+	}
+}
@@ -0,0 +1,348 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Clone 深拷贝记录和它的 schema，修改副本不会影响原记录
+func (r *Record) Clone() *Record {
+	if r == nil {
+		return nil
+	}
+	clone := &Record{
+		Schema:    r.Schema.Clone(),
+		Timestamp: r.Timestamp,
+		Version:   r.Version,
+	}
+	if r.Data != nil {
+		clone.Data = cloneDict(r.Data)
+	}
+	return clone
+}
+
+// Clone 深拷贝 dict、list、bytes 和 json；Decimal 和 time 类型不可变，直接共享
+func (v Value) Clone() Value {
+	switch inner := v.Value.(type) {
+	case map[string]Value:
+		return Value{Type: v.Type, Value: cloneDict(inner)}
+	case []Value:
+		list := make([]Value, len(inner))
+		for i, elem := range inner {
+			list[i] = elem.Clone()
+		}
+		return Value{Type: v.Type, Value: list}
+	case []byte:
+		return Value{Type: v.Type, Value: bytes.Clone(inner)}
+	case json.RawMessage:
+		return Value{Type: v.Type, Value: json.RawMessage(bytes.Clone(inner))}
+	}
+	return v
+}
+
+func cloneDict(data map[string]Value) map[string]Value {
+	dict := make(map[string]Value, len(data))
+	for name, value := range data {
+		dict[name] = value.Clone()
+	}
+	return dict
+}
+
+func (s *Schema) Clone() *Schema {
+	if s == nil {
+		return nil
+	}
+	clone := *s
+	clone.Fields = cloneFields(s.Fields)
+	if s.Metadata != nil {
+		clone.Metadata = make(map[string]string, len(s.Metadata))
+		for k, v := range s.Metadata {
+			clone.Metadata[k] = v
+		}
+	}
+	return &clone
+}
+
+func cloneFields(fields []Field) []Field {
+	if fields == nil {
+		return nil
+	}
+	clone := make([]Field, len(fields))
+	for i, f := range fields {
+		clone[i] = f.Clone()
+	}
+	return clone
+}
+
+// Clone 深拷贝嵌套的 Fields、Elem 和约束，Default 和枚举值按原样共享
+func (f Field) Clone() Field {
+	f.Fields = cloneFields(f.Fields)
+	if f.Elem != nil {
+		elem := f.Elem.Clone()
+		f.Elem = &elem
+	}
+	if f.Scale != nil {
+		scale := *f.Scale
+		f.Scale = &scale
+	}
+	if f.Constraints != nil {
+		c := *f.Constraints
+		f.Constraints = &c
+	}
+	return f
+}
+
+// Equal 比较两个值的类型和内容，Decimal 按数值比较，timestamp 按时刻比较
+func (v Value) Equal(other Value) bool {
+	if v.IsNull() || other.IsNull() {
+		return v.IsNull() && other.IsNull()
+	}
+	if v.Type != other.Type {
+		return false
+	}
+	switch a := v.Value.(type) {
+	case map[string]Value:
+		b, ok := other.Value.(map[string]Value)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for name, value := range a {
+			if o, ok := b[name]; !ok || !value.Equal(o) {
+				return false
+			}
+		}
+		return true
+	case []Value:
+		b, ok := other.Value.([]Value)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !a[i].Equal(b[i]) {
+				return false
+			}
+		}
+		return true
+	case Decimal:
+		b, ok := other.Value.(Decimal)
+		return ok && a.Cmp(b) == 0
+	case time.Time:
+		b, ok := other.Value.(time.Time)
+		return ok && a.Equal(b)
+	case []byte:
+		b, ok := other.Value.([]byte)
+		return ok && bytes.Equal(a, b)
+	case json.RawMessage:
+		b, ok := other.Value.(json.RawMessage)
+		return ok && bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(v.Value, other.Value)
+}
+
+// ChangeKind 记录 Diff 和 schema.Diff 共用的变化类型
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeChanged ChangeKind = "changed"
+)
+
+// Change 一处字段变化，Added 时 Old 为空值，Removed 时 New 为空值
+type Change struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	Old  Value      `json:"old"`
+	New  Value      `json:"new"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s = %v", c.Path, c.New.Value)
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s", c.Path)
+	default:
+		return fmt.Sprintf("~ %s: %v -> %v", c.Path, c.Old.Value, c.New.Value)
+	}
+}
+
+// Diff 列出 r 到 next 的字段变化，按路径排序。两边都是 dict 时逐个成员比较，
+// 都是 list 时按下标比较，其余情况整体比较
+func (r *Record) Diff(next *Record) []Change {
+	var changes []Change
+	changes = diffDict(changes, r.Data, next.Data, "")
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diffDict(changes []Change, old, next map[string]Value, prefix string) []Change {
+	for name, o := range old {
+		path := joinFieldPath(prefix, name)
+		n, ok := next[name]
+		if !ok {
+			changes = append(changes, Change{Path: path, Kind: ChangeRemoved, Old: o, New: Null})
+			continue
+		}
+		changes = diffValue(changes, o, n, path)
+	}
+	for name, n := range next {
+		if _, ok := old[name]; !ok {
+			changes = append(changes, Change{Path: joinFieldPath(prefix, name), Kind: ChangeAdded, Old: Null, New: n})
+		}
+	}
+	return changes
+}
+
+func diffValue(changes []Change, old, next Value, path string) []Change {
+	if old.Type == next.Type {
+		switch o := old.Value.(type) {
+		case map[string]Value:
+			if n, ok := next.Value.(map[string]Value); ok {
+				return diffDict(changes, o, n, path)
+			}
+		case []Value:
+			if n, ok := next.Value.([]Value); ok {
+				return diffList(changes, o, n, path)
+			}
+		}
+	}
+	if !old.Equal(next) {
+		changes = append(changes, Change{Path: path, Kind: ChangeChanged, Old: old, New: next})
+	}
+	return changes
+}
+
+func diffList(changes []Change, old, next []Value, path string) []Change {
+	for i := 0; i < max(len(old), len(next)); i++ {
+		elemPath := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case i >= len(next):
+			changes = append(changes, Change{Path: elemPath, Kind: ChangeRemoved, Old: old[i], New: Null})
+		case i >= len(old):
+			changes = append(changes, Change{Path: elemPath, Kind: ChangeAdded, Old: Null, New: next[i]})
+		default:
+			changes = diffValue(changes, old[i], next[i], elemPath)
+		}
+	}
+	return changes
+}
+
+// MergePolicy 决定 Merge 时两边同一路径上的值不同如何处理
+type MergePolicy string
+
+const (
+	// MergeOverwrite 以参数记录的值为准，默认策略
+	MergeOverwrite MergePolicy = "overwrite"
+	// MergeKeep 保留接收者记录的值
+	MergeKeep MergePolicy = "keep"
+	// MergeNewest 以 Timestamp 较新的记录为准，相同时以参数记录为准
+	MergeNewest MergePolicy = "newest"
+	// MergeFail 返回 *MergeConflictError，列出全部冲突路径
+	MergeFail MergePolicy = "fail"
+)
+
+type MergeConflictError struct {
+	Paths []string
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("merge conflict at %s", strings.Join(e.Paths, ", "))
+}
+
+// Merge 返回合并了 other 的新记录，两个输入都不会被修改。dict 逐个成员递归合并，
+// 其余值相同时直接保留，不同时按 policy 处理。schema 取两边字段的并集，
+// Timestamp 和 Version 取较大者
+func (r *Record) Merge(other *Record, policy MergePolicy) (*Record, error) {
+	takeOther := true
+	switch policy {
+	case MergeOverwrite, "":
+	case MergeKeep:
+		takeOther = false
+	case MergeNewest:
+		takeOther = !other.Timestamp.Before(r.Timestamp)
+	case MergeFail:
+	default:
+		return nil, fmt.Errorf("unknown merge policy %q", policy)
+	}
+
+	m := &merger{takeOther: takeOther, fail: policy == MergeFail}
+	merged := r.Clone()
+	if merged.Data == nil {
+		merged.Data = make(map[string]Value)
+	}
+	m.dict(merged.Data, other.Data, "")
+	if len(m.conflicts) > 0 {
+		sort.Strings(m.conflicts)
+		return nil, NewError(KindValidation, &MergeConflictError{Paths: m.conflicts})
+	}
+
+	switch {
+	case merged.Schema == nil:
+		merged.Schema = other.Schema.Clone()
+	case other.Schema != nil:
+		merged.Schema.Fields = mergeFields(merged.Schema.Fields, other.Schema.Fields)
+	}
+	if other.Timestamp.After(merged.Timestamp) {
+		merged.Timestamp = other.Timestamp
+	}
+	merged.Version = max(merged.Version, other.Version)
+	return merged, nil
+}
+
+type merger struct {
+	takeOther bool
+	fail      bool
+	conflicts []string
+}
+
+// dict 把 other 合并进 base，base 已经是副本，可以原地修改
+func (m *merger) dict(base, other map[string]Value, prefix string) {
+	for name, o := range other {
+		path := joinFieldPath(prefix, name)
+		b, ok := base[name]
+		switch {
+		case !ok:
+			base[name] = o.Clone()
+		case b.Equal(o):
+		default:
+			bd, bok := b.Value.(map[string]Value)
+			od, ook := o.Value.(map[string]Value)
+			if bok && ook {
+				m.dict(bd, od, path)
+				continue
+			}
+			if m.fail {
+				m.conflicts = append(m.conflicts, path)
+			} else if m.takeOther {
+				base[name] = o.Clone()
+			}
+		}
+	}
+}
+
+// mergeFields 保留 base 的字段顺序，追加 other 中新增的字段，两边都是 dict 时递归合并
+func mergeFields(base, other []Field) []Field {
+	for _, o := range other {
+		i := -1
+		for j := range base {
+			if base[j].Name == o.Name {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			base = append(base, o.Clone())
+			continue
+		}
+		if base[i].Type == TypeDict && o.Type == TypeDict {
+			base[i].Fields = mergeFields(base[i].Fields, o.Fields)
+		}
+	}
+	return base
+}
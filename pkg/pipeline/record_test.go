@@ -0,0 +1,121 @@
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func customer() *Record {
+	return &Record{
+		Schema: &Schema{Fields: []Field{
+			{Name: "id", Type: TypeInt64},
+			{Name: "address", Type: TypeDict, Fields: []Field{{Name: "city", Type: TypeString}}},
+		}},
+		Timestamp: time.Unix(100, 0),
+		Data: map[string]Value{
+			"id": {Type: TypeInt64, Value: int64(1)},
+			"address": {Type: TypeDict, Value: map[string]Value{
+				"city": {Type: TypeString, Value: "Paris"},
+			}},
+			"tags":    {Type: TypeList, Value: []Value{{Type: TypeString, Value: "a"}}},
+			"balance": {Type: TypeDecimal, Value: MustParseDecimal("10.50")},
+		},
+	}
+}
+
+func TestRecord_Clone(t *testing.T) {
+	r := customer()
+	clone := r.Clone()
+	if err := clone.SetValue("address.city", Value{Type: TypeString, Value: "Rome"}); err != nil {
+		t.Fatal(err)
+	}
+	clone.Data["tags"].Value.([]Value)[0] = Value{Type: TypeString, Value: "b"}
+	clone.Schema.Fields[1].Fields[0].Name = "town"
+
+	if v, _ := r.GetValue("address.city"); v.Value != "Paris" {
+		t.Errorf("want original dict untouched, got %v", v.Value)
+	}
+	if v, _ := r.GetValue("tags[0]"); v.Value != "a" {
+		t.Errorf("want original list untouched, got %v", v.Value)
+	}
+	if r.Schema.Fields[1].Fields[0].Name != "city" {
+		t.Error("want original schema untouched")
+	}
+}
+
+func TestRecord_Diff(t *testing.T) {
+	old := customer()
+	next := old.Clone()
+	next.SetValue("address.city", Value{Type: TypeString, Value: "Rome"})
+	next.SetValue("tags[1]", Value{Type: TypeString, Value: "b"})
+	next.SetValue("balance", Value{Type: TypeDecimal, Value: MustParseDecimal("10.5")})
+	next.SetValue("email", Value{Type: TypeString, Value: "a@example.com"})
+	next.DeleteValue("id")
+
+	var got []string
+	for _, c := range old.Diff(next) {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"~ address.city: Paris -> Rome",
+		"+ email = a@example.com",
+		"- id",
+		"+ tags[1] = b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if changes := old.Diff(old.Clone()); len(changes) != 0 {
+		t.Errorf("want no changes against a clone, got %v", changes)
+	}
+}
+
+func TestRecord_Merge(t *testing.T) {
+	base := customer()
+	other := &Record{
+		Schema:    &Schema{Fields: []Field{{Name: "email", Type: TypeString}}},
+		Timestamp: time.Unix(50, 0),
+		Data: map[string]Value{
+			"email": {Type: TypeString, Value: "a@example.com"},
+			"address": {Type: TypeDict, Value: map[string]Value{
+				"city": {Type: TypeString, Value: "Rome"},
+				"zip":  {Type: TypeString, Value: "00100"},
+			}},
+		},
+	}
+
+	tests := []struct {
+		policy MergePolicy
+		city   string
+	}{
+		{MergeOverwrite, "Rome"},
+		{MergeKeep, "Paris"},
+		{MergeNewest, "Paris"},
+	}
+	for _, tt := range tests {
+		merged, err := base.Merge(other, tt.policy)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.policy, err)
+		}
+		if v, _ := merged.GetValue("address.city"); v.Value != tt.city {
+			t.Errorf("%s: want city %s, got %v", tt.policy, tt.city, v.Value)
+		}
+		if v, _ := merged.GetValue("address.zip"); v.Value != "00100" {
+			t.Errorf("%s: want nested member added, got %v", tt.policy, v.Value)
+		}
+		if _, ok := merged.Schema.Lookup("email"); !ok {
+			t.Errorf("%s: want schema fields merged", tt.policy)
+		}
+	}
+	if v, _ := base.GetValue("address.city"); v.Value != "Paris" {
+		t.Error("want merge to leave its inputs untouched")
+	}
+
+	_, err := base.Merge(other, MergeFail)
+	var conflict *MergeConflictError
+	if !errors.As(err, &conflict) || !reflect.DeepEqual(conflict.Paths, []string{"address.city"}) {
+		t.Errorf("want conflict at address.city, got %v", err)
+	}
+}
@@ -1,9 +1,6 @@
 package pipeline
 
-import (
-	"fmt"
-	"time"
-)
+import "time"
 
 type Record struct {
 	Schema    *Schema
@@ -13,23 +10,74 @@ type Record struct {
 }
 
 type Schema struct {
-	Fields     []Field
-	PrimaryKey string
-	Version    int
-	Metadata   map[string]string
+	Fields     []Field           `json:"fields" yaml:"fields"`
+	PrimaryKey string            `json:"primary_key,omitempty" yaml:"primary_key,omitempty"`
+	Version    int               `json:"version" yaml:"version"`
+	Metadata   map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	// UnknownFields decides how Validate treats fields missing from the schema
+	UnknownFields UnknownFieldPolicy `json:"unknown_fields,omitempty" yaml:"unknown_fields,omitempty"`
 }
 
 type Field struct {
-	Name     string
-	Type     FieldType
-	Required bool
+	Name     string    `json:"name" yaml:"name"`
+	Type     FieldType `json:"type" yaml:"type"`
+	Required bool      `json:"required,omitempty" yaml:"required,omitempty"`
+	// Nullable allows a present field to hold a nil value
+	Nullable bool `json:"nullable,omitempty" yaml:"nullable,omitempty"`
+	// Default fills the field when upcasting records that lack it
+	Default any `json:"default,omitempty" yaml:"default,omitempty"`
+	// Fields describes the known members of a TypeDict field
+	Fields []Field `json:"fields,omitempty" yaml:"fields,omitempty"`
+	// Elem describes the elements of a TypeList field,
+	// or every member of a TypeDict field without Fields
+	Elem *Field `json:"elem,omitempty" yaml:"elem,omitempty"`
+	// Scale is the number of fractional digits kept for TypeDecimal
+	Scale *int `json:"scale,omitempty" yaml:"scale,omitempty"`
+	// Timezone is the IANA location TypeTimestamp values are converted to, UTC when empty
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	// Precision truncates TypeTimestamp and TypeDuration values
+	// and is the unit of their numeric forms
+	Precision   TimePrecision `json:"precision,omitempty" yaml:"precision,omitempty"`
+	Constraints *Constraints  `json:"constraints,omitempty" yaml:"constraints,omitempty"`
+}
+
+// Constraints 字段值的约束，未设置的项不检查
+type Constraints struct {
+	Enum      []any    `json:"enum,omitempty" yaml:"enum,omitempty"`
+	Min       *float64 `json:"min,omitempty" yaml:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty" yaml:"max,omitempty"`
+	MinLength *int     `json:"min_length,omitempty" yaml:"min_length,omitempty"`
+	MaxLength *int     `json:"max_length,omitempty" yaml:"max_length,omitempty"`
+	Pattern   string   `json:"pattern,omitempty" yaml:"pattern,omitempty"`
 }
 
+type UnknownFieldPolicy string
+
+const (
+	// UnknownAllow ignores fields missing from the schema, the default
+	UnknownAllow UnknownFieldPolicy = "allow"
+	// UnknownReject reports fields missing from the schema as violations
+	UnknownReject UnknownFieldPolicy = "reject"
+	// UnknownDrop ignores them in Validate and removes them in Strip
+	UnknownDrop UnknownFieldPolicy = "drop"
+)
+
+// Value 中 TypeDict 的值为 map[string]Value，TypeList 的值为 []Value，
+// TypeDecimal 的值为 Decimal，TypeJSON 的值为 json.RawMessage，
+// TypeTimestamp 的值为 time.Time，TypeDuration 的值为 time.Duration，
+// TypeBytes 的值为 []byte。Value 为 nil 表示空值，无论 Type 是什么
 type Value struct {
 	Type  FieldType
 	Value any
 }
 
+// Null 没有类型信息的空值
+var Null = Value{Type: TypeNull}
+
+func (v Value) IsNull() bool {
+	return v.Value == nil
+}
+
 type FieldType int
 
 const (
@@ -42,24 +90,9 @@ const (
 	TypeDict
 	TypeDecimal
 	TypeJSON
+	TypeTimestamp
+	TypeDuration
+	TypeBytes
+	// TypeNull 字段只能为空值，通常出现在推断结果或外部 schema 中
+	TypeNull
 )
-
-func (r *Record) GetValue(name string) (Value, bool) {
-	value, ok := r.Data[name]
-	return value, ok
-}
-
-func (s *Schema) Validate(record *Record) error {
-	for _, field := range s.Fields {
-		value, ok := record.Data[field.Name]
-		if !ok && field.Required {
-			return fmt.Errorf("field %s is required but not present", field.Name)
-		}
-
-		if ok && value.Type != field.Type {
-			return fmt.Errorf("field %s type mismatch, want %s, get %s", field.Name,
-				field.Type, value.Type)
-		}
-	}
-	return nil
-}
@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimePrecision 时间戳和时长保留的精度，同时是数值形式的时间的单位
+type TimePrecision string
+
+const (
+	PrecisionSecond TimePrecision = "s"
+	PrecisionMilli  TimePrecision = "ms"
+	PrecisionMicro  TimePrecision = "us"
+	PrecisionNano   TimePrecision = "ns"
+)
+
+// Unit 返回精度对应的时长，未设置时为纳秒
+func (p TimePrecision) Unit() (time.Duration, error) {
+	switch p {
+	case "", PrecisionNano:
+		return time.Nanosecond, nil
+	case PrecisionMicro:
+		return time.Microsecond, nil
+	case PrecisionMilli:
+		return time.Millisecond, nil
+	case PrecisionSecond:
+		return time.Second, nil
+	default:
+		return 0, fmt.Errorf("unknown time precision %q, valid precisions: s, ms, us, ns", string(p))
+	}
+}
+
+// timestampLayouts 依次尝试的字符串格式，不带时区的按字段时区解释
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+var locations sync.Map
+
+// Location 返回字段的 Timezone，未设置时为 UTC
+func (f Field) Location() (*time.Location, error) {
+	if f.Timezone == "" || strings.EqualFold(f.Timezone, "UTC") {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(f.Timezone); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", f.Timezone, err)
+	}
+	locations.Store(f.Timezone, loc)
+	return loc, nil
+}
+
+// toTimestamp 数值按 Unix 纪元计数，单位为字段的 Precision，未设置时为秒。
+// 结果转换到字段时区并截断到该精度
+func toTimestamp(v any, field Field) (time.Time, error) {
+	loc, err := field.Location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	unit, err := field.Precision.Unit()
+	if err != nil {
+		return time.Time{}, err
+	}
+	epochUnit := unit
+	if field.Precision == "" {
+		epochUnit = time.Second
+	}
+
+	var t time.Time
+	switch ts := v.(type) {
+	case time.Time:
+		t = ts
+	case string:
+		t, err = parseTimestamp(ts, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+	default:
+		n, err := toUnits(v, epochUnit)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot convert %T to timestamp: %w", v, err)
+		}
+		t = time.Unix(0, n)
+	}
+	return t.In(loc).Truncate(unit), nil
+}
+
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as timestamp", s)
+}
+
+// toDuration 字符串按 time.ParseDuration 解析，数值的单位为字段的 Precision，
+// 未设置时为纳秒，与 time.Duration 一致
+func toDuration(v any, field Field) (time.Duration, error) {
+	unit, err := field.Precision.Unit()
+	if err != nil {
+		return 0, err
+	}
+
+	var d time.Duration
+	switch dur := v.(type) {
+	case time.Duration:
+		d = dur
+	case string:
+		d, err = time.ParseDuration(strings.TrimSpace(dur))
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse %q as duration", dur)
+		}
+	default:
+		n, err := toUnits(v, unit)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert %T to duration: %w", v, err)
+		}
+		d = time.Duration(n)
+	}
+	return d.Truncate(unit), nil
+}
+
+// toUnits 将以 unit 为单位的数值换算为纳秒，超出 int64 纳秒的范围时返回错误
+func toUnits(v any, unit time.Duration) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return mulUnits(int64(n), unit)
+	case int64:
+		return mulUnits(n, unit)
+	case float64:
+		if math.IsNaN(n) || math.IsInf(n, 0) {
+			return 0, fmt.Errorf("invalid number %v", n)
+		}
+		f := math.Round(n * float64(unit))
+		// float64(math.MaxInt64) rounds up to 2^63, which no longer fits
+		if f >= math.MaxInt64 || f < math.MinInt64 {
+			return 0, fmt.Errorf("%v x %s overflows int64 nanoseconds", n, unit)
+		}
+		return int64(f), nil
+	case Decimal:
+		return toUnits(n.Float64(), unit)
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return mulUnits(i, unit)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return 0, err
+		}
+		return toUnits(f, unit)
+	}
+	return 0, fmt.Errorf("cannot convert %T to a number", v)
+}
+
+func mulUnits(n int64, unit time.Duration) (int64, error) {
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, fmt.Errorf("%d x %s overflows int64 nanoseconds", n, unit)
+	}
+	return n * int64(unit), nil
+}
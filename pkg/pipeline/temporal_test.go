@@ -0,0 +1,157 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFromNative_Timestamp(t *testing.T) {
+	field := Field{Type: TypeTimestamp, Timezone: "Asia/Shanghai", Precision: PrecisionMilli}
+	want := time.Date(2024, 3, 1, 8, 30, 0, 123000000, time.UTC)
+
+	for _, input := range []any{
+		"2024-03-01T16:30:00.123456+08:00",
+		"2024-03-01 16:30:00.123",
+		int64(1709281800123),
+		json.Number("1709281800123"),
+		want.Add(999 * time.Microsecond),
+	} {
+		v, err := FromNative(input, field)
+		if err != nil {
+			t.Fatalf("%v: %v", input, err)
+		}
+		ts := v.Value.(time.Time)
+		if v.Type != TypeTimestamp || !ts.Equal(want) {
+			t.Errorf("%v: want %v, got %v", input, want, ts)
+		}
+		if ts.Location().String() != "Asia/Shanghai" {
+			t.Errorf("%v: want timestamp in field timezone, got %s", input, ts.Location())
+		}
+	}
+
+	// numbers count seconds when no precision is set
+	v, err := FromNative(1709281800, Field{Type: TypeTimestamp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Value.(time.Time); !got.Equal(time.Unix(1709281800, 0)) || got.Location() != time.UTC {
+		t.Errorf("want UTC epoch seconds, got %v", got)
+	}
+
+	// millisecond epochs read as seconds overflow int64 nanoseconds instead of wrapping
+	for _, input := range []any{int64(1709281800123), 1709281800123.0, json.Number("1709281800123"), int64(-1709281800123)} {
+		if v, err := FromNative(input, Field{Type: TypeTimestamp}); err == nil {
+			t.Errorf("%v: want overflow error, got %v", input, v.Value)
+		}
+	}
+	if v, err := FromNative(float64(1<<63), Field{Type: TypeDuration}); err == nil {
+		t.Errorf("want overflow error for 2^63ns, got %v", v.Value)
+	}
+
+	if _, err := FromNative("yesterday", field); err == nil {
+		t.Error("want error for unparsable timestamp")
+	}
+	if _, err := FromNative("2024-03-01", Field{Type: TypeTimestamp, Timezone: "Nowhere/City"}); err == nil {
+		t.Error("want error for unknown timezone")
+	}
+}
+
+func TestFromNative_DurationBytesNull(t *testing.T) {
+	v, err := FromNative("1h30m15.5s", Field{Type: TypeDuration, Precision: PrecisionSecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 90*time.Minute+15*time.Second {
+		t.Errorf("want duration truncated to seconds, got %v", v.Value)
+	}
+	v, err = FromNative(int64(1500), Field{Type: TypeDuration, Precision: PrecisionMilli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 1500*time.Millisecond {
+		t.Errorf("want 1.5s, got %v", v.Value)
+	}
+
+	v, err = FromNative("aGVsbG8=", Field{Type: TypeBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v.Value.([]byte)) != "hello" {
+		t.Errorf("want decoded base64, got %q", v.Value)
+	}
+	if _, err := FromNative(42, Field{Type: TypeBytes}); err == nil {
+		t.Error("want error converting int to bytes")
+	}
+
+	v, err = FromNative(nil, Field{Type: TypeString})
+	if err != nil || !v.IsNull() {
+		t.Errorf("want null for nil input, got %+v, %v", v, err)
+	}
+	if _, err := FromNative("x", Field{Type: TypeNull}); err == nil {
+		t.Error("want error converting value to null type")
+	}
+}
+
+func TestValue_MarshalJSON(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+	data := map[string]Value{
+		"at":      {Type: TypeTimestamp, Value: ts},
+		"elapsed": {Type: TypeDuration, Value: 2 * time.Second},
+		"blob":    {Type: TypeBytes, Value: []byte("hi")},
+		"missing": Null,
+		"tags":    {Type: TypeList, Value: []Value{{Type: TypeString, Value: "a"}, Null}},
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"at":"2024-03-01T08:30:00Z","blob":"aGk=","elapsed":"2s","missing":null,"tags":["a",null]}`
+	if string(out) != want {
+		t.Errorf("want %s, got %s", want, out)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for name, field := range map[string]Field{
+		"at":      {Type: TypeTimestamp},
+		"elapsed": {Type: TypeDuration},
+		"blob":    {Type: TypeBytes},
+	} {
+		v, err := FromNative(decoded[name], field)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(mustJSON(t, v)) != string(mustJSON(t, data[name])) {
+			t.Errorf("%s: want round trip, got %+v", name, v)
+		}
+	}
+}
+
+func TestValidate_Null(t *testing.T) {
+	schema := &Schema{Fields: []Field{
+		{Name: "gone", Type: TypeNull},
+		{Name: "note", Type: TypeString},
+	}}
+	record := &Record{Data: map[string]Value{"gone": Null, "note": Null}}
+	err := schema.ValidateAll(record)
+	if err == nil {
+		t.Fatal("want violation for null in non-nullable field")
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Violations) != 1 || verr.Violations[0].Path != "note" {
+		t.Errorf("want only note reported, got %v", err)
+	}
+}
+
+func mustJSON(t *testing.T, v Value) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
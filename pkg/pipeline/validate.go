@@ -0,0 +1,275 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Violation 一条校验失败，Path 为完整的字段路径
+type Violation struct {
+	Path    string `json:"path"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError 汇总全部 Violation，经 NewError 包装为 KindValidation
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Violations) == 1 {
+		return e.Violations[0].Message
+	}
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return fmt.Sprintf("%d violations: %s", len(e.Violations), strings.Join(messages, "; "))
+}
+
+// Validate 在第一处问题处停止
+func (s *Schema) Validate(record *Record) error {
+	return s.validate(record, false)
+}
+
+// ValidateAll 收集全部问题，可用 errors.As 取出 *ValidationError
+func (s *Schema) ValidateAll(record *Record) error {
+	return s.validate(record, true)
+}
+
+func (s *Schema) validate(record *Record, all bool) error {
+	v := &validator{all: all, unknown: s.UnknownFields}
+	v.fields(s.Fields, record.Data, "")
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return NewError(KindValidation, &ValidationError{Violations: v.violations})
+}
+
+// Strip 在 UnknownDrop 策略下删除 schema 中不存在的字段
+func (s *Schema) Strip(record *Record) {
+	if s.UnknownFields == UnknownDrop {
+		stripFields(s.Fields, record.Data)
+	}
+}
+
+func stripFields(fields []Field, data map[string]Value) {
+	known := make(map[string]Field, len(fields))
+	for _, f := range fields {
+		known[f.Name] = f
+	}
+	for name, value := range data {
+		f, ok := known[name]
+		if !ok {
+			delete(data, name)
+			continue
+		}
+		if members, ok := value.Value.(map[string]Value); ok && len(f.Fields) > 0 {
+			stripFields(f.Fields, members)
+		}
+	}
+}
+
+type validator struct {
+	all        bool
+	unknown    UnknownFieldPolicy
+	violations []Violation
+}
+
+// report 返回 false 表示应停止校验
+func (v *validator) report(path, rule, format string, args ...any) bool {
+	v.violations = append(v.violations, Violation{
+		Path:    path,
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+	})
+	return v.all
+}
+
+func (v *validator) fields(fields []Field, data map[string]Value, prefix string) bool {
+	for _, field := range fields {
+		path := joinFieldPath(prefix, field.Name)
+		value, ok := data[field.Name]
+		if !ok {
+			if field.Required && !v.report(path, "required", "field %s is required but not present", path) {
+				return false
+			}
+			continue
+		}
+		if !v.value(field, value, path) {
+			return false
+		}
+	}
+
+	if v.unknown == UnknownReject {
+		known := make(map[string]bool, len(fields))
+		for _, f := range fields {
+			known[f.Name] = true
+		}
+		var names []string
+		for name := range data {
+			if !known[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := joinFieldPath(prefix, name)
+			if !v.report(path, "unknown", "field %s is not defined in the schema", path) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (v *validator) value(field Field, value Value, path string) bool {
+	if value.Value == nil {
+		if !field.Nullable && field.Type != TypeNull {
+			return v.report(path, "nullable", "field %s is null but not nullable", path)
+		}
+		return true
+	}
+	if value.Type != field.Type {
+		return v.report(path, "type", "field %s type mismatch, want %s, get %s", path, field.Type, value.Type)
+	}
+	if field.Constraints != nil && !v.constraints(field.Constraints, value, path) {
+		return false
+	}
+
+	switch field.Type {
+	case TypeDict:
+		if len(field.Fields) == 0 && field.Elem == nil {
+			return true
+		}
+		members, ok := value.Value.(map[string]Value)
+		if !ok {
+			return v.report(path, "type", "field %s holds %T, want map[string]Value", path, value.Value)
+		}
+		if len(field.Fields) > 0 {
+			return v.fields(field.Fields, members, path)
+		}
+		names := make([]string, 0, len(members))
+		for name := range members {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !v.value(*field.Elem, members[name], joinFieldPath(path, name)) {
+				return false
+			}
+		}
+	case TypeList:
+		if field.Elem == nil {
+			return true
+		}
+		elems, ok := value.Value.([]Value)
+		if !ok {
+			return v.report(path, "type", "field %s holds %T, want []Value", path, value.Value)
+		}
+		for i, elem := range elems {
+			if !v.value(*field.Elem, elem, fmt.Sprintf("%s[%d]", path, i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (v *validator) constraints(c *Constraints, value Value, path string) bool {
+	if len(c.Enum) > 0 && !inEnum(c.Enum, value.Value) {
+		if !v.report(path, "enum", "field %s value %v is not one of %v", path, value.Value, c.Enum) {
+			return false
+		}
+	}
+
+	if n, ok := toFloat(value.Value); ok {
+		if c.Min != nil && n < *c.Min && !v.report(path, "min", "field %s value %v is less than %v", path, value.Value, *c.Min) {
+			return false
+		}
+		if c.Max != nil && n > *c.Max && !v.report(path, "max", "field %s value %v is greater than %v", path, value.Value, *c.Max) {
+			return false
+		}
+	}
+
+	if str, ok := value.Value.(string); ok {
+		length := utf8.RuneCountInString(str)
+		if c.MinLength != nil && length < *c.MinLength &&
+			!v.report(path, "min_length", "field %s length %d is less than %d", path, length, *c.MinLength) {
+			return false
+		}
+		if c.MaxLength != nil && length > *c.MaxLength &&
+			!v.report(path, "max_length", "field %s length %d is greater than %d", path, length, *c.MaxLength) {
+			return false
+		}
+		if c.Pattern != "" {
+			re, err := compilePattern(c.Pattern)
+			if err != nil {
+				return v.report(path, "pattern", "field %s has invalid pattern: %v", path, err)
+			}
+			if !re.MatchString(str) && !v.report(path, "pattern", "field %s value %q does not match %s", path, str, c.Pattern) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// inEnum 数值按大小比较，因此从 JSON 读入的 float64 枚举值可以匹配 int64 字段
+func inEnum(enum []any, value any) bool {
+	n, numeric := toFloat(value)
+	for _, e := range enum {
+		if numeric {
+			if m, ok := toFloat(e); ok && m == n {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(e, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, !math.IsNaN(n)
+	case Decimal:
+		return n.Float64(), true
+	}
+	return 0, false
+}
+
+var patterns sync.Map
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+func joinFieldPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,468 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// avroDecimalPrecision 导出 decimal 时使用的精度，pipeline.Field 不记录总位数
+const avroDecimalPrecision = 38
+
+var (
+	avroName        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	avroInvalidName = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+// FromAvro 将 Avro record schema (.avsc) 转换为 pipeline.Schema。
+// int/long 映射为 int64，float/double 映射为 float64，bytes/fixed 映射为 bytes，
+// decimal、timestamp-*、time-* 逻辑类型映射为 decimal、timestamp、duration，
+// enum 映射为带枚举约束的 string，map 映射为带 Elem 的 dict，
+// ["null", T] 映射为 Nullable 的 T，其他 union 映射为 json。
+// 没有 default 的字段为 Required；record 的 name、namespace、doc 和字段 doc 保存在 Metadata 中
+func FromAvro(data []byte) (*pipeline.Schema, error) {
+	var root any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("decode Avro schema: %w", err)
+	}
+	record, ok := root.(map[string]any)
+	if !ok || record["type"] != "record" {
+		return nil, fmt.Errorf("Avro schema root must be a record")
+	}
+
+	c := &avroConverter{
+		named:     make(map[string]any),
+		metadata:  make(map[string]string),
+		resolving: make(map[string]bool),
+	}
+	namespace, _ := record["namespace"].(string)
+	name, _ := record["name"].(string)
+	c.resolving[c.register(record, namespace)] = true
+
+	fields, err := c.fields(record, namespace, "")
+	if err != nil {
+		return nil, err
+	}
+	setDoc(c.metadata, MetadataName, name)
+	setDoc(c.metadata, MetadataNamespace, namespace)
+	if doc, _ := record["doc"].(string); doc != "" {
+		c.metadata[MetadataDoc] = doc
+	}
+	s := &pipeline.Schema{Fields: fields, Version: 1}
+	if len(c.metadata) > 0 {
+		s.Metadata = c.metadata
+	}
+	return s, nil
+}
+
+type avroConverter struct {
+	// named 按全名记录已定义的 record、enum、fixed，供后续引用
+	named     map[string]any
+	metadata  map[string]string
+	resolving map[string]bool
+}
+
+func fullName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func (c *avroConverter) register(def map[string]any, namespace string) string {
+	name, _ := def["name"].(string)
+	if ns, ok := def["namespace"].(string); ok {
+		namespace = ns
+	}
+	full := fullName(name, namespace)
+	c.named[full] = def
+	return full
+}
+
+func (c *avroConverter) fields(record map[string]any, namespace, prefix string) ([]pipeline.Field, error) {
+	if ns, ok := record["namespace"].(string); ok {
+		namespace = ns
+	}
+	raw, ok := record["fields"].([]any)
+	if !ok {
+		return nil, fmt.Errorf("record %v has no fields", record["name"])
+	}
+
+	fields := make([]pipeline.Field, 0, len(raw))
+	for _, item := range raw {
+		def, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("record %v: field must be an object", record["name"])
+		}
+		name, _ := def["name"].(string)
+		path := joinPath(prefix, name)
+		field, err := c.field(def["type"], namespace, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		field.Name = name
+		if value, ok := def["default"]; ok {
+			field.Default = value
+		} else {
+			field.Required = true
+		}
+		if doc, _ := def["doc"].(string); doc != "" {
+			c.metadata[DocKey(path)] = doc
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func (c *avroConverter) field(t any, namespace, path string) (pipeline.Field, error) {
+	switch t := t.(type) {
+	case string:
+		if field, ok := avroPrimitive(t); ok {
+			return field, nil
+		}
+		full := fullName(t, namespace)
+		def, ok := c.named[full]
+		if !ok {
+			if def, ok = c.named[t]; !ok {
+				return pipeline.Field{}, fmt.Errorf("unknown Avro type %q", t)
+			}
+			full = t
+		}
+		// a record that refers to itself cannot be flattened into fields
+		if c.resolving[full] {
+			return pipeline.Field{Type: pipeline.TypeJSON}, nil
+		}
+		c.resolving[full] = true
+		defer delete(c.resolving, full)
+		return c.complex(def.(map[string]any), namespace, path, false)
+	case []any:
+		return c.union(t, namespace, path)
+	case map[string]any:
+		return c.complex(t, namespace, path, true)
+	default:
+		return pipeline.Field{}, fmt.Errorf("invalid Avro type %v", t)
+	}
+}
+
+func avroPrimitive(name string) (pipeline.Field, bool) {
+	switch name {
+	case "null":
+		return pipeline.Field{Type: pipeline.TypeNull}, true
+	case "boolean":
+		return pipeline.Field{Type: pipeline.TypeBoolean}, true
+	case "int", "long":
+		return pipeline.Field{Type: pipeline.TypeInt64}, true
+	case "float", "double":
+		return pipeline.Field{Type: pipeline.TypeFloat64}, true
+	case "bytes":
+		return pipeline.Field{Type: pipeline.TypeBytes}, true
+	case "string":
+		return pipeline.Field{Type: pipeline.TypeString}, true
+	}
+	return pipeline.Field{}, false
+}
+
+func (c *avroConverter) union(branches []any, namespace, path string) (pipeline.Field, error) {
+	var typed []any
+	nullable := false
+	for _, b := range branches {
+		if b == "null" {
+			nullable = true
+			continue
+		}
+		typed = append(typed, b)
+	}
+	if len(typed) != 1 {
+		for _, b := range typed {
+			// named types must still be registered for later references
+			if def, ok := b.(map[string]any); ok {
+				if _, err := c.complex(def, namespace, path, true); err != nil {
+					return pipeline.Field{}, err
+				}
+			}
+		}
+		return pipeline.Field{Type: pipeline.TypeJSON, Nullable: nullable}, nil
+	}
+	field, err := c.field(typed[0], namespace, path)
+	if err != nil {
+		return pipeline.Field{}, err
+	}
+	field.Nullable = field.Nullable || nullable
+	return field, nil
+}
+
+// complex 处理对象形式的类型，define 为 true 表示这是命名类型的定义而不是引用
+func (c *avroConverter) complex(def map[string]any, namespace, path string, define bool) (pipeline.Field, error) {
+	if logical, _ := def["logicalType"].(string); logical != "" {
+		if field, ok := avroLogical(logical, def); ok {
+			if define && def["type"] == "fixed" {
+				c.register(def, namespace)
+			}
+			return field, nil
+		}
+	}
+
+	switch def["type"] {
+	case "record", "error":
+		if define {
+			full := c.register(def, namespace)
+			c.resolving[full] = true
+			defer delete(c.resolving, full)
+		}
+		if ns, ok := def["namespace"].(string); ok {
+			namespace = ns
+		}
+		fields, err := c.fields(def, namespace, path)
+		if err != nil {
+			return pipeline.Field{}, err
+		}
+		if doc, _ := def["doc"].(string); doc != "" {
+			if _, ok := c.metadata[DocKey(path)]; !ok {
+				c.metadata[DocKey(path)] = doc
+			}
+		}
+		return pipeline.Field{Type: pipeline.TypeDict, Fields: fields}, nil
+	case "enum":
+		if define {
+			c.register(def, namespace)
+		}
+		symbols, _ := def["symbols"].([]any)
+		return pipeline.Field{
+			Type:        pipeline.TypeString,
+			Constraints: &pipeline.Constraints{Enum: symbols},
+		}, nil
+	case "fixed":
+		if define {
+			c.register(def, namespace)
+		}
+		return pipeline.Field{Type: pipeline.TypeBytes}, nil
+	case "array":
+		elem, err := c.field(def["items"], namespace, path+"[]")
+		if err != nil {
+			return pipeline.Field{}, err
+		}
+		return pipeline.Field{Type: pipeline.TypeList, Elem: &elem}, nil
+	case "map":
+		elem, err := c.field(def["values"], namespace, path+"[]")
+		if err != nil {
+			return pipeline.Field{}, err
+		}
+		return pipeline.Field{Type: pipeline.TypeDict, Elem: &elem}, nil
+	default:
+		// {"type": "long"} and logical types this package does not know
+		return c.field(def["type"], namespace, path)
+	}
+}
+
+func avroLogical(logical string, def map[string]any) (pipeline.Field, bool) {
+	switch logical {
+	case "decimal":
+		field := pipeline.Field{Type: pipeline.TypeDecimal}
+		scale := 0
+		if n, ok := def["scale"].(float64); ok {
+			scale = int(n)
+		}
+		field.Scale = &scale
+		return field, true
+	case "timestamp-millis", "local-timestamp-millis":
+		return pipeline.Field{Type: pipeline.TypeTimestamp, Precision: pipeline.PrecisionMilli}, true
+	case "timestamp-micros", "local-timestamp-micros":
+		return pipeline.Field{Type: pipeline.TypeTimestamp, Precision: pipeline.PrecisionMicro}, true
+	case "timestamp-nanos", "local-timestamp-nanos":
+		return pipeline.Field{Type: pipeline.TypeTimestamp, Precision: pipeline.PrecisionNano}, true
+	case "date":
+		return pipeline.Field{Type: pipeline.TypeTimestamp, Precision: pipeline.PrecisionSecond}, true
+	case "time-millis":
+		return pipeline.Field{Type: pipeline.TypeDuration, Precision: pipeline.PrecisionMilli}, true
+	case "time-micros":
+		return pipeline.Field{Type: pipeline.TypeDuration, Precision: pipeline.PrecisionMicro}, true
+	case "uuid":
+		return pipeline.Field{Type: pipeline.TypeString}, true
+	}
+	return pipeline.Field{}, false
+}
+
+// avroRecord 与 avroField 保持 Avro schema 中键的惯用顺序
+type avroRecord struct {
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace,omitempty"`
+	Doc       string      `json:"doc,omitempty"`
+	Fields    []avroField `json:"fields"`
+}
+
+type avroField struct {
+	Name    string `json:"name"`
+	Type    any    `json:"type"`
+	Doc     string `json:"doc,omitempty"`
+	Default any    `json:"default,omitempty"`
+	// HasNull 为 true 时显式输出 "default": null
+	HasNull bool `json:"-"`
+}
+
+func (f avroField) MarshalJSON() ([]byte, error) {
+	type plain avroField
+	data, err := json.Marshal(plain(f))
+	if err != nil || !f.HasNull || f.Default != nil {
+		return data, err
+	}
+	return append(data[:len(data)-1], []byte(`,"default":null}`)...), nil
+}
+
+// ToAvro 将 pipeline.Schema 导出为 Avro record schema。Nullable 字段导出为
+// ["null", T]（有默认值时为 [T, "null"]），非 Required 且没有默认值时默认值为 null；timestamp 按 Precision
+// 导出为 timestamp-millis/micros/nanos，decimal 导出为 bytes 上的 decimal 逻辑类型，
+// json 导出为 string，dict 有 Fields 时导出为嵌套 record，否则导出为 map
+func ToAvro(s *pipeline.Schema) ([]byte, error) {
+	name := s.Metadata[MetadataName]
+	if name == "" {
+		name = "Record"
+	}
+	e := &avroExporter{metadata: s.Metadata}
+	fields, err := e.fields(s.Fields, "")
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(avroRecord{
+		Type:      "record",
+		Name:      name,
+		Namespace: s.Metadata[MetadataNamespace],
+		Doc:       s.Metadata[MetadataDoc],
+		Fields:    fields,
+	}, "", "  ")
+}
+
+type avroExporter struct {
+	metadata map[string]string
+}
+
+func (e *avroExporter) fields(fields []pipeline.Field, prefix string) ([]avroField, error) {
+	out := make([]avroField, 0, len(fields))
+	for _, f := range fields {
+		path := joinPath(prefix, f.Name)
+		t, err := e.nullable(f, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		out = append(out, avroField{
+			Name:    f.Name,
+			Type:    t,
+			Doc:     e.metadata[DocKey(path)],
+			Default: f.Default,
+			HasNull: f.Nullable && !f.Required,
+		})
+	}
+	return out, nil
+}
+
+func (e *avroExporter) nullable(f pipeline.Field, path string) (any, error) {
+	t, err := e.typ(f, path)
+	if err != nil || !f.Nullable || f.Type == pipeline.TypeNull {
+		return t, err
+	}
+	// the default value must match the first branch of a union
+	if f.Default != nil {
+		return []any{t, "null"}, nil
+	}
+	return []any{"null", t}, nil
+}
+
+func (e *avroExporter) typ(f pipeline.Field, path string) (any, error) {
+	switch f.Type {
+	case pipeline.TypeString:
+		if f.Constraints != nil && len(f.Constraints.Enum) > 0 {
+			if symbols, ok := avroSymbols(f.Constraints.Enum); ok {
+				return map[string]any{"type": "enum", "name": avroTypeName(path), "symbols": symbols}, nil
+			}
+		}
+		return "string", nil
+	case pipeline.TypeJSON, pipeline.TypeUnknown:
+		return "string", nil
+	case pipeline.TypeInt64:
+		return "long", nil
+	case pipeline.TypeFloat64:
+		return "double", nil
+	case pipeline.TypeBoolean:
+		return "boolean", nil
+	case pipeline.TypeBytes:
+		return "bytes", nil
+	case pipeline.TypeNull:
+		return "null", nil
+	case pipeline.TypeDecimal:
+		scale := 0
+		if f.Scale != nil {
+			scale = *f.Scale
+		}
+		return map[string]any{"type": "bytes", "logicalType": "decimal",
+			"precision": avroDecimalPrecision, "scale": scale}, nil
+	case pipeline.TypeTimestamp:
+		logical := "timestamp-nanos"
+		switch f.Precision {
+		case pipeline.PrecisionSecond, pipeline.PrecisionMilli:
+			logical = "timestamp-millis"
+		case pipeline.PrecisionMicro:
+			logical = "timestamp-micros"
+		}
+		return map[string]any{"type": "long", "logicalType": logical}, nil
+	case pipeline.TypeDuration:
+		switch f.Precision {
+		case pipeline.PrecisionSecond, pipeline.PrecisionMilli:
+			return map[string]any{"type": "int", "logicalType": "time-millis"}, nil
+		case pipeline.PrecisionMicro:
+			return map[string]any{"type": "long", "logicalType": "time-micros"}, nil
+		}
+		// nanoseconds have no logical type
+		return "long", nil
+	case pipeline.TypeList:
+		var items any = "string"
+		if f.Elem != nil {
+			var err error
+			if items, err = e.nullable(*f.Elem, path+"[]"); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case pipeline.TypeDict:
+		if len(f.Fields) > 0 {
+			fields, err := e.fields(f.Fields, path)
+			if err != nil {
+				return nil, err
+			}
+			return avroRecord{Type: "record", Name: avroTypeName(path), Fields: fields}, nil
+		}
+		var values any = "string"
+		if f.Elem != nil {
+			var err error
+			if values, err = e.nullable(*f.Elem, path+"[]"); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]any{"type": "map", "values": values}, nil
+	default:
+		return nil, fmt.Errorf("type %s has no Avro equivalent", f.Type)
+	}
+}
+
+// avroTypeName 由字段路径生成 schema 内唯一的类型名
+func avroTypeName(path string) string {
+	name := strings.NewReplacer(".", "_", "[]", "_item").Replace(path)
+	if !avroName.MatchString(name) {
+		name = "_" + avroInvalidName.ReplaceAllString(name, "_")
+	}
+	return name
+}
+
+func avroSymbols(enum []any) ([]string, bool) {
+	symbols := make([]string, len(enum))
+	for i, v := range enum {
+		s, ok := v.(string)
+		if !ok || !avroName.MatchString(s) {
+			return nil, false
+		}
+		symbols[i] = s
+	}
+	return symbols, true
+}
@@ -0,0 +1,105 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const orderAvro = `{
+  "type": "record",
+  "name": "Order",
+  "namespace": "shop",
+  "doc": "An order placed in the shop",
+  "fields": [
+    {"name": "id", "type": "long", "doc": "Order number"},
+    {"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["NEW", "PAID"]}},
+    {"name": "created_at", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "note", "type": ["null", "string"], "default": null},
+    {"name": "total", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+    {"name": "customer", "type": {"type": "record", "name": "Customer", "fields": [
+      {"name": "email", "type": "string"},
+      {"name": "referrer", "type": ["null", "Customer"], "default": null}
+    ]}},
+    {"name": "billing", "type": "Customer"},
+    {"name": "tags", "type": {"type": "array", "items": "string"}, "default": []},
+    {"name": "attrs", "type": {"type": "map", "values": "int"}},
+    {"name": "previous", "type": "Status"},
+    {"name": "payload", "type": ["null", "string", "bytes"]}
+  ]
+}`
+
+func TestFromAvro(t *testing.T) {
+	s, err := FromAvro([]byte(orderAvro))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []pipeline.FieldType{
+		pipeline.TypeInt64, pipeline.TypeString, pipeline.TypeTimestamp, pipeline.TypeString,
+		pipeline.TypeDecimal, pipeline.TypeDict, pipeline.TypeDict, pipeline.TypeList,
+		pipeline.TypeDict, pipeline.TypeString, pipeline.TypeJSON,
+	}
+	if len(s.Fields) != len(want) {
+		t.Fatalf("want %d fields, got %d", len(want), len(s.Fields))
+	}
+	for i, f := range s.Fields {
+		if f.Type != want[i] {
+			t.Errorf("%s: want %s, got %s", f.Name, want[i], f.Type)
+		}
+	}
+
+	if f, _ := s.Lookup("status"); !reflect.DeepEqual(f.Constraints.Enum, []any{"NEW", "PAID"}) {
+		t.Errorf("want enum symbols as constraint, got %+v", f.Constraints)
+	}
+	if f, _ := s.Lookup("created_at"); f.Precision != pipeline.PrecisionMilli || !f.Required {
+		t.Errorf("want required millisecond timestamp, got %+v", f)
+	}
+	if f, _ := s.Lookup("note"); !f.Nullable || f.Required {
+		t.Errorf("want optional nullable note, got %+v", f)
+	}
+	if f, _ := s.Lookup("total"); *f.Scale != 2 {
+		t.Errorf("want scale 2, got %d", *f.Scale)
+	}
+	if f, _ := s.Lookup("customer.referrer"); f.Type != pipeline.TypeJSON {
+		t.Errorf("want recursive record as json, got %s", f.Type)
+	}
+	if f, _ := s.Lookup("billing.email"); f.Type != pipeline.TypeString {
+		t.Errorf("want named record reference expanded, got %s", f.Type)
+	}
+	if f, _ := s.Lookup("previous"); f.Constraints == nil {
+		t.Error("want named enum reference to keep its symbols")
+	}
+	if s.Metadata[MetadataName] != "Order" || s.Metadata[MetadataNamespace] != "shop" ||
+		s.Metadata[DocKey("id")] != "Order number" {
+		t.Errorf("want names and docs in metadata, got %v", s.Metadata)
+	}
+}
+
+func TestAvro_RoundTrip(t *testing.T) {
+	s := &pipeline.Schema{
+		Version:  1,
+		Metadata: map[string]string{MetadataName: "Order", DocKey("id"): "Order number"},
+		Fields: []pipeline.Field{
+			{Name: "id", Type: pipeline.TypeInt64, Required: true},
+			{Name: "note", Type: pipeline.TypeString, Nullable: true, Default: "none"},
+			{Name: "at", Type: pipeline.TypeTimestamp, Precision: pipeline.PrecisionMicro, Required: true},
+			{Name: "address", Type: pipeline.TypeDict, Required: true, Fields: []pipeline.Field{
+				{Name: "zip", Type: pipeline.TypeString, Required: true},
+			}},
+			{Name: "scores", Type: pipeline.TypeList, Required: true, Elem: &pipeline.Field{Type: pipeline.TypeFloat64}},
+		},
+	}
+	data, err := ToAvro(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := FromAvro(data)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(s, back) {
+		t.Errorf("want round trip to preserve schema\nbefore: %+v\nafter:  %+v\n%s", s, back, data)
+	}
+}
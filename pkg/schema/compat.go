@@ -0,0 +1,127 @@
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+type Compatibility string
+
+const (
+	CompatNone Compatibility = "none"
+	// CompatBackward means the new schema can read records written with the old one
+	CompatBackward Compatibility = "backward"
+	// CompatForward means the old schema can read records written with the new one
+	CompatForward Compatibility = "forward"
+	CompatFull    Compatibility = "full"
+)
+
+func ParseCompatibility(s string) (Compatibility, error) {
+	switch c := Compatibility(strings.ToLower(s)); c {
+	case CompatNone, CompatBackward, CompatForward, CompatFull:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown compatibility %q, valid values: none, backward, forward, full", s)
+}
+
+// Issue 一处破坏兼容性的变更
+type Issue struct {
+	Path    string        `json:"path"`
+	Rule    Compatibility `json:"rule"`
+	Message string        `json:"message"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s (%s): %s", i.Path, i.Rule, i.Message)
+}
+
+type IncompatibleError struct {
+	Issues []Issue
+}
+
+func (e *IncompatibleError) Error() string {
+	lines := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		lines = append(lines, issue.String())
+	}
+	return "incompatible schema change: " + strings.Join(lines, "; ")
+}
+
+// Check 列出从 prev 演进到 next 时违反 mode 的全部变更
+func Check(prev, next *pipeline.Schema, mode Compatibility) []Issue {
+	var issues []Issue
+	if mode == CompatBackward || mode == CompatFull {
+		issues = checkRead(issues, next.Fields, prev.Fields, "", CompatBackward)
+	}
+	if mode == CompatForward || mode == CompatFull {
+		issues = checkRead(issues, prev.Fields, next.Fields, "", CompatForward)
+	}
+	return issues
+}
+
+// CheckCompatibility 与 Check 相同，但以 *IncompatibleError 返回
+func CheckCompatibility(prev, next *pipeline.Schema, mode Compatibility) error {
+	if issues := Check(prev, next, mode); len(issues) > 0 {
+		return &IncompatibleError{Issues: issues}
+	}
+	return nil
+}
+
+// checkRead 检查 reader 能否读取 writer 写出的记录
+func checkRead(issues []Issue, reader, writer []pipeline.Field, prefix string, rule Compatibility) []Issue {
+	written := make(map[string]pipeline.Field, len(writer))
+	for _, f := range writer {
+		written[f.Name] = f
+	}
+
+	for _, r := range reader {
+		path := joinPath(prefix, r.Name)
+		w, ok := written[r.Name]
+		if !ok {
+			if r.Required && r.Default == nil {
+				issues = append(issues, Issue{Path: path, Rule: rule,
+					Message: "required field is missing from the writer schema and has no default"})
+			}
+			continue
+		}
+		if r.Required && !w.Required && r.Default == nil {
+			issues = append(issues, Issue{Path: path, Rule: rule,
+				Message: "field is optional in the writer schema but required without default in the reader schema"})
+		}
+		issues = checkType(issues, r, w, path, rule)
+	}
+	return issues
+}
+
+func checkType(issues []Issue, r, w pipeline.Field, path string, rule Compatibility) []Issue {
+	if !Promotable(w.Type, r.Type) {
+		return append(issues, Issue{Path: path, Rule: rule,
+			Message: fmt.Sprintf("type changed from %s to %s", w.Type, r.Type)})
+	}
+	switch r.Type {
+	case pipeline.TypeDict:
+		issues = checkRead(issues, r.Fields, w.Fields, path, rule)
+	case pipeline.TypeList:
+		if r.Elem != nil && w.Elem != nil {
+			issues = checkType(issues, *r.Elem, *w.Elem, path+"[]", rule)
+		}
+	}
+	return issues
+}
+
+// Promotable 判断 from 类型的值能否无损地由 to 类型读取
+func Promotable(from, to pipeline.FieldType) bool {
+	if from == to {
+		return true
+	}
+	return from == pipeline.TypeInt64 && (to == pipeline.TypeFloat64 || to == pipeline.TypeDecimal)
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
@@ -0,0 +1,117 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func userSchemaV1() *pipeline.Schema {
+	return &pipeline.Schema{
+		Version: 1,
+		Fields: []pipeline.Field{
+			{Name: "id", Type: pipeline.TypeInt64, Required: true},
+			{Name: "name", Type: pipeline.TypeString, Required: true},
+			{Name: "score", Type: pipeline.TypeInt64},
+			{Name: "address", Type: pipeline.TypeDict, Fields: []pipeline.Field{
+				{Name: "city", Type: pipeline.TypeString, Required: true},
+			}},
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	v2 := &pipeline.Schema{
+		Version: 2,
+		Fields: []pipeline.Field{
+			{Name: "id", Type: pipeline.TypeInt64, Required: true},
+			// name removed
+			{Name: "score", Type: pipeline.TypeFloat64},
+			{Name: "country", Type: pipeline.TypeString, Required: true, Default: "unknown"},
+			{Name: "address", Type: pipeline.TypeDict, Fields: []pipeline.Field{
+				{Name: "city", Type: pipeline.TypeBoolean, Required: true},
+				{Name: "zip", Type: pipeline.TypeString, Required: true},
+			}},
+		},
+	}
+
+	testCases := []struct {
+		mode Compatibility
+		want map[string]Compatibility
+	}{
+		{CompatNone, map[string]Compatibility{}},
+		{CompatBackward, map[string]Compatibility{
+			"address.city": CompatBackward,
+			"address.zip":  CompatBackward,
+		}},
+		{CompatForward, map[string]Compatibility{
+			"name":         CompatForward,
+			"score":        CompatForward,
+			"address.city": CompatForward,
+		}},
+	}
+	for _, tc := range testCases {
+		issues := Check(userSchemaV1(), v2, tc.mode)
+		got := make(map[string]Compatibility)
+		for _, issue := range issues {
+			got[issue.Path] = issue.Rule
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: want %v, got %v", tc.mode, tc.want, issues)
+			continue
+		}
+		for path, rule := range tc.want {
+			if got[path] != rule {
+				t.Errorf("%s: want %s reported for %s, got %v", tc.mode, rule, path, issues)
+			}
+		}
+	}
+
+	if err := CheckCompatibility(userSchemaV1(), userSchemaV1(), CompatFull); err != nil {
+		t.Errorf("want identical schemas compatible, got %v", err)
+	}
+}
+
+func TestUpcast(t *testing.T) {
+	v2 := &pipeline.Schema{
+		Version: 2,
+		Fields: []pipeline.Field{
+			{Name: "id", Type: pipeline.TypeInt64, Required: true},
+			{Name: "score", Type: pipeline.TypeFloat64},
+			{Name: "country", Type: pipeline.TypeString, Required: true, Default: "unknown"},
+			{Name: "level", Type: pipeline.TypeInt64, Default: float64(1)},
+		},
+	}
+	old := &pipeline.Record{
+		Schema:  userSchemaV1(),
+		Version: 1,
+		Data: map[string]pipeline.Value{
+			"id":    {Type: pipeline.TypeInt64, Value: int64(7)},
+			"name":  {Type: pipeline.TypeString, Value: "a"},
+			"score": {Type: pipeline.TypeInt64, Value: int64(3)},
+		},
+	}
+
+	record, err := Upcast(old, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Version != 2 {
+		t.Errorf("want version 2, got %d", record.Version)
+	}
+	if _, ok := record.Data["name"]; ok {
+		t.Error("want removed field dropped")
+	}
+	if v := record.Data["score"]; v.Type != pipeline.TypeFloat64 || v.Value != float64(3) {
+		t.Errorf("want score promoted to float64, got %+v", v)
+	}
+	if v := record.Data["country"]; v.Value != "unknown" {
+		t.Errorf("want default country, got %+v", v)
+	}
+	if v := record.Data["level"]; v.Type != pipeline.TypeInt64 || v.Value != int64(1) {
+		t.Errorf("want default converted to int64, got %+v", v)
+	}
+	if err := v2.Validate(record); err != nil {
+		t.Errorf("want upcast record valid, got %v", err)
+	}
+}
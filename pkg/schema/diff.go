@@ -0,0 +1,109 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// ChangeKind 与记录的 Diff 使用同一个类型
+type ChangeKind = pipeline.ChangeKind
+
+const (
+	ChangeAdded   = pipeline.ChangeAdded
+	ChangeRemoved = pipeline.ChangeRemoved
+	ChangeChanged = pipeline.ChangeChanged
+)
+
+type FieldChange struct {
+	Path    string     `json:"path"`
+	Kind    ChangeKind `json:"kind"`
+	Details []string   `json:"details,omitempty"`
+}
+
+func (c FieldChange) String() string {
+	switch c.Kind {
+	case ChangeAdded:
+		return "+ " + c.Path + " " + strings.Join(c.Details, ", ")
+	case ChangeRemoved:
+		return "- " + c.Path + " " + strings.Join(c.Details, ", ")
+	default:
+		return "~ " + c.Path + ": " + strings.Join(c.Details, ", ")
+	}
+}
+
+// Diff 按字段路径列出 prev 到 next 的变化，嵌套字段逐层比较
+func Diff(prev, next *pipeline.Schema) []FieldChange {
+	var changes []FieldChange
+	if prev.PrimaryKey != next.PrimaryKey {
+		changes = append(changes, FieldChange{Path: "(primary key)", Kind: ChangeChanged,
+			Details: []string{fmt.Sprintf("%q -> %q", prev.PrimaryKey, next.PrimaryKey)}})
+	}
+	return diffFields(changes, prev.Fields, next.Fields, "")
+}
+
+func diffFields(changes []FieldChange, prev, next []pipeline.Field, prefix string) []FieldChange {
+	old := make(map[string]pipeline.Field, len(prev))
+	for _, f := range prev {
+		old[f.Name] = f
+	}
+	seen := make(map[string]bool, len(next))
+
+	for _, n := range next {
+		seen[n.Name] = true
+		path := joinPath(prefix, n.Name)
+		o, ok := old[n.Name]
+		if !ok {
+			changes = append(changes, FieldChange{Path: path, Kind: ChangeAdded, Details: describe(n)})
+			continue
+		}
+		changes = diffField(changes, o, n, path)
+	}
+	for _, o := range prev {
+		if !seen[o.Name] {
+			changes = append(changes, FieldChange{Path: joinPath(prefix, o.Name), Kind: ChangeRemoved, Details: describe(o)})
+		}
+	}
+	return changes
+}
+
+func diffField(changes []FieldChange, o, n pipeline.Field, path string) []FieldChange {
+	var details []string
+	if o.Type != n.Type {
+		details = append(details, fmt.Sprintf("type %s -> %s", o.Type, n.Type))
+	}
+	if o.Required != n.Required {
+		details = append(details, fmt.Sprintf("required %t -> %t", o.Required, n.Required))
+	}
+	if !reflect.DeepEqual(o.Default, n.Default) {
+		details = append(details, fmt.Sprintf("default %v -> %v", o.Default, n.Default))
+	}
+	if len(details) > 0 {
+		changes = append(changes, FieldChange{Path: path, Kind: ChangeChanged, Details: details})
+	}
+
+	if o.Type == n.Type {
+		switch n.Type {
+		case pipeline.TypeDict:
+			changes = diffFields(changes, o.Fields, n.Fields, path)
+		case pipeline.TypeList:
+			if o.Elem != nil && n.Elem != nil {
+				changes = diffField(changes, *o.Elem, *n.Elem, path+"[]")
+			}
+		}
+	}
+	return changes
+}
+
+func describe(f pipeline.Field) []string {
+	details := []string{f.Type.String()}
+	if f.Required {
+		details = append(details, "required")
+	}
+	if f.Default != nil {
+		details = append(details, fmt.Sprintf("default %v", f.Default))
+	}
+	return details
+}
@@ -0,0 +1,49 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"gopkg.in/yaml.v3"
+)
+
+func isYAML(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// ReadFile 读取 JSON 或 YAML（按扩展名区分）格式的 schema 文件
+func ReadFile(path string) (*pipeline.Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s pipeline.Schema
+	if isYAML(path) {
+		err = yaml.Unmarshal(data, &s)
+	} else {
+		err = json.Unmarshal(data, &s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode schema %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+func WriteFile(path string, s *pipeline.Schema) error {
+	var data []byte
+	var err error
+	if isYAML(path) {
+		data, err = yaml.Marshal(s)
+	} else {
+		data, err = json.MarshalIndent(s, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,310 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+type InconsistencyKind string
+
+const (
+	// InconsistencyType means a field was seen with several types and had to be widened
+	InconsistencyType InconsistencyKind = "type_conflict"
+	// InconsistencyMissing means a field is absent from some records
+	InconsistencyMissing InconsistencyKind = "missing"
+	// InconsistencyNull means a field is explicitly null in some records
+	InconsistencyNull InconsistencyKind = "null"
+)
+
+type Inconsistency struct {
+	Path    string            `json:"path"`
+	Kind    InconsistencyKind `json:"kind"`
+	Message string            `json:"message"`
+	// Types counts the observed types for type conflicts
+	Types map[string]int `json:"types,omitempty"`
+}
+
+// Report 推断过程中发现的不一致，按路径排序
+type Report struct {
+	Records         int             `json:"records"`
+	Inconsistencies []Inconsistency `json:"inconsistencies"`
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d records scanned, %d inconsistencies\n", r.Records, len(r.Inconsistencies))
+	for _, inc := range r.Inconsistencies {
+		fmt.Fprintf(&b, "  %s [%s]: %s\n", inc.Path, inc.Kind, inc.Message)
+	}
+	return b.String()
+}
+
+// Inferrer 逐条观察记录并推断 Schema。嵌套对象推断为 TypeDict，
+// 数组推断为 TypeList，冲突的类型按 widen 的规则放宽
+type Inferrer struct {
+	root    *node
+	records int
+}
+
+func NewInferrer() *Inferrer {
+	return &Inferrer{root: newNode()}
+}
+
+func (i *Inferrer) Observe(record *pipeline.Record) {
+	i.records++
+	i.root.observe(record.Data)
+}
+
+// Infer 从 source 读取最多 n 条记录（n <= 0 表示读到结束）并推断 Schema
+func Infer(source pipeline.Source, n int) (*pipeline.Schema, *Report, error) {
+	inferrer := NewInferrer()
+	for n <= 0 || inferrer.records < n {
+		msg, err := source.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if msg.Payload != nil {
+			inferrer.Observe(msg.Payload)
+		}
+	}
+	schema, report := inferrer.Result()
+	return schema, report, nil
+}
+
+func (i *Inferrer) Result() (*pipeline.Schema, *Report) {
+	report := &Report{Records: i.records}
+	fields := i.root.fields(report, "")
+	sort.Slice(report.Inconsistencies, func(a, b int) bool {
+		x, y := report.Inconsistencies[a], report.Inconsistencies[b]
+		if x.Path != y.Path {
+			return x.Path < y.Path
+		}
+		return x.Kind < y.Kind
+	})
+	return &pipeline.Schema{Fields: fields, Version: 1}, report
+}
+
+type node struct {
+	types map[pipeline.FieldType]int
+	// present counts every occurrence including nulls
+	present  int
+	nulls    int
+	dicts    int
+	children map[string]*node
+	elem     *node
+}
+
+func newNode() *node {
+	return &node{
+		types:    make(map[pipeline.FieldType]int),
+		children: make(map[string]*node),
+	}
+}
+
+func (n *node) child(name string) *node {
+	c, ok := n.children[name]
+	if !ok {
+		c = newNode()
+		n.children[name] = c
+	}
+	return c
+}
+
+func (n *node) observe(v any) {
+	if value, ok := v.(pipeline.Value); ok {
+		n.observeTyped(value.Value, value.Type)
+		return
+	}
+	n.observeTyped(v, pipeline.TypeUnknown)
+}
+
+// observe 对 dict 的子字段计数，对其他值记录类型
+func (n *node) observeTyped(v any, hint pipeline.FieldType) {
+	switch v := v.(type) {
+	case nil:
+		n.nulls++
+	case map[string]pipeline.Value:
+		n.dicts++
+		n.types[pipeline.TypeDict]++
+		for name, child := range v {
+			c := n.child(name)
+			c.present++
+			c.observe(child)
+		}
+	case map[string]any:
+		n.dicts++
+		n.types[pipeline.TypeDict]++
+		for name, child := range v {
+			c := n.child(name)
+			c.present++
+			c.observe(child)
+		}
+	case []pipeline.Value:
+		n.types[pipeline.TypeList]++
+		n.observeElems(len(v), func(i int) any { return v[i] })
+	case []any:
+		n.types[pipeline.TypeList]++
+		n.observeElems(len(v), func(i int) any { return v[i] })
+	default:
+		n.types[scalarType(v, hint)]++
+	}
+}
+
+func (n *node) observeElems(length int, at func(i int) any) {
+	if n.elem == nil {
+		n.elem = newNode()
+	}
+	for i := 0; i < length; i++ {
+		n.elem.present++
+		n.elem.observe(at(i))
+	}
+}
+
+func scalarType(v any, hint pipeline.FieldType) pipeline.FieldType {
+	switch v := v.(type) {
+	case string:
+		return pipeline.TypeString
+	case bool:
+		return pipeline.TypeBoolean
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return pipeline.TypeInt64
+	case float32, float64:
+		return pipeline.TypeFloat64
+	case time.Time:
+		return pipeline.TypeTimestamp
+	case time.Duration:
+		return pipeline.TypeDuration
+	case []byte:
+		return pipeline.TypeBytes
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return pipeline.TypeInt64
+		}
+		return pipeline.TypeFloat64
+	}
+	if hint != pipeline.TypeUnknown {
+		return hint
+	}
+	return pipeline.TypeJSON
+}
+
+// fields 子字段按名称排序，保证输出稳定
+func (n *node) fields(report *Report, prefix string) []pipeline.Field {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]pipeline.Field, 0, len(names))
+	for _, name := range names {
+		c := n.children[name]
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		field := c.field(report, path, name)
+		field.Required = c.present == n.dicts && c.nulls == 0
+		field.Nullable = c.nulls > 0
+
+		if missing := n.dicts - c.present; missing > 0 {
+			report.add(path, InconsistencyMissing, fmt.Sprintf("absent in %d of %d records", missing, n.dicts), nil)
+		}
+		if c.nulls > 0 {
+			report.add(path, InconsistencyNull, fmt.Sprintf("null in %d of %d records", c.nulls, c.present), nil)
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func (n *node) field(report *Report, path, name string) pipeline.Field {
+	field := pipeline.Field{Name: name, Type: n.resolveType(report, path)}
+	switch field.Type {
+	case pipeline.TypeDict:
+		field.Fields = n.fields(report, path)
+	case pipeline.TypeList:
+		if n.elem != nil && (len(n.elem.types) > 0 || n.elem.nulls > 0) {
+			elem := n.elem.field(report, path+"[]", "")
+			elem.Required = n.elem.nulls == 0
+			elem.Nullable = n.elem.nulls > 0
+			field.Elem = &elem
+		}
+	}
+	return field
+}
+
+func (n *node) resolveType(report *Report, path string) pipeline.FieldType {
+	types := make([]pipeline.FieldType, 0, len(n.types))
+	for t := range n.types {
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		// only ever null
+		return pipeline.TypeNull
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+
+	resolved, lossy := types[0], false
+	for _, t := range types[1:] {
+		var l bool
+		resolved, l = widen(resolved, t)
+		lossy = lossy || l
+	}
+	if lossy {
+		counts := make(map[string]int, len(n.types))
+		names := make([]string, 0, len(types))
+		for _, t := range types {
+			counts[t.String()] = n.types[t]
+			names = append(names, t.String())
+		}
+		report.add(path, InconsistencyType,
+			fmt.Sprintf("mixed types %s, widened to %s", strings.Join(names, ", "), resolved), counts)
+	}
+	return resolved
+}
+
+func isNumeric(t pipeline.FieldType) bool {
+	return t == pipeline.TypeInt64 || t == pipeline.TypeFloat64 || t == pipeline.TypeDecimal
+}
+
+func isScalar(t pipeline.FieldType) bool {
+	switch t {
+	case pipeline.TypeString, pipeline.TypeBoolean, pipeline.TypeTimestamp, pipeline.TypeDuration, pipeline.TypeBytes:
+		return true
+	}
+	return isNumeric(t)
+}
+
+// widen 返回能容纳 a 和 b 的类型，lossy 表示这不是单纯的数值提升，需要报告。
+// 数值之间提升为更宽的数值类型，其他标量放宽为字符串，涉及嵌套结构时放宽为 JSON
+func widen(a, b pipeline.FieldType) (t pipeline.FieldType, lossy bool) {
+	switch {
+	case a == b:
+		return a, false
+	case isNumeric(a) && isNumeric(b):
+		return max(a, b), false
+	case isScalar(a) && isScalar(b):
+		return pipeline.TypeString, true
+	default:
+		return pipeline.TypeJSON, true
+	}
+}
+
+func (r *Report) add(path string, kind InconsistencyKind, message string, types map[string]int) {
+	r.Inconsistencies = append(r.Inconsistencies, Inconsistency{
+		Path:    path,
+		Kind:    kind,
+		Message: message,
+		Types:   types,
+	})
+}
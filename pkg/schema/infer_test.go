@@ -0,0 +1,101 @@
+package schema
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+type jsonSource struct {
+	decoder *json.Decoder
+}
+
+func newJSONSource(input string) *jsonSource {
+	decoder := json.NewDecoder(strings.NewReader(input))
+	decoder.UseNumber()
+	return &jsonSource{decoder: decoder}
+}
+
+func (s *jsonSource) Read() (*pipeline.Message, error) {
+	var obj map[string]any
+	if err := s.decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+	data := make(map[string]pipeline.Value, len(obj))
+	for k, v := range obj {
+		data[k] = pipeline.Value{Value: v}
+	}
+	return pipeline.NewMessage(&pipeline.Record{Data: data}), nil
+}
+
+func findField(fields []pipeline.Field, name string) pipeline.Field {
+	for _, f := range fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return pipeline.Field{}
+}
+
+func TestInfer(t *testing.T) {
+	input := `
+{"id": 1, "price": 10, "user": {"name": "a", "age": 30}, "tags": ["x"], "code": 7}
+{"id": 2, "price": 10.5, "user": {"name": "b"}, "tags": [], "code": "A7", "note": null}
+{"id": 3, "price": 11, "user": {"name": "c", "age": 31}, "tags": ["y", "z"], "code": 8}
+{"id": 4, "price": 12}`
+
+	schema, report, err := Infer(newJSONSource(input), 3)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if report.Records != 3 {
+		t.Errorf("want 3 records scanned, got %d", report.Records)
+	}
+
+	id := findField(schema.Fields, "id")
+	if id.Type != pipeline.TypeInt64 || !id.Required {
+		t.Errorf("id: want required int64, got %+v", id)
+	}
+	if price := findField(schema.Fields, "price"); price.Type != pipeline.TypeFloat64 {
+		t.Errorf("price: want int64 widened to float64, got %s", price.Type)
+	}
+	if code := findField(schema.Fields, "code"); code.Type != pipeline.TypeString {
+		t.Errorf("code: want conflict widened to string, got %s", code.Type)
+	}
+	if note := findField(schema.Fields, "note"); note.Required {
+		t.Error("note: want optional")
+	}
+
+	user := findField(schema.Fields, "user")
+	if user.Type != pipeline.TypeDict || !user.Required {
+		t.Fatalf("user: want required dict, got %+v", user)
+	}
+	if age := findField(user.Fields, "age"); age.Type != pipeline.TypeInt64 || age.Required {
+		t.Errorf("user.age: want optional int64, got %+v", age)
+	}
+	tags := findField(schema.Fields, "tags")
+	if tags.Type != pipeline.TypeList || tags.Elem == nil || tags.Elem.Type != pipeline.TypeString {
+		t.Errorf("tags: want list of strings, got %+v", tags)
+	}
+
+	kinds := make(map[string]InconsistencyKind)
+	for _, inc := range report.Inconsistencies {
+		kinds[inc.Path] = inc.Kind
+	}
+	want := map[string]InconsistencyKind{
+		"code":     InconsistencyType,
+		"note":     InconsistencyNull,
+		"user.age": InconsistencyMissing,
+	}
+	for path, kind := range want {
+		if kinds[path] != kind {
+			t.Errorf("%s: want %s reported, got %q", path, kind, kinds[path])
+		}
+	}
+	if _, ok := kinds["price"]; ok {
+		t.Error("price: numeric widening should not be reported")
+	}
+}
@@ -0,0 +1,483 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// jsonSchema 只包含能映射到 pipeline.Schema 的关键字
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 jsonTypes              `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Properties           jsonProperties         `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	AnyOf                []*jsonSchema          `json:"anyOf,omitempty"`
+	OneOf                []*jsonSchema          `json:"oneOf,omitempty"`
+	Enum                 []any                  `json:"enum,omitempty"`
+	Default              any                    `json:"default,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Scale                *int                   `json:"x-scale,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+	Definitions          map[string]*jsonSchema `json:"definitions,omitempty"`
+}
+
+// jsonTypes 兼容 "type": "string" 和 "type": ["string", "null"] 两种写法
+type jsonTypes []string
+
+func (t *jsonTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = jsonTypes{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*t = multiple
+	return nil
+}
+
+func (t jsonTypes) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+type jsonProperty struct {
+	Name   string
+	Schema *jsonSchema
+}
+
+// jsonProperties 保留 properties 的书写顺序，导入后的字段顺序与原文件一致
+type jsonProperties []jsonProperty
+
+func (p *jsonProperties) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("properties must be an object")
+	}
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		var prop jsonSchema
+		if err := decoder.Decode(&prop); err != nil {
+			return fmt.Errorf("property %v: %w", tok, err)
+		}
+		*p = append(*p, jsonProperty{Name: tok.(string), Schema: &prop})
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+func (p jsonProperties) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, prop := range p {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(prop.Name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(prop.Schema)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// FromJSONSchema 将根为 object 的 JSON Schema 转换为 pipeline.Schema。
+// integer 映射为 int64，number 映射为 float64（format 为 decimal 时映射为 decimal），
+// format 为 date-time/date 和 duration 的字符串映射为 timestamp 和 duration，
+// base64 编码的字符串映射为 bytes，允许 null 的类型映射为 Nullable 字段，
+// 无法用单一类型表示的 schema 映射为 json。本地的 $ref 会被展开，
+// title 和 description 保存在 Metadata 中
+func FromJSONSchema(data []byte) (*pipeline.Schema, error) {
+	var root jsonSchema
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("decode JSON Schema: %w", err)
+	}
+
+	c := &jsonSchemaConverter{root: &root, metadata: make(map[string]string), resolving: make(map[string]bool)}
+	top, err := c.resolve(&root)
+	if err != nil {
+		return nil, err
+	}
+	if t, _ := top.types(); t != "object" {
+		return nil, fmt.Errorf("JSON Schema root must be an object, got %q", t)
+	}
+
+	s := &pipeline.Schema{Version: 1}
+	fields, err := c.fields(top, "")
+	if err != nil {
+		return nil, err
+	}
+	s.Fields = fields
+	if isFalse(top.AdditionalProperties) {
+		s.UnknownFields = pipeline.UnknownReject
+	}
+	setDoc(c.metadata, MetadataTitle, top.Title)
+	setDoc(c.metadata, MetadataDoc, top.Description)
+	setDoc(c.metadata, MetadataName, top.ID)
+	if len(c.metadata) > 0 {
+		s.Metadata = c.metadata
+	}
+	return s, nil
+}
+
+type jsonSchemaConverter struct {
+	root      *jsonSchema
+	metadata  map[string]string
+	resolving map[string]bool
+}
+
+// resolve 沿 $ref 链找到实际的定义，只引用自身的链无法解析
+func (c *jsonSchemaConverter) resolve(s *jsonSchema) (*jsonSchema, error) {
+	seen := make(map[string]bool)
+	for s.Ref != "" {
+		if seen[s.Ref] {
+			return nil, fmt.Errorf("$ref %q refers to itself", s.Ref)
+		}
+		seen[s.Ref] = true
+
+		var defs map[string]*jsonSchema
+		var name string
+		switch {
+		case strings.HasPrefix(s.Ref, "#/$defs/"):
+			defs, name = c.root.Defs, strings.TrimPrefix(s.Ref, "#/$defs/")
+		case strings.HasPrefix(s.Ref, "#/definitions/"):
+			defs, name = c.root.Definitions, strings.TrimPrefix(s.Ref, "#/definitions/")
+		default:
+			return nil, fmt.Errorf("unsupported $ref %q, only local definitions are resolved", s.Ref)
+		}
+		target, ok := defs[name]
+		if !ok {
+			return nil, fmt.Errorf("$ref %q not found", s.Ref)
+		}
+		s = target
+	}
+	return s, nil
+}
+
+func (c *jsonSchemaConverter) fields(s *jsonSchema, prefix string) ([]pipeline.Field, error) {
+	required := make(map[string]bool, len(s.Required))
+	for _, name := range s.Required {
+		required[name] = true
+	}
+
+	fields := make([]pipeline.Field, 0, len(s.Properties))
+	for _, prop := range s.Properties {
+		path := joinPath(prefix, prop.Name)
+		field, err := c.field(prop.Schema, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		field.Name = prop.Name
+		field.Required = required[prop.Name]
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func (c *jsonSchemaConverter) field(s *jsonSchema, path string) (pipeline.Field, error) {
+	// recursive definitions cannot be flattened into fields
+	if s.Ref != "" {
+		if c.resolving[s.Ref] {
+			return pipeline.Field{Type: pipeline.TypeJSON}, nil
+		}
+		c.resolving[s.Ref] = true
+		defer delete(c.resolving, s.Ref)
+	}
+	resolved, err := c.resolve(s)
+	if err != nil {
+		return pipeline.Field{}, err
+	}
+	s = resolved
+
+	var field pipeline.Field
+	if branches := append(append([]*jsonSchema(nil), s.AnyOf...), s.OneOf...); len(branches) > 0 {
+		field, err = c.union(branches, path)
+		if err != nil {
+			return pipeline.Field{}, err
+		}
+	} else {
+		t, nullable := s.types()
+		field, err = c.typed(s, t, path)
+		if err != nil {
+			return pipeline.Field{}, err
+		}
+		field.Nullable = nullable
+	}
+
+	setDoc(c.metadata, DocKey(path), s.Description)
+	if s.Default != nil {
+		field.Default = s.Default
+	}
+	field.Constraints = s.constraints()
+	return field, nil
+}
+
+// union 只接受“一个类型加 null”的组合，其余映射为 json
+func (c *jsonSchemaConverter) union(branches []*jsonSchema, path string) (pipeline.Field, error) {
+	var typed []*jsonSchema
+	nullable := false
+	for _, b := range branches {
+		if t, _ := b.types(); t == "null" && b.Ref == "" {
+			nullable = true
+			continue
+		}
+		typed = append(typed, b)
+	}
+	if len(typed) != 1 {
+		return pipeline.Field{Type: pipeline.TypeJSON, Nullable: nullable}, nil
+	}
+	field, err := c.field(typed[0], path)
+	if err != nil {
+		return pipeline.Field{}, err
+	}
+	field.Nullable = field.Nullable || nullable
+	return field, nil
+}
+
+func (c *jsonSchemaConverter) typed(s *jsonSchema, t, path string) (pipeline.Field, error) {
+	switch t {
+	case "string":
+		switch {
+		case s.Format == "date-time" || s.Format == "date":
+			return pipeline.Field{Type: pipeline.TypeTimestamp}, nil
+		case s.Format == "duration":
+			return pipeline.Field{Type: pipeline.TypeDuration}, nil
+		case s.Format == "decimal":
+			return pipeline.Field{Type: pipeline.TypeDecimal, Scale: s.Scale}, nil
+		case s.ContentEncoding == "base64" || s.Format == "byte":
+			return pipeline.Field{Type: pipeline.TypeBytes}, nil
+		}
+		return pipeline.Field{Type: pipeline.TypeString}, nil
+	case "integer":
+		return pipeline.Field{Type: pipeline.TypeInt64}, nil
+	case "number":
+		if s.Format == "decimal" {
+			return pipeline.Field{Type: pipeline.TypeDecimal, Scale: s.Scale}, nil
+		}
+		return pipeline.Field{Type: pipeline.TypeFloat64}, nil
+	case "boolean":
+		return pipeline.Field{Type: pipeline.TypeBoolean}, nil
+	case "null":
+		return pipeline.Field{Type: pipeline.TypeNull}, nil
+	case "array":
+		field := pipeline.Field{Type: pipeline.TypeList}
+		if s.Items != nil {
+			elem, err := c.field(s.Items, path+"[]")
+			if err != nil {
+				return pipeline.Field{}, err
+			}
+			field.Elem = &elem
+		}
+		return field, nil
+	case "object":
+		field := pipeline.Field{Type: pipeline.TypeDict}
+		if len(s.Properties) > 0 {
+			fields, err := c.fields(s, path)
+			if err != nil {
+				return pipeline.Field{}, err
+			}
+			field.Fields = fields
+		} else if elem := additionalSchema(s.AdditionalProperties); elem != nil {
+			e, err := c.field(elem, path+"[]")
+			if err != nil {
+				return pipeline.Field{}, err
+			}
+			field.Elem = &e
+		}
+		return field, nil
+	default:
+		return pipeline.Field{Type: pipeline.TypeJSON}, nil
+	}
+}
+
+// types 返回去掉 null 之后唯一的类型，多个类型时返回空字符串。
+// 没有 type 但有 enum 时按第一个枚举值推断
+func (s *jsonSchema) types() (t string, nullable bool) {
+	var others []string
+	for _, name := range s.Type {
+		if name == "null" {
+			nullable = true
+			continue
+		}
+		others = append(others, name)
+	}
+	switch {
+	case len(others) == 1:
+		return others[0], nullable
+	case len(others) == 0 && nullable:
+		return "null", false
+	case len(s.Type) == 0 && len(s.Properties) > 0:
+		return "object", false
+	case len(s.Type) == 0 && len(s.Enum) > 0:
+		switch s.Enum[0].(type) {
+		case string:
+			return "string", false
+		case bool:
+			return "boolean", false
+		case float64:
+			return "number", false
+		}
+	}
+	return "", nullable
+}
+
+func (s *jsonSchema) constraints() *pipeline.Constraints {
+	if len(s.Enum) == 0 && s.Minimum == nil && s.Maximum == nil &&
+		s.MinLength == nil && s.MaxLength == nil && s.Pattern == "" {
+		return nil
+	}
+	return &pipeline.Constraints{
+		Enum:      s.Enum,
+		Min:       s.Minimum,
+		Max:       s.Maximum,
+		MinLength: s.MinLength,
+		MaxLength: s.MaxLength,
+		Pattern:   s.Pattern,
+	}
+}
+
+func isFalse(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("false"))
+}
+
+func additionalSchema(raw json.RawMessage) *jsonSchema {
+	if len(raw) == 0 || raw[0] != '{' {
+		return nil
+	}
+	var s jsonSchema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil
+	}
+	return &s
+}
+
+// ToJSONSchema 将 pipeline.Schema 导出为 JSON Schema (draft 2020-12)，
+// 是 FromJSONSchema 的逆过程，导出后再导入得到等价的 schema
+func ToJSONSchema(s *pipeline.Schema) ([]byte, error) {
+	root := &jsonSchema{
+		Schema:      jsonSchemaDraft,
+		ID:          s.Metadata[MetadataName],
+		Title:       s.Metadata[MetadataTitle],
+		Description: s.Metadata[MetadataDoc],
+	}
+	if err := exportObject(root, s.Fields, s.Metadata, ""); err != nil {
+		return nil, err
+	}
+	if s.UnknownFields == pipeline.UnknownReject {
+		root.AdditionalProperties = json.RawMessage("false")
+	}
+	return json.MarshalIndent(root, "", "  ")
+}
+
+func exportObject(obj *jsonSchema, fields []pipeline.Field, metadata map[string]string, prefix string) error {
+	obj.Type = jsonTypes{"object"}
+	for _, f := range fields {
+		path := joinPath(prefix, f.Name)
+		prop, err := exportField(f, metadata, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		obj.Properties = append(obj.Properties, jsonProperty{Name: f.Name, Schema: prop})
+		if f.Required {
+			obj.Required = append(obj.Required, f.Name)
+		}
+	}
+	return nil
+}
+
+func exportField(f pipeline.Field, metadata map[string]string, path string) (*jsonSchema, error) {
+	s := &jsonSchema{Description: metadata[DocKey(path)], Default: f.Default}
+	var t string
+	switch f.Type {
+	case pipeline.TypeString:
+		t = "string"
+	case pipeline.TypeInt64:
+		t = "integer"
+	case pipeline.TypeFloat64:
+		t = "number"
+	case pipeline.TypeDecimal:
+		t, s.Format, s.Scale = "number", "decimal", f.Scale
+	case pipeline.TypeBoolean:
+		t = "boolean"
+	case pipeline.TypeTimestamp:
+		t, s.Format = "string", "date-time"
+	case pipeline.TypeDuration:
+		t, s.Format = "string", "duration"
+	case pipeline.TypeBytes:
+		t, s.ContentEncoding = "string", "base64"
+	case pipeline.TypeNull:
+		t = "null"
+	case pipeline.TypeList:
+		t = "array"
+		if f.Elem != nil {
+			items, err := exportField(*f.Elem, metadata, path+"[]")
+			if err != nil {
+				return nil, err
+			}
+			s.Items = items
+		}
+	case pipeline.TypeDict:
+		if len(f.Fields) > 0 {
+			if err := exportObject(s, f.Fields, metadata, path); err != nil {
+				return nil, err
+			}
+		} else if f.Elem != nil {
+			elem, err := exportField(*f.Elem, metadata, path+"[]")
+			if err != nil {
+				return nil, err
+			}
+			data, err := json.Marshal(elem)
+			if err != nil {
+				return nil, err
+			}
+			s.AdditionalProperties = data
+		}
+		t = "object"
+	case pipeline.TypeJSON, pipeline.TypeUnknown:
+		// any JSON value
+	default:
+		return nil, fmt.Errorf("type %s has no JSON Schema equivalent", f.Type)
+	}
+
+	if t != "" {
+		s.Type = jsonTypes{t}
+		if f.Nullable && t != "null" {
+			s.Type = append(s.Type, "null")
+		}
+	}
+	if c := f.Constraints; c != nil {
+		s.Enum, s.Minimum, s.Maximum = c.Enum, c.Min, c.Max
+		s.MinLength, s.MaxLength, s.Pattern = c.MinLength, c.MaxLength, c.Pattern
+	}
+	return s, nil
+}
@@ -0,0 +1,115 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const orderJSONSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Order",
+  "description": "An order placed in the shop",
+  "type": "object",
+  "additionalProperties": false,
+  "required": ["id", "created_at"],
+  "properties": {
+    "id": {"type": "integer", "description": "Order number"},
+    "status": {"type": "string", "enum": ["new", "paid"]},
+    "created_at": {"type": "string", "format": "date-time"},
+    "note": {"type": ["string", "null"], "maxLength": 200},
+    "total": {"type": "number", "format": "decimal", "x-scale": 2},
+    "customer": {"$ref": "#/$defs/customer"},
+    "tags": {"type": "array", "items": {"type": "string"}},
+    "attrs": {"type": "object", "additionalProperties": {"type": "integer"}},
+    "extra": {"anyOf": [{"type": "string"}, {"type": "integer"}]}
+  },
+  "$defs": {
+    "customer": {
+      "type": "object",
+      "description": "Who placed the order",
+      "properties": {"email": {"type": "string"}, "referrer": {"$ref": "#/$defs/customer"}}
+    }
+  }
+}`
+
+func TestFromJSONSchema(t *testing.T) {
+	s, err := FromJSONSchema([]byte(orderJSONSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]pipeline.FieldType{
+		"id": pipeline.TypeInt64, "status": pipeline.TypeString, "created_at": pipeline.TypeTimestamp,
+		"note": pipeline.TypeString, "total": pipeline.TypeDecimal, "customer": pipeline.TypeDict,
+		"tags": pipeline.TypeList, "attrs": pipeline.TypeDict, "extra": pipeline.TypeJSON,
+	}
+	if len(s.Fields) != len(want) || s.Fields[0].Name != "id" || s.Fields[8].Name != "extra" {
+		t.Fatalf("want fields in declaration order, got %+v", s.Fields)
+	}
+	for _, f := range s.Fields {
+		if f.Type != want[f.Name] {
+			t.Errorf("%s: want %s, got %s", f.Name, want[f.Name], f.Type)
+		}
+	}
+
+	if f, _ := s.Lookup("created_at"); !f.Required {
+		t.Error("want created_at required")
+	}
+	if f, _ := s.Lookup("note"); !f.Nullable || *f.Constraints.MaxLength != 200 {
+		t.Errorf("want nullable note with max length, got %+v", f)
+	}
+	if f, _ := s.Lookup("total"); f.Scale == nil || *f.Scale != 2 {
+		t.Errorf("want total scale 2, got %+v", f)
+	}
+	if f, _ := s.Lookup("customer.referrer"); f.Type != pipeline.TypeJSON {
+		t.Errorf("want recursive reference as json, got %s", f.Type)
+	}
+	if f, _ := s.Lookup("attrs"); f.Elem == nil || f.Elem.Type != pipeline.TypeInt64 {
+		t.Errorf("want attrs elem int64, got %+v", f.Elem)
+	}
+	if s.UnknownFields != pipeline.UnknownReject {
+		t.Error("want additionalProperties false to reject unknown fields")
+	}
+	if s.Metadata[MetadataTitle] != "Order" || s.Metadata[DocKey("id")] != "Order number" ||
+		s.Metadata[DocKey("customer")] != "Who placed the order" {
+		t.Errorf("want docs in metadata, got %v", s.Metadata)
+	}
+}
+
+func TestJSONSchema_RoundTrip(t *testing.T) {
+	s, err := FromJSONSchema([]byte(orderJSONSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ToJSONSchema(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := FromJSONSchema(data)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(s, back) {
+		t.Errorf("want round trip to preserve schema\nbefore: %+v\nafter:  %+v\n%s", s, back, data)
+	}
+}
+
+func TestFromJSONSchema_RootMustBeObject(t *testing.T) {
+	if _, err := FromJSONSchema([]byte(`{"type": "string"}`)); err == nil {
+		t.Error("want error for non-object root")
+	}
+}
+
+func TestFromJSONSchema_RefCycle(t *testing.T) {
+	for _, input := range []string{
+		`{"$ref":"#/$defs/x","$defs":{"x":{"$ref":"#/$defs/y"},"y":{"$ref":"#/$defs/x"}}}`,
+		`{"type":"object","properties":{"a":{"$ref":"#/definitions/x"}},"definitions":{"x":{"$ref":"#/definitions/x"}}}`,
+	} {
+		if _, err := FromJSONSchema([]byte(input)); err == nil || !strings.Contains(err.Error(), "itself") {
+			t.Errorf("%s: want $ref cycle error, got %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package schema
+
+// 从外部格式导入时写入 Schema.Metadata 的键，导出时从这里读回
+const (
+	MetadataName      = "name"
+	MetadataNamespace = "namespace"
+	MetadataTitle     = "title"
+	MetadataDoc       = "doc"
+)
+
+// DocKey 返回字段文档在 Metadata 中的键，例如 "doc.address.zip"，
+// list 元素的路径以 "[]" 结尾
+func DocKey(path string) string {
+	return MetadataDoc + "." + path
+}
+
+// setDoc 只记录非空文档，避免 Metadata 被空字符串填满
+func setDoc(metadata map[string]string, key, doc string) {
+	if doc != "" {
+		metadata[key] = doc
+	}
+}
@@ -0,0 +1,252 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// FromProtoFile 编译 .proto 文件并转换其中的 message。message 可以是全名或短名，
+// 为空时文件中必须只有一个顶层 message。importPaths 为空时以文件所在目录作为导入路径，
+// google/protobuf 下的标准文件总是可以导入
+func FromProtoFile(filename, message string, importPaths ...string) (*pipeline.Schema, error) {
+	if len(importPaths) == 0 {
+		importPaths = []string{filepath.Dir(filename)}
+		filename = filepath.Base(filename)
+	}
+	compiler := protocompile.Compiler{
+		Resolver:       protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: importPaths}),
+		SourceInfoMode: protocompile.SourceInfoStandard,
+	}
+	files, err := compiler.Compile(context.Background(), filename)
+	if err != nil {
+		return nil, fmt.Errorf("compile %s: %w", filename, err)
+	}
+
+	md, err := findMessage(files[0].Messages(), message)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return FromProtoMessage(md), nil
+}
+
+// FromDescriptorSet 从 protoc --descriptor_set_out 生成的 FileDescriptorSet 中转换 message，
+// message 的含义与 FromProtoFile 相同，为空时在全部文件中查找唯一的顶层 message
+func FromDescriptorSet(data []byte, message string) (*pipeline.Schema, error) {
+	md, err := DescriptorSetMessage(data, message)
+	if err != nil {
+		return nil, err
+	}
+	return FromProtoMessage(md), nil
+}
+
+// DescriptorSetMessage 在 FileDescriptorSet 中查找 message 的描述符
+func DescriptorSetMessage(data []byte, message string) (protoreflect.MessageDescriptor, error) {
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("decode descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("link descriptor set: %w", err)
+	}
+
+	if d, err := files.FindDescriptorByName(protoreflect.FullName(message)); err == nil {
+		if md, ok := d.(protoreflect.MessageDescriptor); ok {
+			return md, nil
+		}
+		return nil, fmt.Errorf("%s is not a message", message)
+	}
+
+	var candidates []protoreflect.MessageDescriptor
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		// only the files listed in the set, not the imports they were linked against
+		for _, f := range set.File {
+			if f.GetName() == fd.Path() {
+				msgs := fd.Messages()
+				for i := 0; i < msgs.Len(); i++ {
+					candidates = append(candidates, msgs.Get(i))
+				}
+			}
+		}
+		return true
+	})
+	return pickMessage(candidates, message)
+}
+
+func findMessage(msgs protoreflect.MessageDescriptors, message string) (protoreflect.MessageDescriptor, error) {
+	candidates := make([]protoreflect.MessageDescriptor, msgs.Len())
+	for i := range candidates {
+		candidates[i] = msgs.Get(i)
+	}
+	if message != "" {
+		// nested messages are addressed by their full name
+		for _, md := range candidates {
+			if nested := findNested(md, message); nested != nil {
+				return nested, nil
+			}
+		}
+	}
+	return pickMessage(candidates, message)
+}
+
+func findNested(md protoreflect.MessageDescriptor, name string) protoreflect.MessageDescriptor {
+	if string(md.FullName()) == name {
+		return md
+	}
+	msgs := md.Messages()
+	for i := 0; i < msgs.Len(); i++ {
+		if found := findNested(msgs.Get(i), name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func pickMessage(candidates []protoreflect.MessageDescriptor, message string) (protoreflect.MessageDescriptor, error) {
+	if message == "" {
+		if len(candidates) != 1 {
+			return nil, fmt.Errorf("found %d messages, name the one to convert", len(candidates))
+		}
+		return candidates[0], nil
+	}
+	for _, md := range candidates {
+		if string(md.Name()) == message || string(md.FullName()) == message {
+			return md, nil
+		}
+	}
+	return nil, fmt.Errorf("message %s not found", message)
+}
+
+// FromProtoMessage 将 message 描述符转换为 pipeline.Schema。
+// 整数映射为 int64，float/double 映射为 float64，enum 映射为带枚举约束的 string，
+// repeated 映射为 list，map 映射为带 Elem 的 dict，嵌套 message 映射为 dict；
+// Timestamp、Duration 和包装类型等常用类型映射为对应的 pipeline 类型，
+// Any、Value 和递归引用映射为 json。有 presence 的字段（message、optional、oneof 成员）为 Nullable，
+// proto2 required 字段为 Required。全名、包名和注释保存在 Metadata 中
+func FromProtoMessage(md protoreflect.MessageDescriptor) *pipeline.Schema {
+	c := &protoConverter{metadata: make(map[string]string), resolving: make(map[protoreflect.FullName]bool)}
+	c.resolving[md.FullName()] = true
+	s := &pipeline.Schema{Fields: c.fields(md, ""), Version: 1}
+
+	c.metadata[MetadataName] = string(md.FullName())
+	setDoc(c.metadata, MetadataNamespace, string(md.ParentFile().Package()))
+	setDoc(c.metadata, MetadataDoc, protoComment(md))
+	s.Metadata = c.metadata
+	return s
+}
+
+type protoConverter struct {
+	metadata  map[string]string
+	resolving map[protoreflect.FullName]bool
+}
+
+func (c *protoConverter) fields(md protoreflect.MessageDescriptor, prefix string) []pipeline.Field {
+	fds := md.Fields()
+	fields := make([]pipeline.Field, 0, fds.Len())
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+		path := joinPath(prefix, string(fd.Name()))
+
+		var field pipeline.Field
+		switch {
+		case fd.IsMap():
+			elem := c.kind(fd.MapValue(), path+"[]")
+			field = pipeline.Field{Type: pipeline.TypeDict, Elem: &elem}
+		case fd.IsList():
+			elem := c.kind(fd, path+"[]")
+			field = pipeline.Field{Type: pipeline.TypeList, Elem: &elem}
+		default:
+			field = c.kind(fd, path)
+			field.Nullable = field.Nullable || fd.HasPresence()
+		}
+		field.Name = string(fd.Name())
+		field.Required = fd.Cardinality() == protoreflect.Required
+		setDoc(c.metadata, DocKey(path), protoComment(fd))
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// kind 只看字段的元素类型，不考虑 repeated
+func (c *protoConverter) kind(fd protoreflect.FieldDescriptor, path string) pipeline.Field {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return pipeline.Field{Type: pipeline.TypeBoolean}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return pipeline.Field{Type: pipeline.TypeInt64}
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return pipeline.Field{Type: pipeline.TypeFloat64}
+	case protoreflect.StringKind:
+		return pipeline.Field{Type: pipeline.TypeString}
+	case protoreflect.BytesKind:
+		return pipeline.Field{Type: pipeline.TypeBytes}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		enum := make([]any, values.Len())
+		for i := range enum {
+			enum[i] = string(values.Get(i).Name())
+		}
+		return pipeline.Field{Type: pipeline.TypeString, Constraints: &pipeline.Constraints{Enum: enum}}
+	default:
+		return c.message(fd.Message(), path)
+	}
+}
+
+func (c *protoConverter) message(md protoreflect.MessageDescriptor, path string) pipeline.Field {
+	if field, ok := wellKnownTypes[md.FullName()]; ok {
+		return field
+	}
+	if c.resolving[md.FullName()] {
+		return pipeline.Field{Type: pipeline.TypeJSON}
+	}
+	c.resolving[md.FullName()] = true
+	defer delete(c.resolving, md.FullName())
+
+	if doc := protoComment(md); doc != "" {
+		if _, ok := c.metadata[DocKey(path)]; !ok {
+			c.metadata[DocKey(path)] = doc
+		}
+	}
+	return pipeline.Field{Type: pipeline.TypeDict, Fields: c.fields(md, path)}
+}
+
+// wellKnownTypes 常用的 google.protobuf 类型，包装类型表示可以为空的标量
+var wellKnownTypes = map[protoreflect.FullName]pipeline.Field{
+	"google.protobuf.Timestamp":   {Type: pipeline.TypeTimestamp, Precision: pipeline.PrecisionNano},
+	"google.protobuf.Duration":    {Type: pipeline.TypeDuration, Precision: pipeline.PrecisionNano},
+	"google.protobuf.DoubleValue": {Type: pipeline.TypeFloat64, Nullable: true},
+	"google.protobuf.FloatValue":  {Type: pipeline.TypeFloat64, Nullable: true},
+	"google.protobuf.Int64Value":  {Type: pipeline.TypeInt64, Nullable: true},
+	"google.protobuf.UInt64Value": {Type: pipeline.TypeInt64, Nullable: true},
+	"google.protobuf.Int32Value":  {Type: pipeline.TypeInt64, Nullable: true},
+	"google.protobuf.UInt32Value": {Type: pipeline.TypeInt64, Nullable: true},
+	"google.protobuf.BoolValue":   {Type: pipeline.TypeBoolean, Nullable: true},
+	"google.protobuf.StringValue": {Type: pipeline.TypeString, Nullable: true},
+	"google.protobuf.BytesValue":  {Type: pipeline.TypeBytes, Nullable: true},
+	"google.protobuf.Struct":      {Type: pipeline.TypeDict},
+	"google.protobuf.ListValue":   {Type: pipeline.TypeList},
+	"google.protobuf.Value":       {Type: pipeline.TypeJSON, Nullable: true},
+	"google.protobuf.Any":         {Type: pipeline.TypeJSON},
+	"google.protobuf.Empty":       {Type: pipeline.TypeDict},
+}
+
+func protoComment(d protoreflect.Descriptor) string {
+	loc := d.ParentFile().SourceLocations().ByDescriptor(d)
+	lines := strings.Split(strings.TrimSpace(loc.LeadingComments), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "\n")
+}
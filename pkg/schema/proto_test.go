@@ -0,0 +1,131 @@
+package schema
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const orderProto = `syntax = "proto3";
+
+package shop;
+
+import "google/protobuf/timestamp.proto";
+import "google/protobuf/wrappers.proto";
+
+// An order placed in the shop
+message Order {
+  enum Status {
+    NEW = 0;
+    PAID = 1;
+  }
+
+  // Order number
+  int64 id = 1;
+  Status status = 2;
+  google.protobuf.Timestamp created_at = 3;
+  optional string note = 4;
+  repeated string tags = 5;
+  map<string, int32> attrs = 6;
+  Customer customer = 7;
+  google.protobuf.DoubleValue discount = 8;
+  bytes signature = 9;
+}
+
+message Customer {
+  string email = 1;
+  Customer referrer = 2;
+}
+`
+
+func TestFromProtoFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "order.proto")
+	if err := os.WriteFile(path, []byte(orderProto), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := FromProtoFile(path, ""); err == nil {
+		t.Error("want error when the file has several messages and none is named")
+	}
+
+	s, err := FromProtoFile(path, "shop.Order")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]pipeline.FieldType{
+		"id": pipeline.TypeInt64, "status": pipeline.TypeString, "created_at": pipeline.TypeTimestamp,
+		"note": pipeline.TypeString, "tags": pipeline.TypeList, "attrs": pipeline.TypeDict,
+		"customer": pipeline.TypeDict, "discount": pipeline.TypeFloat64, "signature": pipeline.TypeBytes,
+	}
+	if len(s.Fields) != len(want) {
+		t.Fatalf("want %d fields, got %d", len(want), len(s.Fields))
+	}
+	for _, f := range s.Fields {
+		if f.Type != want[f.Name] {
+			t.Errorf("%s: want %s, got %s", f.Name, want[f.Name], f.Type)
+		}
+	}
+
+	if f, _ := s.Lookup("note"); !f.Nullable {
+		t.Error("want optional field nullable")
+	}
+	if f, _ := s.Lookup("id"); f.Nullable {
+		t.Error("want plain proto3 scalar not nullable")
+	}
+	if f, _ := s.Lookup("discount"); !f.Nullable {
+		t.Error("want wrapper type nullable")
+	}
+	if f, _ := s.Lookup("status"); len(f.Constraints.Enum) != 2 {
+		t.Errorf("want enum values as constraint, got %+v", f.Constraints)
+	}
+	if f, _ := s.Lookup("customer.referrer"); f.Type != pipeline.TypeJSON {
+		t.Errorf("want recursive message as json, got %s", f.Type)
+	}
+	if f, _ := s.Lookup("attrs"); f.Elem == nil || f.Elem.Type != pipeline.TypeInt64 {
+		t.Errorf("want map values int64, got %+v", f.Elem)
+	}
+	if s.Metadata[MetadataName] != "shop.Order" || s.Metadata[MetadataDoc] != "An order placed in the shop" ||
+		s.Metadata[DocKey("id")] != "Order number" {
+		t.Errorf("want names and comments in metadata, got %v", s.Metadata)
+	}
+}
+
+func TestFromDescriptorSet(t *testing.T) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			Accessor: protocompile.SourceAccessorFromMap(map[string]string{"order.proto": orderProto}),
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), "order.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	deps := files[0].Imports()
+	for i := 0; i < deps.Len(); i++ {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(deps.Get(i).FileDescriptor))
+	}
+	set.File = append(set.File, protodesc.ToFileDescriptorProto(files[0]))
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := FromDescriptorSet(data, "Customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Fields) != 2 || s.Metadata[MetadataName] != "shop.Customer" {
+		t.Errorf("want Customer found by short name, got %+v", s)
+	}
+	if _, err := FromDescriptorSet(data, "Missing"); err == nil {
+		t.Error("want error for unknown message")
+	}
+}
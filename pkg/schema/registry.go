@@ -0,0 +1,297 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+var (
+	ErrSubjectNotFound = errors.New("subject not found")
+	ErrVersionNotFound = errors.New("schema version not found")
+)
+
+var subjectPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Ref 按 subject 和版本引用注册中心里的 schema，Version 为 0 表示最新版本
+type Ref struct {
+	Subject string `json:"subject" yaml:"subject"`
+	Version int    `json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+// ParseRef 解析 "orders"、"orders@3" 或 "orders@latest"
+func ParseRef(s string) (Ref, error) {
+	subject, version, found := strings.Cut(s, "@")
+	ref := Ref{Subject: subject}
+	if !subjectPattern.MatchString(subject) {
+		return ref, fmt.Errorf("invalid subject %q", subject)
+	}
+	if !found || version == "latest" {
+		return ref, nil
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil || v <= 0 {
+		return ref, fmt.Errorf("invalid schema version %q", version)
+	}
+	ref.Version = v
+	return ref, nil
+}
+
+func (r Ref) String() string {
+	if r.Version == 0 {
+		return r.Subject + "@latest"
+	}
+	return fmt.Sprintf("%s@%d", r.Subject, r.Version)
+}
+
+type subjectConfig struct {
+	Compatibility Compatibility `json:"compatibility"`
+}
+
+// Registry 基于目录的 schema 注册中心，布局为 <dir>/<subject>/v<version>.json。
+// 已注册的版本不可修改，因此读取过的 schema 会一直缓存
+type Registry struct {
+	dir    string
+	compat Compatibility
+
+	mu    sync.RWMutex
+	cache map[Ref]*pipeline.Schema
+}
+
+// NewRegistry compat 是没有单独配置的 subject 使用的兼容性规则
+func NewRegistry(dir string, compat Compatibility) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Registry{
+		dir:    dir,
+		compat: compat,
+		cache:  make(map[Ref]*pipeline.Schema),
+	}, nil
+}
+
+func (r *Registry) subjectDir(subject string) string {
+	return filepath.Join(r.dir, subject)
+}
+
+func (r *Registry) versionPath(subject string, version int) string {
+	return filepath.Join(r.subjectDir(subject), fmt.Sprintf("v%d.json", version))
+}
+
+func (r *Registry) Subjects() ([]string, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	var subjects []string
+	for _, e := range entries {
+		if e.IsDir() && subjectPattern.MatchString(e.Name()) {
+			subjects = append(subjects, e.Name())
+		}
+	}
+	return subjects, nil
+}
+
+// Versions 返回升序排列的版本号
+func (r *Registry) Versions(subject string) ([]int, error) {
+	entries, err := os.ReadDir(r.subjectDir(subject))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", subject, ErrSubjectNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, "v") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if v, err := strconv.Atoi(strings.TrimSuffix(name[1:], ".json")); err == nil && v > 0 {
+			versions = append(versions, v)
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (r *Registry) Latest(subject string) (*pipeline.Schema, error) {
+	return r.Resolve(Ref{Subject: subject})
+}
+
+func (r *Registry) Get(subject string, version int) (*pipeline.Schema, error) {
+	return r.Resolve(Ref{Subject: subject, Version: version})
+}
+
+// Resolve 返回 ref 指向的 schema。返回值被缓存共享，调用方不能修改
+func (r *Registry) Resolve(ref Ref) (*pipeline.Schema, error) {
+	if ref.Version == 0 {
+		versions, err := r.Versions(ref.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("%s: %w", ref.Subject, ErrVersionNotFound)
+		}
+		ref.Version = versions[len(versions)-1]
+	}
+
+	r.mu.RLock()
+	s, ok := r.cache[ref]
+	r.mu.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	s, err := ReadFile(r.versionPath(ref.Subject, ref.Version))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", ref, ErrVersionNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Version = ref.Version
+
+	r.mu.Lock()
+	r.cache[ref] = s
+	r.mu.Unlock()
+	return s, nil
+}
+
+func (r *Registry) Compatibility(subject string) (Compatibility, error) {
+	data, err := os.ReadFile(filepath.Join(r.subjectDir(subject), "config.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return r.compat, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var config subjectConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", fmt.Errorf("decode %s config: %w", subject, err)
+	}
+	return ParseCompatibility(string(config.Compatibility))
+}
+
+func (r *Registry) SetCompatibility(subject string, compat Compatibility) error {
+	if !subjectPattern.MatchString(subject) {
+		return fmt.Errorf("invalid subject %q", subject)
+	}
+	if err := os.MkdirAll(r.subjectDir(subject), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(subjectConfig{Compatibility: compat})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.subjectDir(subject), "config.json"), data, 0o644)
+}
+
+// Register 在通过兼容性检查后注册新版本并返回版本号。
+// 与最新版本相同的 schema 不会重复注册，直接返回已有版本号
+func (r *Registry) Register(subject string, s *pipeline.Schema) (int, error) {
+	if !subjectPattern.MatchString(subject) {
+		return 0, fmt.Errorf("invalid subject %q", subject)
+	}
+	compat, err := r.Compatibility(subject)
+	if err != nil {
+		return 0, err
+	}
+	return r.register(subject, s, compat)
+}
+
+// RegisterWithCompatibility 按 compat 检查并注册 s，注册成功后才把 compat 保存为 subject 的规则，
+// 失败时 subject 的规则保持不变
+func (r *Registry) RegisterWithCompatibility(subject string, s *pipeline.Schema, compat Compatibility) (int, error) {
+	if !subjectPattern.MatchString(subject) {
+		return 0, fmt.Errorf("invalid subject %q", subject)
+	}
+	version, err := r.register(subject, s, compat)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.SetCompatibility(subject, compat); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (r *Registry) register(subject string, s *pipeline.Schema, compat Compatibility) (int, error) {
+	if err := os.MkdirAll(r.subjectDir(subject), 0o755); err != nil {
+		return 0, err
+	}
+
+	versions, err := r.Versions(subject)
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	if len(versions) > 0 {
+		latest, err := r.Get(subject, versions[len(versions)-1])
+		if err != nil {
+			return 0, err
+		}
+		candidate := *s
+		candidate.Version = latest.Version
+		if reflect.DeepEqual(normalize(&candidate), normalize(latest)) {
+			return latest.Version, nil
+		}
+
+		if err := CheckCompatibility(latest, s, compat); err != nil {
+			return 0, err
+		}
+		version = latest.Version + 1
+	}
+
+	registered := *s
+	registered.Version = version
+	if err := r.writeVersion(subject, &registered); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// writeVersion 先写临时文件再硬链接到目标路径，目标已存在时失败而不是覆盖
+func (r *Registry) writeVersion(subject string, s *pipeline.Schema) error {
+	tmp, err := os.CreateTemp(r.subjectDir(subject), ".register-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	if err := WriteFile(tmp.Name(), s); err != nil {
+		return err
+	}
+	if err := os.Link(tmp.Name(), r.versionPath(subject, s.Version)); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s version %d was registered concurrently", subject, s.Version)
+		}
+		return err
+	}
+	return nil
+}
+
+// normalize 经过一次 JSON 往返，使默认值等字段与从文件读取的结果可比较
+func normalize(s *pipeline.Schema) *pipeline.Schema {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return s
+	}
+	var n pipeline.Schema
+	if err := json.Unmarshal(data, &n); err != nil {
+		return s
+	}
+	return &n
+}
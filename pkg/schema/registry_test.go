@@ -0,0 +1,92 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func TestRegistry(t *testing.T) {
+	registry, err := NewRegistry(t.TempDir(), CompatBackward)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v1 := userSchemaV1()
+	version, err := registry.Register("users", v1)
+	if err != nil || version != 1 {
+		t.Fatalf("want version 1, got %d, %v", version, err)
+	}
+	if version, _ := registry.Register("users", v1); version != 1 {
+		t.Errorf("want identical schema deduplicated, got version %d", version)
+	}
+
+	// adding a required field without default breaks backward compatibility
+	broken := *v1
+	broken.Fields = append(append([]pipeline.Field{}, v1.Fields...),
+		pipeline.Field{Name: "email", Type: pipeline.TypeString, Required: true})
+	var incompatible *IncompatibleError
+	if _, err := registry.Register("users", &broken); !errors.As(err, &incompatible) {
+		t.Fatalf("want IncompatibleError, got %v", err)
+	}
+
+	v2 := broken
+	v2.Fields[len(v2.Fields)-1].Default = ""
+	if version, err := registry.Register("users", &v2); err != nil || version != 2 {
+		t.Fatalf("want version 2, got %d, %v", version, err)
+	}
+
+	ref, err := ParseRef("users@1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := registry.Resolve(ref)
+	if err != nil || len(s.Fields) != len(v1.Fields) || s.Version != 1 {
+		t.Errorf("want version 1 resolved, got %+v, %v", s, err)
+	}
+	latest, err := registry.Latest("users")
+	if err != nil || latest.Version != 2 {
+		t.Errorf("want latest version 2, got %+v, %v", latest, err)
+	}
+	if _, err := registry.Get("users", 3); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("want ErrVersionNotFound, got %v", err)
+	}
+	if _, err := registry.Latest("orders"); !errors.Is(err, ErrSubjectNotFound) {
+		t.Errorf("want ErrSubjectNotFound, got %v", err)
+	}
+
+	changes := Diff(s, latest)
+	if len(changes) != 1 || changes[0].Path != "email" || changes[0].Kind != ChangeAdded {
+		t.Errorf("want email added, got %v", changes)
+	}
+}
+
+func TestRegistry_RegisterWithCompatibility(t *testing.T) {
+	registry, err := NewRegistry(t.TempDir(), CompatBackward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := userSchemaV1()
+	if _, err := registry.Register("users", v1); err != nil {
+		t.Fatal(err)
+	}
+
+	broken := *v1
+	broken.Fields = append(append([]pipeline.Field{}, v1.Fields...),
+		pipeline.Field{Name: "email", Type: pipeline.TypeString, Required: true})
+	var incompatible *IncompatibleError
+	if _, err := registry.RegisterWithCompatibility("users", &broken, CompatFull); !errors.As(err, &incompatible) {
+		t.Fatalf("want IncompatibleError, got %v", err)
+	}
+	if compat, err := registry.Compatibility("users"); err != nil || compat != CompatBackward {
+		t.Errorf("want compatibility unchanged after a rejected schema, got %s, %v", compat, err)
+	}
+
+	if version, err := registry.RegisterWithCompatibility("users", &broken, CompatNone); err != nil || version != 2 {
+		t.Fatalf("want version 2, got %d, %v", version, err)
+	}
+	if compat, err := registry.Compatibility("users"); err != nil || compat != CompatNone {
+		t.Errorf("want compatibility saved, got %s, %v", compat, err)
+	}
+}
@@ -0,0 +1,131 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// Upcast 将按旧版本写出的记录转换为 target 版本：补齐缺失字段的默认值，
+// 提升数值类型，丢弃 target 中不存在的字段
+func Upcast(record *pipeline.Record, target *pipeline.Schema) (*pipeline.Record, error) {
+	data, err := upcastFields(record.Data, target.Fields, "")
+	if err != nil {
+		return nil, err
+	}
+	return &pipeline.Record{
+		Schema:    target,
+		Data:      data,
+		Timestamp: record.Timestamp,
+		Version:   target.Version,
+	}, nil
+}
+
+func upcastFields(data map[string]pipeline.Value, fields []pipeline.Field, prefix string) (map[string]pipeline.Value, error) {
+	result := make(map[string]pipeline.Value, len(fields))
+	for _, field := range fields {
+		path := joinPath(prefix, field.Name)
+		value, ok := data[field.Name]
+		if !ok {
+			if field.Default != nil {
+				v, err := defaultValue(field.Default, field)
+				if err != nil {
+					return nil, pipeline.Errorf(pipeline.KindConversion, "%s: default: %w", path, err)
+				}
+				result[field.Name] = v
+			} else if field.Required {
+				return nil, pipeline.Errorf(pipeline.KindValidation, "%s: required field missing and has no default", path)
+			}
+			continue
+		}
+
+		v, err := upcastValue(value, field, path)
+		if err != nil {
+			return nil, err
+		}
+		result[field.Name] = v
+	}
+	return result, nil
+}
+
+func upcastValue(value pipeline.Value, field pipeline.Field, path string) (pipeline.Value, error) {
+	if value.Value == nil {
+		return value, nil
+	}
+	switch {
+	case value.Type == pipeline.TypeInt64 && (field.Type == pipeline.TypeFloat64 || field.Type == pipeline.TypeDecimal):
+		v, err := pipeline.FromNative(value.Value, field)
+		if err != nil {
+			return value, pipeline.Errorf(pipeline.KindConversion, "%s: %w", path, err)
+		}
+		return v, nil
+	case value.Type != field.Type:
+		return value, pipeline.Errorf(pipeline.KindConversion, "%s: cannot upcast %s to %s", path, value.Type, field.Type)
+	}
+
+	switch field.Type {
+	case pipeline.TypeDict:
+		members, ok := value.Value.(map[string]pipeline.Value)
+		if !ok || len(field.Fields) == 0 {
+			return value, nil
+		}
+		upcast, err := upcastFields(members, field.Fields, path)
+		if err != nil {
+			return value, err
+		}
+		return pipeline.Value{Type: field.Type, Value: upcast}, nil
+	case pipeline.TypeList:
+		elems, ok := value.Value.([]pipeline.Value)
+		if !ok || field.Elem == nil {
+			return value, nil
+		}
+		upcast := make([]pipeline.Value, len(elems))
+		for i, elem := range elems {
+			v, err := upcastValue(elem, *field.Elem, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return value, err
+			}
+			upcast[i] = v
+		}
+		return pipeline.Value{Type: field.Type, Value: upcast}, nil
+	}
+	return value, nil
+}
+
+// defaultValue 将 schema 文件中解码得到的默认值转换为字段类型
+func defaultValue(raw any, field pipeline.Field) (pipeline.Value, error) {
+	switch field.Type {
+	case pipeline.TypeString:
+		if s, ok := raw.(string); ok {
+			return pipeline.Value{Type: field.Type, Value: s}, nil
+		}
+	case pipeline.TypeBoolean:
+		if b, ok := raw.(bool); ok {
+			return pipeline.Value{Type: field.Type, Value: b}, nil
+		}
+	case pipeline.TypeInt64:
+		switch n := raw.(type) {
+		case int:
+			return pipeline.Value{Type: field.Type, Value: int64(n)}, nil
+		case int64:
+			return pipeline.Value{Type: field.Type, Value: n}, nil
+		case float64:
+			if n == math.Trunc(n) {
+				return pipeline.Value{Type: field.Type, Value: int64(n)}, nil
+			}
+		}
+	case pipeline.TypeFloat64:
+		switch n := raw.(type) {
+		case int:
+			return pipeline.Value{Type: field.Type, Value: float64(n)}, nil
+		case int64:
+			return pipeline.Value{Type: field.Type, Value: float64(n)}, nil
+		case float64:
+			return pipeline.Value{Type: field.Type, Value: n}, nil
+		}
+	default:
+		return pipeline.FromNative(raw, field)
+	}
+	return pipeline.Value{}, fmt.Errorf("cannot use %T as %s", raw, field.Type)
+}
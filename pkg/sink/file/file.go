@@ -0,0 +1,201 @@
+package file
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/codec/jsonl"
+	"github.com/ipush/littlepipe/pkg/compress"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// FileSink 以两阶段提交写文件：写入先进入隐藏的 in-progress 文件，
+// Prepare 将其落盘并改名为 pending，Commit 才发布为 <prefix>-<txn><ext>
+type FileSink struct {
+	dir         string
+	prefix      string
+	ext         string
+	codec       codec.Codec
+	compression string
+
+	file       *os.File
+	writer     *bufio.Writer
+	compressor io.WriteCloser
+	encoder    codec.Encoder
+}
+
+// NewFileSink 以 JSON Lines 格式写文件
+func NewFileSink(dir, prefix, ext string) (*FileSink, error) {
+	return NewFileSinkWithCodec(dir, prefix, ext, jsonl.New(jsonl.Config{}))
+}
+
+// NewFileSinkWithCodec 每个文件使用独立的 Encoder，表头等只写一次的内容出现在每个发布的文件中
+func NewFileSinkWithCodec(dir, prefix, ext string, c codec.Codec) (*FileSink, error) {
+	return NewFileSinkWithCompression(dir, prefix, ext, c, compress.None)
+}
+
+// NewFileSinkWithCompression 每个文件单独压缩，发布的文件名在 ext 之后加上压缩格式的扩展名，
+// 例如 .jsonl.gz
+func NewFileSinkWithCompression(dir, prefix, ext string, c codec.Codec, compression string) (*FileSink, error) {
+	if err := compress.Check(compression, false); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileSink{dir: dir, prefix: prefix, ext: ext + compress.Ext(compression), codec: c, compression: compression}, nil
+}
+
+func (s *FileSink) inProgressPath() string {
+	return filepath.Join(s.dir, "."+s.prefix+".inprogress")
+}
+
+func (s *FileSink) pendingPath(txn uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf(".%s-%020d.pending", s.prefix, txn))
+}
+
+func (s *FileSink) publishedPath(txn uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s-%020d%s", s.prefix, txn, s.ext))
+}
+
+func (s *FileSink) Write(data *pipeline.Message) error {
+	if data.Payload == nil {
+		return fmt.Errorf("FileSink: message %s has no payload", data.ID)
+	}
+	if s.file == nil {
+		f, err := os.OpenFile(s.inProgressPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		s.file = f
+		s.writer = bufio.NewWriter(f)
+		if s.compressor, err = compress.NewWriter(s.writer, s.compression); err != nil {
+			f.Close()
+			s.file, s.writer = nil, nil
+			return err
+		}
+		s.encoder = codec.NewEncoder(s.codec, s.compressor)
+	}
+	return s.encoder.Encode(data.Payload)
+}
+
+// Prepare 将 in-progress 文件持久化为 pending，之后崩溃也不会丢失
+func (s *FileSink) Prepare(txn uint64) error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.encoder.Close(); err != nil {
+		return err
+	}
+	if err := s.compressor.Close(); err != nil {
+		return err
+	}
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file, s.writer, s.compressor, s.encoder = nil, nil, nil, nil
+	return os.Rename(s.inProgressPath(), s.pendingPath(txn))
+}
+
+func (s *FileSink) Commit(txn uint64) error {
+	err := os.Rename(s.pendingPath(txn), s.publishedPath(txn))
+	if errors.Is(err, os.ErrNotExist) {
+		// empty transaction or already published
+		return nil
+	}
+	return err
+}
+
+func (s *FileSink) Abort(txn uint64) error {
+	if s.file != nil {
+		// releases the compressor, the data it writes is discarded with the file
+		s.compressor.Close()
+		s.file.Close()
+		s.file, s.writer, s.compressor, s.encoder = nil, nil, nil, nil
+	}
+	if err := os.Remove(s.inProgressPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.pendingPath(txn)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Recover 发布 checkpoint 已覆盖但尚未发布的 pending 文件，删除其余未提交的文件
+func (s *FileSink) Recover(committed uint64) error {
+	if err := os.Remove(s.inProgressPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	pending, err := filepath.Glob(filepath.Join(s.dir, "."+s.prefix+"-*.pending"))
+	if err != nil {
+		return err
+	}
+	for _, path := range pending {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "."+s.prefix+"-"), ".pending")
+		txn, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		if txn <= committed {
+			err = s.Commit(txn)
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 在非 exactly-once 模式下直接发布当前文件，序号接在已发布和 pending 的文件之后，
+// 不会覆盖尚未发布的事务
+func (s *FileSink) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	txn, err := s.lastTxn()
+	if err != nil {
+		return err
+	}
+	if err := s.Prepare(txn + 1); err != nil {
+		return err
+	}
+	return s.Commit(txn + 1)
+}
+
+// lastTxn 返回已发布和 pending 文件中最大的事务序号
+func (s *FileSink) lastTxn() (uint64, error) {
+	var last uint64
+	for _, pattern := range []struct{ prefix, suffix string }{
+		{s.prefix + "-", s.ext},
+		{"." + s.prefix + "-", ".pending"},
+	} {
+		paths, err := filepath.Glob(filepath.Join(s.dir, pattern.prefix+"*"+pattern.suffix))
+		if err != nil {
+			return 0, err
+		}
+		for _, path := range paths {
+			name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), pattern.prefix), pattern.suffix)
+			if txn, err := strconv.ParseUint(name, 10, 64); err == nil && txn > last {
+				last = txn
+			}
+		}
+	}
+	return last, nil
+}
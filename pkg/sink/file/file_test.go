@@ -0,0 +1,265 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/checkpoint"
+	"github.com/ipush/littlepipe/pkg/codec/jsonl"
+	"github.com/ipush/littlepipe/pkg/compress"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	filesource "github.com/ipush/littlepipe/pkg/source/file"
+)
+
+// failOnce 第一次遇到指定行时失败，模拟崩溃
+type failOnce struct {
+	line   string
+	failed bool
+}
+
+func (s *failOnce) Process(msg *pipeline.Message) (*pipeline.Message, error) {
+	if !s.failed && msg.Payload.Data[filesource.LineField].Value == s.line {
+		s.failed = true
+		return nil, errors.New("crash")
+	}
+	return msg, nil
+}
+
+func readPublished(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "out-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	var lines []string
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.Split(strings.TrimSpace(string(data)), "\n")...)
+	}
+	return lines
+}
+
+func TestFileSink_ExactlyOnce(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte("a\nb\nc\nd\ne\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "out")
+	store := checkpoint.NewMemoryStore()
+	stage := &failOnce{line: "c"}
+
+	run := func() error {
+		source, err := filesource.NewFileSource(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer source.Close()
+		sink, err := NewFileSink(outDir, "out", ".jsonl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pipeline.NewLittlePipe(pipeline.Config{
+			ExactlyOnce: &pipeline.ExactlyOnceConfig{Name: "test", Store: store, CommitEvery: 2},
+		}).SetSource(source).AddStage(stage).SetSink(sink).Run()
+	}
+
+	if err := run(); err == nil {
+		t.Fatal("want first run to fail")
+	}
+	// depending on when the failure cancels the run, the first transaction
+	// may or may not be committed, but nothing after the failure is
+	if got := readPublished(t, outDir); len(got) > 2 {
+		t.Fatalf("want at most the first transaction published, got %v", got)
+	}
+
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	got := readPublished(t, outDir)
+	want := []string{`{"line":"a"}`, `{"line":"b"}`, `{"line":"c"}`, `{"line":"d"}`, `{"line":"e"}`}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	if leftovers, _ := filepath.Glob(filepath.Join(outDir, ".*")); len(leftovers) != 0 {
+		t.Errorf("want no uncommitted files, got %v", leftovers)
+	}
+}
+
+func TestFileSink_RecoverPublishesCommittedPending(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir, "out", ".jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for txn := uint64(1); txn <= 2; txn++ {
+		msg := pipeline.NewMessage(&pipeline.Record{Data: map[string]pipeline.Value{
+			"n": {Type: pipeline.TypeInt64, Value: int64(txn)},
+		}})
+		if err := sink.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Prepare(txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// checkpoint only covers txn 1: publish it, drop txn 2
+	if err := sink.Recover(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := readPublished(t, dir); len(got) != 1 || got[0] != `{"n":1}` {
+		t.Errorf("want only txn 1 published, got %v", got)
+	}
+	if _, err := os.Stat(sink.pendingPath(2)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want txn 2 aborted, got %v", err)
+	}
+}
+
+func TestFileSink_PublishedWhenRunCompletes(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(input, []byte("a\nb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := filesource.NewFileSource(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	outDir := filepath.Join(dir, "out")
+	sink, err := NewFileSink(outDir, "out", ".jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without exactly-once only Close publishes, and Run calls it after the last write
+	if err := pipeline.NewLittlePipe(pipeline.Config{}).SetSource(source).SetSink(sink).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readPublished(t, outDir); strings.Join(got, ",") != `{"line":"a"},{"line":"b"}` {
+		t.Errorf("published %v", got)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(outDir, ".*")); len(leftovers) != 0 {
+		t.Errorf("want nothing in progress, got %v", leftovers)
+	}
+}
+
+func TestFileSink_CloseSkipsPendingTxn(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(dir, "out", ".jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(n int64) {
+		msg := pipeline.NewMessage(&pipeline.Record{Data: map[string]pipeline.Value{"n": pipeline.ValueOf(n)}})
+		if err := sink.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(1)
+	if err := sink.Prepare(1); err != nil {
+		t.Fatal(err)
+	}
+	write(2)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(sink.pendingPath(1)); err != nil {
+		t.Errorf("pending txn 1 was touched: %v", err)
+	}
+	if data, err := os.ReadFile(sink.publishedPath(2)); err != nil || string(data) != "{\"n\":2}\n" {
+		t.Errorf("want close published as txn 2, got %q, %v", data, err)
+	}
+}
+
+func TestFileSink_CompressedReplay(t *testing.T) {
+	for _, compression := range []string{compress.Gzip, compress.Zstd, compress.Snappy, compress.LZ4} {
+		dir := t.TempDir()
+		sink, err := NewFileSinkWithCompression(dir, "out", ".jsonl", jsonl.New(jsonl.Config{}), compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for txn := uint64(1); txn <= 2; txn++ {
+			for i := 0; i < 3; i++ {
+				msg := pipeline.NewMessage(&pipeline.Record{Data: map[string]pipeline.Value{
+					"n": pipeline.ValueOf(int(txn)*10 + i),
+				}})
+				if err := sink.Write(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Prepare(txn); err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Commit(txn); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// two published files appended into one archive are read as a single stream
+		paths, err := filepath.Glob(filepath.Join(dir, "out-*.jsonl"+compress.Ext(compression)))
+		if err != nil || len(paths) != 2 {
+			t.Fatalf("%s: published %v, %v", compression, paths, err)
+		}
+		var archive []byte
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive = append(archive, data...)
+		}
+		path := filepath.Join(dir, "archive")
+		if err := os.WriteFile(path, archive, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		source, err := filesource.NewFileSourceWithCodec(path, jsonl.New(jsonl.Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		var position []byte
+		for {
+			msg, err := source.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", compression, err)
+			}
+			got = append(got, fmt.Sprint(msg.Payload.Data["n"].Value))
+			if len(got) == 4 {
+				if position, err = source.Position(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if strings.Join(got, ",") != "10,11,12,20,21,22" {
+			t.Errorf("%s: read %v", compression, got)
+		}
+
+		// positions are offsets in the decompressed stream
+		if err := source.Seek(position); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := source.Read()
+		if err != nil || !msg.Payload.Data["n"].Equal(pipeline.ValueOf(21)) {
+			t.Errorf("%s: after seek %v, %v", compression, msg, err)
+		}
+		source.Close()
+	}
+}
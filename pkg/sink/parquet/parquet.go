@@ -0,0 +1,311 @@
+// Package parquet 把记录按 row group 写入 Parquet 文件，按大小或时间滚动文件
+package parquet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	parquetgo "github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const (
+	Ext = ".parquet"
+
+	DefaultRowGroupSize = 64 * 1024
+	DefaultCompression  = "snappy"
+)
+
+type Config struct {
+	Dir    string
+	Prefix string
+	// Schema 为空时使用第一条记录的 Schema。不在 schema 中的字段不写入
+	Schema *pipeline.Schema
+	// Compression none、snappy、gzip、zstd、lz4 或 brotli，默认为 snappy
+	Compression string
+	// RowGroupSize 每个 row group 的行数，默认为 DefaultRowGroupSize
+	RowGroupSize int64
+	// MaxFileSize 文件达到该字节数后滚动，0 表示不限。大小在 row group 写出后检查，
+	// 因此文件最多超出一个 row group
+	MaxFileSize int64
+	// RollInterval 文件打开超过该时长后滚动，没有新记录时也会按时关闭，0 表示不限
+	RollInterval time.Duration
+	// PageStatistics 除了每个 column chunk 的 min、max 和 null 计数，也在每个数据页中写入统计信息
+	PageStatistics bool
+}
+
+// ParquetSink 先写入隐藏的 in-progress 文件，滚动或 Close 时写出 footer 并发布为
+// <prefix>-<seq>.parquet，因此发布的文件总是完整的。进程异常退出时 in-progress 文件没有 footer，
+// 下次创建 sink 时被删除，这部分记录需要由上游重放
+type ParquetSink struct {
+	config Config
+	codec  compress.Codec
+
+	mu      sync.Mutex
+	schema  *parquetgo.Schema
+	root    *column
+	file    *os.File
+	buffer  *bufio.Writer
+	counter *countingWriter
+	writer  *parquetgo.Writer
+	// rows 当前 row group 中的行数
+	rows   int64
+	opened time.Time
+	timer  *time.Timer
+	seq    uint64
+	// err 记录后台滚动的失败，由下一次 Write 或 Close 返回
+	err error
+}
+
+func NewParquetSink(config Config) (*ParquetSink, error) {
+	if config.Prefix == "" {
+		return nil, fmt.Errorf("ParquetSink: prefix is required")
+	}
+	if config.Compression == "" {
+		config.Compression = DefaultCompression
+	}
+	codec, err := compressionCodec(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if config.RowGroupSize <= 0 {
+		config.RowGroupSize = DefaultRowGroupSize
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &ParquetSink{config: config, codec: codec}
+	if config.Schema != nil {
+		if err := s.setSchema(config.Schema); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(s.inProgressPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if s.seq, err = s.lastPublished(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func compressionCodec(name string) (compress.Codec, error) {
+	switch strings.ToLower(name) {
+	case "none", "uncompressed":
+		return &parquetgo.Uncompressed, nil
+	case "snappy":
+		return &parquetgo.Snappy, nil
+	case "gzip":
+		return &parquetgo.Gzip, nil
+	case "zstd":
+		return &parquetgo.Zstd, nil
+	case "lz4":
+		return &parquetgo.Lz4Raw, nil
+	case "brotli":
+		return &parquetgo.Brotli, nil
+	}
+	return nil, fmt.Errorf("ParquetSink: unknown compression %q, valid: none, snappy, gzip, zstd, lz4, brotli", name)
+}
+
+func (s *ParquetSink) setSchema(ps *pipeline.Schema) error {
+	schema, err := SchemaOf(ps)
+	if err != nil {
+		return fmt.Errorf("ParquetSink: %w", err)
+	}
+	s.config.Schema = ps
+	s.schema = schema
+	next := 0
+	s.root = columnsOf(schema, "", &next)
+	return nil
+}
+
+func (s *ParquetSink) inProgressPath() string {
+	return filepath.Join(s.config.Dir, "."+s.config.Prefix+".inprogress")
+}
+
+func (s *ParquetSink) publishedPath(seq uint64) string {
+	return filepath.Join(s.config.Dir, fmt.Sprintf("%s-%020d%s", s.config.Prefix, seq, Ext))
+}
+
+func (s *ParquetSink) Write(data *pipeline.Message) error {
+	if data.Payload == nil {
+		return fmt.Errorf("ParquetSink: message %s has no payload", data.ID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.takeErr(); err != nil {
+		return err
+	}
+
+	if s.schema == nil {
+		if data.Payload.Schema == nil {
+			return fmt.Errorf("ParquetSink: message %s has no schema and none is configured", data.ID)
+		}
+		if err := s.setSchema(data.Payload.Schema); err != nil {
+			return err
+		}
+	}
+	row, err := s.row(data.Payload)
+	if err != nil {
+		return pipeline.Errorf(pipeline.KindConversion, "ParquetSink: message %s: %w", data.ID, err)
+	}
+	if s.writer == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.writer.WriteRows([]parquetgo.Row{row}); err != nil {
+		return err
+	}
+	if s.rows++; s.rows >= s.config.RowGroupSize {
+		if err := s.writer.Flush(); err != nil {
+			return err
+		}
+		s.rows = 0
+	}
+
+	if s.config.MaxFileSize > 0 && s.counter.n >= s.config.MaxFileSize ||
+		s.config.RollInterval > 0 && time.Since(s.opened) >= s.config.RollInterval {
+		return s.roll()
+	}
+	return nil
+}
+
+// row 先完整转换记录，转换失败时不会写入半条记录
+func (s *ParquetSink) row(record *pipeline.Record) (parquetgo.Row, error) {
+	members, err := group(s.config.Schema.Fields, record.Data)
+	if err != nil {
+		return nil, err
+	}
+	r := &row{columns: make([][]parquetgo.Value, s.root.leaves)}
+	if err := r.value(s.root, members, 0, 0, 0); err != nil {
+		return nil, err
+	}
+	var out parquetgo.Row
+	for _, values := range r.columns {
+		out = append(out, values...)
+	}
+	return out, nil
+}
+
+func (s *ParquetSink) open() error {
+	f, err := os.OpenFile(s.inProgressPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	s.buffer = bufio.NewWriter(f)
+	// counted before the buffer so that MaxFileSize sees every row group as soon as it is written
+	s.counter = &countingWriter{w: s.buffer}
+	s.rows = 0
+	s.writer = parquetgo.NewWriter(s.counter, s.schema,
+		parquetgo.Compression(s.codec),
+		parquetgo.WriteBufferSize(0),
+		parquetgo.DataPageStatistics(s.config.PageStatistics),
+		parquetgo.CreatedBy("littlepipe", "", ""),
+	)
+	s.opened = time.Now()
+	if s.config.RollInterval > 0 {
+		writer := s.writer
+		s.timer = time.AfterFunc(s.config.RollInterval, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			// the file may already have been rolled by a write
+			if s.writer == writer {
+				if err := s.roll(); err != nil && s.err == nil {
+					s.err = err
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// roll 写出 footer 并发布当前文件，调用方持有锁
+func (s *ParquetSink) roll() error {
+	if s.writer == nil {
+		return nil
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	writer, buffer, file := s.writer, s.buffer, s.file
+	s.writer, s.buffer, s.file, s.counter = nil, nil, nil, nil
+
+	if err := writer.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := buffer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	s.seq++
+	return os.Rename(s.inProgressPath(), s.publishedPath(s.seq))
+}
+
+func (s *ParquetSink) takeErr() error {
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Flush 立即发布当前文件，没有打开的文件时什么也不做
+func (s *ParquetSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.takeErr(); err != nil {
+		return err
+	}
+	return s.roll()
+}
+
+// Close 发布当前文件。LittlePipe.Run 正常结束或被 Stop 时会调用它，所有写入的记录都在完整的文件中
+func (s *ParquetSink) Close() error {
+	return s.Flush()
+}
+
+func (s *ParquetSink) lastPublished() (uint64, error) {
+	published, err := filepath.Glob(filepath.Join(s.config.Dir, s.config.Prefix+"-*"+Ext))
+	if err != nil {
+		return 0, err
+	}
+	var last uint64
+	for _, path := range published {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), s.config.Prefix+"-"), Ext)
+		if seq, err := strconv.ParseUint(name, 10, 64); err == nil && seq > last {
+			last = seq
+		}
+	}
+	return last, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,381 @@
+package parquet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	parquetgo "github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func testSchema() *pipeline.Schema {
+	scale := 2
+	return &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "id", Type: pipeline.TypeInt64, Required: true},
+		{Name: "name", Type: pipeline.TypeString, Nullable: true},
+		{Name: "price", Type: pipeline.TypeDecimal, Scale: &scale},
+		{Name: "at", Type: pipeline.TypeTimestamp, Precision: pipeline.PrecisionMilli},
+		{Name: "tags", Type: pipeline.TypeList, Elem: &pipeline.Field{Type: pipeline.TypeString}},
+		{Name: "attrs", Type: pipeline.TypeDict, Elem: &pipeline.Field{Type: pipeline.TypeFloat64}},
+		{Name: "address", Type: pipeline.TypeDict, Fields: []pipeline.Field{
+			{Name: "city", Type: pipeline.TypeString, Required: true},
+		}},
+	}}
+}
+
+func message(i int) *pipeline.Message {
+	data := map[string]pipeline.Value{
+		"id":    pipeline.ValueOf(int64(i)),
+		"price": pipeline.ValueOf(pipeline.MustParseDecimal(fmt.Sprintf("-%d.5", i))),
+		"at":    pipeline.ValueOf(time.UnixMilli(int64(1700000000000 + i)).UTC()),
+		"tags":  pipeline.ValueOf([]any{"a", fmt.Sprint(i)}),
+		"attrs": pipeline.ValueOf(map[string]any{"w": float64(i)}),
+		"extra": pipeline.ValueOf("not in the schema"),
+	}
+	if i%2 == 0 {
+		data["name"] = pipeline.ValueOf(fmt.Sprintf("n%d", i))
+		data["address"] = pipeline.ValueOf(map[string]any{"city": "Oslo"})
+	}
+	return &pipeline.Message{ID: fmt.Sprint(i), Payload: &pipeline.Record{Data: data}}
+}
+
+func openFile(t *testing.T, path string) *parquetgo.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf, err := parquetgo.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pf
+}
+
+func published(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "out-*"+Ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestParquetSink_RowGroupsAndValues(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewParquetSink(Config{Dir: dir, Prefix: "out", Schema: testSchema(), Compression: "zstd", RowGroupSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(message(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(published(t, dir)) != 0 {
+		t.Fatal("file published before Close")
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := published(t, dir)
+	if len(paths) != 1 {
+		t.Fatalf("published = %v", paths)
+	}
+	f := openFile(t, paths[0])
+	if f.NumRows() != 10 {
+		t.Errorf("rows = %d", f.NumRows())
+	}
+	groups := f.Metadata().RowGroups
+	if len(groups) != 3 {
+		t.Errorf("row groups = %d", len(groups))
+	}
+	for _, c := range groups[0].Columns {
+		if c.MetaData.Codec != format.Zstd {
+			t.Errorf("%v codec = %v", c.MetaData.PathInSchema, c.MetaData.Codec)
+		}
+	}
+
+	id, _ := f.Schema().Lookup("id")
+	stats := groups[1].Columns[id.ColumnIndex].MetaData.Statistics
+	if len(stats.MinValue) != 8 || len(stats.MaxValue) != 8 {
+		t.Fatalf("id statistics = %+v", stats)
+	}
+	if min, max := binary.LittleEndian.Uint64(stats.MinValue), binary.LittleEndian.Uint64(stats.MaxValue); min != 4 || max != 7 {
+		t.Errorf("id range of the second row group = %d..%d", min, max)
+	}
+	name, _ := f.Schema().Lookup("name")
+	if n := groups[1].Columns[name.ColumnIndex].MetaData.Statistics.NullCount; n != 2 {
+		t.Errorf("name null count = %d", n)
+	}
+
+	rows := make([]parquetgo.Row, 10)
+	reader := parquetgo.NewReader(f)
+	for n := 0; n < len(rows); {
+		read, err := reader.ReadRows(rows[n:])
+		if read == 0 {
+			t.Fatalf("read %d rows: %v", n, err)
+		}
+		// the reader reuses its page buffers for byte array values
+		for i := n; i < n+read; i++ {
+			rows[i] = rows[i].Clone()
+		}
+		n += read
+	}
+	values := func(path ...string) []parquetgo.Value {
+		leaf, ok := f.Schema().Lookup(path...)
+		if !ok {
+			t.Fatalf("no column %v", path)
+		}
+		var out []parquetgo.Value
+		for _, v := range rows[3] {
+			if v.Column() == leaf.ColumnIndex {
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+	if v := values("id"); len(v) != 1 || v[0].Int64() != 3 {
+		t.Errorf("id = %v", v)
+	}
+	if v := values("name"); len(v) != 1 || !v[0].IsNull() {
+		t.Errorf("name = %v", v)
+	}
+	if v := values("address", "city"); len(v) != 1 || !v[0].IsNull() {
+		t.Errorf("address.city = %v", v)
+	}
+	if v := values("tags", "list", "element"); len(v) != 2 || v[0].String() != "a" || v[1].String() != "3" ||
+		v[0].RepetitionLevel() != 0 || v[1].RepetitionLevel() != 1 {
+		t.Errorf("tags = %v", v)
+	}
+	if k, v := values("attrs", "key_value", "key"), values("attrs", "key_value", "value"); len(k) != 1 || k[0].String() != "w" ||
+		len(v) != 1 || v[0].Double() != 3 {
+		t.Errorf("attrs = %v %v", k, v)
+	}
+	price := values("price")
+	if len(price) != 1 || pipeline.NewDecimalFromBigInt(bigFromTwos(price[0].ByteArray()), 2).String() != "-3.50" {
+		t.Errorf("price = %v", price)
+	}
+	if v := values("at"); len(v) != 1 || v[0].Int64() != 1700000000003 {
+		t.Errorf("at = %v", v)
+	}
+}
+
+func TestParquetSink_RollBySize(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewParquetSink(Config{Dir: dir, Prefix: "out", Schema: testSchema(), RowGroupSize: 2, MaxFileSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := sink.Write(message(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	paths := published(t, dir)
+	if len(paths) != 3 {
+		t.Fatalf("published = %v", paths)
+	}
+	var total int64
+	for _, path := range paths {
+		total += openFile(t, path).NumRows()
+	}
+	if total != 5 {
+		t.Errorf("rows = %d", total)
+	}
+}
+
+// messages 依次返回 message(0) 到 message(n-1)
+type messages struct{ next, n int }
+
+func (s *messages) Read() (*pipeline.Message, error) {
+	if s.next == s.n {
+		return nil, io.EOF
+	}
+	s.next++
+	return message(s.next - 1), nil
+}
+
+func TestParquetSink_PipelineRun(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewParquetSink(Config{Dir: dir, Prefix: "out", Schema: testSchema(), RowGroupSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a finished run closes the sink, so the rows end up in a file with a footer
+	if err := pipeline.NewLittlePipe(pipeline.Config{}).SetSource(&messages{n: 5}).SetSink(sink).Run(); err != nil {
+		t.Fatal(err)
+	}
+	paths := published(t, dir)
+	if len(paths) != 1 {
+		t.Fatalf("published = %v", paths)
+	}
+	if rows := openFile(t, paths[0]).NumRows(); rows != 5 {
+		t.Errorf("rows = %d", rows)
+	}
+	if _, err := os.Stat(sink.inProgressPath()); !os.IsNotExist(err) {
+		t.Errorf("in-progress file left behind: %v", err)
+	}
+}
+
+// pausedMessages 返回 n 条消息后阻塞，直到 resume 被关闭
+type pausedMessages struct {
+	messages
+	resume chan struct{}
+}
+
+func (s *pausedMessages) Read() (*pipeline.Message, error) {
+	if s.next == s.n {
+		<-s.resume
+		return nil, io.EOF
+	}
+	return s.messages.Read()
+}
+
+// countingSink 在写完 n 条消息时关闭 written
+type countingSink struct {
+	*ParquetSink
+	n       int
+	written chan struct{}
+}
+
+func (s *countingSink) Write(msg *pipeline.Message) error {
+	err := s.ParquetSink.Write(msg)
+	if s.n--; s.n == 0 {
+		close(s.written)
+	}
+	return err
+}
+
+func TestParquetSink_PublishedOnStop(t *testing.T) {
+	dir := t.TempDir()
+	parquetSink, err := NewParquetSink(Config{Dir: dir, Prefix: "out", Schema: testSchema(), RowGroupSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &pausedMessages{messages: messages{n: 3}, resume: make(chan struct{})}
+	defer close(source.resume)
+	sink := &countingSink{ParquetSink: parquetSink, n: 3, written: make(chan struct{})}
+	p := pipeline.NewLittlePipe(pipeline.Config{}).SetSource(source).SetSink(sink)
+
+	done := make(chan error, 1)
+	go func() { done <- p.Run() }()
+	select {
+	case <-sink.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rows not written")
+	}
+	// a graceful shutdown while the source is idle still leaves a complete file
+	p.Stop()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("want canceled, got %v", err)
+	}
+	paths := published(t, dir)
+	if len(paths) != 1 {
+		t.Fatalf("published = %v", paths)
+	}
+	if rows := openFile(t, paths[0]).NumRows(); rows != 3 {
+		t.Errorf("rows = %d", rows)
+	}
+}
+
+func TestParquetSink_RollByTime(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewParquetSink(Config{Dir: dir, Prefix: "out", RollInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message(1)
+	msg.Payload.Schema = testSchema()
+	if err := sink.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	// an idle file is closed by the timer
+	deadline := time.Now().Add(2 * time.Second)
+	for len(published(t, dir)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if paths := published(t, dir); len(paths) != 1 || openFile(t, paths[0]).NumRows() != 1 {
+		t.Fatalf("published = %v", paths)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new sink continues the sequence and removes the leftover in-progress file
+	if err := os.WriteFile(filepath.Join(dir, ".out.inprogress"), []byte("PAR1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sink, err = NewParquetSink(Config{Dir: dir, Prefix: "out", Schema: testSchema()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(message(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if paths := published(t, dir); len(paths) != 2 || filepath.Base(paths[1]) != fmt.Sprintf("out-%020d.parquet", 2) {
+		t.Errorf("published = %v", paths)
+	}
+}
+
+func TestParquetSink_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewParquetSink(Config{Dir: dir, Prefix: "out", Compression: "lzo"}); err == nil {
+		t.Error("unknown compression accepted")
+	}
+	if _, err := NewParquetSink(Config{Dir: dir, Prefix: "out", Schema: &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "amount", Type: pipeline.TypeDecimal},
+	}}}); err == nil {
+		t.Error("decimal without scale accepted")
+	}
+
+	sink, err := NewParquetSink(Config{Dir: dir, Prefix: "out", Schema: testSchema()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := message(1)
+	delete(msg.Payload.Data, "id")
+	if err := sink.Write(msg); pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("missing required field: %v", err)
+	}
+	if err := sink.Write(message(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if paths := published(t, dir); len(paths) != 1 || openFile(t, paths[0]).NumRows() != 1 {
+		t.Errorf("published = %v", paths)
+	}
+}
+
+// bigFromTwos 解码 FIXED_LEN_BYTE_ARRAY 中的二进制补码
+func bigFromTwos(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return n
+}
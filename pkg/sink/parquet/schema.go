@@ -0,0 +1,345 @@
+package parquet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	parquetgo "github.com/parquet-go/parquet-go"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/schema"
+)
+
+// decimalPrecision decimal 列使用的精度，对应 16 字节的 FIXED_LEN_BYTE_ARRAY
+const (
+	decimalPrecision = 38
+	decimalSize      = 16
+)
+
+// SchemaOf 由 pipeline.Schema 生成 Parquet schema。非 Required 或 Nullable 的字段为 optional；
+// int64、float64、boolean、bytes 为对应的物理类型，string 和 json 为带注解的 BYTE_ARRAY，
+// decimal 为 DECIMAL(38, Scale)，必须设置 Scale；timestamp 按 Precision 为 TIMESTAMP(MILLIS/MICROS/NANOS)，
+// duration 为按 Precision 计数的 int64；list 为 LIST，dict 有 Fields 时为嵌套的 group，
+// 只有 Elem 时为键为字符串的 MAP，都没有时与 unknown 一样保存为 json
+func SchemaOf(s *pipeline.Schema) (*parquetgo.Schema, error) {
+	group := make(parquetgo.Group, len(s.Fields))
+	for _, f := range s.Fields {
+		node, err := nodeOf(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		group[f.Name] = node
+	}
+	name := s.Metadata[schema.MetadataName]
+	if name == "" {
+		name = "Record"
+	}
+	return parquetgo.NewSchema(name, group), nil
+}
+
+func optional(f pipeline.Field) bool {
+	return f.Nullable || !f.Required || f.Type == pipeline.TypeNull
+}
+
+func nodeOf(f pipeline.Field) (parquetgo.Node, error) {
+	node, err := typeNode(f)
+	if err != nil {
+		return nil, err
+	}
+	if optional(f) {
+		return parquetgo.Optional(node), nil
+	}
+	return parquetgo.Required(node), nil
+}
+
+func typeNode(f pipeline.Field) (parquetgo.Node, error) {
+	switch f.Type {
+	case pipeline.TypeString:
+		return parquetgo.String(), nil
+	case pipeline.TypeInt64, pipeline.TypeDuration:
+		return parquetgo.Int(64), nil
+	case pipeline.TypeFloat64:
+		return parquetgo.Leaf(parquetgo.DoubleType), nil
+	case pipeline.TypeBoolean:
+		return parquetgo.Leaf(parquetgo.BooleanType), nil
+	case pipeline.TypeBytes:
+		return parquetgo.Leaf(parquetgo.ByteArrayType), nil
+	case pipeline.TypeDecimal:
+		if f.Scale == nil {
+			return nil, fmt.Errorf("decimal columns need a Scale")
+		}
+		if *f.Scale < 0 || *f.Scale > decimalPrecision {
+			return nil, fmt.Errorf("decimal scale %d out of range", *f.Scale)
+		}
+		return parquetgo.Decimal(*f.Scale, decimalPrecision, parquetgo.FixedLenByteArrayType(decimalSize)), nil
+	case pipeline.TypeTimestamp:
+		switch f.Precision {
+		case pipeline.PrecisionSecond, pipeline.PrecisionMilli:
+			return parquetgo.Timestamp(parquetgo.Millisecond), nil
+		case pipeline.PrecisionMicro:
+			return parquetgo.Timestamp(parquetgo.Microsecond), nil
+		}
+		return parquetgo.Timestamp(parquetgo.Nanosecond), nil
+	case pipeline.TypeList:
+		elem, err := nodeOf(elemField(f))
+		if err != nil {
+			return nil, err
+		}
+		return parquetgo.List(elem), nil
+	case pipeline.TypeDict:
+		if len(f.Fields) > 0 {
+			group := make(parquetgo.Group, len(f.Fields))
+			for _, member := range f.Fields {
+				node, err := nodeOf(member)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", member.Name, err)
+				}
+				group[member.Name] = node
+			}
+			return group, nil
+		}
+		if f.Elem != nil {
+			value, err := nodeOf(*f.Elem)
+			if err != nil {
+				return nil, err
+			}
+			return parquetgo.Map(parquetgo.String(), value), nil
+		}
+		return parquetgo.JSON(), nil
+	case pipeline.TypeJSON, pipeline.TypeUnknown, pipeline.TypeNull:
+		return parquetgo.JSON(), nil
+	}
+	return nil, fmt.Errorf("type %s has no Parquet equivalent", f.Type)
+}
+
+// elemField list 没有 Elem 时元素保存为可空的 json
+func elemField(f pipeline.Field) pipeline.Field {
+	if f.Elem == nil {
+		return pipeline.Field{Type: pipeline.TypeJSON, Nullable: true}
+	}
+	return *f.Elem
+}
+
+// native 把值转换为与 Parquet 物理结构相同的树：group 为 map[string]any，
+// repeated 字段为 []any，叶子为 parquetgo.Value，空值为 nil
+func native(f pipeline.Field, v pipeline.Value) (any, error) {
+	if v.IsNull() {
+		return nil, nil
+	}
+	switch f.Type {
+	case pipeline.TypeList:
+		list, ok := v.Value.([]pipeline.Value)
+		if !ok {
+			return nil, fmt.Errorf("expected list, got %s", v.Type)
+		}
+		elem := elemField(f)
+		items := make([]any, len(list))
+		for i, item := range list {
+			n, err := native(elem, item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			items[i] = map[string]any{"element": n}
+		}
+		return map[string]any{"list": items}, nil
+
+	case pipeline.TypeDict:
+		if len(f.Fields) == 0 && f.Elem == nil {
+			break
+		}
+		dict, ok := v.Value.(map[string]pipeline.Value)
+		if !ok {
+			return nil, fmt.Errorf("expected dict, got %s", v.Type)
+		}
+		if len(f.Fields) > 0 {
+			return group(f.Fields, dict)
+		}
+		keys := make([]string, 0, len(dict))
+		for k := range dict {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		entries := make([]any, len(keys))
+		for i, k := range keys {
+			n, err := native(*f.Elem, dict[k])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			entries[i] = map[string]any{"key": parquetgo.ByteArrayValue([]byte(k)), "value": n}
+		}
+		return map[string]any{"key_value": entries}, nil
+	}
+	return leaf(f, v)
+}
+
+// group 不在 fields 中的成员被忽略
+func group(fields []pipeline.Field, data map[string]pipeline.Value) (map[string]any, error) {
+	out := make(map[string]any, len(fields))
+	for _, f := range fields {
+		v, ok := data[f.Name]
+		if !ok {
+			continue
+		}
+		n, err := native(f, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		out[f.Name] = n
+	}
+	return out, nil
+}
+
+func leaf(f pipeline.Field, v pipeline.Value) (any, error) {
+	switch f.Type {
+	case pipeline.TypeJSON, pipeline.TypeUnknown, pipeline.TypeNull, pipeline.TypeDict:
+		if raw, ok := v.Value.(json.RawMessage); ok {
+			return parquetgo.ByteArrayValue(raw), nil
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return parquetgo.ByteArrayValue(data), nil
+	}
+
+	// the schema is used without Timezone so that timestamps stay in UTC
+	converted, err := pipeline.FromNative(v, pipeline.Field{Type: f.Type, Precision: f.Precision, Scale: f.Scale})
+	if err != nil {
+		return nil, err
+	}
+	switch x := converted.Value.(type) {
+	case string:
+		return parquetgo.ByteArrayValue([]byte(x)), nil
+	case []byte:
+		return parquetgo.ByteArrayValue(x), nil
+	case int64:
+		return parquetgo.Int64Value(x), nil
+	case float64:
+		return parquetgo.DoubleValue(x), nil
+	case bool:
+		return parquetgo.BooleanValue(x), nil
+	case pipeline.Decimal:
+		b, err := decimalBytes(x)
+		if err != nil {
+			return nil, err
+		}
+		return parquetgo.FixedLenByteArrayValue(b), nil
+	case time.Time:
+		switch f.Precision {
+		case pipeline.PrecisionSecond, pipeline.PrecisionMilli:
+			return parquetgo.Int64Value(x.UnixMilli()), nil
+		case pipeline.PrecisionMicro:
+			return parquetgo.Int64Value(x.UnixMicro()), nil
+		}
+		return parquetgo.Int64Value(x.UnixNano()), nil
+	case time.Duration:
+		unit, err := f.Precision.Unit()
+		if err != nil {
+			return nil, err
+		}
+		return parquetgo.Int64Value(int64(x / unit)), nil
+	}
+	return nil, fmt.Errorf("cannot write %s", converted.Type)
+}
+
+var decimalLimit = new(big.Int).Lsh(big.NewInt(1), 8*decimalSize)
+
+// decimalBytes 16 字节大端序二进制补码
+func decimalBytes(d pipeline.Decimal) ([]byte, error) {
+	n := d.Unscaled()
+	if n.BitLen() >= 8*decimalSize {
+		return nil, fmt.Errorf("decimal %s exceeds %d digits", d, decimalPrecision)
+	}
+	if n.Sign() < 0 {
+		n.Add(n, decimalLimit)
+	}
+	return n.FillBytes(make([]byte, decimalSize)), nil
+}
+
+// column 与 Parquet schema 的节点一一对应，记录叶子列的下标，用于把值拆成列
+type column struct {
+	name     string
+	optional bool
+	repeated bool
+	leaf     bool
+	index    int
+	leaves   int
+	children []*column
+}
+
+func columnsOf(node parquetgo.Node, name string, next *int) *column {
+	c := &column{name: name, optional: node.Optional(), repeated: node.Repeated(), leaf: node.Leaf(), index: *next}
+	if c.leaf {
+		*next++
+		c.leaves = 1
+		return c
+	}
+	for _, f := range node.Fields() {
+		child := columnsOf(f, f.Name(), next)
+		c.children = append(c.children, child)
+		c.leaves += child.leaves
+	}
+	return c
+}
+
+// row 按 Dremel 编码把一条记录拆成带重复级别和定义级别的列值
+type row struct {
+	columns [][]parquetgo.Value
+}
+
+func (r *row) nulls(c *column, rep, def int) {
+	for i := 0; i < c.leaves; i++ {
+		r.columns[c.index+i] = append(r.columns[c.index+i], parquetgo.NullValue().Level(rep, def, c.index+i))
+	}
+}
+
+func (r *row) write(c *column, v any, rep, def, depth int) error {
+	switch {
+	case c.optional:
+		if v == nil {
+			r.nulls(c, rep, def)
+			return nil
+		}
+		return r.value(c, v, rep, def+1, depth)
+	case c.repeated:
+		items, _ := v.([]any)
+		if len(items) == 0 {
+			r.nulls(c, rep, def)
+			return nil
+		}
+		for i, item := range items {
+			itemRep := rep
+			if i > 0 {
+				itemRep = depth + 1
+			}
+			if err := r.value(c, item, itemRep, def+1, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if v == nil {
+		return fmt.Errorf("%s: required value is missing", c.name)
+	}
+	return r.value(c, v, rep, def, depth)
+}
+
+func (r *row) value(c *column, v any, rep, def, depth int) error {
+	if c.leaf {
+		r.columns[c.index] = append(r.columns[c.index], v.(parquetgo.Value).Level(rep, def, c.index))
+		return nil
+	}
+	members, _ := v.(map[string]any)
+	for _, child := range c.children {
+		if err := r.write(child, members[child.name], rep, def, depth); err != nil {
+			if c.name == "" {
+				return err
+			}
+			return fmt.Errorf("%s.%w", c.name, err)
+		}
+	}
+	return nil
+}
@@ -3,29 +3,67 @@ package stdout
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/codec/text"
+	"github.com/ipush/littlepipe/pkg/compress"
 	"github.com/ipush/littlepipe/pkg/pipeline"
 )
 
-// StdoutSink 将数据写入标准输出
+// StdoutSink 将数据写入标准输出，默认输出 text 记录的 line 字段
 type StdoutSink struct {
-	writer *bufio.Writer
+	writer     *bufio.Writer
+	compressor io.WriteCloser
+	encoder    codec.Encoder
 }
 
 func NewStdoutSink() *StdoutSink {
-	return &StdoutSink{
-		writer: bufio.NewWriter(os.Stdout),
+	return NewStdoutSinkWithCodec(text.New(""))
+}
+
+func NewStdoutSinkWithCodec(c codec.Codec) *StdoutSink {
+	s, _ := NewStdoutSinkWithCompression(c, compress.None)
+	return s
+}
+
+// NewStdoutSinkWithCompression 压缩输出，每条记录之后 flush 压缩器，读取方可以立即解压到这条记录
+func NewStdoutSinkWithCompression(c codec.Codec, compression string) (*StdoutSink, error) {
+	writer := bufio.NewWriter(os.Stdout)
+	compressor, err := compress.NewWriter(writer, compression)
+	if err != nil {
+		return nil, err
 	}
+	return &StdoutSink{
+		writer:     writer,
+		compressor: compressor,
+		encoder:    codec.NewEncoder(c, compressor),
+	}, nil
 }
 
 func (s *StdoutSink) Write(data *pipeline.Message) error {
-	if str, ok := data.Payload.(string); ok {
-		_, err := s.writer.WriteString(str + "\n")
-		if err != nil {
+	if data.Payload == nil {
+		return fmt.Errorf("StdoutSink: message %s has no payload", data.ID)
+	}
+	if err := s.encoder.Encode(data.Payload); err != nil {
+		return err
+	}
+	if f, ok := s.compressor.(compress.Flusher); ok {
+		if err := f.Flush(); err != nil {
 			return err
 		}
-		return s.writer.Flush()
 	}
-	return fmt.Errorf("StdoutSink: unsupported data type: %T", data)
+	return s.writer.Flush()
+}
+
+// Close 写出编码器的结尾，例如容器格式的尾部，以及压缩格式的结尾
+func (s *StdoutSink) Close() error {
+	if err := s.encoder.Close(); err != nil {
+		return err
+	}
+	if err := s.compressor.Close(); err != nil {
+		return err
+	}
+	return s.writer.Flush()
 }
@@ -0,0 +1,166 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/codec/text"
+	"github.com/ipush/littlepipe/pkg/compress"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// LineField 默认 text codec 保存一行文本的字段
+const LineField = text.LineField
+
+// FileSource 用 codec 逐条读取文件，记录字节偏移以支持 checkpoint。
+// 压缩的文件按解压后的偏移记录位置
+type FileSource struct {
+	path        string
+	file        *os.File
+	compression string
+	// stream 为 nil 时文件没有压缩，decoder 直接读取 file
+	stream  io.ReadCloser
+	codec   codec.Codec
+	decoder codec.Decoder
+	// base 是当前 decoder 开始读取的偏移，decoder 报告的偏移相对于它
+	base   int64
+	offset int64
+}
+
+// NewFileSource 按行读取文件，每行是一条 text 记录
+func NewFileSource(path string) (*FileSource, error) {
+	return NewFileSourceWithCodec(path, text.New(""))
+}
+
+// NewFileSourceWithCodec 按 magic bytes 或扩展名自动识别压缩格式
+func NewFileSourceWithCodec(path string, c codec.Codec) (*FileSource, error) {
+	return NewFileSourceWithCompression(path, c, compress.Auto)
+}
+
+// NewFileSourceWithCompression compression 为 compress.Auto、compress.None 或指定的格式
+func NewFileSourceWithCompression(path string, c codec.Codec, compression string) (*FileSource, error) {
+	if err := compress.Check(compression, true); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(compression, compress.Auto) {
+		if compression, err = detect(f, path); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	s := &FileSource{path: path, file: f, compression: compression, codec: c}
+	r, err := s.open()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.decoder = codec.NewDecoder(c, r)
+	return s, nil
+}
+
+// detect 按文件开头的 magic bytes 识别压缩格式，识别不了时按扩展名，空文件不解压
+func detect(f *os.File, path string) (string, error) {
+	header := make([]byte, 16)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if n == 0 {
+		return compress.None, nil
+	}
+	if name := compress.Detect(header[:n]); name != compress.None {
+		return name, nil
+	}
+	return compress.FromExtension(path), nil
+}
+
+// open 从文件开头创建读取流，压缩的文件返回解压后的流
+func (s *FileSource) open() (io.Reader, error) {
+	if s.stream != nil {
+		s.stream.Close()
+		s.stream = nil
+	}
+	if s.compression == "" || strings.EqualFold(s.compression, compress.None) {
+		return s.file, nil
+	}
+	stream, err := compress.NewReader(s.file, s.compression, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	s.stream = stream
+	return stream, nil
+}
+
+func (s *FileSource) Read() (*pipeline.Message, error) {
+	start := s.offset
+	record, err := s.decoder.Decode()
+	if o, ok := s.decoder.(codec.Offsetter); ok {
+		// advance past undecodable records too, so a retry does not loop on them
+		s.offset = s.base + o.Offset()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	msg := pipeline.NewMessage(record)
+	// stable across replays so idempotent sinks can deduplicate
+	msg.ID = fmt.Sprintf("%s:%d", s.path, start)
+	return msg, nil
+}
+
+func (s *FileSource) Position() ([]byte, error) {
+	if _, ok := s.decoder.(codec.Offsetter); !ok {
+		return nil, fmt.Errorf("codec %s does not report offsets", s.codec.Name())
+	}
+	return []byte(strconv.FormatInt(s.offset, 10)), nil
+}
+
+func (s *FileSource) Seek(position []byte) error {
+	offset, err := strconv.ParseInt(string(position), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid position %q: %w", position, err)
+	}
+	// decoders that need the header are created at the start of the file and skip ahead themselves
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r, err := s.open()
+	if err != nil {
+		return err
+	}
+	if resumer, ok := codec.NewDecoder(s.codec, r).(codec.Resumer); ok {
+		if err := resumer.Resume(offset); err != nil {
+			return err
+		}
+		s.decoder = resumer.(codec.Decoder)
+		s.base, s.offset = 0, offset
+		return nil
+	}
+
+	if s.stream != nil {
+		// a compressed stream cannot seek, the skipped part is decompressed and dropped
+		if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+			return fmt.Errorf("seek to %d: %w", offset, err)
+		}
+	} else if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	s.decoder = codec.NewDecoder(s.codec, r)
+	s.base, s.offset = offset, offset
+	return nil
+}
+
+func (s *FileSource) Close() error {
+	if s.stream != nil {
+		s.stream.Close()
+	}
+	return s.file.Close()
+}
@@ -1,29 +1,52 @@
 package stdin
 
 import (
-	"bufio"
 	"io"
 	"os"
 
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/codec/text"
+	"github.com/ipush/littlepipe/pkg/compress"
 	"github.com/ipush/littlepipe/pkg/pipeline"
 )
 
+// StdinSource 从标准输入读取记录，默认每行一条文本记录
 type StdinSource struct {
-	scanner *bufio.Scanner
+	codec       codec.Codec
+	compression string
+	input       io.Reader
+	decoder     codec.Decoder
 }
 
 func NewStdinSource() *StdinSource {
-	return &StdinSource{
-		scanner: bufio.NewScanner(os.Stdin),
+	return NewStdinSourceWithCodec(text.New(""))
+}
+
+// NewStdinSourceWithCodec 按 magic bytes 自动识别压缩的输入
+func NewStdinSourceWithCodec(c codec.Codec) *StdinSource {
+	return &StdinSource{codec: c, compression: compress.Auto, input: os.Stdin}
+}
+
+// NewStdinSourceWithCompression compression 为 compress.Auto、compress.None 或指定的格式
+func NewStdinSourceWithCompression(c codec.Codec, compression string) (*StdinSource, error) {
+	if err := compress.Check(compression, true); err != nil {
+		return nil, err
 	}
+	return &StdinSource{codec: c, compression: compression, input: os.Stdin}, nil
 }
 
 func (s *StdinSource) Read() (*pipeline.Message, error) {
-	if s.scanner.Scan() {
-		return pipeline.NewMessage(s.scanner.Text()), nil
+	if s.decoder == nil {
+		// detection reads the first bytes, so it waits until the first Read
+		r, err := compress.NewReader(s.input, s.compression, "")
+		if err != nil {
+			return nil, err
+		}
+		s.decoder = codec.NewDecoder(s.codec, r)
 	}
-	if err := s.scanner.Err(); err != nil {
+	record, err := s.decoder.Decode()
+	if err != nil {
 		return nil, err
 	}
-	return nil, io.EOF
+	return pipeline.NewMessage(record), nil
 }
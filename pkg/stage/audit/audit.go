@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// MetadataChanges 写入被审计消息元数据的键，值为 []pipeline.Change
+const MetadataChanges = "audit.changes"
+
+type Op string
+
+const (
+	// OpCreated 第一次见到某个主键，全部字段都记为 added
+	OpCreated Op = "created"
+	// OpUpdated 同一主键的新版本
+	OpUpdated Op = "updated"
+)
+
+type AuditConfig struct {
+	// KeyPath 主键的字段路径，为空时使用记录 schema 的 PrimaryKey
+	KeyPath string `json:"key_path,omitempty" yaml:"key_path,omitempty"`
+	// EmitUnchanged 为 true 时内容没有变化的新版本也产生事件
+	EmitUnchanged bool `json:"emit_unchanged,omitempty" yaml:"emit_unchanged,omitempty"`
+}
+
+// EventSchema 变化事件记录的 schema，old 和 new 以 JSON 保存，因此不同类型的字段可以放在同一个列表里
+var EventSchema = &pipeline.Schema{
+	Version:    1,
+	PrimaryKey: "key",
+	Fields: []pipeline.Field{
+		{Name: "key", Type: pipeline.TypeString, Required: true},
+		{Name: "op", Type: pipeline.TypeString, Required: true},
+		{Name: "changes", Type: pipeline.TypeList, Required: true, Elem: &pipeline.Field{
+			Type: pipeline.TypeDict,
+			Fields: []pipeline.Field{
+				{Name: "path", Type: pipeline.TypeString, Required: true},
+				{Name: "kind", Type: pipeline.TypeString, Required: true},
+				{Name: "old", Type: pipeline.TypeJSON, Required: true},
+				{Name: "new", Type: pipeline.TypeJSON, Required: true},
+			},
+		}},
+	},
+}
+
+// AuditStage 记住每个主键最近一次的记录，与新到达的版本比较，把变化写入 events sink。
+// 原消息带着变化列表继续向下游传递；Timestamp 早于已记录版本的消息视为乱序，不产生事件。
+// 每个主键保留一份记录副本，内存随主键数量增长
+type AuditStage struct {
+	config AuditConfig
+	events pipeline.Sink
+	last   map[string]*pipeline.Record
+}
+
+func NewAuditStage(config AuditConfig, events pipeline.Sink) *AuditStage {
+	return &AuditStage{
+		config: config,
+		events: events,
+		last:   make(map[string]*pipeline.Record),
+	}
+}
+
+func (s *AuditStage) Process(msg *pipeline.Message) (*pipeline.Message, error) {
+	if msg.Payload == nil {
+		return nil, pipeline.Errorf(pipeline.KindValidation, "message %s has no payload", msg.ID)
+	}
+	key, err := s.key(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, ok := s.last[key]
+	if ok && msg.Payload.Timestamp.Before(prev.Timestamp) {
+		return msg, nil
+	}
+	op, base := OpUpdated, prev
+	if !ok {
+		op, base = OpCreated, &pipeline.Record{}
+	}
+	changes := base.Diff(msg.Payload)
+	// downstream stages may modify the message, keep our own copy
+	s.last[key] = msg.Payload.Clone()
+
+	if len(changes) == 0 && !s.config.EmitUnchanged {
+		return msg, nil
+	}
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]any)
+	}
+	msg.Metadata[MetadataChanges] = changes
+
+	event, err := newEvent(key, op, msg.Payload, changes)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.events.Write(event); err != nil {
+		return nil, fmt.Errorf("write audit event: %w", err)
+	}
+	return msg, nil
+}
+
+func (s *AuditStage) key(record *pipeline.Record) (string, error) {
+	path := s.config.KeyPath
+	if path == "" && record.Schema != nil {
+		path = record.Schema.PrimaryKey
+	}
+	if path == "" {
+		return "", pipeline.Errorf(pipeline.KindValidation, "audit: no key path configured and record has no primary key")
+	}
+	value, ok := record.GetValue(path)
+	if !ok || value.IsNull() {
+		return "", pipeline.Errorf(pipeline.KindValidation, "audit: key %s is missing", path)
+	}
+	return fmt.Sprint(value.Native()), nil
+}
+
+func newEvent(key string, op Op, record *pipeline.Record, changes []pipeline.Change) (*pipeline.Message, error) {
+	list := make([]pipeline.Value, len(changes))
+	for i, c := range changes {
+		old, err := json.Marshal(c.Old)
+		if err != nil {
+			return nil, pipeline.Errorf(pipeline.KindConversion, "audit: encode %s: %w", c.Path, err)
+		}
+		next, err := json.Marshal(c.New)
+		if err != nil {
+			return nil, pipeline.Errorf(pipeline.KindConversion, "audit: encode %s: %w", c.Path, err)
+		}
+		list[i] = pipeline.Value{Type: pipeline.TypeDict, Value: map[string]pipeline.Value{
+			"path": {Type: pipeline.TypeString, Value: c.Path},
+			"kind": {Type: pipeline.TypeString, Value: string(c.Kind)},
+			"old":  {Type: pipeline.TypeJSON, Value: json.RawMessage(old)},
+			"new":  {Type: pipeline.TypeJSON, Value: json.RawMessage(next)},
+		}}
+	}
+
+	return pipeline.NewMessage(&pipeline.Record{
+		Schema:    EventSchema,
+		Timestamp: record.Timestamp,
+		Version:   EventSchema.Version,
+		Data: map[string]pipeline.Value{
+			"key":     {Type: pipeline.TypeString, Value: key},
+			"op":      {Type: pipeline.TypeString, Value: string(op)},
+			"changes": {Type: pipeline.TypeList, Value: list},
+		},
+	}), nil
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+type memorySink struct {
+	msgs []*pipeline.Message
+}
+
+func (s *memorySink) Write(msg *pipeline.Message) error {
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+var accountSchema = &pipeline.Schema{
+	PrimaryKey: "id",
+	Fields: []pipeline.Field{
+		{Name: "id", Type: pipeline.TypeString},
+		{Name: "status", Type: pipeline.TypeString},
+	},
+}
+
+func account(status string, at int64) *pipeline.Message {
+	return pipeline.NewMessage(&pipeline.Record{
+		Schema:    accountSchema,
+		Timestamp: time.Unix(at, 0),
+		Data: map[string]pipeline.Value{
+			"id":     {Type: pipeline.TypeString, Value: "acc-1"},
+			"status": {Type: pipeline.TypeString, Value: status},
+		},
+	})
+}
+
+func TestAuditStage(t *testing.T) {
+	events := &memorySink{}
+	stage := NewAuditStage(AuditConfig{}, events)
+
+	for _, msg := range []*pipeline.Message{
+		account("new", 1),
+		account("active", 2),
+		account("active", 3), // unchanged
+		account("closed", 0), // out of order
+		account("suspended", 4),
+	} {
+		out, err := stage.Process(msg)
+		if err != nil || out != msg {
+			t.Fatalf("want message passed through, got %v", err)
+		}
+	}
+
+	if len(events.msgs) != 3 {
+		t.Fatalf("want 3 events, got %d", len(events.msgs))
+	}
+	first := events.msgs[0].Payload
+	if op, _ := first.GetValue("op"); op.Value != string(OpCreated) {
+		t.Errorf("want first event created, got %v", op.Value)
+	}
+	if err := EventSchema.Validate(first); err != nil {
+		t.Errorf("want event valid against EventSchema, got %v", err)
+	}
+
+	last := events.msgs[2].Payload
+	if path, _ := last.GetValue("changes[0].path"); path.Value != "status" {
+		t.Errorf("want status change, got %v", path.Value)
+	}
+	old, _ := last.GetValue("changes[0].old")
+	if string(old.Value.(json.RawMessage)) != `"active"` {
+		t.Errorf("want previous status active, got %s", old.Value)
+	}
+}
+
+func TestAuditStage_MissingKey(t *testing.T) {
+	stage := NewAuditStage(AuditConfig{KeyPath: "account.id"}, &memorySink{})
+	_, err := stage.Process(account("new", 1))
+	if pipeline.KindOf(err) != pipeline.KindValidation {
+		t.Errorf("want validation error for missing key, got %v", err)
+	}
+}
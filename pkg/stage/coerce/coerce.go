@@ -0,0 +1,57 @@
+package coerce
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// MetadataViolations 写入消息元数据的键，值为 []pipeline.Violation
+const MetadataViolations = "coercion.violations"
+
+// CoerceStage 在校验之前把记录转换为 schema 声明的类型，通常接在文本类 source 之后。
+// 严格模式下转换失败的记录写入死信 sink 并过滤掉，没有死信 sink 时返回转换错误；
+// 宽松模式下失败的字段置为空值，记录连同问题列表继续向下游传递
+type CoerceStage struct {
+	schema     *pipeline.Schema
+	mode       pipeline.CoercionMode
+	deadLetter pipeline.Sink
+}
+
+func NewCoerceStage(schema *pipeline.Schema, mode pipeline.CoercionMode, deadLetter pipeline.Sink) *CoerceStage {
+	return &CoerceStage{
+		schema:     schema,
+		mode:       mode,
+		deadLetter: deadLetter,
+	}
+}
+
+func (s *CoerceStage) Process(msg *pipeline.Message) (*pipeline.Message, error) {
+	if msg.Payload == nil {
+		return nil, pipeline.Errorf(pipeline.KindValidation, "message %s has no payload", msg.ID)
+	}
+
+	err := s.schema.Coerce(msg.Payload, s.mode)
+	if err == nil {
+		return msg, nil
+	}
+
+	var cerr *pipeline.CoercionError
+	if errors.As(err, &cerr) {
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]any)
+		}
+		msg.Metadata[MetadataViolations] = cerr.Violations
+	}
+	if s.mode == pipeline.CoerceLenient {
+		return msg, nil
+	}
+	if s.deadLetter == nil {
+		return nil, err
+	}
+	if err := s.deadLetter.Write(msg); err != nil {
+		return nil, fmt.Errorf("write dead letter: %w", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,75 @@
+package coerce
+
+import (
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+type memorySink struct {
+	msgs []*pipeline.Message
+}
+
+func (s *memorySink) Write(msg *pipeline.Message) error {
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func schema() *pipeline.Schema {
+	return &pipeline.Schema{Fields: []pipeline.Field{
+		{Name: "qty", Type: pipeline.TypeInt64},
+		{Name: "paid", Type: pipeline.TypeBoolean, Nullable: true},
+	}}
+}
+
+func row(qty, paid string) *pipeline.Message {
+	return pipeline.NewMessage(&pipeline.Record{Data: map[string]pipeline.Value{
+		"qty":  {Type: pipeline.TypeString, Value: qty},
+		"paid": {Type: pipeline.TypeString, Value: paid},
+	}})
+}
+
+func TestCoerceStage_Strict(t *testing.T) {
+	deadLetter := &memorySink{}
+	stage := NewCoerceStage(schema(), pipeline.CoerceStrict, deadLetter)
+
+	out, err := stage.Process(row("3", "true"))
+	if err != nil || out == nil {
+		t.Fatalf("want coerced record, got %v", err)
+	}
+	if err := schema().Validate(out.Payload); err != nil {
+		t.Errorf("want coerced record valid, got %v", err)
+	}
+
+	out, err = stage.Process(row("3", "yes"))
+	if err != nil || out != nil {
+		t.Fatalf("want record filtered to dead letter, got %v, %v", out, err)
+	}
+	violations, _ := deadLetter.msgs[0].Metadata[MetadataViolations].([]pipeline.Violation)
+	if len(violations) != 1 || violations[0].Path != "paid" {
+		t.Errorf("want paid violation in metadata, got %v", violations)
+	}
+
+	stage = NewCoerceStage(schema(), pipeline.CoerceStrict, nil)
+	if _, err := stage.Process(row("x", "1")); pipeline.KindOf(err) != pipeline.KindConversion {
+		t.Errorf("want conversion error without dead letter, got %v", err)
+	}
+}
+
+func TestCoerceStage_Lenient(t *testing.T) {
+	stage := NewCoerceStage(schema(), pipeline.CoerceLenient, nil)
+
+	out, err := stage.Process(row("3", "maybe"))
+	if err != nil || out == nil {
+		t.Fatalf("want record passed on in lenient mode, got %v", err)
+	}
+	if v, _ := out.Payload.GetValue("paid"); !v.IsNull() {
+		t.Errorf("want failed field nulled, got %v", v.Value)
+	}
+	if _, ok := out.Metadata[MetadataViolations]; !ok {
+		t.Error("want violations recorded in metadata")
+	}
+	if err := schema().Validate(out.Payload); err != nil {
+		t.Errorf("want nullable field to pass validation, got %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package grok
+
+import "github.com/ipush/littlepipe/pkg/pipeline"
+
+type GrokConfig struct {
+	// Field 要解析的字符串字段路径，默认为 text codec 保存原始行的 line
+	Field string `json:"field,omitempty" yaml:"field,omitempty"`
+	// Patterns 依次尝试的模式，使用第一个匹配的。模式是 Go 正则表达式，
+	// 可以用 (?P<name>...) 命名捕获，也可以用 %{NAME}、%{NAME:field} 或 %{NAME:field:type} 引用 grok 模式
+	Patterns []string `json:"patterns" yaml:"patterns"`
+	// Definitions 额外的 grok 模式，同名时覆盖内置模式
+	Definitions map[string]string `json:"definitions,omitempty" yaml:"definitions,omitempty"`
+	// Types 捕获字段的类型，优先于 %{NAME:field:type} 中的类型，未指定的字段为字符串
+	Types map[string]pipeline.FieldType `json:"types,omitempty" yaml:"types,omitempty"`
+	// TimeLayouts 按 Go 时间格式解析的 timestamp 字段，例如 HTTPDATE 对应 "02/Jan/2006:15:04:05 -0700"
+	TimeLayouts map[string]string `json:"time_layouts,omitempty" yaml:"time_layouts,omitempty"`
+	// RemoveField 匹配后删除被解析的字段，除非某个捕获写回了这个字段
+	RemoveField bool `json:"remove_field,omitempty" yaml:"remove_field,omitempty"`
+	// TagField 和 Tag 没有模式匹配时追加到记录中的标签列表和标签，
+	// 默认为 tags 和 _grokparsefailure
+	TagField string `json:"tag_field,omitempty" yaml:"tag_field,omitempty"`
+	Tag      string `json:"tag,omitempty" yaml:"tag,omitempty"`
+}
@@ -0,0 +1,308 @@
+package grok
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec/text"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+const (
+	// MetadataPattern 写入消息元数据的键，值为匹配的模式在 GrokConfig.Patterns 中的下标
+	MetadataPattern = "grok.pattern"
+
+	DefaultTagField = "tags"
+	DefaultTag      = "_grokparsefailure"
+)
+
+// GrokStage 用一组模式解析记录中的字符串字段，把捕获写成带类型的字段。
+// 模式依次尝试，第一个匹配的生效；都不匹配、字段不存在或不是字符串时记录原样通过，
+// 并在 TagField 列表中追加 Tag，下游可以据此过滤或转入死信
+type GrokStage struct {
+	config   GrokConfig
+	patterns []*pattern
+}
+
+func NewGrokStage(config GrokConfig) (*GrokStage, error) {
+	if len(config.Patterns) == 0 {
+		return nil, fmt.Errorf("grok requires at least one pattern")
+	}
+	if config.Field == "" {
+		config.Field = text.LineField
+	}
+	if config.TagField == "" {
+		config.TagField = DefaultTagField
+	}
+	if config.Tag == "" {
+		config.Tag = DefaultTag
+	}
+
+	definitions := make(map[string]string, len(Patterns)+len(config.Definitions))
+	for name, def := range Patterns {
+		definitions[name] = def
+	}
+	for name, def := range config.Definitions {
+		definitions[name] = def
+	}
+
+	s := &GrokStage{config: config}
+	for i, expr := range config.Patterns {
+		p, err := compile(expr, definitions, config)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %d: %w", i, err)
+		}
+		s.patterns = append(s.patterns, p)
+	}
+	return s, nil
+}
+
+func (s *GrokStage) Process(msg *pipeline.Message) (*pipeline.Message, error) {
+	if msg.Payload == nil {
+		return nil, pipeline.Errorf(pipeline.KindValidation, "message %s has no payload", msg.ID)
+	}
+	record := msg.Payload
+
+	value, _ := record.GetValue(s.config.Field)
+	line, ok := value.Value.(string)
+	if !ok {
+		return msg, s.tag(record)
+	}
+	for i, p := range s.patterns {
+		groups := p.re.FindStringSubmatchIndex(line)
+		if groups == nil {
+			continue
+		}
+		written, err := p.apply(record, line, groups)
+		if err != nil {
+			return nil, pipeline.Errorf(pipeline.KindConversion, "grok pattern %d: %w", i, err)
+		}
+		if s.config.RemoveField && !written[s.config.Field] {
+			record.DeleteValue(s.config.Field)
+			removeField(record, s.config.Field)
+		}
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]any)
+		}
+		msg.Metadata[MetadataPattern] = i
+		return msg, nil
+	}
+	return msg, s.tag(record)
+}
+
+// tag 在标签列表中追加 Tag，已有时不重复追加
+func (s *GrokStage) tag(record *pipeline.Record) error {
+	tag := pipeline.Value{Type: pipeline.TypeString, Value: s.config.Tag}
+	var tags []pipeline.Value
+	if existing, ok := record.GetValue(s.config.TagField); ok && !existing.IsNull() {
+		list, ok := existing.Value.([]pipeline.Value)
+		if !ok {
+			return pipeline.Errorf(pipeline.KindValidation, "tag field %s is %s, not list", s.config.TagField, existing.Type)
+		}
+		for _, t := range list {
+			if t.Equal(tag) {
+				return nil
+			}
+		}
+		tags = append(tags, list...)
+	}
+	tags = append(tags, tag)
+	if err := record.SetValue(s.config.TagField, pipeline.Value{Type: pipeline.TypeList, Value: tags}); err != nil {
+		return err
+	}
+	if record.Schema != nil {
+		if _, ok := record.Schema.Lookup(s.config.TagField); !ok {
+			record.Schema = record.Schema.Clone()
+			return record.Schema.SetField(s.config.TagField, pipeline.Field{
+				Type: pipeline.TypeList, Nullable: true, Elem: &pipeline.Field{Type: pipeline.TypeString},
+			})
+		}
+	}
+	return nil
+}
+
+// removeField 从 schema 中删除被解析的顶层字段，否则 Required 的 line 字段会让后面的校验失败
+func removeField(record *pipeline.Record, name string) {
+	if record.Schema == nil {
+		return
+	}
+	for i, f := range record.Schema.Fields {
+		if f.Name == name {
+			schema := record.Schema.Clone()
+			schema.Fields = append(schema.Fields[:i], schema.Fields[i+1:]...)
+			record.Schema = schema
+			return
+		}
+	}
+}
+
+type capture struct {
+	group  int
+	field  string
+	typ    pipeline.FieldType
+	layout string
+}
+
+type pattern struct {
+	re       *regexp.Regexp
+	captures []capture
+}
+
+var reference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(\w+))?\}`)
+
+// groupPrefix 展开 %{NAME:field} 时生成的分组名前缀，字段名本身可以包含分组名不允许的字符
+const groupPrefix = "grok__"
+
+// maxDepth 限制模式的嵌套层数，避免互相引用的定义无限展开
+const maxDepth = 32
+
+type compiler struct {
+	definitions map[string]string
+	fields      map[string]string
+	types       map[string]pipeline.FieldType
+}
+
+func compile(expr string, definitions map[string]string, config GrokConfig) (*pattern, error) {
+	c := &compiler{definitions: definitions, fields: make(map[string]string), types: make(map[string]pipeline.FieldType)}
+	expanded, err := c.expand(expr, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pattern{re: re}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		field, ok := c.fields[name]
+		if !ok {
+			// a named group written directly in the expression
+			field = name
+		}
+		capt := capture{group: i, field: field, typ: c.types[name], layout: config.TimeLayouts[field]}
+		if t, ok := config.Types[field]; ok {
+			capt.typ = t
+		}
+		if capt.layout != "" && capt.typ == pipeline.TypeUnknown {
+			capt.typ = pipeline.TypeTimestamp
+		}
+		if capt.typ == pipeline.TypeUnknown {
+			capt.typ = pipeline.TypeString
+		}
+		p.captures = append(p.captures, capt)
+	}
+	return p, nil
+}
+
+func (c *compiler) expand(expr string, depth int) (string, error) {
+	if depth > maxDepth {
+		return "", fmt.Errorf("patterns nested deeper than %d levels, check for a cycle", maxDepth)
+	}
+	var firstErr error
+	expanded := reference.ReplaceAllStringFunc(expr, func(ref string) string {
+		if firstErr != nil {
+			return ""
+		}
+		m := reference.FindStringSubmatch(ref)
+		name, field, typeName := m[1], m[2], m[3]
+		def, ok := c.definitions[name]
+		if !ok {
+			firstErr = fmt.Errorf("unknown grok pattern %s", name)
+			return ""
+		}
+		body, err := c.expand(def, depth+1)
+		if err != nil {
+			firstErr = fmt.Errorf("%s: %w", name, err)
+			return ""
+		}
+		if field == "" {
+			return "(?:" + body + ")"
+		}
+
+		group := fmt.Sprintf("%s%d", groupPrefix, len(c.fields))
+		c.fields[group] = field
+		if typeName != "" {
+			t, err := parseType(typeName)
+			if err != nil {
+				firstErr = fmt.Errorf("%s: %w", ref, err)
+				return ""
+			}
+			c.types[group] = t
+		}
+		return "(?P<" + group + ">" + body + ")"
+	})
+	return expanded, firstErr
+}
+
+// parseType 除了 pipeline 的类型名，还接受 Logstash 的 int 和 float
+func parseType(name string) (pipeline.FieldType, error) {
+	switch strings.ToLower(name) {
+	case "int", "integer", "long":
+		return pipeline.TypeInt64, nil
+	case "float", "double":
+		return pipeline.TypeFloat64, nil
+	}
+	return pipeline.ParseFieldType(name)
+}
+
+// apply 写入匹配的捕获，返回写入的字段。同一字段有多个捕获时使用第一个参与匹配的，
+// 空的捕获只写入字符串字段。全部捕获转换成功后才修改记录
+func (p *pattern) apply(record *pipeline.Record, line string, groups []int) (map[string]bool, error) {
+	written := make(map[string]bool, len(p.captures))
+	var captured []capture
+	var values []pipeline.Value
+	for _, capt := range p.captures {
+		start, end := groups[2*capt.group], groups[2*capt.group+1]
+		if start < 0 || written[capt.field] {
+			continue
+		}
+		raw := line[start:end]
+		if raw == "" && capt.typ != pipeline.TypeString {
+			continue
+		}
+		value, err := capt.convert(raw)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", capt.field, err)
+		}
+		written[capt.field] = true
+		captured = append(captured, capt)
+		values = append(values, value)
+	}
+
+	var schema *pipeline.Schema
+	if record.Schema != nil && len(captured) > 0 {
+		// the schema may be shared with other records, e.g. every line read by the text codec
+		schema = record.Schema.Clone()
+	}
+	for i, capt := range captured {
+		if err := record.SetValue(capt.field, values[i]); err != nil {
+			return nil, err
+		}
+		if schema != nil {
+			if err := schema.SetField(capt.field, pipeline.Field{Type: capt.typ, Nullable: true}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if schema != nil {
+		record.Schema = schema
+	}
+	return written, nil
+}
+
+func (c capture) convert(raw string) (pipeline.Value, error) {
+	if c.layout != "" {
+		t, err := time.Parse(c.layout, raw)
+		if err != nil {
+			return pipeline.Value{}, err
+		}
+		return pipeline.Value{Type: pipeline.TypeTimestamp, Value: t}, nil
+	}
+	return pipeline.Coerce(pipeline.Value{Type: pipeline.TypeString, Value: raw}, pipeline.Field{Type: c.typ}, pipeline.CoerceStrict)
+}
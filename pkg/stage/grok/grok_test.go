@@ -0,0 +1,170 @@
+package grok
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/codec/text"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func lineMessage(t *testing.T, line string) *pipeline.Message {
+	t.Helper()
+	record, err := text.New("").Decode([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pipeline.NewMessage(record)
+}
+
+func TestGrokStage_Nginx(t *testing.T) {
+	stage, err := NewGrokStage(GrokConfig{
+		Patterns:    []string{`^%{NGINXACCESS}$`},
+		TimeLayouts: map[string]string{"timestamp": HTTPDateLayout},
+		RemoveField: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `93.184.216.34 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?a=1 HTTP/1.1" 200 2326 "http://example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`
+	msg, err := stage.Process(lineMessage(t, line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := msg.Payload.Data
+	if d["clientip"].Value != "93.184.216.34" || d["auth"].Value != "frank" || d["verb"].Value != "GET" || d["request"].Value != "/apache_pb.gif?a=1" {
+		t.Errorf("strings = %v", d)
+	}
+	if d["response"].Value != int64(200) || d["bytes"].Value != int64(2326) {
+		t.Errorf("numbers = %#v %#v", d["response"], d["bytes"])
+	}
+	if ts, ok := d["timestamp"].Value.(time.Time); !ok || ts.UTC().Hour() != 20 {
+		t.Errorf("timestamp = %#v", d["timestamp"])
+	}
+	if _, ok := d[text.LineField]; ok {
+		t.Error("source field not removed")
+	}
+	if _, ok := msg.Payload.Schema.Lookup(text.LineField); ok {
+		t.Error("source field still in schema")
+	}
+	if f, ok := msg.Payload.Schema.Lookup("bytes"); !ok || f.Type != pipeline.TypeInt64 {
+		t.Errorf("schema bytes = %+v", f)
+	}
+	if msg.Metadata[MetadataPattern] != 0 {
+		t.Errorf("metadata = %v", msg.Metadata)
+	}
+	if err := msg.Payload.Schema.Validate(msg.Payload); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+
+	// "-" for bytes leaves the field out instead of failing
+	msg, err = stage.Process(lineMessage(t, `::1 - - [10/Oct/2000:13:55:36 +0000] "HEAD / HTTP/1.0" 304 - "-" "curl"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.Payload.Data["bytes"]; ok || msg.Payload.Data["clientip"].Value != "::1" {
+		t.Errorf("no bytes = %v", msg.Payload.Data)
+	}
+}
+
+func TestGrokStage_FallbackAndTag(t *testing.T) {
+	stage, err := NewGrokStage(GrokConfig{
+		Patterns: []string{
+			`^%{SYSLOGLINE}$`,
+			`^(?P<level>[A-Z]+) (?P<msg>.*)$`,
+		},
+		Types: map[string]pipeline.FieldType{"pid": pipeline.TypeString},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := stage.Process(lineMessage(t, "Mar  7 04:02:01 web-1 CRON[4242]: (root) CMD (run-parts)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := msg.Payload.Data
+	if d["logsource"].Value != "web-1" || d["program"].Value != "CRON" || d["pid"].Value != "4242" || d["message"].Value != "(root) CMD (run-parts)" {
+		t.Errorf("syslog = %v", d)
+	}
+
+	msg, err = stage.Process(lineMessage(t, "WARN disk almost full"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Payload.Data["level"].Value != "WARN" || msg.Metadata[MetadataPattern] != 1 {
+		t.Errorf("fallback = %v %v", msg.Payload.Data, msg.Metadata)
+	}
+	if _, ok := msg.Payload.Data[DefaultTagField]; ok {
+		t.Error("matched record tagged")
+	}
+
+	msg, err = stage.Process(lineMessage(t, "garbage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = stage.Process(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := msg.Payload.Data[DefaultTagField].Value.([]pipeline.Value)
+	if len(tags) != 1 || tags[0].Value != DefaultTag {
+		t.Errorf("tags = %v", tags)
+	}
+	if msg.Payload.Data[text.LineField].Value != "garbage" {
+		t.Error("unmatched record changed")
+	}
+	if err := msg.Payload.Schema.Validate(msg.Payload); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+}
+
+func TestGrokStage_Errors(t *testing.T) {
+	bad := []GrokConfig{
+		{},
+		{Patterns: []string{`%{NOPE:x}`}},
+		{Patterns: []string{`%{INT:x:weird}`}},
+		{Patterns: []string{`%{A}`}, Definitions: map[string]string{"A": `%{B}`, "B": `%{A}`}},
+		{Patterns: []string{`(`}},
+	}
+	for i, config := range bad {
+		if _, err := NewGrokStage(config); err == nil {
+			t.Errorf("config %d accepted", i)
+		}
+	}
+
+	stage, err := NewGrokStage(GrokConfig{
+		Field:       "raw",
+		Patterns:    []string{`%{WORD:n:int}`},
+		Definitions: map[string]string{"WORD": `\w+`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := pipeline.NewMessage(&pipeline.Record{Data: map[string]pipeline.Value{"raw": pipeline.ValueOf("abc")}})
+	_, err = stage.Process(msg)
+	if pipeline.KindOf(err) != pipeline.KindConversion || !strings.Contains(err.Error(), "field n") {
+		t.Errorf("err = %v", err)
+	}
+	if _, ok := msg.Payload.Data["n"]; ok {
+		t.Error("failed conversion wrote a field")
+	}
+}
+
+func TestGrokStage_NestedTarget(t *testing.T) {
+	stage, err := NewGrokStage(GrokConfig{Patterns: []string{`%{IP:client.ip} %{NUMBER:client.port:int64}`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := stage.Process(lineMessage(t, "10.0.0.1 8080"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := msg.Payload.GetValue("client.port"); v.Value != int64(8080) {
+		t.Errorf("client = %v", msg.Payload.Data["client"])
+	}
+	if f, ok := msg.Payload.Schema.Lookup("client.ip"); !ok || f.Type != pipeline.TypeString {
+		t.Errorf("schema = %+v", msg.Payload.Schema)
+	}
+}
@@ -0,0 +1,26 @@
+package sample
+
+import "time"
+
+type Mode string
+
+const (
+	// ModeRandom keeps each message independently with probability Rate
+	ModeRandom Mode = "random"
+	// ModeHash keeps a message when the hash of KeyField falls under Rate,
+	// so the same key is always kept or always dropped
+	ModeHash Mode = "hash"
+	// ModeReservoir keeps a uniform sample of Size messages per Window,
+	// or of the whole stream when Window is 0
+	ModeReservoir Mode = "reservoir"
+)
+
+type SampleConfig struct {
+	Mode     Mode          `json:"mode"`
+	Rate     float64       `json:"rate"`
+	KeyField string        `json:"key_field"`
+	Size     int           `json:"size"`
+	Window   time.Duration `json:"window"`
+	// Seed makes random and reservoir sampling reproducible, 0 picks a random seed
+	Seed int64 `json:"seed"`
+}
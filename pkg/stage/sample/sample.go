@@ -0,0 +1,168 @@
+package sample
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// SampleStage 按配置的方式对消息采样，未被选中的消息被过滤掉。
+// 蓄水池模式在窗口结束或输入结束时按到达顺序一次性输出样本，
+// 没有新消息时窗口也会按时结束，见 Tick
+type SampleStage struct {
+	config SampleConfig
+	rand   *rand.Rand
+
+	reservoir   []slot
+	seen        int
+	windowStart time.Time
+	now         func() time.Time
+}
+
+// slot 蓄水池中的消息及其在窗口内的到达序号
+type slot struct {
+	seq int
+	msg *pipeline.Message
+}
+
+func NewSampleStage(config SampleConfig) (*SampleStage, error) {
+	switch config.Mode {
+	case ModeRandom, ModeHash:
+		if config.Rate < 0 || config.Rate > 1 {
+			return nil, fmt.Errorf("sample rate must be within [0, 1], got %v", config.Rate)
+		}
+		if config.Mode == ModeHash && config.KeyField == "" {
+			return nil, fmt.Errorf("hash sampling requires key_field")
+		}
+	case ModeReservoir:
+		if config.Size <= 0 {
+			return nil, fmt.Errorf("reservoir size must be positive, got %d", config.Size)
+		}
+	default:
+		return nil, fmt.Errorf("unknown sample mode %q", config.Mode)
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &SampleStage{
+		config: config,
+		rand:   rand.New(rand.NewSource(seed)),
+		now:    time.Now,
+	}, nil
+}
+
+func (s *SampleStage) Process(msg *pipeline.Message) (*pipeline.Message, error) {
+	switch s.config.Mode {
+	case ModeRandom:
+		if s.rand.Float64() < s.config.Rate {
+			return msg, nil
+		}
+		return nil, nil
+	case ModeHash:
+		if hashFraction(s.key(msg)) < s.config.Rate {
+			return msg, nil
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("reservoir sampling emits through ProcessAll")
+	}
+}
+
+func (s *SampleStage) ProcessAll(msg *pipeline.Message) ([]*pipeline.Message, error) {
+	if s.config.Mode != ModeReservoir {
+		result, err := s.Process(msg)
+		if err != nil || result == nil {
+			return nil, err
+		}
+		return []*pipeline.Message{result}, nil
+	}
+
+	var emitted []*pipeline.Message
+	now := s.now()
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	}
+	if s.config.Window > 0 && now.Sub(s.windowStart) >= s.config.Window {
+		emitted = s.drain()
+		s.windowStart = now
+	}
+	s.offer(msg)
+	return emitted, nil
+}
+
+// Flush 输出最后一个窗口（或整个流）的样本
+func (s *SampleStage) Flush() ([]*pipeline.Message, error) {
+	if s.config.Mode != ModeReservoir {
+		return nil, nil
+	}
+	return s.drain(), nil
+}
+
+// TickInterval 按窗口采样时让 pipeline 在输入空闲时定期调用 Tick，窗口结束的延迟不超过窗口的十分之一
+func (s *SampleStage) TickInterval() time.Duration {
+	if s.config.Mode != ModeReservoir || s.config.Window <= 0 {
+		return 0
+	}
+	return max(s.config.Window/10, time.Millisecond)
+}
+
+// Tick 在窗口已经结束但没有新消息到来时输出该窗口的样本
+func (s *SampleStage) Tick() ([]*pipeline.Message, error) {
+	if s.TickInterval() == 0 || s.windowStart.IsZero() {
+		return nil, nil
+	}
+	now := s.now()
+	if now.Sub(s.windowStart) < s.config.Window {
+		return nil, nil
+	}
+	// the next window starts with the next message
+	s.windowStart = time.Time{}
+	return s.drain(), nil
+}
+
+// offer 蓄水池算法 R
+func (s *SampleStage) offer(msg *pipeline.Message) {
+	s.seen++
+	if len(s.reservoir) < s.config.Size {
+		s.reservoir = append(s.reservoir, slot{seq: s.seen, msg: msg})
+		return
+	}
+	if i := s.rand.Intn(s.seen); i < s.config.Size {
+		s.reservoir[i] = slot{seq: s.seen, msg: msg}
+	}
+}
+
+// drain 按到达顺序返回样本，而不是按蓄水池中的位置
+func (s *SampleStage) drain() []*pipeline.Message {
+	sort.Slice(s.reservoir, func(i, j int) bool { return s.reservoir[i].seq < s.reservoir[j].seq })
+	var sample []*pipeline.Message
+	for _, sl := range s.reservoir {
+		sample = append(sample, sl.msg)
+	}
+	s.reservoir = nil
+	s.seen = 0
+	return sample
+}
+
+// key 记录中缺少 KeyField 时退回到 Message.ID
+func (s *SampleStage) key(msg *pipeline.Message) string {
+	if msg.Payload != nil {
+		if v, ok := msg.Payload.GetValue(s.config.KeyField); ok {
+			return fmt.Sprint(v.Value)
+		}
+	}
+	return msg.ID
+}
+
+func hashFraction(key string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return float64(h.Sum64()) / math.MaxUint64
+}
@@ -0,0 +1,179 @@
+package sample
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func userMessage(user string) *pipeline.Message {
+	return pipeline.NewMessage(&pipeline.Record{
+		Data: map[string]pipeline.Value{
+			"user": {Type: pipeline.TypeString, Value: user},
+		},
+	})
+}
+
+func TestSampleStage_HashIsDeterministic(t *testing.T) {
+	stage, err := NewSampleStage(SampleConfig{Mode: ModeHash, Rate: 0.5, KeyField: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		first, _ := stage.Process(userMessage(user))
+		second, _ := stage.Process(userMessage(user))
+		if (first == nil) != (second == nil) {
+			t.Fatalf("%s sampled inconsistently", user)
+		}
+		if first != nil {
+			kept++
+		}
+	}
+	if kept < 400 || kept > 600 {
+		t.Errorf("want roughly half kept, got %d of 1000", kept)
+	}
+}
+
+func TestSampleStage_Random(t *testing.T) {
+	stage, err := NewSampleStage(SampleConfig{Mode: ModeRandom, Rate: 0.1, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if msg, _ := stage.Process(userMessage("u")); msg != nil {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Errorf("want roughly 10%% kept, got %d of 10000", kept)
+	}
+}
+
+func TestSampleStage_ReservoirWindow(t *testing.T) {
+	stage, err := NewSampleStage(SampleConfig{Mode: ModeReservoir, Size: 3, Window: time.Minute, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	stage.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		out, err := stage.ProcessAll(userMessage("u"))
+		if err != nil || len(out) != 0 {
+			t.Fatalf("want nothing emitted inside the window, got %d, %v", len(out), err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	out, _ := stage.ProcessAll(userMessage("next"))
+	if len(out) != 3 {
+		t.Fatalf("want a sample of 3 when the window closes, got %d", len(out))
+	}
+
+	out, _ = stage.Flush()
+	if len(out) != 1 || out[0].Payload.Data["user"].Value != "next" {
+		t.Errorf("want the last window flushed at end of stream, got %d", len(out))
+	}
+}
+
+func TestSampleStage_ReservoirArrivalOrder(t *testing.T) {
+	stage, err := NewSampleStage(SampleConfig{Mode: ModeReservoir, Size: 5, Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		msg := userMessage("u")
+		msg.ID = fmt.Sprintf("%03d", i)
+		if _, err := stage.ProcessAll(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, _ := stage.Flush()
+	if len(out) != 5 {
+		t.Fatalf("want 5, got %d", len(out))
+	}
+	for i := 1; i < len(out); i++ {
+		if out[i-1].ID >= out[i].ID {
+			t.Fatalf("want arrival order, got %s before %s", out[i-1].ID, out[i].ID)
+		}
+	}
+}
+
+func TestSampleStage_TickClosesIdleWindow(t *testing.T) {
+	stage, err := NewSampleStage(SampleConfig{Mode: ModeReservoir, Size: 2, Window: time.Minute, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	stage.now = func() time.Time { return now }
+	if stage.TickInterval() != 6*time.Second {
+		t.Errorf("tick interval = %v", stage.TickInterval())
+	}
+
+	stage.ProcessAll(userMessage("a"))
+	if out, _ := stage.Tick(); len(out) != 0 {
+		t.Fatalf("want nothing before the window ends, got %d", len(out))
+	}
+	now = now.Add(time.Minute)
+	if out, _ := stage.Tick(); len(out) != 1 {
+		t.Fatalf("want the idle window emitted, got %d", len(out))
+	}
+	if out, _ := stage.Tick(); len(out) != 0 {
+		t.Errorf("want an empty window to emit nothing, got %d", len(out))
+	}
+}
+
+// pausingSource 返回 msgs 后一直阻塞，直到 resume 被关闭
+type pausingSource struct {
+	msgs   []*pipeline.Message
+	resume chan struct{}
+}
+
+func (s *pausingSource) Read() (*pipeline.Message, error) {
+	if len(s.msgs) == 0 {
+		<-s.resume
+		return nil, io.EOF
+	}
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return msg, nil
+}
+
+type chanSink chan *pipeline.Message
+
+func (s chanSink) Write(msg *pipeline.Message) error {
+	s <- msg
+	return nil
+}
+
+func TestSampleStage_WindowClosesWhileSourceIsIdle(t *testing.T) {
+	stage, err := NewSampleStage(SampleConfig{Mode: ModeReservoir, Size: 5, Window: 20 * time.Millisecond, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &pausingSource{msgs: []*pipeline.Message{userMessage("a"), userMessage("b")}, resume: make(chan struct{})}
+	sink := make(chanSink, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- pipeline.NewLittlePipe(pipeline.Config{}).SetSource(source).AddStage(stage).SetSink(sink).Run()
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sink:
+		case <-time.After(5 * time.Second):
+			t.Fatal("sample held back while the source is idle")
+		}
+	}
+	close(source.resume)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
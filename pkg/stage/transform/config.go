@@ -3,12 +3,23 @@ package transform
 import "github.com/ipush/littlepipe/pkg/pipeline"
 
 type TransformRule struct {
-	Target   string             `json:"target"`
-	Expr     string             `json:"expr"`
-	Type     pipeline.FieldType `json:"type"`
-	Required bool               `json:"required"`
+	Target   string             `json:"target" yaml:"target"`
+	Expr     string             `json:"expr" yaml:"expr"`
+	Type     pipeline.FieldType `json:"type" yaml:"type"`
+	Required bool               `json:"required" yaml:"required"`
+	// Elem and Fields type the elements of list and dict targets,
+	// Scale fixes the fractional digits of decimal targets,
+	// Timezone and Precision apply to timestamp and duration targets
+	Elem      *pipeline.Field        `json:"elem,omitempty" yaml:"elem,omitempty"`
+	Fields    []pipeline.Field       `json:"fields,omitempty" yaml:"fields,omitempty"`
+	Scale     *int                   `json:"scale,omitempty" yaml:"scale,omitempty"`
+	Timezone  string                 `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	Precision pipeline.TimePrecision `json:"precision,omitempty" yaml:"precision,omitempty"`
 }
 
 type TransformConfig struct {
-	Rules []TransformRule `json:"rules"`
+	Rules []TransformRule `json:"rules" yaml:"rules"`
+	// KeepInput starts from a copy of the input record instead of an empty one,
+	// so fields without a rule pass through unchanged
+	KeepInput bool `json:"keep_input,omitempty" yaml:"keep_input,omitempty"`
 }
@@ -2,6 +2,7 @@ package transform
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/expr-lang/expr"
 	"github.com/expr-lang/expr/vm"
@@ -9,10 +10,12 @@ import (
 )
 
 type compiledRule struct {
-	target   string
-	program  *vm.Program
-	type_    pipeline.FieldType
-	required bool
+	target  string
+	program *vm.Program
+	// field describes the target, including element types of lists and dicts
+	field pipeline.Field
+	// nested targets are paths such as user.address.city
+	nested bool
 }
 
 type ExprTransform struct {
@@ -35,101 +38,112 @@ func NewExprTransformer(config TransformConfig) *ExprTransform {
 		}
 
 		transformer.rules = append(transformer.rules, &compiledRule{
-			target:   rule.Target,
-			program:  program,
-			required: rule.Required,
-			type_:    rule.Type,
+			target:  rule.Target,
+			program: program,
+			field: pipeline.Field{
+				Name:      rule.Target,
+				Type:      rule.Type,
+				Required:  rule.Required,
+				Fields:    rule.Fields,
+				Elem:      rule.Elem,
+				Scale:     rule.Scale,
+				Timezone:  rule.Timezone,
+				Precision: rule.Precision,
+			},
+			nested: strings.ContainsAny(rule.Target, ".["),
 		})
 	}
 	return transformer
 }
 
 func (t *ExprTransform) Process(msg *pipeline.Message) (*pipeline.Message, error) {
-	newRecord := &pipeline.Record{
-		Schema: &pipeline.Schema{
-			Fields: make([]pipeline.Field, 0, len(t.rules)),
-		},
-		Data:      make(map[string]pipeline.Value),
-		Timestamp: msg.Payload.Timestamp,
+	var newRecord *pipeline.Record
+	if t.config.KeepInput {
+		// rules see the input as it was, even when they overwrite its fields
+		newRecord = msg.Payload.Clone()
+		if newRecord.Schema == nil {
+			newRecord.Schema = &pipeline.Schema{}
+		}
+		if newRecord.Data == nil {
+			newRecord.Data = make(map[string]pipeline.Value)
+		}
+	} else {
+		newRecord = &pipeline.Record{
+			Schema: &pipeline.Schema{
+				Fields: make([]pipeline.Field, 0, len(t.rules)),
+			},
+			Data:      make(map[string]pipeline.Value),
+			Timestamp: msg.Payload.Timestamp,
+		}
 	}
 
 	// prepare expr env
 	env := make(map[string]any)
 	for name, value := range msg.Payload.Data {
-		env[name] = value.Value
+		env[name] = exprValue(value.Native())
 	}
 
 	for _, rule := range t.rules {
 		result, err := expr.Run(rule.program, env)
 		if err != nil {
-			if rule.required {
-				return nil, fmt.Errorf("execute rule %s: %w", rule.target, err)
+			if rule.field.Required {
+				return nil, pipeline.Errorf(pipeline.KindValidation, "execute rule %s: %w", rule.target, err)
 			}
 			continue
 		}
 
-		value, err := convertValue(result, rule.type_)
+		value, err := pipeline.FromNative(result, rule.field)
 		if err != nil {
-			return nil, fmt.Errorf("convert value for %s: %w", rule.target, err)
+			return nil, pipeline.Errorf(pipeline.KindConversion, "convert value for %s: %w", rule.target, err)
 		}
 
-		newRecord.Schema.Fields = append(newRecord.Schema.Fields, pipeline.Field{
-			Name:     rule.target,
-			Type:     rule.type_,
-			Required: rule.required,
-		})
+		if !rule.nested {
+			setTopField(newRecord.Schema, rule.field)
+			newRecord.Data[rule.target] = value
+			continue
+		}
 
-		newRecord.Data[rule.target] = value
+		if err := newRecord.Schema.SetField(rule.target, rule.field); err != nil {
+			return nil, fmt.Errorf("set field %s: %w", rule.target, err)
+		}
+		if err := newRecord.SetValue(rule.target, value); err != nil {
+			return nil, fmt.Errorf("set value %s: %w", rule.target, err)
+		}
 	}
 
 	return &pipeline.Message{
 		ID:       msg.ID,
 		Payload:  newRecord,
 		Metadata: msg.Metadata,
+		Priority: msg.Priority,
 	}, nil
 }
 
-func convertValue(v interface{}, t pipeline.FieldType) (pipeline.Value, error) {
-	switch t {
-	case pipeline.TypeString:
-		str, ok := v.(string)
-		if !ok {
-			return pipeline.Value{}, fmt.Errorf("cannot convert %T to string", v)
-		}
-		return pipeline.Value{Type: t, Value: str}, nil
-
-	case pipeline.TypeInt64:
-		switch num := v.(type) {
-		case int:
-			return pipeline.Value{Type: t, Value: int64(num)}, nil
-		case int64:
-			return pipeline.Value{Type: t, Value: num}, nil
-		case float64:
-			return pipeline.Value{Type: t, Value: int64(num)}, nil
-		default:
-			return pipeline.Value{}, fmt.Errorf("cannot convert %T to int64", v)
+// setTopField 替换同名的顶层字段，不存在时追加
+func setTopField(schema *pipeline.Schema, field pipeline.Field) {
+	for i := range schema.Fields {
+		if schema.Fields[i].Name == field.Name {
+			schema.Fields[i] = field
+			return
 		}
+	}
+	schema.Fields = append(schema.Fields, field)
+}
 
-	case pipeline.TypeFloat64:
-		switch num := v.(type) {
-		case float64:
-			return pipeline.Value{Type: t, Value: num}, nil
-		case int:
-			return pipeline.Value{Type: t, Value: float64(num)}, nil
-		case int64:
-			return pipeline.Value{Type: t, Value: float64(num)}, nil
-		default:
-			return pipeline.Value{}, fmt.Errorf("cannot convert %T to float64", v)
+// exprValue 把 Decimal 转换为 float64，expr 的算术和比较运算不支持 Decimal，
+// 例如 logfmt 和 CSV 默认把 1.5 这样的数解码为 Decimal
+func exprValue(v any) any {
+	switch inner := v.(type) {
+	case pipeline.Decimal:
+		return inner.Float64()
+	case map[string]any:
+		for k, child := range inner {
+			inner[k] = exprValue(child)
 		}
-
-	case pipeline.TypeBoolean:
-		b, ok := v.(bool)
-		if !ok {
-			return pipeline.Value{}, fmt.Errorf("cannot convert %T to boolean", v)
+	case []any:
+		for i, child := range inner {
+			inner[i] = exprValue(child)
 		}
-		return pipeline.Value{Type: t, Value: b}, nil
-
-	default:
-		return pipeline.Value{}, fmt.Errorf("unsupported type: %v", t)
 	}
+	return v
 }
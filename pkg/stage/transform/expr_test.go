@@ -2,9 +2,12 @@ package transform
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ipush/littlepipe/pkg/codec"
+	"github.com/ipush/littlepipe/pkg/codec/logfmt"
 	"github.com/ipush/littlepipe/pkg/pipeline"
 )
 
@@ -212,3 +215,166 @@ func BenchmarkExprTransformer_Parallel(b *testing.B) {
 		}
 	})
 }
+
+// 测试嵌套路径的读取和写入
+func TestExprTransformer_NestedPaths(t *testing.T) {
+	transformer := NewExprTransformer(TransformConfig{
+		Rules: []TransformRule{
+			{
+				Target:   "location.city",
+				Expr:     `user.address.city`,
+				Type:     pipeline.TypeString,
+				Required: true,
+			},
+			{
+				Target:   "first_sku",
+				Expr:     `items[0].sku`,
+				Type:     pipeline.TypeString,
+				Required: true,
+			},
+		},
+	})
+
+	msg := pipeline.NewMessage(&pipeline.Record{Data: map[string]pipeline.Value{
+		"user": {Type: pipeline.TypeDict, Value: map[string]pipeline.Value{
+			"address": {Type: pipeline.TypeDict, Value: map[string]pipeline.Value{
+				"city": {Type: pipeline.TypeString, Value: "Berlin"},
+			}},
+		}},
+		"items": {Type: pipeline.TypeList, Value: []pipeline.Value{
+			{Type: pipeline.TypeDict, Value: map[string]pipeline.Value{
+				"sku": {Type: pipeline.TypeString, Value: "A1"},
+			}},
+		}},
+	}})
+
+	out, err := transformer.Process(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := out.Payload.GetValue("location.city"); v.Value != "Berlin" {
+		t.Errorf("want Berlin, got %v", v.Value)
+	}
+	if v, _ := out.Payload.GetValue("first_sku"); v.Value != "A1" {
+		t.Errorf("want A1, got %v", v.Value)
+	}
+	if err := out.Payload.Schema.Validate(out.Payload); err != nil {
+		t.Errorf("want output valid against its schema, got %v", err)
+	}
+}
+
+// 测试 list、dict、decimal 和 json 类型的规则
+func TestExprTransformer_CompositeTypes(t *testing.T) {
+	scale := 2
+	transformer := NewExprTransformer(TransformConfig{
+		Rules: []TransformRule{
+			{Target: "names", Expr: `[first_name, last_name]`, Type: pipeline.TypeList,
+				Elem: &pipeline.Field{Type: pipeline.TypeString}, Required: true},
+			{Target: "person", Expr: `{"name": first_name, "age": age}`, Type: pipeline.TypeDict,
+				Fields: []pipeline.Field{{Name: "age", Type: pipeline.TypeInt64}}, Required: true},
+			{Target: "net_salary", Expr: "salary * 0.8", Type: pipeline.TypeDecimal, Scale: &scale, Required: true},
+			{Target: "raw", Expr: `{"active": is_active}`, Type: pipeline.TypeJSON, Required: true},
+		},
+	})
+
+	out, err := transformer.Process(createTestMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := out.Payload.GetValue("names[1]"); v.Value != "Doe" {
+		t.Errorf("want Doe, got %v", v.Value)
+	}
+	if v, _ := out.Payload.GetValue("person.age"); v.Type != pipeline.TypeInt64 || v.Value != int64(30) {
+		t.Errorf("want int64 age, got %+v", v)
+	}
+	if v, _ := out.Payload.GetValue("net_salary"); v.Value.(pipeline.Decimal).String() != "40000.00" {
+		t.Errorf("want 40000.00, got %v", v.Value)
+	}
+	if v, _ := out.Payload.GetValue("raw"); fmt.Sprintf("%s", v.Value) != `{"active":true}` {
+		t.Errorf("want JSON document, got %s", v.Value)
+	}
+	if err := out.Payload.Schema.Validate(out.Payload); err != nil {
+		t.Errorf("want output valid against its schema, got %v", err)
+	}
+}
+
+// 测试保留输入记录，规则只覆盖目标字段
+func TestExprTransformer_KeepInput(t *testing.T) {
+	transformer := NewExprTransformer(TransformConfig{
+		KeepInput: true,
+		Rules: []TransformRule{
+			{Target: "age", Expr: "age + 1", Type: pipeline.TypeInt64, Required: true},
+			{Target: "name", Expr: `first_name + " " + last_name`, Type: pipeline.TypeString, Required: true},
+		},
+	})
+
+	in := createTestMessage()
+	out, err := transformer.Process(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := out.Payload.Data["age"]; v.Value != int64(31) {
+		t.Errorf("want age overwritten, got %v", v.Value)
+	}
+	if v := out.Payload.Data["salary"]; v.Value != float64(50000) {
+		t.Errorf("want salary passed through, got %v", v.Value)
+	}
+	if in.Payload.Data["age"].Value != int64(30) {
+		t.Error("want input record untouched")
+	}
+	if len(out.Payload.Schema.Fields) != 6 {
+		t.Errorf("want input fields plus name, got %d fields", len(out.Payload.Schema.Fields))
+	}
+	if err := out.Payload.Schema.Validate(out.Payload); err != nil {
+		t.Errorf("want output valid against its schema, got %v", err)
+	}
+}
+
+// decoderSource 从 codec 的流式解码器读取消息
+type decoderSource struct{ decoder codec.Decoder }
+
+func (s decoderSource) Read() (*pipeline.Message, error) {
+	record, err := s.decoder.Decode()
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.NewMessage(record), nil
+}
+
+type recordSink struct{ records []*pipeline.Record }
+
+func (s *recordSink) Write(msg *pipeline.Message) error {
+	s.records = append(s.records, msg.Payload)
+	return nil
+}
+
+// 测试 logfmt 默认解码的 decimal 可以直接参与表达式运算
+func TestExprTransformer_LogfmtDecimals(t *testing.T) {
+	transformer := NewExprTransformer(TransformConfig{
+		Rules: []TransformRule{
+			{Target: "total", Expr: "price * qty", Type: pipeline.TypeFloat64, Required: true},
+			{Target: "expensive", Expr: "price > 1", Type: pipeline.TypeBoolean, Required: true},
+		},
+	})
+	input := "price=1.5 qty=2\nprice=0.25 qty=4\n"
+	sink := &recordSink{}
+	err := pipeline.NewLittlePipe(pipeline.Config{}).
+		SetSource(decoderSource{codec.NewDecoder(logfmt.New(logfmt.Config{}), strings.NewReader(input))}).
+		AddStage(transformer).
+		SetSink(sink).
+		Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 2 {
+		t.Fatalf("want 2 records, got %d", len(sink.records))
+	}
+	for i, want := range []float64{3, 1} {
+		if got := sink.records[i].Data["total"].Value; got != want {
+			t.Errorf("record %d: want total %v, got %v", i, want, got)
+		}
+	}
+	if sink.records[0].Data["expensive"].Value != true || sink.records[1].Data["expensive"].Value != false {
+		t.Errorf("want comparisons on decimals, got %v, %v", sink.records[0].Data, sink.records[1].Data)
+	}
+}
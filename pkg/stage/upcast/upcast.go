@@ -0,0 +1,39 @@
+package upcast
+
+import (
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/ipush/littlepipe/pkg/schema"
+)
+
+// UpcastStage 将版本与当前 schema 不同的记录转换为当前版本。
+// Version 为 0 的记录没有版本信息，原样通过
+type UpcastStage struct {
+	target *pipeline.Schema
+}
+
+func NewUpcastStage(target *pipeline.Schema) *UpcastStage {
+	return &UpcastStage{target: target}
+}
+
+func (s *UpcastStage) Process(msg *pipeline.Message) (*pipeline.Message, error) {
+	if msg.Payload == nil || msg.Payload.Version == 0 || msg.Payload.Version == s.target.Version {
+		return msg, nil
+	}
+
+	record, err := schema.Upcast(msg.Payload, s.target)
+	if err != nil {
+		return nil, pipeline.Errorf(pipeline.KindValidation, "upcast message %s from version %d to %d: %w",
+			msg.ID, msg.Payload.Version, s.target.Version, err)
+	}
+	msg.Payload = record
+	return msg, nil
+}
+
+// NewUpcastStageFromRegistry 以注册中心中 ref 指向的 schema 作为当前版本
+func NewUpcastStageFromRegistry(registry *schema.Registry, ref schema.Ref) (*UpcastStage, error) {
+	target, err := registry.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	return NewUpcastStage(target), nil
+}
@@ -0,0 +1,51 @@
+package upcast
+
+import (
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+func target() *pipeline.Schema {
+	return &pipeline.Schema{Version: 2, Fields: []pipeline.Field{
+		{Name: "id", Type: pipeline.TypeInt64},
+		{Name: "price", Type: pipeline.TypeFloat64},
+		{Name: "currency", Type: pipeline.TypeString, Default: "EUR"},
+	}}
+}
+
+func message(version int, price pipeline.Value) *pipeline.Message {
+	return pipeline.NewMessage(&pipeline.Record{Version: version, Data: map[string]pipeline.Value{
+		"id":    pipeline.ValueOf(int64(1)),
+		"price": price,
+	}})
+}
+
+func TestUpcastStage_OldVersion(t *testing.T) {
+	out, err := NewUpcastStage(target()).Process(message(1, pipeline.ValueOf(int64(3))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := out.Payload
+	if r.Version != 2 || r.Data["price"].Value != 3.0 || r.Data["currency"].Value != "EUR" {
+		t.Errorf("upcast = %v (version %d)", r.Data, r.Version)
+	}
+}
+
+func TestUpcastStage_UnversionedPassesThrough(t *testing.T) {
+	msg := message(0, pipeline.ValueOf("not a number"))
+	out, err := NewUpcastStage(target()).Process(msg)
+	if err != nil || out != msg {
+		t.Fatalf("want unversioned record unchanged, got %v", err)
+	}
+	if out.Payload.Version != 0 || out.Payload.Data["price"].Value != "not a number" {
+		t.Errorf("record = %v", out.Payload.Data)
+	}
+}
+
+func TestUpcastStage_IncompatibleIsValidationError(t *testing.T) {
+	_, err := NewUpcastStage(target()).Process(message(1, pipeline.ValueOf("3")))
+	if pipeline.KindOf(err) != pipeline.KindValidation {
+		t.Errorf("want validation error, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+// MetadataViolations 写入死信消息元数据的键，值为 []pipeline.Violation
+const MetadataViolations = "validation.violations"
+
+// ValidateStage 按 schema 校验记录并收集全部问题。配置了死信 sink 时，
+// 无效记录连同问题列表写入死信 sink 并从 pipeline 中过滤掉，否则返回校验错误
+type ValidateStage struct {
+	schema     *pipeline.Schema
+	deadLetter pipeline.Sink
+}
+
+func NewValidateStage(schema *pipeline.Schema, deadLetter pipeline.Sink) *ValidateStage {
+	return &ValidateStage{
+		schema:     schema,
+		deadLetter: deadLetter,
+	}
+}
+
+func (s *ValidateStage) Process(msg *pipeline.Message) (*pipeline.Message, error) {
+	if msg.Payload == nil {
+		return nil, pipeline.Errorf(pipeline.KindValidation, "message %s has no payload", msg.ID)
+	}
+
+	s.schema.Strip(msg.Payload)
+	err := s.schema.ValidateAll(msg.Payload)
+	if err == nil {
+		return msg, nil
+	}
+	if s.deadLetter == nil {
+		return nil, err
+	}
+
+	var verr *pipeline.ValidationError
+	if errors.As(err, &verr) {
+		if msg.Metadata == nil {
+			msg.Metadata = make(map[string]any)
+		}
+		msg.Metadata[MetadataViolations] = verr.Violations
+	}
+	if err := s.deadLetter.Write(msg); err != nil {
+		return nil, fmt.Errorf("write dead letter: %w", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,99 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+)
+
+type memorySink struct {
+	msgs []*pipeline.Message
+}
+
+func (s *memorySink) Write(msg *pipeline.Message) error {
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func orderSchema() *pipeline.Schema {
+	return &pipeline.Schema{
+		UnknownFields: pipeline.UnknownReject,
+		Fields: []pipeline.Field{
+			{Name: "id", Type: pipeline.TypeString, Required: true,
+				Constraints: &pipeline.Constraints{Pattern: `^ord-[0-9]+$`, MaxLength: ptr(12)}},
+			{Name: "status", Type: pipeline.TypeString, Required: true,
+				Constraints: &pipeline.Constraints{Enum: []any{"new", "paid"}}},
+			{Name: "amount", Type: pipeline.TypeFloat64,
+				Constraints: &pipeline.Constraints{Min: ptr(0.0), Max: ptr(1000.0)}},
+			{Name: "qty", Type: pipeline.TypeInt64,
+				Constraints: &pipeline.Constraints{Enum: []any{float64(1), float64(2)}}},
+			{Name: "note", Type: pipeline.TypeString, Nullable: true},
+		},
+	}
+}
+
+func order(data map[string]pipeline.Value) *pipeline.Message {
+	return pipeline.NewMessage(&pipeline.Record{Data: data})
+}
+
+func TestValidateStage(t *testing.T) {
+	deadLetter := &memorySink{}
+	stage := NewValidateStage(orderSchema(), deadLetter)
+
+	valid := order(map[string]pipeline.Value{
+		"id":     {Type: pipeline.TypeString, Value: "ord-1"},
+		"status": {Type: pipeline.TypeString, Value: "paid"},
+		"amount": {Type: pipeline.TypeFloat64, Value: 10.5},
+		"qty":    {Type: pipeline.TypeInt64, Value: int64(2)},
+		"note":   {Type: pipeline.TypeString, Value: nil},
+	})
+	if out, err := stage.Process(valid); err != nil || out != valid {
+		t.Fatalf("want valid record passed through, got %v", err)
+	}
+
+	invalid := order(map[string]pipeline.Value{
+		"id":     {Type: pipeline.TypeString, Value: "order-1234567890"},
+		"status": {Type: pipeline.TypeString, Value: "lost"},
+		"amount": {Type: pipeline.TypeFloat64, Value: -1.0},
+		"qty":    {Type: pipeline.TypeInt64, Value: int64(3)},
+		"extra":  {Type: pipeline.TypeBoolean, Value: true},
+	})
+	out, err := stage.Process(invalid)
+	if err != nil || out != nil {
+		t.Fatalf("want invalid record filtered, got %v, %v", out, err)
+	}
+	if len(deadLetter.msgs) != 1 {
+		t.Fatalf("want invalid record in dead letter sink, got %d", len(deadLetter.msgs))
+	}
+
+	violations := deadLetter.msgs[0].Metadata[MetadataViolations].([]pipeline.Violation)
+	rules := make(map[string]bool)
+	for _, v := range violations {
+		rules[v.Path+":"+v.Rule] = true
+	}
+	for _, want := range []string{"id:pattern", "id:max_length", "status:enum", "amount:min", "qty:enum", "extra:unknown"} {
+		if !rules[want] {
+			t.Errorf("want violation %s, got %v", want, violations)
+		}
+	}
+}
+
+func TestValidateStage_WithoutDeadLetter(t *testing.T) {
+	stage := NewValidateStage(orderSchema(), nil)
+	_, err := stage.Process(order(map[string]pipeline.Value{
+		"id": {Type: pipeline.TypeString, Value: nil},
+	}))
+
+	var verr *pipeline.ValidationError
+	if !errors.As(err, &verr) || pipeline.KindOf(err) != pipeline.KindValidation {
+		t.Fatalf("want classified validation error, got %v", err)
+	}
+	if len(verr.Violations) != 2 {
+		t.Errorf("want null id and missing status reported, got %v", verr.Violations)
+	}
+}
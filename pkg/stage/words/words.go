@@ -7,7 +7,7 @@ import (
 	"github.com/ipush/littlepipe/pkg/pipeline"
 )
 
-// UppercaseStage 将文本转换为大写
+// UppercaseStage 将记录中顶层的字符串字段转换为大写
 type UppercaseStage struct{}
 
 func NewUppercaseStage() *UppercaseStage {
@@ -15,8 +15,15 @@ func NewUppercaseStage() *UppercaseStage {
 }
 
 func (s *UppercaseStage) Process(data *pipeline.Message) (*pipeline.Message, error) {
-	if str, ok := data.Payload.(string); ok {
-		return pipeline.NewMessage(strings.ToUpper(str)), nil
+	if data.Payload == nil {
+		return nil, fmt.Errorf("UppercaseStage: message %s has no payload", data.ID)
 	}
-	return nil, fmt.Errorf("UppercaseStage: unsupported data type: %T", data)
+	record := data.Payload.Clone()
+	for name, value := range record.Data {
+		if str, ok := value.Value.(string); ok && value.Type == pipeline.TypeString {
+			record.Data[name] = pipeline.Value{Type: pipeline.TypeString, Value: strings.ToUpper(str)}
+		}
+	}
+	data.Payload = record
+	return data, nil
 }
@@ -0,0 +1,62 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Handler 暴露 pipeline 状态以及 start/stop/restart 操作:
+//
+//	GET  /pipelines
+//	GET  /pipelines/{name}
+//	POST /pipelines/{name}/start
+//	POST /pipelines/{name}/stop
+//	POST /pipelines/{name}/restart
+func (s *Supervisor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pipelines", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Statuses())
+	})
+	mux.HandleFunc("GET /pipelines/{name}", func(w http.ResponseWriter, r *http.Request) {
+		st, err := s.Status(r.PathValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	})
+	mux.HandleFunc("POST /pipelines/{name}/start", s.action(s.Start))
+	mux.HandleFunc("POST /pipelines/{name}/stop", s.action(s.Stop))
+	mux.HandleFunc("POST /pipelines/{name}/restart", s.action(s.Restart))
+	return mux
+}
+
+func (s *Supervisor) action(fn func(name string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if err := fn(name); err != nil {
+			writeError(w, err)
+			return
+		}
+		st, _ := s.Status(name)
+		writeJSON(w, http.StatusOK, st)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, ErrAlreadyRunning), errors.Is(err, ErrNotRunning):
+		code = http.StatusConflict
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
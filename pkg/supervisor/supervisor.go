@@ -0,0 +1,429 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/observability"
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNotFound       = errors.New("pipeline not found")
+	ErrAlreadyExists  = errors.New("pipeline already exists")
+	ErrAlreadyRunning = errors.New("pipeline already running")
+	ErrNotRunning     = errors.New("pipeline not running")
+)
+
+// Env 每个 pipeline 独享的日志、追踪和指标
+type Env struct {
+	Name    string
+	Logger  observability.Logger
+	Tracer  *observability.Tracer
+	Metrics *observability.Metrics
+}
+
+// Factory 每次（重新）启动时创建一个新的 LittlePipe，
+// 因为 LittlePipe 在 Run 返回后不能再次运行
+type Factory func(env Env) (*pipeline.LittlePipe, error)
+
+// Backoff 失败重启的指数退避策略
+type Backoff struct {
+	Min        time.Duration
+	Max        time.Duration
+	Multiplier float64
+	// a run lasting at least ResetAfter resets the delay back to Min
+	ResetAfter time.Duration
+}
+
+var DefaultBackoff = Backoff{
+	Min:        time.Second,
+	Max:        time.Minute,
+	Multiplier: 2,
+	ResetAfter: time.Minute,
+}
+
+func (b Backoff) delay(attempt int) time.Duration {
+	d := float64(b.Min)
+	for i := 0; i < attempt; i++ {
+		d *= b.Multiplier
+		if d >= float64(b.Max) {
+			return b.Max
+		}
+	}
+	return time.Duration(d)
+}
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Min <= 0 {
+		b.Min = DefaultBackoff.Min
+	}
+	if b.Max < b.Min {
+		b.Max = b.Min
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	}
+	if b.ResetAfter <= 0 {
+		b.ResetAfter = DefaultBackoff.ResetAfter
+	}
+	return b
+}
+
+type Spec struct {
+	Name    string
+	Factory Factory
+	Backoff Backoff
+	// MaxRestarts limits consecutive restarts, 0 means unlimited
+	MaxRestarts int
+}
+
+type State string
+
+const (
+	StateStopped  State = "stopped"
+	StateRunning  State = "running"
+	StateBackoff  State = "backoff"
+	StateFinished State = "finished"
+	StateFailed   State = "failed"
+)
+
+type Status struct {
+	Name      string    `json:"name"`
+	State     State     `json:"state"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+}
+
+type Config struct {
+	// Namespace is the metrics namespace shared by all pipelines,
+	// each pipeline's series carry a "pipeline" label
+	Namespace  string
+	Logger     observability.Logger
+	Registerer prometheus.Registerer
+}
+
+// Supervisor 在同一进程内运行多个命名的 pipeline，并在失败时按退避策略重启
+type Supervisor struct {
+	config   Config
+	restarts *prometheus.CounterVec
+
+	mu        sync.Mutex
+	pipelines map[string]*managed
+}
+
+func New(config Config) *Supervisor {
+	if config.Logger == nil {
+		config.Logger = observability.NewLogger()
+	}
+	if config.Registerer == nil {
+		config.Registerer = prometheus.DefaultRegisterer
+	}
+
+	restarts := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: config.Namespace,
+		Name:      "pipeline_restarts_total",
+		Help:      "Total number of pipeline restarts after failure",
+	}, []string{"pipeline"})
+	config.Registerer.MustRegister(restarts)
+
+	return &Supervisor{
+		config:    config,
+		restarts:  restarts,
+		pipelines: make(map[string]*managed),
+	}
+}
+
+// Add 注册一个 pipeline，但不会启动它
+func (s *Supervisor) Add(spec Spec) error {
+	if spec.Name == "" || spec.Factory == nil {
+		return fmt.Errorf("pipeline name and factory are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pipelines[spec.Name]; ok {
+		return fmt.Errorf("%s: %w", spec.Name, ErrAlreadyExists)
+	}
+
+	spec.Backoff = spec.Backoff.withDefaults()
+	reg := prometheus.WrapRegistererWith(prometheus.Labels{"pipeline": spec.Name}, s.config.Registerer)
+	s.pipelines[spec.Name] = &managed{
+		spec: spec,
+		env: Env{
+			Name:    spec.Name,
+			Logger:  s.config.Logger.With(zap.String("pipeline", spec.Name)),
+			Tracer:  observability.NewTracer(spec.Name),
+			Metrics: observability.NewMetricsWithRegisterer(s.config.Namespace, reg),
+		},
+		restarts: s.restarts.WithLabelValues(spec.Name),
+		state:    StateStopped,
+	}
+	return nil
+}
+
+// Remove 停止并移除 pipeline，同时注销它的指标
+func (s *Supervisor) Remove(name string) error {
+	s.mu.Lock()
+	m, ok := s.pipelines[name]
+	if ok {
+		delete(s.pipelines, name)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+
+	m.stop()
+	m.env.Metrics.Unregister()
+	s.restarts.DeleteLabelValues(name)
+	return nil
+}
+
+func (s *Supervisor) Start(name string) error {
+	m, err := s.get(name)
+	if err != nil {
+		return err
+	}
+	return m.start()
+}
+
+func (s *Supervisor) Stop(name string) error {
+	m, err := s.get(name)
+	if err != nil {
+		return err
+	}
+	if !m.stop() {
+		return fmt.Errorf("%s: %w", name, ErrNotRunning)
+	}
+	return nil
+}
+
+func (s *Supervisor) Restart(name string) error {
+	m, err := s.get(name)
+	if err != nil {
+		return err
+	}
+	m.stop()
+	return m.start()
+}
+
+// StartAll 启动所有尚未运行的 pipeline
+func (s *Supervisor) StartAll() {
+	for _, m := range s.list() {
+		m.start()
+	}
+}
+
+// StopAll 并行停止所有 pipeline 并等待它们退出
+func (s *Supervisor) StopAll() {
+	var wg sync.WaitGroup
+	for _, m := range s.list() {
+		wg.Add(1)
+		go func(m *managed) {
+			defer wg.Done()
+			m.stop()
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (s *Supervisor) Status(name string) (Status, error) {
+	m, err := s.get(name)
+	if err != nil {
+		return Status{}, err
+	}
+	return m.status(), nil
+}
+
+// Statuses 返回按名称排序的全部 pipeline 状态
+func (s *Supervisor) Statuses() []Status {
+	list := s.list()
+	statuses := make([]Status, 0, len(list))
+	for _, m := range list {
+		statuses = append(statuses, m.status())
+	}
+	return statuses
+}
+
+func (s *Supervisor) get(name string) (*managed, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.pipelines[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return m, nil
+}
+
+func (s *Supervisor) list() []*managed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]*managed, 0, len(s.pipelines))
+	for _, m := range s.pipelines {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].spec.Name < list[j].spec.Name
+	})
+	return list
+}
+
+type managed struct {
+	spec     Spec
+	env      Env
+	restarts prometheus.Counter
+
+	mu           sync.Mutex
+	state        State
+	restartCount int
+	lastErr      error
+	startedAt    time.Time
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+func (m *managed) start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done != nil {
+		select {
+		case <-m.done:
+		default:
+			return fmt.Errorf("%s: %w", m.spec.Name, ErrAlreadyRunning)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	m.restartCount = 0
+	m.lastErr = nil
+	go m.loop(ctx, m.done)
+	return nil
+}
+
+// stop 返回 false 表示 pipeline 本来就没有在运行
+func (m *managed) stop() bool {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.mu.Unlock()
+	if done == nil {
+		return false
+	}
+
+	select {
+	case <-done:
+		return false
+	default:
+	}
+	cancel()
+	<-done
+	return true
+}
+
+func (m *managed) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	logger := m.env.Logger
+
+	attempt := 0
+	for {
+		startedAt := time.Now()
+		m.setState(StateRunning, startedAt, nil)
+		logger.Info("starting pipeline")
+
+		err := m.runOnce(ctx)
+		if ctx.Err() != nil {
+			m.setState(StateStopped, time.Time{}, nil)
+			logger.Info("pipeline stopped")
+			return
+		}
+		if err == nil {
+			m.setState(StateFinished, time.Time{}, nil)
+			logger.Info("pipeline finished")
+			return
+		}
+
+		kind := pipeline.KindOf(err)
+		logger.Error("pipeline failed",
+			zap.String("error_kind", string(kind)),
+			zap.Error(err))
+		if kind == pipeline.KindPermanent {
+			// restarting cannot fix a permanent error
+			m.setState(StateFailed, time.Time{}, err)
+			return
+		}
+		if time.Since(startedAt) >= m.spec.Backoff.ResetAfter {
+			attempt = 0
+		}
+		if m.spec.MaxRestarts > 0 && attempt >= m.spec.MaxRestarts {
+			m.setState(StateFailed, time.Time{}, err)
+			logger.Error("pipeline gave up after max restarts",
+				zap.Int("max_restarts", m.spec.MaxRestarts))
+			return
+		}
+
+		delay := m.spec.Backoff.delay(attempt)
+		attempt++
+		m.setState(StateBackoff, time.Time{}, err)
+		logger.Info("restarting pipeline", zap.Duration("delay", delay))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			m.setState(StateStopped, time.Time{}, err)
+			logger.Info("pipeline stopped")
+			return
+		}
+
+		m.restarts.Inc()
+		m.mu.Lock()
+		m.restartCount++
+		m.mu.Unlock()
+	}
+}
+
+func (m *managed) runOnce(ctx context.Context) error {
+	pipe, err := m.spec.Factory(m.env)
+	if err != nil {
+		return fmt.Errorf("create pipeline: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, pipe.Stop)
+	defer stop()
+	return pipe.Run()
+}
+
+func (m *managed) setState(state State, startedAt time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+	m.startedAt = startedAt
+	if err != nil {
+		m.lastErr = err
+	}
+}
+
+func (m *managed) status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := Status{
+		Name:      m.spec.Name,
+		State:     m.state,
+		Restarts:  m.restartCount,
+		StartedAt: m.startedAt,
+	}
+	if m.lastErr != nil {
+		st.LastError = m.lastErr.Error()
+	}
+	return st
+}
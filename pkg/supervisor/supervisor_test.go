@@ -0,0 +1,140 @@
+package supervisor
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipush/littlepipe/pkg/pipeline"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type failingSource struct{}
+
+func (failingSource) Read() (*pipeline.Message, error) {
+	return nil, errors.New("boom")
+}
+
+// blockingSource 一直阻塞直到 pipeline 被取消
+type blockingSource struct{ ch chan struct{} }
+
+func (s blockingSource) Read() (*pipeline.Message, error) {
+	<-s.ch
+	return nil, io.EOF
+}
+
+type discardSink struct{}
+
+func (discardSink) Write(*pipeline.Message) error { return nil }
+
+func newTestSupervisor() *Supervisor {
+	return New(Config{Namespace: "test", Registerer: prometheus.NewRegistry()})
+}
+
+func waitState(t *testing.T, s *Supervisor, name string, want State) Status {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		st, err := s.Status(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.State == want {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pipeline %s: want state %s, got %s", name, want, st.State)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSupervisor_RestartWithBackoff(t *testing.T) {
+	s := newTestSupervisor()
+	var runs atomic.Int32
+	err := s.Add(Spec{
+		Name: "failing",
+		Factory: func(env Env) (*pipeline.LittlePipe, error) {
+			runs.Add(1)
+			return pipeline.NewLittlePipe(pipeline.Config{}).
+				SetSource(failingSource{}).
+				SetSink(discardSink{}), nil
+		},
+		Backoff:     Backoff{Min: time.Millisecond, Max: 4 * time.Millisecond, Multiplier: 2},
+		MaxRestarts: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start("failing"); err != nil {
+		t.Fatal(err)
+	}
+
+	st := waitState(t, s, "failing", StateFailed)
+	if st.Restarts != 3 || runs.Load() != 4 {
+		t.Errorf("want 3 restarts and 4 runs, got %d restarts and %d runs", st.Restarts, runs.Load())
+	}
+	if st.LastError == "" {
+		t.Error("want last error to be recorded")
+	}
+}
+
+func TestSupervisor_StartStopRestart(t *testing.T) {
+	s := newTestSupervisor()
+	for _, name := range []string{"a", "b"} {
+		err := s.Add(Spec{
+			Name: name,
+			Factory: func(env Env) (*pipeline.LittlePipe, error) {
+				return pipeline.NewLittlePipe(pipeline.Config{}).
+					SetSource(blockingSource{ch: make(chan struct{})}).
+					SetSink(discardSink{}), nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Add(Spec{Name: "a", Factory: func(Env) (*pipeline.LittlePipe, error) { return nil, nil }}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("want ErrAlreadyExists, got %v", err)
+	}
+
+	s.StartAll()
+	waitState(t, s, "a", StateRunning)
+	waitState(t, s, "b", StateRunning)
+	if err := s.Start("a"); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("want ErrAlreadyRunning, got %v", err)
+	}
+
+	if err := s.Stop("a"); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, s, "a", StateStopped)
+	waitState(t, s, "b", StateRunning)
+	if err := s.Stop("a"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("want ErrNotRunning, got %v", err)
+	}
+
+	if err := s.Restart("b"); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, s, "b", StateRunning)
+
+	s.StopAll()
+	waitState(t, s, "b", StateStopped)
+	if err := s.Start("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want ErrNotFound, got %v", err)
+	}
+}
+
+func TestBackoff_Defaults(t *testing.T) {
+	b := Backoff{Max: 10 * time.Millisecond}.withDefaults()
+	if b.Min != DefaultBackoff.Min || b.Max != b.Min {
+		t.Errorf("min/max = %v/%v", b.Min, b.Max)
+	}
+	// a small Max must not make a crash loop reset its delay after a few milliseconds
+	if b.ResetAfter != DefaultBackoff.ResetAfter {
+		t.Errorf("ResetAfter = %v, want %v", b.ResetAfter, DefaultBackoff.ResetAfter)
+	}
+}